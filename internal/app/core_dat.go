@@ -0,0 +1,215 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/sdk"
+)
+
+const (
+	datKindFBNeo = "fbneo"
+	datKindMame  = "mame"
+
+	coreDatFlagDesc = "核心与 DAT 的对应关系，格式 核心=DAT文件[@版本]，核心名不含 _libretro 后缀，结尾 * 匹配任意后缀，DAT 相对 --dat 目录，例如 mame2003_plus=mame2003-plus.xml@0.78；优先于内置规则，可重复指定"
+)
+
+// coreDatRule maps libretro cores to the DAT of the romset they emulate.
+// Core is matched against the core name without its _libretro suffix; a
+// trailing * matches any suffix.
+type coreDatRule struct {
+	Core string
+	// File is relative to the --dat directory unless absolute.
+	File string
+	// Version is the DAT version the core needs, "" accepts any.
+	Version string
+}
+
+func (r *coreDatRule) matches(core string) bool {
+	if prefix, ok := strings.CutSuffix(r.Core, "*"); ok {
+		return strings.HasPrefix(core, prefix)
+	}
+	return core == r.Core
+}
+
+// kind is the DAT format of the rule: FinalBurn cores ship Logiqx DATs,
+// everything else is read as MAME.
+func (r *coreDatRule) kind() string {
+	if strings.HasPrefix(r.Core, "fb") {
+		return datKindFBNeo
+	}
+	return datKindMame
+}
+
+// defaultCoreDats covers the arcade cores of a stock RetroArch. Cores frozen
+// on an old MAME release are checked against the DAT of that release, never
+// against the current mame.dat; the first matching rule wins.
+var defaultCoreDats = []*coreDatRule{
+	{Core: "fbneo*", File: "fbneo.dat"},
+	{Core: "fbalpha2012*", File: "fbalpha2012.dat"},
+	{Core: "mame2000", File: "mame2000.dat"},
+	{Core: "mame2003_plus", File: "mame2003-plus.dat"},
+	{Core: "mame2003*", File: "mame2003.dat"},
+	{Core: "mame2010", File: "mame2010.dat"},
+	{Core: "mame2015", File: "mame2015.dat"},
+	{Core: "mame2016", File: "mame2016.dat"},
+	// AdvanceMAME's 0.106 romset, e.g. from advmame -listxml.
+	{Core: "advmame", File: "advmame.dat"},
+	{Core: "mame*", File: "mame.dat"},
+}
+
+// parseCoreDatRule parses "core=file[@version]".
+func parseCoreDatRule(spec string) (*coreDatRule, error) {
+	core, file, ok := strings.Cut(spec, "=")
+	core = normalizeCoreName(core)
+	file = strings.TrimSpace(file)
+	if !ok || core == "" || core == "*" || file == "" {
+		return nil, fmt.Errorf("invalid core dat %q, want core=file[@version]", spec)
+	}
+	rule := &coreDatRule{Core: core, File: file}
+	if idx := strings.LastIndex(file, "@"); idx > 0 {
+		rule.File, rule.Version = strings.TrimSpace(file[:idx]), strings.TrimSpace(file[idx+1:])
+	}
+	return rule, nil
+}
+
+func parseCoreDatRules(specs []string) ([]*coreDatRule, error) {
+	var rules []*coreDatRule
+	for _, spec := range specs {
+		rule, err := parseCoreDatRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return append(rules, defaultCoreDats...), nil
+}
+
+// normalizeCoreName lower-cases a core name and drops the _libretro suffix
+// and what follows, as in mame2003_plus_libretro_android.
+func normalizeCoreName(core string) string {
+	core = strings.ToLower(strings.TrimSpace(core))
+	if idx := strings.Index(core, "_libretro"); idx > 0 {
+		core = core[:idx]
+	}
+	return core
+}
+
+func matchCoreDat(rules []*coreDatRule, core string) *coreDatRule {
+	core = normalizeCoreName(core)
+	if core == "" {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.matches(core) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// romSet is a loaded DAT. Its name, the DAT file name without extension,
+// keys the testers and names the set in rom check events.
+type romSet struct {
+	Name    string
+	Path    string
+	Kind    string
+	Version string
+	defs    map[string]romDefInfo
+}
+
+// romSetPath resolves the DAT file of rule.
+func romSetPath(datDir string, rule *coreDatRule) string {
+	if filepath.IsAbs(rule.File) || datDir == "" {
+		return rule.File
+	}
+	return filepath.Join(datDir, rule.File)
+}
+
+// loadRomSets loads the DAT of every rule whose file exists. A missing DAT
+// is only an error for the first n rules, the ones given explicitly; the
+// cores of the built-in rules then go unchecked.
+func loadRomSets(datDir string, rules []*coreDatRule, explicit int) (map[string]*romSet, []string, error) {
+	sets := make(map[string]*romSet)
+	var missing []string
+	for idx, rule := range rules {
+		p := romSetPath(datDir, rule)
+		if !filepath.IsAbs(p) && datDir == "" {
+			if idx < explicit {
+				return nil, nil, fmt.Errorf("core dat %s=%s: relative dat file requires --dat", rule.Core, rule.File)
+			}
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		if set, ok := sets[name]; ok {
+			if set.Path != p {
+				return nil, nil, fmt.Errorf("dat files %s and %s share the name %s", set.Path, p, name)
+			}
+			if err := checkRomSetVersion(set, rule); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if _, err := os.Stat(p); err != nil {
+			if idx < explicit {
+				return nil, nil, fmt.Errorf("core dat %s=%s: %w", rule.Core, rule.File, err)
+			}
+			missing = append(missing, filepath.Base(p))
+			continue
+		}
+		set := &romSet{Name: name, Path: p, Kind: rule.kind()}
+		var err error
+		if set.Kind == datKindFBNeo {
+			set.defs, set.Version, err = loadRomDefsFromFBNeo(p)
+		} else {
+			set.defs, set.Version, err = loadRomDefsFromMame(p)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkRomSetVersion(set, rule); err != nil {
+			return nil, nil, err
+		}
+		sets[name] = set
+	}
+	return sets, missing, nil
+}
+
+func checkRomSetVersion(set *romSet, rule *coreDatRule) error {
+	if rule.Version == "" || strings.EqualFold(strings.TrimSpace(set.Version), rule.Version) {
+		return nil
+	}
+	return fmt.Errorf("core %s needs %s version %s, found %q", rule.Core, filepath.Base(set.Path), rule.Version, set.Version)
+}
+
+func newRomSetTester(set *romSet) (sdk.IRomTestSDK, error) {
+	if set.Kind == datKindFBNeo {
+		return sdk.NewFBNeoTestSDK(set.Path)
+	}
+	return sdk.NewMameTestSDK(set.Path)
+}
+
+// romSetForCore returns the loaded DAT core is verified against, or nil
+// when the core has no rule or its DAT is not available.
+func (c *WebCommand) romSetForCore(core string) *romSet {
+	rule := matchCoreDat(c.coreDats, core)
+	if rule == nil {
+		return nil
+	}
+	p := romSetPath(c.datDir, rule)
+	set := c.romSets[strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))]
+	if set == nil || set.Path != p {
+		return nil
+	}
+	return set
+}
+
+// romSetName is the name of the DAT core is verified against, "" for none.
+func (c *WebCommand) romSetName(core string) string {
+	if set := c.romSetForCore(core); set != nil {
+		return set.Name
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchCoreDat(t *testing.T) {
+	rules, err := parseCoreDatRules([]string{"mame2003_plus=custom/mame2003-plus.xml@0.78", "neogeo*=/dats/neogeo.dat"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cases := map[string]string{
+		"mame2003_plus_libretro":         "custom/mame2003-plus.xml",
+		"mame2003_plus_libretro_android": "custom/mame2003-plus.xml",
+		"mame2003_libretro":              "mame2003.dat",
+		"mame2003_midway_libretro":       "mame2003.dat",
+		"mame2010_libretro":              "mame2010.dat",
+		"mame_libretro":                  "mame.dat",
+		"fbalpha2012_cps1_libretro":      "fbalpha2012.dat",
+		"FBNeo_libretro":                 "fbneo.dat",
+		"neogeo_plus":                    "/dats/neogeo.dat",
+		"advmame":                        "advmame.dat",
+		"snes9x_libretro":                "",
+		"":                               "",
+	}
+	for core, want := range cases {
+		got := ""
+		if rule := matchCoreDat(rules, core); rule != nil {
+			got = rule.File
+		}
+		assert.Equal(t, want, got, core)
+	}
+	assert.Equal(t, "0.78", rules[0].Version)
+	assert.Equal(t, datKindFBNeo, matchCoreDat(rules, "fbalpha2012_libretro").kind())
+	assert.Equal(t, datKindMame, matchCoreDat(rules, "mame2010_libretro").kind())
+
+	for _, spec := range []string{"mame2003", "=mame.dat", "*=mame.dat", "mame="} {
+		_, err := parseCoreDatRule(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLoadRomSets(t *testing.T) {
+	datDir := t.TempDir()
+	writeTestFile(t, filepath.Join(datDir, "mame.dat"), `<datafile><header><version>0.282</version></header><machine name="sf2"><rom name="a.bin" size="1" crc="00"/></machine></datafile>`)
+	writeTestFile(t, filepath.Join(datDir, "mame2003.dat"), `<mame build="0.78"><game name="sf2" romof="sf2base"><rom name="b.bin" size="1" crc="00"/></game></mame>`)
+	writeTestFile(t, filepath.Join(datDir, "fbneo.dat"), `<datafile><header><version>1.0.0.03</version></header><game name="kof98"><rom name="c.bin" size="1" crc="00"/></game></datafile>`)
+
+	rules, err := parseCoreDatRules(nil)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	sets, missing, err := loadRomSets(datDir, rules, 0)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	assert.Len(t, sets, 3)
+	assert.Contains(t, missing, "mame2010.dat")
+	assert.Equal(t, "0.78", sets["mame2003"].Version)
+	assert.Equal(t, "sf2base", sets["mame2003"].defs["sf2"].Parent)
+	assert.Equal(t, datKindFBNeo, sets["fbneo"].Kind)
+
+	c := &WebCommand{datDir: datDir, coreDats: rules, romSets: sets}
+	assert.Equal(t, "mame2003", c.romSetName("mame2003_libretro"))
+	assert.Equal(t, "", c.romSetName("mame2003_plus_libretro"), "plus has its own romset")
+	assert.Equal(t, "mame", c.romSetName("mame_libretro"))
+	assert.Equal(t, "", c.romSetName("mame2010_libretro"), "never the current mame.dat")
+	assert.Equal(t, "", c.romSetName("fbalpha2012_libretro"))
+	if chain := c.parentChainFromDefs("mame2003_libretro", "sf2"); assert.Len(t, chain, 1) {
+		assert.Equal(t, "sf2base.zip", chain[0].Name)
+	}
+	assert.Empty(t, c.parentChainFromDefs("mame_libretro", "sf2"))
+
+	explicit, _ := parseCoreDatRules([]string{"mame2003_plus=mame2003.dat@0.78"})
+	_, _, err = loadRomSets(datDir, explicit, 1)
+	assert.NoError(t, err)
+	explicit, _ = parseCoreDatRules([]string{"mame2003_plus=mame2003.dat@0.139"})
+	_, _, err = loadRomSets(datDir, explicit, 1)
+	assert.Error(t, err, "version mismatch")
+	explicit, _ = parseCoreDatRules([]string{"mame2010=missing.dat"})
+	_, _, err = loadRomSets(datDir, explicit, 1)
+	assert.Error(t, err, "explicit dat must exist")
+}
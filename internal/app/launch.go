@@ -0,0 +1,266 @@
+package app
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/spf13/pflag"
+)
+
+const launchRuleFlagDesc = "自定义启动命令识别规则，格式 正则=核心，正则匹配 launch 内容，核心可用 $1 引用分组，例如 'mymame\\.sh=mame2003' 或 'cores/(\\w+)_libretro=$1'；优先于内置规则，可重复指定"
+
+// ILaunchAnalyzer recognises one kind of launch command. Analyze returns the
+// libretro core or emulator the command runs, which rom verification keys
+// on, or "" when the command is not of its kind.
+type ILaunchAnalyzer interface {
+	Name() string
+	Analyze(args []string) string
+}
+
+// launchAnalyzers run in order on the launch command with wrappers removed;
+// the first non-empty identity wins.
+var launchAnalyzers = []ILaunchAnalyzer{
+	retroarchLaunchAnalyzer{},
+	androidIntentLaunchAnalyzer{},
+	standaloneLaunchAnalyzer{},
+}
+
+// launchRule is a user rule: when Pattern matches the launch command, the
+// identity is Identity expanded with the submatches.
+type launchRule struct {
+	Pattern  *regexp.Regexp
+	Identity string
+}
+
+// parseLaunchRule parses "regexp=identity". The identity has no "=", so the
+// regexp may contain one.
+func parseLaunchRule(spec string) (*launchRule, error) {
+	idx := strings.LastIndex(spec, "=")
+	if idx <= 0 || strings.TrimSpace(spec[idx+1:]) == "" {
+		return nil, fmt.Errorf("invalid launch rule %q, want regexp=core", spec)
+	}
+	re, err := regexp.Compile(spec[:idx])
+	if err != nil {
+		return nil, fmt.Errorf("invalid launch rule %q: %w", spec, err)
+	}
+	return &launchRule{Pattern: re, Identity: strings.TrimSpace(spec[idx+1:])}, nil
+}
+
+// launchAnalyzer derives the core of collections from their launch command,
+// trying the user rules before the built-in analyzers.
+type launchAnalyzer struct {
+	rules []*launchRule
+}
+
+func newLaunchAnalyzer(specs []string) (*launchAnalyzer, error) {
+	a := &launchAnalyzer{}
+	for _, spec := range specs {
+		rule, err := parseLaunchRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+// analyze returns the core or emulator launch runs, "" when unknown. A nil
+// analyzer only uses the built-in ones.
+func (a *launchAnalyzer) analyze(launch string) string {
+	launch = strings.TrimSpace(launch)
+	if launch == "" {
+		return ""
+	}
+	if a != nil {
+		flat := strings.Join(strings.Fields(launch), " ")
+		for _, rule := range a.rules {
+			if m := rule.Pattern.FindStringSubmatchIndex(flat); m != nil {
+				return string(rule.Pattern.ExpandString(nil, rule.Identity, flat, m))
+			}
+		}
+	}
+	args, err := splitLaunch(launch)
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+	args = unwrapLaunchArgs(args)
+	for _, analyzer := range launchAnalyzers {
+		if identity := analyzer.Analyze(args); identity != "" {
+			return identity
+		}
+	}
+	return ""
+}
+
+// splitLaunch splits a launch command like a shell would, after turning
+// Windows path separators into slashes so shlex does not take them as
+// escapes.
+func splitLaunch(launch string) ([]string, error) {
+	return shlex.Split(strings.ReplaceAll(launch, "\\", "/"))
+}
+
+// unwrapLaunchArgs strips the commands that only run another one: sh -c,
+// env, cmd /c start and flatpak run, which leaves the application id as the
+// program.
+func unwrapLaunchArgs(args []string) []string {
+	for len(args) > 0 {
+		prog := launchProgram(args[0])
+		switch {
+		case (prog == "sh" || prog == "bash" || prog == "dash" || prog == "zsh") && len(args) > 2 && strings.HasPrefix(args[1], "-") && strings.Contains(args[1], "c"):
+			inner, err := splitLaunch(args[2])
+			if err != nil || len(inner) == 0 {
+				return args
+			}
+			args = inner
+		case prog == "env":
+			rest := args[1:]
+			for len(rest) > 0 && (strings.HasPrefix(rest[0], "-") || strings.Contains(rest[0], "=")) {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return args
+			}
+			args = rest
+		case prog == "cmd" && len(args) > 2 && strings.EqualFold(args[1], "/c"):
+			args = args[2:]
+		case prog == "start" && len(args) > 1:
+			rest := args[1:]
+			// start takes an optional window title, then its own /switches.
+			if rest[0] == "" {
+				rest = rest[1:]
+			}
+			for len(rest) > 0 && strings.HasPrefix(rest[0], "/") && !strings.Contains(rest[0][1:], "/") {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return args
+			}
+			args = rest
+		case prog == "flatpak" && len(args) > 2 && args[1] == "run":
+			rest := args[2:]
+			for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return args
+			}
+			args = rest
+		default:
+			return args
+		}
+	}
+	return args
+}
+
+// launchProgram is the lower-cased program name of argv0, without
+// directory or extension.
+func launchProgram(arg string) string {
+	base := strings.ToLower(path.Base(strings.Trim(arg, "\"'")))
+	switch path.Ext(base) {
+	case ".exe", ".bat", ".cmd", ".sh", ".appimage":
+		base = strings.TrimSuffix(base, path.Ext(base))
+	}
+	return base
+}
+
+// retroarchLaunchAnalyzer reads the core from -L/--libretro of RetroArch,
+// however it is started.
+type retroarchLaunchAnalyzer struct{}
+
+func (retroarchLaunchAnalyzer) Name() string { return "retroarch" }
+
+func (retroarchLaunchAnalyzer) Analyze(args []string) string {
+	return coreNameFromPath(retroarchCorePath(args))
+}
+
+// retroarchCorePath is the -L/--libretro value of a RetroArch command, ""
+// for other commands.
+func retroarchCorePath(args []string) string {
+	found := false
+	for _, arg := range args {
+		if strings.Contains(strings.ToLower(path.Base(arg)), "retroarch") {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ""
+	}
+	fs := pflag.NewFlagSet("launch", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	corePath := fs.StringP("libretro", "L", "", "libretro core")
+	_ = fs.Parse(args)
+	return strings.Trim(*corePath, "\"'")
+}
+
+// androidEmulatorPackages maps standalone Android emulators to the romset
+// they run.
+var androidEmulatorPackages = map[string]string{
+	"com.seleuco.mame4all":   "mame2000",
+	"com.seleuco.mame4droid": "mame2010",
+	"com.seleuco.mame4d2024": "mame",
+}
+
+// androidIntentLaunchAnalyzer reads "am start" intents, as used by Pegasus
+// on Android: the LIBRETRO extra of RetroArch, or the package of a known
+// standalone emulator.
+type androidIntentLaunchAnalyzer struct{}
+
+func (androidIntentLaunchAnalyzer) Name() string { return "android-intent" }
+
+func (androidIntentLaunchAnalyzer) Analyze(args []string) string {
+	if len(args) < 2 || launchProgram(args[0]) != "am" || args[1] != "start" {
+		return ""
+	}
+	pkg := ""
+	for i := 2; i < len(args); i++ {
+		switch args[i] {
+		case "-n":
+			if i+1 < len(args) {
+				pkg, _, _ = strings.Cut(args[i+1], "/")
+				i++
+			}
+		case "-e", "--es", "--esn", "--ez", "--ei", "--el", "--ef", "--eu", "--ecn", "--eia", "--ela", "--efa", "--esa":
+			if i+2 < len(args) {
+				if strings.EqualFold(args[i+1], "LIBRETRO") {
+					return coreNameFromPath(args[i+2])
+				}
+				i += 2
+			}
+		case "--user", "-a", "-d", "-t", "-c", "-f", "--activity-flags":
+			i++
+		}
+	}
+	return androidEmulatorPackages[strings.ToLower(pkg)]
+}
+
+// standaloneEmulators maps emulator binaries and flatpak ids to the romset
+// they run.
+var standaloneEmulators = map[string]string{
+	"mame":             "mame",
+	"mame64":           "mame",
+	"mamearcade":       "mame",
+	"mamearcade64":     "mame",
+	"groovymame":       "mame",
+	"org.mamedev.mame": "mame",
+	"fbneo":            "fbneo",
+	"fbneo64":          "fbneo",
+	"mame4all":         "mame2000",
+	// AdvanceMAME is frozen on MAME 0.106, which no libretro core runs.
+	"advmame": "advmame",
+}
+
+// standaloneLaunchAnalyzer recognises emulators started directly.
+type standaloneLaunchAnalyzer struct{}
+
+func (standaloneLaunchAnalyzer) Name() string { return "standalone" }
+
+func (standaloneLaunchAnalyzer) Analyze(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return standaloneEmulators[launchProgram(args[0])]
+}
@@ -0,0 +1,426 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+const appDirFlagDesc = "Pegasus 程序目录，用于检查启动命令时展开 {env.appdir}；不指定时不检查含该占位符的路径"
+
+// pegasusLaunchVars are the placeholders Pegasus expands in launch and
+// workdir.
+var pegasusLaunchVars = []string{"file.path", "file.uri", "file.dir", "file.name", "file.basename", "env.appdir"}
+
+var launchVarPattern = regexp.MustCompile(`\{([^{}\s]*)\}`)
+
+// launchCheck is the dry run of a launch command for one sample game.
+type launchCheck struct {
+	Core     string   `json:"core,omitempty"`
+	Sample   string   `json:"sample,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	WorkDir  string   `json:"workdir,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+func (c *launchCheck) addProblem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// checkLaunch splits launch like Pegasus does, expands the placeholders of
+// it and workdir for sample, the rom of a game, and checks the program, the
+// libretro core and the workdir when they are paths on this machine.
+// The workdir is relative to metadataDir and defaults to the directory of
+// the program; a relative program is taken from the workdir or metadataDir.
+// {env.appdir} stays unexpanded when appDir is "".
+func checkLaunch(analyzer *launchAnalyzer, metadataDir, launch, workdir, sample, appDir string) *launchCheck {
+	res := &launchCheck{Sample: filepath.ToSlash(sample)}
+	if strings.TrimSpace(launch) == "" {
+		res.addProblem("launch is empty")
+		return res
+	}
+	res.Core = analyzer.analyze(launch)
+	checkLaunchVars(res, "launch", launch)
+	checkLaunchVars(res, "workdir", workdir)
+	args, err := splitLaunch(launch)
+	if err != nil {
+		res.addProblem("launch: %v", err)
+		return res
+	}
+	if len(args) == 0 {
+		res.addProblem("launch is empty")
+		return res
+	}
+	vars := launchVarValues(sample, appDir)
+	for _, arg := range args {
+		res.Args = append(res.Args, expandLaunchVars(arg, vars))
+	}
+	workDir, hasWorkDir := "", false
+	if wd := strings.TrimSpace(workdir); wd != "" {
+		res.WorkDir = expandLaunchVars(strings.ReplaceAll(wd, "\\", "/"), vars)
+		if workDir, hasWorkDir = localLaunchPath(metadataDir, res.WorkDir); hasWorkDir {
+			if info, err := os.Stat(workDir); err != nil || !info.IsDir() {
+				res.addProblem("workdir %s does not exist", res.WorkDir)
+			}
+		}
+	}
+	cmd := unwrapLaunchArgs(res.Args)
+	if len(cmd) == 0 || launchProgram(cmd[0]) == "am" {
+		// Android intents name paths on the device.
+		return res
+	}
+	baseDir := metadataDir
+	if hasWorkDir {
+		baseDir = workDir
+	}
+	if p, ok := localLaunchPath(baseDir, cmd[0]); ok && strings.Contains(cmd[0], "/") {
+		if _, err := os.Stat(p); err != nil {
+			res.addProblem("program %s does not exist", cmd[0])
+		}
+		if strings.TrimSpace(workdir) == "" {
+			// Without a workdir the program runs in its own directory.
+			baseDir = filepath.Dir(p)
+		}
+	}
+	if core := retroarchCorePath(cmd); core != "" && strings.Contains(core, "/") {
+		if p, ok := localLaunchPath(baseDir, core); ok {
+			if _, err := os.Stat(p); err != nil {
+				res.addProblem("core %s does not exist", core)
+			}
+		}
+	}
+	return res
+}
+
+// checkLaunchVars reports placeholders Pegasus does not know and braces
+// left open, which Pegasus passes on verbatim.
+func checkLaunchVars(res *launchCheck, field, value string) {
+	known := make(map[string]struct{}, len(pegasusLaunchVars))
+	for _, name := range pegasusLaunchVars {
+		known[name] = struct{}{}
+	}
+	for _, m := range launchVarPattern.FindAllStringSubmatch(value, -1) {
+		if _, ok := known[m[1]]; !ok {
+			res.addProblem("%s: unknown placeholder %s", field, m[0])
+		}
+	}
+	if rest := launchVarPattern.ReplaceAllString(value, ""); strings.ContainsAny(rest, "{}") {
+		res.addProblem("%s: unbalanced braces", field)
+	}
+}
+
+// launchVarValues computes the placeholders for sample as Pegasus does.
+func launchVarValues(sample, appDir string) map[string]string {
+	p := filepath.ToSlash(sample)
+	name := path.Base(p)
+	base := name
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		base = name[:idx]
+	}
+	uriPath := p
+	if !strings.HasPrefix(uriPath, "/") {
+		uriPath = "/" + uriPath
+	}
+	vars := map[string]string{
+		"file.path":     p,
+		"file.uri":      (&url.URL{Scheme: "file", Path: uriPath}).String(),
+		"file.dir":      path.Dir(p),
+		"file.name":     name,
+		"file.basename": base,
+	}
+	if appDir != "" {
+		vars["env.appdir"] = filepath.ToSlash(appDir)
+	}
+	return vars
+}
+
+func expandLaunchVars(arg string, vars map[string]string) string {
+	return launchVarPattern.ReplaceAllStringFunc(arg, func(m string) string {
+		if v, ok := vars[m[1:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// localLaunchPath resolves p against baseDir. It reports false for paths
+// that cannot be checked here: unexpanded placeholders, home-relative paths
+// and drive letters on other systems than Windows.
+func localLaunchPath(baseDir, p string) (string, bool) {
+	if p == "" || strings.ContainsAny(p, "{}") || strings.HasPrefix(p, "~") {
+		return "", false
+	}
+	if runtime.GOOS != "windows" && len(p) >= 2 && p[1] == ':' {
+		return "", false
+	}
+	native := filepath.FromSlash(p)
+	if filepath.IsAbs(native) {
+		return filepath.Clean(native), true
+	}
+	return filepath.Join(baseDir, native), true
+}
+
+// LaunchCheckCommand dry-runs the launch command of every collection.
+type LaunchCheckCommand struct {
+	dir         string
+	appDir      string
+	launchRules []string
+	analyzer    *launchAnalyzer
+}
+
+func NewLaunchCheckCommand() *LaunchCheckCommand { return &LaunchCheckCommand{} }
+
+func (c *LaunchCheckCommand) Name() string { return "launch-check" }
+
+func (c *LaunchCheckCommand) Desc() string {
+	return "检查各合集的 launch / workdir：占位符、命令解析，以及本地的程序和核心是否存在"
+}
+
+func (c *LaunchCheckCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringVar(&c.appDir, "appdir", "", appDirFlagDesc)
+	f.StringArrayVar(&c.launchRules, "launch-rule", nil, launchRuleFlagDesc)
+}
+
+func (c *LaunchCheckCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("launch-check requires --dir")
+	}
+	analyzer, err := newLaunchAnalyzer(c.launchRules)
+	if err != nil {
+		return err
+	}
+	c.analyzer = analyzer
+	logutil.GetLogger(ctx).Info("starting launch check", zap.String("dir", c.dir))
+	return nil
+}
+
+func (c *LaunchCheckCommand) Run(ctx context.Context) error {
+	reports, err := checkCollectionLaunches(c.dir, c.analyzer, c.appDir)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, report := range reports {
+		if report.hasProblems() {
+			failed++
+		}
+		fmt.Println(report.String())
+	}
+	logutil.GetLogger(ctx).Info("launch check completed",
+		zap.Int("collections", len(reports)),
+		zap.Int("collections_with_problems", failed),
+	)
+	if failed > 0 {
+		return fmt.Errorf("launch check found problems in %d collections", failed)
+	}
+	return nil
+}
+
+func (c *LaunchCheckCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("launch-check", func() IRunner { return NewLaunchCheckCommand() })
+}
+
+// collectionLaunchReport is the launch check of one collection and of the
+// games in it with a launch of their own.
+type collectionLaunchReport struct {
+	RelPath    string
+	Collection string
+	*launchCheck
+	Games []*gameLaunchReport
+}
+
+// gameLaunchReport is the check of a launch set on a game.
+type gameLaunchReport struct {
+	Game string
+	*launchCheck
+}
+
+func (r *collectionLaunchReport) hasProblems() bool {
+	if len(r.Problems) > 0 {
+		return true
+	}
+	for _, game := range r.Games {
+		if len(game.Problems) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *collectionLaunchReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s [%s]", r.RelPath, r.Collection)
+	if r.Core != "" {
+		fmt.Fprintf(&b, " core=%s", r.Core)
+	}
+	if !r.hasProblems() {
+		b.WriteString(" ok")
+	}
+	writeLaunchCheck(&b, r.launchCheck, "  ")
+	for _, game := range r.Games {
+		fmt.Fprintf(&b, "\n  game %s", game.Game)
+		if game.Core != "" {
+			fmt.Fprintf(&b, " core=%s", game.Core)
+		}
+		writeLaunchCheck(&b, game.launchCheck, "    ")
+	}
+	return b.String()
+}
+
+func writeLaunchCheck(b *strings.Builder, res *launchCheck, indent string) {
+	if len(res.Args) > 0 {
+		fmt.Fprintf(b, "\n%srun: %s", indent, joinLaunchArgs(res.Args))
+	}
+	if res.WorkDir != "" {
+		fmt.Fprintf(b, "\n%sworkdir: %s", indent, res.WorkDir)
+	}
+	for _, problem := range res.Problems {
+		fmt.Fprintf(b, "\n%sproblem: %s", indent, problem)
+	}
+}
+
+// joinLaunchArgs quotes the arguments that need it for display.
+func joinLaunchArgs(args []string) string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = "\"" + strings.ReplaceAll(arg, "\"", "\\\"") + "\""
+		}
+		out[i] = arg
+	}
+	return strings.Join(out, " ")
+}
+
+// checkCollectionLaunches checks every collection under root, using its
+// first game with a file as the sample, and every game launch, which
+// overrides the one of its collection. A collection without a launch is
+// only a problem when some of its games have none either.
+func checkCollectionLaunches(root string, analyzer *launchAnalyzer, appDir string) ([]*collectionLaunchReport, error) {
+	files, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	var reports []*collectionLaunchReport
+	for _, metadataPath := range files {
+		doc, err := metadata.ParseMetadataFile(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		metadataDir := filepath.Dir(metadataPath)
+		relPath := filepath.ToSlash(metadataPath)
+		if rel, err := filepath.Rel(root, metadataPath); err == nil {
+			relPath = filepath.ToSlash(rel)
+		}
+		typedCollections, _ := doc.Collections()
+		typedGames, _ := doc.Games()
+		type pendingGame struct {
+			typed  metadata.Game
+			sample string
+		}
+		type pending struct {
+			typed  metadata.Collection
+			block  *metadata.Block
+			sample string
+			games  []*pendingGame
+		}
+		var colls []*pending
+		gameIdx := 0
+		for _, blk := range doc.Blocks {
+			if blk == nil {
+				continue
+			}
+			switch blk.Kind {
+			case metadata.KindCollection:
+				p := &pending{block: blk}
+				if idx := len(colls); idx < len(typedCollections) {
+					p.typed = typedCollections[idx]
+				}
+				colls = append(colls, p)
+			case metadata.KindGame:
+				game := &pendingGame{}
+				if gameIdx < len(typedGames) {
+					game.typed = typedGames[gameIdx]
+				}
+				gameIdx++
+				if len(colls) == 0 {
+					continue
+				}
+				last := colls[len(colls)-1]
+				if files := extractBlockFiles(blk); len(files) > 0 {
+					game.sample = resolveAssetPath(metadataDir, files[0])
+					if last.sample == "" {
+						last.sample = game.sample
+					}
+				}
+				last.games = append(last.games, game)
+			}
+		}
+		for _, p := range colls {
+			sample := p.sample
+			if sample == "" {
+				sample = launchSampleFile(metadataDir, parseCollectionExtensions(p.block))
+			}
+			name := strings.TrimSpace(p.typed.Name)
+			if name == "" {
+				name = filepath.Base(metadataDir)
+			}
+			report := &collectionLaunchReport{RelPath: relPath, Collection: name}
+			inherited := 0
+			for _, game := range p.games {
+				if strings.TrimSpace(game.typed.Launch) == "" {
+					inherited++
+					continue
+				}
+				workdir := game.typed.WorkDir
+				if strings.TrimSpace(workdir) == "" {
+					workdir = p.typed.WorkDir
+				}
+				gameSample := game.sample
+				if gameSample == "" {
+					gameSample = sample
+				}
+				report.Games = append(report.Games, &gameLaunchReport{
+					Game:        strings.TrimSpace(game.typed.Title),
+					launchCheck: checkLaunch(analyzer, metadataDir, game.typed.Launch, workdir, gameSample, appDir),
+				})
+			}
+			switch {
+			case strings.TrimSpace(p.typed.Launch) != "" || len(report.Games) == 0:
+				report.launchCheck = checkLaunch(analyzer, metadataDir, p.typed.Launch, p.typed.WorkDir, sample, appDir)
+			case inherited > 0:
+				report.launchCheck = &launchCheck{}
+				report.addProblem("launch is empty, used by %d games without their own", inherited)
+			default:
+				// Every game brings its own launch.
+				report.launchCheck = &launchCheck{}
+			}
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// launchSampleFile makes up a rom for collections without games, using the
+// first extension of the collection.
+func launchSampleFile(metadataDir string, exts []string) string {
+	ext := "zip"
+	if len(exts) > 0 {
+		ext = exts[0]
+	}
+	return filepath.Join(metadataDir, "example."+strings.TrimPrefix(ext, "."))
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLaunch(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "ra", "retroarch"), "")
+	writeTestFile(t, filepath.Join(root, "ra", "cores", "fbneo_libretro.so"), "")
+	metadataDir := filepath.Join(root, "arcade")
+	sample := filepath.Join(metadataDir, "sf2.zip")
+
+	res := checkLaunch(nil, metadataDir, "../ra/retroarch -L cores/fbneo_libretro.so {file.path}", "", sample, "")
+	assert.Empty(t, res.Problems, "core relative to the program directory")
+	assert.Equal(t, "fbneo_libretro", res.Core)
+	assert.Equal(t, filepath.ToSlash(sample), res.Args[3])
+
+	res = checkLaunch(nil, metadataDir, "retroarch -L ../ra/cores/mame_libretro.so \"{file.dir}/{file.basename}.7z\" {file.uri}", "{file.dir}", sample, "")
+	assert.Equal(t, []string{"workdir " + filepath.ToSlash(metadataDir) + " does not exist", "core ../ra/cores/mame_libretro.so does not exist"}, res.Problems)
+	assert.Equal(t, filepath.ToSlash(filepath.Join(metadataDir, "sf2.7z")), res.Args[3])
+	assert.Equal(t, "file://"+filepath.ToSlash(sample), res.Args[4])
+
+	res = checkLaunch(nil, metadataDir, "{env.appdir}/bin/mame {file.name} {file.pth} {rom", "", sample, "")
+	assert.Equal(t, []string{"launch: unknown placeholder {file.pth}", "launch: unbalanced braces"}, res.Problems)
+	assert.Equal(t, "{env.appdir}/bin/mame", res.Args[0], "appdir not given")
+	res = checkLaunch(nil, metadataDir, "{env.appdir}/bin/mame {file.name}", "", sample, root)
+	assert.Equal(t, []string{"program " + filepath.ToSlash(root) + "/bin/mame does not exist"}, res.Problems)
+
+	res = checkLaunch(nil, metadataDir, "am start -n com.retroarch/.Activity -e LIBRETRO /data/cores/mame2003_libretro_android.so -e ROM {file.path}", "", sample, "")
+	assert.Empty(t, res.Problems, "device paths are not checked")
+	assert.Equal(t, "mame2003_libretro_android", res.Core)
+
+	assert.Equal(t, []string{"launch is empty"}, checkLaunch(nil, metadataDir, " ", "", sample, "").Problems)
+	assert.Len(t, checkLaunch(nil, metadataDir, `retroarch -L "cores`, "", sample, "").Problems, 1)
+}
+
+func TestCheckCollectionLaunches(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "arcade", "metadata.pegasus.txt"), "collection: Arcade\nlaunch: retroarch -L /missing/fbneo_libretro.so {file.path}\n\n"+
+		"game: Street Fighter II\nfile: sf2.zip\n\n"+
+		"collection: Empty\nextension: 7z\nlaunch: mame {file.basename}\n")
+	reports, err := checkCollectionLaunches(root, nil, "")
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !assert.Len(t, reports, 2) {
+		return
+	}
+	assert.Equal(t, "Arcade", reports[0].Collection)
+	assert.Equal(t, "arcade/metadata.pegasus.txt", reports[0].RelPath)
+	assert.Equal(t, filepath.ToSlash(filepath.Join(root, "arcade", "sf2.zip")), reports[0].Sample)
+	assert.Equal(t, []string{"core /missing/fbneo_libretro.so does not exist"}, reports[0].Problems)
+	assert.Equal(t, "Empty", reports[1].Collection)
+	assert.Equal(t, "mame", reports[1].Core)
+	assert.Equal(t, []string{"mame", "example"}, reports[1].Args)
+	assert.Empty(t, reports[1].Problems)
+}
+
+func TestCheckCollectionLaunchesGameLaunch(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "pc", "alpha", "alpha.exe"), "")
+	writeTestFile(t, filepath.Join(root, "pc", "metadata.pegasus.txt"), "collection: PC\n\n"+
+		"game: Alpha\nfile: alpha/alpha.exe\nlaunch: {file.path}\n\n"+
+		"game: Beta\nfile: beta.exe\nlaunch: wine {file.pth}\n\n"+
+		"collection: Mixed\n\n"+
+		"game: Gamma\nfile: gamma.exe\nlaunch: wine {file.path}\n\n"+
+		"game: Delta\nfile: delta.exe\n")
+	reports, err := checkCollectionLaunches(root, nil, "")
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !assert.Len(t, reports, 2) {
+		return
+	}
+	pc := reports[0]
+	assert.Empty(t, pc.Problems, "games bring their own launch")
+	if assert.Len(t, pc.Games, 2) {
+		assert.Equal(t, "Alpha", pc.Games[0].Game)
+		assert.Equal(t, []string{filepath.ToSlash(filepath.Join(root, "pc", "alpha", "alpha.exe"))}, pc.Games[0].Args)
+		assert.Empty(t, pc.Games[0].Problems)
+		assert.Equal(t, []string{"launch: unknown placeholder {file.pth}"}, pc.Games[1].Problems)
+	}
+	assert.True(t, pc.hasProblems())
+	assert.Contains(t, pc.String(), "game Beta")
+
+	mixed := reports[1]
+	assert.Equal(t, []string{"launch is empty, used by 1 games without their own"}, mixed.Problems)
+	if assert.Len(t, mixed.Games, 1) {
+		assert.Empty(t, mixed.Games[0].Problems)
+	}
+}
+
+func TestHandleLaunchCheck(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, filepath.Join(root, "arcade", "sf2.zip"), "x")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\ngame: Street Fighter II\nfile: sf2.zip\nx-index-id: 1\n")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store}
+	if err := c.reloadCollections(context.Background()); err != nil {
+		t.Fatalf("load collections: %v", err)
+	}
+	body, _ := json.Marshal(&launchCheckRequest{MetadataPath: filepath.ToSlash(metaPath), XIndexID: 1, Launch: "fbneo {file.name} {file.ext}"})
+	rec := httptest.NewRecorder()
+	c.handleLaunchCheck(rec, httptest.NewRequest(http.MethodPost, "/api/collections/launch-check", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var res launchCheck
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	assert.Equal(t, "fbneo", res.Core)
+	assert.Equal(t, []string{"fbneo", "sf2.zip", "{file.ext}"}, res.Args)
+	assert.Equal(t, []string{"launch: unknown placeholder {file.ext}"}, res.Problems)
+
+	body, _ = json.Marshal(&launchCheckRequest{MetadataPath: filepath.ToSlash(metaPath), XIndexID: 9, Launch: "fbneo"})
+	rec = httptest.NewRecorder()
+	c.handleLaunchCheck(rec, httptest.NewRequest(http.MethodPost, "/api/collections/launch-check", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLaunchCheckCommand(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, filepath.Join(root, "cores", "fbneo_libretro.so"), "")
+	writeTestFile(t, metaPath, "collection: Arcade\nlaunch: retroarch -L "+filepath.ToSlash(filepath.Join(root, "cores", "fbneo_libretro.so"))+" {file.path}\n")
+
+	c := &LaunchCheckCommand{dir: root, launchRules: []string{"("}}
+	assert.Error(t, c.PreRun(context.Background()), "invalid launch rule")
+
+	c = &LaunchCheckCommand{dir: root, launchRules: []string{`retroarch=custom`}}
+	if err := c.PreRun(context.Background()); err != nil {
+		t.Fatalf("prerun: %v", err)
+	}
+	if assert.NotNil(t, c.analyzer) {
+		assert.Equal(t, "custom", c.analyzer.analyze("retroarch {file.path}"))
+	}
+	assert.NoError(t, c.Run(context.Background()))
+
+	writeTestFile(t, metaPath, "collection: Arcade\nlaunch: retroarch -L /missing/fbneo_libretro.so {file.path}\n")
+	assert.EqualError(t, c.Run(context.Background()), "launch check found problems in 1 collections")
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaunchAnalyzerBuiltin(t *testing.T) {
+	cases := map[string]string{
+		`retroarch -L /usr/lib/libretro/mame2003_plus_libretro.so {file.path}`:                          "mame2003_plus_libretro",
+		`"C:\RetroArch\retroarch.exe" --libretro "C:\RetroArch\cores\fbneo_libretro.dll" "{file.path}"`: "fbneo_libretro",
+		`flatpak run org.libretro.RetroArch -L mame_libretro.so {file.path}`:                            "mame_libretro",
+		`sh -c "retroarch -L cores/mame2010_libretro.so {file.path}"`:                                   "mame2010_libretro",
+		`env SDL_AUDIODRIVER=alsa retroarch -L cores/mame2000_libretro.so {file.path}`:                  "mame2000_libretro",
+		`am start --user 0
+  -n com.retroarch.aarch64/com.retroarch.browser.retroactivity.RetroActivityFuture
+  -e ROM {file.path}
+  -e LIBRETRO /data/data/com.retroarch.aarch64/cores/mame2003_plus_libretro_android.so
+  --activity-clear-task`: "mame2003_plus_libretro_android",
+		`am start -n com.seleuco.mame4d2024/com.seleuco.mame4droid.MAME4droid -a android.intent.action.VIEW -d {file.uri}`: "mame",
+		`am start -n com.retroarch/.browser.retroactivity.RetroActivityFuture -e ROM {file.path}`:                          "",
+		`/usr/games/mame64 -rompath /roms {file.basename}`:                                                                 "mame",
+		`cmd /c start "" "D:\Emu\FBNeo\fbneo64.exe" {file.basename}`:                                                       "fbneo",
+		`flatpak run org.mamedev.MAME {file.basename}`:                                                                     "mame",
+		`/usr/bin/advmame {file.basename}`:                                                                                 "advmame",
+		`retroarch {file.path}`:                                                                                            "",
+		`snes9x {file.path}`:                                                                                               "",
+		``:                                                                                                                 "",
+		`retroarch -L "unterminated`:                                                                                       "",
+	}
+	var a *launchAnalyzer
+	for launch, want := range cases {
+		assert.Equal(t, want, a.analyze(launch), launch)
+	}
+}
+
+func TestLaunchAnalyzerRules(t *testing.T) {
+	a, err := newLaunchAnalyzer([]string{`mymame\.sh=mame2003`, `cores/(\w+)_libretro=$1`})
+	if err != nil {
+		t.Fatalf("new analyzer: %v", err)
+	}
+	assert.Equal(t, "mame2003", a.analyze(`/opt/bin/mymame.sh {file.path}`))
+	assert.Equal(t, "mame2010", a.analyze(`retroarch -L cores/mame2010_libretro.so {file.path}`), "rules first")
+	assert.Equal(t, "fbneo", a.analyze(`fbneo {file.basename}`), "builtin fallback")
+
+	for _, spec := range []string{"mame", "=mame", "mame=", "(=mame"} {
+		_, err := newLaunchAnalyzer([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,303 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/media"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+// issueMissing marks an assets.* value whose file does not exist.
+const issueMissing = "missing"
+
+type MediaAuditCommand struct {
+	dir     string
+	limits  []string
+	convert string
+	fix     bool
+}
+
+func NewMediaAuditCommand() *MediaAuditCommand {
+	return &MediaAuditCommand{}
+}
+
+func (c *MediaAuditCommand) Name() string { return "media-audit" }
+
+func (c *MediaAuditCommand) Desc() string {
+	return "检查 media 目录中损坏、超限或扩展名不符的媒体文件"
+}
+
+func (c *MediaAuditCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringArrayVar(&c.limits, "media-limit", nil, "覆盖媒体限制，格式 类型=大小[:宽x高]，例如 boxfront=8m:2048x2048，可重复指定")
+	f.StringVar(&c.convert, "media-convert", string(media.ConvertOff), "配合 --fix 使用的图片转换: off / auto / png / jpeg")
+	f.BoolVar(&c.fix, "fix", false, "修复可修复的问题：按内容修正扩展名，并按 --media-convert 缩放或转换图片，同时更新 metadata 引用")
+}
+
+func (c *MediaAuditCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("media-audit requires --dir")
+	}
+	if _, err := parseMediaRules(c.limits); err != nil {
+		return err
+	}
+	if _, err := media.ParseConvert(c.convert); err != nil {
+		return err
+	}
+	logutil.GetLogger(ctx).Info("starting media audit",
+		zap.String("dir", c.dir),
+		zap.Bool("fix", c.fix),
+	)
+	return nil
+}
+
+func (c *MediaAuditCommand) Run(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	rules, _ := parseMediaRules(c.limits)
+	mode, _ := media.ParseConvert(c.convert)
+	report, err := auditMedia(c.dir, &mediaAuditOptions{Rules: rules, Convert: mode, Fix: c.fix})
+	if err != nil {
+		return err
+	}
+	for _, finding := range report.Findings {
+		fmt.Println(finding.String())
+	}
+	logger.Info("media audit completed",
+		zap.Int("metadata_found", report.MetadataFiles),
+		zap.Int("files_checked", report.FilesChecked),
+		zap.Int("files_with_issues", len(report.Findings)),
+		zap.Int("files_fixed", report.Fixed),
+		zap.Bool("fix", c.fix),
+	)
+	return nil
+}
+
+func (c *MediaAuditCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("media-audit", func() IRunner { return NewMediaAuditCommand() })
+}
+
+type mediaAuditOptions struct {
+	Rules   media.Rules
+	Convert media.Convert
+	Fix     bool
+}
+
+// mediaFinding is a file with problems. Field and Game are empty for files
+// under media/ that no game references. FixedPath is set when --fix moved
+// the file; Issues then lists what is left.
+type mediaFinding struct {
+	RelPath   string
+	Game      string
+	Field     string
+	Issues    []media.Issue
+	FixedPath string
+}
+
+func (f *mediaFinding) String() string {
+	var b strings.Builder
+	b.WriteString(f.RelPath)
+	if f.Field != "" {
+		fmt.Fprintf(&b, " [%s %s]", f.Game, f.Field)
+	} else {
+		b.WriteString(" [未引用]")
+	}
+	if f.FixedPath != "" {
+		fmt.Fprintf(&b, " 已修复 -> %s", f.FixedPath)
+	}
+	for _, issue := range f.Issues {
+		fmt.Fprintf(&b, "\n  %s: %s", issue.Code, issue.Message)
+	}
+	return b.String()
+}
+
+type mediaAuditReport struct {
+	MetadataFiles int
+	FilesChecked  int
+	Fixed         int
+	Findings      []*mediaFinding
+}
+
+// auditMedia checks every assets.* file referenced by the metadata files
+// under root against its field's rule, then the remaining files in each
+// media/ directory against the generic rule. With Fix set, files referenced
+// from media/ are normalised and the metadata updated to their new names.
+func auditMedia(root string, opts *mediaAuditOptions) (*mediaAuditReport, error) {
+	files, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	report := &mediaAuditReport{MetadataFiles: len(files)}
+	for _, metadataPath := range files {
+		if err := auditMetadataMedia(root, metadataPath, opts, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func auditMetadataMedia(root, metadataPath string, opts *mediaAuditOptions, report *mediaAuditReport) error {
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	mediaDir := filepath.Join(metadataDir, "media") + string(os.PathSeparator)
+	relPath := func(path string) string {
+		if rel, err := filepath.Rel(root, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+		return filepath.ToSlash(path)
+	}
+	referenced := make(map[string]struct{})
+	// renamed maps files Normalize moved to their new path. Games sharing a
+	// file refer to it by the old path too, so every reference is rewritten
+	// once all of them have been checked.
+	renamed := make(map[string]string)
+	for _, block := range doc.Blocks {
+		if block == nil || block.Kind != metadata.KindGame {
+			continue
+		}
+		for _, entry := range block.Entries {
+			if entry == nil || !isAssetFieldKey(entry.Key) {
+				continue
+			}
+			rule := opts.Rules.For(entry.Key)
+			for _, value := range entry.Values {
+				path := resolveAssetPath(metadataDir, value)
+				if path == "" {
+					continue
+				}
+				if fixed, ok := renamed[path]; ok {
+					path = fixed
+				}
+				referenced[path] = struct{}{}
+				finding := &mediaFinding{RelPath: relPath(path), Game: getBlockTitle(block), Field: entry.Key}
+				if _, err := os.Stat(path); err != nil {
+					finding.Issues = []media.Issue{{Code: issueMissing, Message: "file does not exist"}}
+					report.Findings = append(report.Findings, finding)
+					continue
+				}
+				report.FilesChecked++
+				_, issues, err := media.Check(path, rule)
+				if err != nil {
+					return err
+				}
+				if len(issues) == 0 {
+					continue
+				}
+				finding.Issues = issues
+				if opts.Fix && strings.HasPrefix(path, mediaDir) {
+					fixed, remaining, err := media.Normalize(path, rule, opts.Convert)
+					if err != nil {
+						return err
+					}
+					if len(remaining) < len(issues) {
+						report.Fixed++
+						finding.FixedPath = relPath(fixed)
+					}
+					finding.Issues = remaining
+					if fixed != path {
+						delete(referenced, path)
+						referenced[fixed] = struct{}{}
+						for from, to := range renamed {
+							if to == path {
+								renamed[from] = fixed
+							}
+						}
+						renamed[path] = fixed
+					}
+				}
+				report.Findings = append(report.Findings, finding)
+			}
+		}
+	}
+	changed, err := rewriteRenamedAssets(doc, metadataDir, renamed)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := metadata.WriteMetadataFile(metadataPath, doc); err != nil {
+			return err
+		}
+	}
+	return auditUnreferencedMedia(mediaDir, referenced, opts, report, relPath)
+}
+
+// rewriteRenamedAssets points every assets.* value of doc that resolves to
+// a key of renamed at its new path, reporting whether any changed.
+func rewriteRenamedAssets(doc *metadata.Document, metadataDir string, renamed map[string]string) (bool, error) {
+	if len(renamed) == 0 {
+		return false, nil
+	}
+	changed := false
+	for _, block := range doc.Blocks {
+		if block == nil || block.Kind != metadata.KindGame {
+			continue
+		}
+		for _, entry := range block.Entries {
+			if entry == nil || !isAssetFieldKey(entry.Key) {
+				continue
+			}
+			for idx, value := range entry.Values {
+				fixed, ok := renamed[resolveAssetPath(metadataDir, value)]
+				if !ok {
+					continue
+				}
+				rel, err := filepath.Rel(metadataDir, fixed)
+				if err != nil {
+					return false, err
+				}
+				entry.Values[idx] = filepath.ToSlash(rel)
+				changed = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+func auditUnreferencedMedia(mediaDir string, referenced map[string]struct{}, opts *mediaAuditOptions, report *mediaAuditReport, relPath func(string) string) error {
+	var paths []string
+	err := filepath.WalkDir(mediaDir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if errors.Is(walkErr, fs.ErrNotExist) {
+				return nil
+			}
+			return walkErr
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if _, ok := referenced[path]; !ok {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	rule := opts.Rules.For("")
+	for _, path := range paths {
+		report.FilesChecked++
+		_, issues, err := media.Check(path, rule)
+		if err != nil {
+			return err
+		}
+		if len(issues) > 0 {
+			report.Findings = append(report.Findings, &mediaFinding{RelPath: relPath(path), Issues: issues})
+		}
+	}
+	return nil
+}
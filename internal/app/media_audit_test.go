@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/media"
+)
+
+func writeTestJPEG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	writeTestFile(t, path, buf.String())
+}
+
+func TestAuditMedia(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, `collection: Arcade
+
+game: Alpha
+file: alpha.zip
+assets.boxfront: media/alpha/boxFront.png
+assets.logo: media/alpha/logo.png
+assets.video: media/alpha/video.mp4
+`)
+	writeTestJPEG(t, filepath.Join(dir, "arcade", "media", "alpha", "boxFront.png"), 32, 32)
+	writeTestPNG(t, filepath.Join(dir, "arcade", "media", "alpha", "video.mp4"), 8, 8, false)
+	writeTestFile(t, filepath.Join(dir, "arcade", "media", "stray.png"), "<html><body>404</body></html>")
+
+	opts := &mediaAuditOptions{Rules: media.DefaultRules(), Convert: media.ConvertOff}
+	report, err := auditMedia(dir, opts)
+	if err != nil {
+		t.Fatalf("audit media: %v", err)
+	}
+	assert.Equal(t, 1, report.MetadataFiles)
+	assert.Equal(t, 3, report.FilesChecked)
+	got := make(map[string][]string)
+	for _, finding := range report.Findings {
+		for _, issue := range finding.Issues {
+			got[finding.RelPath] = append(got[finding.RelPath], issue.Code)
+		}
+	}
+	assert.Equal(t, map[string][]string{
+		"arcade/media/alpha/boxFront.png": {media.IssueMislabelled},
+		"arcade/media/alpha/logo.png":     {issueMissing},
+		"arcade/media/alpha/video.mp4":    {media.IssueMislabelled, media.IssueWrongKind},
+		"arcade/media/stray.png":          {media.IssueUnknownFormat},
+	}, got)
+
+	opts.Fix = true
+	report, err = auditMedia(dir, opts)
+	if err != nil {
+		t.Fatalf("audit media: %v", err)
+	}
+	assert.Equal(t, 1, report.Fixed)
+	assert.FileExists(t, filepath.Join(dir, "arcade", "media", "alpha", "boxFront.jpg"))
+	data, _ := os.ReadFile(metaPath)
+	assert.Contains(t, string(data), "assets.boxfront: media/alpha/boxFront.jpg")
+	assert.Contains(t, string(data), "assets.video: media/alpha/video.mp4", "wrong kind cannot be fixed")
+}
+
+func TestAuditMediaSharedFile(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, `collection: Arcade
+
+game: Alpha
+file: alpha.zip
+assets.boxfront: media/shared/box.png
+
+game: Beta
+file: beta.zip
+assets.boxfront: media/shared/box.png
+`)
+	writeTestJPEG(t, filepath.Join(dir, "media", "shared", "box.png"), 16, 16)
+	writeTestFile(t, filepath.Join(dir, "media", "shared", "box.jpg"), "someone else's file")
+
+	report, err := auditMedia(dir, &mediaAuditOptions{Rules: media.DefaultRules(), Convert: media.ConvertOff, Fix: true})
+	if err != nil {
+		t.Fatalf("audit media: %v", err)
+	}
+	assert.Equal(t, 1, report.Fixed)
+	for _, finding := range report.Findings {
+		if finding.Field != "" {
+			assert.Empty(t, finding.Issues, finding.RelPath)
+		}
+	}
+	data := readTestFile(t, metaPath)
+	assert.NotContains(t, data, "box.png")
+	assert.Equal(t, 2, strings.Count(data, "assets.boxfront: media/shared/box-2.jpg"))
+	assert.Equal(t, "someone else's file", readTestFile(t, filepath.Join(dir, "media", "shared", "box.jpg")))
+}
+
+func TestNormalizeStagedMedia(t *testing.T) {
+	dir := t.TempDir()
+	c := &WebCommand{mediaRules: media.DefaultRules(), mediaConvert: media.ConvertOff}
+
+	staged := filepath.Join(dir, "1__cover.png")
+	writeTestJPEG(t, staged, 16, 16)
+	path, err := c.normalizeStagedMedia("assets.boxfront", staged)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "1__cover.jpg"), path)
+
+	page := filepath.Join(dir, "2__cover.png")
+	writeTestFile(t, page, "<html></html>")
+	_, err = c.normalizeStagedMedia("assets.boxfront", page)
+	var rejected *mediaRejectedError
+	assert.True(t, errors.As(err, &rejected))
+	assert.NoFileExists(t, page)
+
+	rom := filepath.Join(dir, "3__game.zip")
+	writeTestFile(t, rom, "not media")
+	path, err = c.normalizeStagedMedia("file", rom)
+	assert.NoError(t, err)
+	assert.Equal(t, rom, path)
+}
@@ -0,0 +1,472 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/media"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+const (
+	dedupeLinkNone      = ""
+	dedupeLinkHardlink  = "hardlink"
+	dedupeLinkReference = "reference"
+
+	dedupeClusterExact   = "exact"
+	dedupeClusterSimilar = "similar"
+
+	defaultDedupeThreshold = 8
+)
+
+type MediaDedupeCommand struct {
+	dir       string
+	threshold int
+	link      string
+	layoutArg string
+	apply     bool
+}
+
+func NewMediaDedupeCommand() *MediaDedupeCommand {
+	return &MediaDedupeCommand{}
+}
+
+func (c *MediaDedupeCommand) Name() string { return "media-dedupe" }
+
+func (c *MediaDedupeCommand) Desc() string {
+	return "查找重复或相似的媒体图片，并可将完全相同的副本合并"
+}
+
+func (c *MediaDedupeCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.IntVar(&c.threshold, "threshold", defaultDedupeThreshold, "感知哈希判定相似的最大差异位数(0-64)，为 0 时只查找完全相同的文件")
+	f.StringVar(&c.link, "link", dedupeLinkNone, "合并完全相同的副本: hardlink(替换为硬链接) / reference(metadata 改为引用同一文件并删除副本)")
+	f.StringVar(&c.layoutArg, "media-layout", defaultMediaLayoutPattern, mediaLayoutFlagDesc)
+	f.BoolVar(&c.apply, "apply", false, "执行 --link 指定的合并，默认只打印计划")
+}
+
+func (c *MediaDedupeCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("media-dedupe requires --dir")
+	}
+	if c.threshold < 0 || c.threshold > 64 {
+		return fmt.Errorf("invalid threshold %d, want 0-64", c.threshold)
+	}
+	switch c.link {
+	case dedupeLinkNone, dedupeLinkHardlink, dedupeLinkReference:
+	default:
+		return fmt.Errorf("invalid link mode %q, want hardlink or reference", c.link)
+	}
+	if c.apply && c.link == dedupeLinkNone {
+		return errors.New("--apply requires --link")
+	}
+	if _, err := parseMediaLayout(c.layoutArg); err != nil {
+		return err
+	}
+	logutil.GetLogger(ctx).Info("starting media dedupe",
+		zap.String("dir", c.dir),
+		zap.Int("threshold", c.threshold),
+		zap.String("link", c.link),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *MediaDedupeCommand) Run(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	layout, err := parseMediaLayout(c.layoutArg)
+	if err != nil {
+		return err
+	}
+	scan, err := scanMediaDuplicates(ctx, c.dir, c.threshold, layout)
+	if err != nil {
+		return err
+	}
+	var reclaimable int64
+	for _, cluster := range scan.Clusters {
+		fmt.Print(cluster.String())
+		reclaimable += cluster.Reclaimable()
+	}
+	merged := 0
+	if c.link != dedupeLinkNone {
+		plan := scan.planMerge(c.link)
+		for _, action := range plan {
+			fmt.Println(action.String())
+		}
+		if c.apply {
+			if merged, err = scan.applyMerge(plan); err != nil {
+				return err
+			}
+		}
+	}
+	logger.Info("media dedupe completed",
+		zap.Int("images_scanned", len(scan.Files)),
+		zap.Int("clusters", len(scan.Clusters)),
+		zap.String("reclaimable", media.FormatSize(reclaimable)),
+		zap.Int("files_merged", merged),
+		zap.Bool("applied", c.apply),
+	)
+	return nil
+}
+
+func (c *MediaDedupeCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("media-dedupe", func() IRunner { return NewMediaDedupeCommand() })
+}
+
+// dedupeRef is one game's use of a media file, either through an assets.*
+// field or by name in media/<rombase>/.
+type dedupeRef struct {
+	metadataPath string
+	block        *metadata.Block
+	game         string
+	name         string
+}
+
+type dedupeFile struct {
+	Path       string
+	RelPath    string
+	Size       int64
+	refs       []*dedupeRef
+	info       os.FileInfo
+	fp         *media.Fingerprint
+	perceptual bool
+}
+
+func (f *dedupeFile) describe() string {
+	var games []string
+	for _, ref := range f.refs {
+		games = append(games, fmt.Sprintf("%s (assets.%s)", ref.game, ref.name))
+	}
+	return fmt.Sprintf("%s  %s", f.RelPath, strings.Join(games, ", "))
+}
+
+// dedupeCluster groups copies of the same image. Exact clusters hold
+// byte-identical files; similar clusters hold files whose perceptual hashes
+// differ by at most Distance bits.
+type dedupeCluster struct {
+	Kind     string
+	Distance int
+	Files    []*dedupeFile
+}
+
+// Reclaimable is the space freed by keeping one file of an exact cluster.
+func (c *dedupeCluster) Reclaimable() int64 {
+	if c.Kind != dedupeClusterExact || len(c.Files) < 2 {
+		return 0
+	}
+	var total int64
+	for _, file := range c.Files[1:] {
+		if !os.SameFile(file.info, c.Files[0].info) {
+			total += file.Size
+		}
+	}
+	return total
+}
+
+func (c *dedupeCluster) String() string {
+	var b strings.Builder
+	if c.Kind == dedupeClusterExact {
+		fmt.Fprintf(&b, "[完全相同] %d 个文件，可节省 %s\n", len(c.Files), media.FormatSize(c.Reclaimable()))
+	} else {
+		fmt.Fprintf(&b, "[相似] %d 个文件，差异 %d 位\n", len(c.Files), c.Distance)
+	}
+	for _, file := range c.Files {
+		fmt.Fprintf(&b, "  %s\n", file.describe())
+	}
+	return b.String()
+}
+
+type mediaDedupeScan struct {
+	root     string
+	Files    []*dedupeFile
+	Clusters []*dedupeCluster
+	docs     map[string]*metadata.Document
+}
+
+// scanMediaDuplicates fingerprints every image that collectGameAssets
+// would show for the games under root and clusters the copies. threshold
+// is the largest perceptual hash distance treated as similar; 0 only looks
+// for exact copies. Collections without their own media layout use layout.
+func scanMediaDuplicates(ctx context.Context, root string, threshold int, layout *mediaLayout) (*mediaDedupeScan, error) {
+	logger := logutil.GetLogger(ctx)
+	metadataFiles, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	scan := &mediaDedupeScan{root: root, docs: make(map[string]*metadata.Document)}
+	byPath := make(map[string]*dedupeFile)
+	for _, metadataPath := range metadataFiles {
+		doc, err := metadata.ParseMetadataFile(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		scan.docs[metadataPath] = doc
+		games, err := doc.Games()
+		if err != nil {
+			return nil, err
+		}
+		metadataDir := filepath.Dir(metadataPath)
+		assets := newMediaAssetIndex(metadataDir)
+		gameIdx := 0
+		for _, block := range doc.Blocks {
+			if block == nil || block.Kind != metadata.KindGame {
+				continue
+			}
+			game := games[gameIdx]
+			gameIdx++
+			files, _ := resolveGameAssetFiles(assets, mediaLayoutFor(doc, block, layout), metadataDir, game, deriveRomBase(game.Files))
+			for _, asset := range files {
+				if media.KindOfExt(asset.Path) != media.KindImage {
+					continue
+				}
+				name := normalizeAssetKey(asset.Name)
+				ref := &dedupeRef{metadataPath: metadataPath, block: block, game: game.Title, name: name}
+				if file, ok := byPath[asset.Path]; ok {
+					file.refs = append(file.refs, ref)
+					continue
+				}
+				info, err := os.Stat(asset.Path)
+				if err != nil || info.IsDir() {
+					continue
+				}
+				fp, perceptual, err := media.FingerprintFile(asset.Path)
+				if err != nil {
+					logger.Warn("skip media", zap.String("path", asset.Path), zap.Error(err))
+					continue
+				}
+				rel, err := filepath.Rel(root, asset.Path)
+				if err != nil {
+					rel = asset.Path
+				}
+				file := &dedupeFile{
+					Path:       asset.Path,
+					RelPath:    filepath.ToSlash(rel),
+					Size:       info.Size(),
+					refs:       []*dedupeRef{ref},
+					info:       info,
+					fp:         fp,
+					perceptual: perceptual,
+				}
+				byPath[asset.Path] = file
+				scan.Files = append(scan.Files, file)
+			}
+		}
+	}
+	sort.Slice(scan.Files, func(i, j int) bool { return scan.Files[i].RelPath < scan.Files[j].RelPath })
+	scan.Clusters = clusterDuplicates(scan.Files, threshold)
+	return scan, nil
+}
+
+// clusterDuplicates groups files by content hash, then joins the groups
+// whose perceptual hashes are within threshold of each other.
+func clusterDuplicates(files []*dedupeFile, threshold int) []*dedupeCluster {
+	var groups [][]*dedupeFile
+	bySHA := make(map[string]int)
+	for _, file := range files {
+		if idx, ok := bySHA[file.fp.SHA256]; ok {
+			groups[idx] = append(groups[idx], file)
+			continue
+		}
+		bySHA[file.fp.SHA256] = len(groups)
+		groups = append(groups, []*dedupeFile{file})
+	}
+
+	var out []*dedupeCluster
+	for _, group := range groups {
+		if len(group) > 1 && !allSameFile(group) {
+			out = append(out, &dedupeCluster{Kind: dedupeClusterExact, Files: group})
+		}
+	}
+	if threshold <= 0 {
+		return out
+	}
+
+	parent := make([]int, len(groups))
+	distance := make([]int, len(groups))
+	for idx := range parent {
+		parent[idx] = idx
+	}
+	var find func(int) int
+	find = func(idx int) int {
+		if parent[idx] != idx {
+			parent[idx] = find(parent[idx])
+		}
+		return parent[idx]
+	}
+	for i := range groups {
+		a := groups[i][0]
+		if !a.perceptual {
+			continue
+		}
+		for j := i + 1; j < len(groups); j++ {
+			b := groups[j][0]
+			if !b.perceptual {
+				continue
+			}
+			dist := max(media.Distance(a.fp.DHash, b.fp.DHash), media.Distance(a.fp.PHash, b.fp.PHash))
+			if dist > threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			if ri != rj {
+				parent[rj] = ri
+			}
+			distance[ri] = max(distance[ri], distance[rj], dist)
+		}
+	}
+	similar := make(map[int]*dedupeCluster)
+	var order []int
+	for idx, group := range groups {
+		r := find(idx)
+		cluster, ok := similar[r]
+		if !ok {
+			cluster = &dedupeCluster{Kind: dedupeClusterSimilar}
+			similar[r] = cluster
+			order = append(order, r)
+		}
+		cluster.Files = append(cluster.Files, group...)
+	}
+	for _, r := range order {
+		cluster := similar[r]
+		if countContents(cluster.Files) < 2 {
+			continue
+		}
+		cluster.Distance = distance[r]
+		sort.Slice(cluster.Files, func(i, j int) bool { return cluster.Files[i].RelPath < cluster.Files[j].RelPath })
+		out = append(out, cluster)
+	}
+	return out
+}
+
+func allSameFile(files []*dedupeFile) bool {
+	for _, file := range files[1:] {
+		if !os.SameFile(file.info, files[0].info) {
+			return false
+		}
+	}
+	return true
+}
+
+func countContents(files []*dedupeFile) int {
+	seen := make(map[string]struct{})
+	for _, file := range files {
+		seen[file.fp.SHA256] = struct{}{}
+	}
+	return len(seen)
+}
+
+// dedupeAction replaces one exact copy by the cluster's first file.
+type dedupeAction struct {
+	mode   string
+	keep   *dedupeFile
+	remove *dedupeFile
+}
+
+func (a *dedupeAction) String() string {
+	if a.mode == dedupeLinkHardlink {
+		return fmt.Sprintf("硬链接 %s -> %s", a.remove.RelPath, a.keep.RelPath)
+	}
+	return fmt.Sprintf("引用 %s 改为 %s 并删除", a.remove.RelPath, a.keep.RelPath)
+}
+
+// planMerge lists the merges for the exact clusters. Similar images are
+// only reported, as which one to keep is a judgement call.
+func (s *mediaDedupeScan) planMerge(mode string) []*dedupeAction {
+	var out []*dedupeAction
+	for _, cluster := range s.Clusters {
+		if cluster.Kind != dedupeClusterExact {
+			continue
+		}
+		keep := cluster.Files[0]
+		for _, file := range cluster.Files[1:] {
+			// Never touch files outside the library, which other tools
+			// may own.
+			if os.SameFile(file.info, keep.info) || strings.HasPrefix(file.RelPath, "../") || filepath.IsAbs(file.RelPath) {
+				continue
+			}
+			out = append(out, &dedupeAction{mode: mode, keep: keep, remove: file})
+		}
+	}
+	return out
+}
+
+// applyMerge carries out plan and writes back the metadata files whose
+// references changed. It returns the number of files merged.
+func (s *mediaDedupeScan) applyMerge(plan []*dedupeAction) (int, error) {
+	changed := make(map[string]struct{})
+	merged := 0
+	for _, action := range plan {
+		switch action.mode {
+		case dedupeLinkHardlink:
+			if err := replaceWithHardlink(action.keep.Path, action.remove.Path); err != nil {
+				return merged, fmt.Errorf("link %s: %w", action.remove.RelPath, err)
+			}
+		case dedupeLinkReference:
+			for _, ref := range action.remove.refs {
+				rel, err := filepath.Rel(filepath.Dir(ref.metadataPath), action.keep.Path)
+				if err != nil {
+					return merged, err
+				}
+				setAssetReference(ref, filepath.ToSlash(rel))
+				changed[ref.metadataPath] = struct{}{}
+			}
+		}
+		merged++
+	}
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := metadata.WriteMetadataFile(path, s.docs[path]); err != nil {
+			return merged, err
+		}
+	}
+	// Files go only once every reference to them has been rewritten.
+	for _, action := range plan {
+		if action.mode == dedupeLinkReference {
+			if err := os.Remove(action.remove.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return merged, err
+			}
+		}
+	}
+	return merged, nil
+}
+
+// setAssetReference points ref at value, adding an assets.* field when the
+// game only used the file by its name in media/<rombase>/.
+func setAssetReference(ref *dedupeRef, value string) {
+	key := "assets." + ref.name
+	if entry := ref.block.Entry(key); entry != nil {
+		entry.Values = []string{value}
+		entry.Inline = true
+		return
+	}
+	ref.block.Entries = append(ref.block.Entries, &metadata.Entry{Key: key, Values: []string{value}, Inline: true})
+}
+
+// replaceWithHardlink swaps path for a hard link to keep, going through a
+// temporary name so path is never missing.
+func replaceWithHardlink(keep, path string) error {
+	tmp := path + ".retrog-link"
+	_ = os.Remove(tmp)
+	if err := os.Link(keep, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
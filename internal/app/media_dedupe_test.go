@@ -0,0 +1,151 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/media"
+)
+
+func TestMediaDedupe(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, `collection: Arcade
+
+game: Street Fighter II
+file: sf2.zip
+assets.boxfront: media/sf2/boxFront.png
+
+game: Street Fighter II (Japan)
+file: sf2j.zip
+
+game: Street Fighter II (Small)
+file: sf2s.zip
+assets.boxfront: media/sf2s/cover.png
+
+game: Logo Only
+file: logo.zip
+assets.logo: media/logo/logo.png
+`)
+	mediaDir := filepath.Join(dir, "arcade", "media")
+	writePatternPNG(t, filepath.Join(mediaDir, "sf2", "boxFront.png"), 256, 128)
+	// The Japanese release has no field and is found by name.
+	writePatternPNG(t, filepath.Join(mediaDir, "sf2j", "boxFront.png"), 256, 128)
+	writeScaledTestPNG(t, filepath.Join(mediaDir, "sf2", "boxFront.png"), filepath.Join(mediaDir, "sf2s", "cover.png"), 128, 64)
+	writeTestPNG(t, filepath.Join(mediaDir, "logo", "logo.png"), 8, 200, true)
+
+	scan, err := scanMediaDuplicates(context.Background(), dir, defaultDedupeThreshold, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	assert.Len(t, scan.Files, 4)
+	if !assert.Len(t, scan.Clusters, 2) {
+		return
+	}
+	exact, similar := scan.Clusters[0], scan.Clusters[1]
+	assert.Equal(t, dedupeClusterExact, exact.Kind)
+	assert.Equal(t, []string{"arcade/media/sf2/boxFront.png", "arcade/media/sf2j/boxFront.png"}, dedupeRelPaths(exact))
+	assert.Equal(t, dedupeClusterSimilar, similar.Kind)
+	assert.Equal(t, []string{"arcade/media/sf2/boxFront.png", "arcade/media/sf2j/boxFront.png", "arcade/media/sf2s/cover.png"}, dedupeRelPaths(similar))
+
+	plan := scan.planMerge(dedupeLinkReference)
+	if !assert.Len(t, plan, 1) {
+		return
+	}
+	merged, err := scan.applyMerge(plan)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged)
+	assert.NoFileExists(t, filepath.Join(mediaDir, "sf2j", "boxFront.png"))
+	data, _ := os.ReadFile(metaPath)
+	assert.Contains(t, string(data), "game: Street Fighter II (Japan)\nfile: sf2j.zip\nassets.boxfront: media/sf2/boxFront.png\n")
+
+	scan, err = scanMediaDuplicates(context.Background(), dir, 0, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	assert.Empty(t, scan.Clusters, "shared references are not duplicates")
+}
+
+func TestMediaDedupeHardlink(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "nes", "metadata.pegasus.txt"), `collection: NES
+
+game: Alpha
+file: alpha.nes
+
+game: Beta
+file: beta.nes
+`)
+	alpha := filepath.Join(dir, "nes", "media", "alpha", "boxFront.png")
+	beta := filepath.Join(dir, "nes", "media", "beta", "boxFront.png")
+	writeTestPNG(t, alpha, 64, 64, false)
+	writeTestPNG(t, beta, 64, 64, false)
+
+	scan, err := scanMediaDuplicates(context.Background(), dir, 0, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	assert.Len(t, scan.Clusters, 1)
+	merged, err := scan.applyMerge(scan.planMerge(dedupeLinkHardlink))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged)
+	a, _ := os.Stat(alpha)
+	b, _ := os.Stat(beta)
+	assert.True(t, os.SameFile(a, b))
+
+	scan, err = scanMediaDuplicates(context.Background(), dir, 0, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	assert.Empty(t, scan.Clusters, "linked copies are already merged")
+}
+
+func dedupeRelPaths(cluster *dedupeCluster) []string {
+	var out []string
+	for _, file := range cluster.Files {
+		out = append(out, file.RelPath)
+	}
+	return out
+}
+
+// writePatternPNG draws blocks of varying brightness, which unlike a plain
+// gradient give the perceptual hashes something to latch on to.
+func writePatternPNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			bx, by := x*6/width, y*4/height
+			img.SetGray(x, y, color.Gray{Y: uint8((bx*97 + by*53) % 256)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := media.Encode(&buf, img, media.FormatPNG); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	writeTestFile(t, path, buf.String())
+}
+
+func writeScaledTestPNG(t *testing.T, src, dest string, width, height int) {
+	t.Helper()
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("open %s: %v", src, err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("decode %s: %v", src, err)
+	}
+	var buf bytes.Buffer
+	if err := media.Encode(&buf, media.Scale(img, width, height), media.FormatPNG); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	writeTestFile(t, dest, buf.String())
+}
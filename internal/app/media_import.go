@@ -0,0 +1,391 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/media"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"github.com/xxxsen/retrog/internal/scraper"
+	"go.uber.org/zap"
+)
+
+const (
+	importLinkCopy     = "copy"
+	importLinkHardlink = "hardlink"
+
+	importMatchRom   = "rom"
+	importMatchTitle = "title"
+)
+
+// packTypeAssets maps the type folders of Skraper and EmulationStation
+// media packs to asset fields.
+var packTypeAssets = map[string]string{
+	"boxart":        "assets.boxfront",
+	"box2dfront":    "assets.boxfront",
+	"box-2d":        "assets.boxfront",
+	"covers":        "assets.boxfront",
+	"box2dback":     "assets.boxback",
+	"backcovers":    "assets.boxback",
+	"box2dside":     "assets.boxspine",
+	"box3d":         "assets.boxfull",
+	"3dboxes":       "assets.boxfull",
+	"snap":          "assets.screenshot",
+	"snaps":         "assets.screenshot",
+	"screenshot":    "assets.screenshot",
+	"screenshots":   "assets.screenshot",
+	"wheel":         "assets.logo",
+	"wheels":        "assets.logo",
+	"logo":          "assets.logo",
+	"logos":         "assets.logo",
+	"marquee":       "assets.marquee",
+	"marquees":      "assets.marquee",
+	"video":         "assets.video",
+	"videos":        "assets.video",
+	"bezel":         "assets.bezel",
+	"bezels":        "assets.bezel",
+	"support":       "assets.cartridge",
+	"cartridge":     "assets.cartridge",
+	"physicalmedia": "assets.cartridge",
+	"fanart":        "assets.background",
+	"titlescreen":   "assets.titlescreen",
+	"titlescreens":  "assets.titlescreen",
+}
+
+type ImportMediaCommand struct {
+	dir       string
+	pack      string
+	system    string
+	link      string
+	noFuzzy   bool
+	overwrite bool
+	layoutArg string
+	apply     bool
+}
+
+func NewImportMediaCommand() *ImportMediaCommand {
+	return &ImportMediaCommand{}
+}
+
+func (c *ImportMediaCommand) Name() string { return "import-media" }
+
+func (c *ImportMediaCommand) Desc() string {
+	return "从 Skraper / ES 格式的媒体包导入图片与视频到游戏的媒体目录"
+}
+
+func (c *ImportMediaCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringVar(&c.pack, "pack", "", "媒体包目录，结构为 <pack>/<平台>/<类型>/<rom名>.png")
+	f.StringVar(&c.system, "system", "", "指定媒体包中的平台目录名，默认按合集名、简称或 metadata 所在目录名匹配")
+	f.StringVar(&c.link, "link", importLinkCopy, "导入方式: copy(复制) / hardlink(硬链接，需同一文件系统)")
+	f.BoolVar(&c.noFuzzy, "no-fuzzy", false, "只按 ROM 名匹配，不使用游戏名模糊匹配")
+	f.BoolVar(&c.overwrite, "overwrite", false, "覆盖游戏已有的同类媒体")
+	f.StringVar(&c.layoutArg, "media-layout", defaultMediaLayoutPattern, mediaLayoutFlagDesc)
+	f.BoolVar(&c.apply, "apply", false, "执行导入，默认只打印计划")
+}
+
+func (c *ImportMediaCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("import-media requires --dir")
+	}
+	if strings.TrimSpace(c.pack) == "" {
+		return errors.New("import-media requires --pack")
+	}
+	if c.link != importLinkCopy && c.link != importLinkHardlink {
+		return fmt.Errorf("invalid link mode %q, want copy or hardlink", c.link)
+	}
+	if _, err := parseMediaLayout(c.layoutArg); err != nil {
+		return err
+	}
+	logutil.GetLogger(ctx).Info("starting media import",
+		zap.String("dir", c.dir),
+		zap.String("pack", c.pack),
+		zap.String("link", c.link),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *ImportMediaCommand) Run(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	layout, err := parseMediaLayout(c.layoutArg)
+	if err != nil {
+		return err
+	}
+	report, err := importMediaPack(c.dir, &mediaImportOptions{
+		Pack:      c.pack,
+		System:    c.system,
+		Link:      c.link,
+		Fuzzy:     !c.noFuzzy,
+		Overwrite: c.overwrite,
+		Layout:    layout,
+		Apply:     c.apply,
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range report.Imports {
+		fmt.Println(item.String())
+	}
+	logger.Info("media import completed",
+		zap.Int("metadata_found", report.MetadataFiles),
+		zap.Int("games_checked", report.Games),
+		zap.Int("files_imported", len(report.Imports)),
+		zap.Strings("systems_not_found", report.Unmatched),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *ImportMediaCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("import-media", func() IRunner { return NewImportMediaCommand() })
+}
+
+type mediaImportOptions struct {
+	Pack      string
+	System    string
+	Link      string
+	Fuzzy     bool
+	Overwrite bool
+	// Layout places files in collections without their own x-media-layout.
+	Layout *mediaLayout
+	Apply  bool
+}
+
+// mediaImport is one pack file matched to a game field.
+type mediaImport struct {
+	Game   string
+	Key    string
+	Source string
+	Dest   string
+	Match  string
+}
+
+func (m *mediaImport) String() string {
+	return fmt.Sprintf("%s %s <- %s (%s)", m.Game, m.Key, m.Source, m.Match)
+}
+
+type mediaImportReport struct {
+	MetadataFiles int
+	Games         int
+	Imports       []*mediaImport
+	// Unmatched lists the metadata files with no system folder in the pack.
+	Unmatched []string
+}
+
+// packFolder indexes the files of one type folder by lower-case basename
+// and by normalised title.
+type packFolder struct {
+	key     string
+	byName  map[string]string
+	byTitle map[string]string
+}
+
+// importMediaPack copies the files of a <pack>/<system>/<type>/ media pack
+// into media/<rombase>/ of the games under root, matching by ROM basename
+// and, with Fuzzy set, by title. Games keep the media they already have
+// unless Overwrite is set.
+func importMediaPack(root string, opts *mediaImportOptions) (*mediaImportReport, error) {
+	systems, err := listPackSystems(opts.Pack)
+	if err != nil {
+		return nil, err
+	}
+	files, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	report := &mediaImportReport{MetadataFiles: len(files)}
+	for _, metadataPath := range files {
+		if err := importMetadataMedia(root, metadataPath, systems, opts, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// listPackSystems maps the normalised names of the system folders of a pack
+// to their paths.
+func listPackSystems(pack string) (map[string]string, error) {
+	entries, err := os.ReadDir(pack)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			out[scraper.NormalizeTitle(entry.Name())] = filepath.Join(pack, entry.Name())
+		}
+	}
+	return out, nil
+}
+
+// findPackSystem picks the system folder of a metadata file: the --system
+// override, else the first of its collection names, short names and
+// directory name that the pack has.
+func findPackSystem(systems map[string]string, metadataPath string, doc *metadata.Document, override string) string {
+	candidates := []string{override}
+	if override == "" {
+		cols, _ := doc.Collections()
+		for _, col := range cols {
+			candidates = append(candidates, col.ShortName, col.Name)
+		}
+		candidates = append(candidates, filepath.Base(filepath.Dir(metadataPath)))
+	}
+	for _, name := range candidates {
+		if dir, ok := systems[scraper.NormalizeTitle(name)]; ok && name != "" {
+			return dir
+		}
+	}
+	return ""
+}
+
+func loadPackFolders(systemDir string) ([]*packFolder, error) {
+	entries, err := os.ReadDir(systemDir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*packFolder
+	for _, entry := range entries {
+		key, ok := packTypeAssets[strings.ToLower(entry.Name())]
+		if !entry.IsDir() || !ok {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(systemDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		folder := &packFolder{key: key, byName: make(map[string]string), byTitle: make(map[string]string)}
+		for _, file := range files {
+			kind := media.KindOfExt(file.Name())
+			if file.IsDir() || (kind != media.KindImage && kind != media.KindVideo) {
+				continue
+			}
+			path := filepath.Join(systemDir, entry.Name(), file.Name())
+			stem := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+			if _, exists := folder.byName[strings.ToLower(stem)]; !exists {
+				folder.byName[strings.ToLower(stem)] = path
+			}
+			if title := scraper.NormalizeTitle(stem); title != "" {
+				if _, exists := folder.byTitle[title]; !exists {
+					folder.byTitle[title] = path
+				}
+			}
+		}
+		out = append(out, folder)
+	}
+	// Folders of the same field apply in name order, the first match wins.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out, nil
+}
+
+// match finds the file of a game by ROM basename, then by title.
+func (f *packFolder) match(romBase, title string, fuzzy bool) (string, string) {
+	if path, ok := f.byName[strings.ToLower(romBase)]; ok && romBase != "" {
+		return path, importMatchRom
+	}
+	if !fuzzy {
+		return "", ""
+	}
+	for _, name := range []string{title, romBase} {
+		if path, ok := f.byTitle[scraper.NormalizeTitle(name)]; ok && scraper.NormalizeTitle(name) != "" {
+			return path, importMatchTitle
+		}
+	}
+	return "", ""
+}
+
+func importMetadataMedia(root, metadataPath string, systems map[string]string, opts *mediaImportOptions, report *mediaImportReport) error {
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	relPath := func(path, base string) string {
+		if rel, err := filepath.Rel(base, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+		return filepath.ToSlash(path)
+	}
+	systemDir := findPackSystem(systems, metadataPath, doc, opts.System)
+	if systemDir == "" {
+		report.Unmatched = append(report.Unmatched, relPath(metadataPath, root))
+		return nil
+	}
+	folders, err := loadPackFolders(systemDir)
+	if err != nil {
+		return err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	changed := false
+	for _, block := range doc.Blocks {
+		if block == nil || block.Kind != metadata.KindGame {
+			continue
+		}
+		report.Games++
+		title := getBlockTitle(block)
+		romBase := deriveRomBase(extractBlockFiles(block))
+		layout := mediaLayoutFor(doc, block, opts.Layout)
+		current := blockFieldValues(layout, metadataDir, block)
+		done := make(map[string]struct{})
+		for _, folder := range folders {
+			if _, ok := done[folder.key]; ok {
+				continue
+			}
+			if len(current[folder.key]) > 0 && !opts.Overwrite {
+				continue
+			}
+			source, match := folder.match(romBase, title, opts.Fuzzy)
+			if source == "" {
+				continue
+			}
+			done[folder.key] = struct{}{}
+			dest := layout.assetPath(metadataDir, gameMediaBase(block), folder.key) + strings.ToLower(filepath.Ext(source))
+			report.Imports = append(report.Imports, &mediaImport{
+				Game:   title,
+				Key:    folder.key,
+				Source: relPath(source, opts.Pack),
+				Dest:   relPath(dest, root),
+				Match:  match,
+			})
+			if !opts.Apply {
+				continue
+			}
+			if err := importPackFile(source, dest, opts.Link); err != nil {
+				return fmt.Errorf("import %s for %s: %w", source, title, err)
+			}
+			setBlockField(block, folder.key, []string{relPath(dest, metadataDir)})
+			changed = true
+		}
+	}
+	if changed {
+		return metadata.WriteMetadataFile(metadataPath, doc)
+	}
+	return nil
+}
+
+func importPackFile(source, dest, link string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if link == importLinkHardlink {
+		return replaceWithHardlink(source, dest)
+	}
+	tmp := dest + ".retrog-import"
+	if err := copyFileContents(source, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportMediaPack(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "snes", "metadata.pegasus.txt")
+	original := "collection: Super Nintendo\nshortname: snes\n\ngame: Alpha Mission\nfile: alpha.sfc\n\ngame: Beta Quest\nfile: bq.sfc\nassets.logo: media/bq/logo.png\n\ngame: Gamma\nfile: gamma.sfc\n"
+	writeTestFile(t, metaPath, original)
+	writeTestFile(t, filepath.Join(root, "gba", "metadata.pegasus.txt"), "collection: Game Boy Advance\n\ngame: Delta\nfile: delta.gba\n")
+
+	pack := t.TempDir()
+	writeTestPNG(t, filepath.Join(pack, "SNES", "boxart", "alpha.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(pack, "SNES", "wheel", "Beta Quest (USA).png"), 8, 8, true)
+	writeTestFile(t, filepath.Join(pack, "SNES", "videos", "Beta Quest (USA).mp4"), "video")
+	writeTestFile(t, filepath.Join(pack, "SNES", "manuals", "alpha.pdf"), "manual")
+	writeTestFile(t, filepath.Join(pack, "SNES", "snap", "alpha.txt"), "not media")
+
+	opts := &mediaImportOptions{Pack: pack, Link: importLinkCopy, Fuzzy: true}
+	report, err := importMediaPack(root, opts)
+	if err != nil {
+		t.Fatalf("import media: %v", err)
+	}
+	assert.Equal(t, []string{"gba/metadata.pegasus.txt"}, report.Unmatched)
+	got := make(map[string]string)
+	for _, item := range report.Imports {
+		got[item.Game+" "+item.Key] = item.Dest + " " + item.Match
+	}
+	assert.Equal(t, map[string]string{
+		"Alpha Mission assets.boxfront": "snes/media/alpha/boxFront.png rom",
+		"Beta Quest assets.video":       "snes/media/bq/video.mp4 title",
+	}, got, "Beta Quest keeps its logo")
+	assert.Equal(t, original, readTestFile(t, metaPath), "dry run writes nothing")
+
+	opts.Apply = true
+	opts.Link = importLinkHardlink
+	if _, err := importMediaPack(root, opts); err != nil {
+		t.Fatalf("import media: %v", err)
+	}
+	data := readTestFile(t, metaPath)
+	assert.Contains(t, data, "assets.boxfront: media/alpha/boxFront.png")
+	assert.Contains(t, data, "assets.video: media/bq/video.mp4")
+	src, _ := os.Stat(filepath.Join(pack, "SNES", "boxart", "alpha.png"))
+	dst, err := os.Stat(filepath.Join(root, "snes", "media", "alpha", "boxFront.png"))
+	if assert.NoError(t, err) {
+		assert.True(t, os.SameFile(src, dst), "imported as a hard link")
+	}
+
+	opts.Apply = false
+	opts.Overwrite = true
+	opts.Fuzzy = false
+	report, err = importMediaPack(root, opts)
+	if err != nil {
+		t.Fatalf("import media: %v", err)
+	}
+	if assert.Len(t, report.Imports, 1) {
+		assert.Equal(t, "assets.boxfront", report.Imports[0].Key)
+	}
+}
@@ -0,0 +1,532 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/media"
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+const (
+	defaultMediaLayoutPattern = "media/{rom}/{asset}"
+	// mediaLayoutEntryKey overrides the layout of a collection's games.
+	mediaLayoutEntryKey = "x-media-layout"
+
+	layoutRomToken   = "{rom}"
+	layoutAssetToken = "{asset}"
+
+	mediaLayoutFlagDesc = "媒体目录布局，{rom} 为 ROM 名、{asset} 为媒体类型，可附加 类型=目录名，例如 media/{asset}/{rom} 或 {asset}/{rom},boxfront=box,screenshot=snap；合集可用 x-media-layout 字段单独指定"
+)
+
+// mediaLayout says where the media of a game live, relative to its
+// metadata directory. The pattern has no extension and holds {rom}, the
+// ROM basename, and {asset}, the asset name:
+//
+//	media/{rom}/{asset}    media/sf2/boxFront.png (default)
+//	media/{asset}/{rom}    media/boxFront/sf2.png
+//	{asset}/{rom},boxfront=box,screenshot=snap    box/sf2.png, snap/sf2.png
+//
+// The name=folder pairs replace the {asset} value of a field.
+type mediaLayout struct {
+	pattern string
+	names   map[string]string
+	assets  map[string]string
+}
+
+// parseMediaLayout parses "pattern[,asset=name...]"; "" is the default.
+func parseMediaLayout(spec string) (*mediaLayout, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = defaultMediaLayoutPattern
+	}
+	parts := strings.Split(spec, ",")
+	pattern := path.Clean(strings.ReplaceAll(strings.TrimSpace(parts[0]), "\\", "/"))
+	if strings.Count(pattern, layoutRomToken) != 1 || strings.Count(pattern, layoutAssetToken) != 1 {
+		return nil, fmt.Errorf("invalid media layout %q: the pattern needs {rom} and {asset} once each", spec)
+	}
+	if path.IsAbs(pattern) || strings.ContainsAny(pattern, "*?[:") {
+		return nil, fmt.Errorf("invalid media layout %q: the pattern must be a relative path", spec)
+	}
+	for _, seg := range strings.Split(pattern, "/") {
+		rest := strings.NewReplacer(layoutRomToken, "", layoutAssetToken, "").Replace(seg)
+		if seg == ".." || strings.ContainsAny(rest, "{}") {
+			return nil, fmt.Errorf("invalid media layout %q: bad segment %q", spec, seg)
+		}
+	}
+	l := &mediaLayout{pattern: pattern, names: make(map[string]string), assets: make(map[string]string)}
+	for _, pair := range parts[1:] {
+		asset, name, ok := strings.Cut(pair, "=")
+		asset = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(asset)), "assets.")
+		name = strings.TrimSpace(name)
+		if !ok || asset == "" || name == "" || name != sanitizeFileComponent(name) {
+			return nil, fmt.Errorf("invalid media layout %q: bad asset name %q, want asset=name", spec, pair)
+		}
+		l.names[asset] = name
+		l.assets[strings.ToLower(name)] = asset
+	}
+	return l, nil
+}
+
+func defaultMediaLayout() *mediaLayout {
+	l, _ := parseMediaLayout("")
+	return l
+}
+
+func layoutOrDefault(l *mediaLayout) *mediaLayout {
+	if l == nil {
+		return defaultMediaLayout()
+	}
+	return l
+}
+
+func (l *mediaLayout) String() string {
+	assets := make([]string, 0, len(l.names))
+	for asset := range l.names {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+	parts := []string{l.pattern}
+	for _, asset := range assets {
+		parts = append(parts, asset+"="+l.names[asset])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *mediaLayout) isDefault() bool {
+	return l.String() == defaultMediaLayoutPattern
+}
+
+// assetName is the {asset} value of an assets.* field.
+func (l *mediaLayout) assetName(key string) string {
+	asset := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(key)), "assets.")
+	if name, ok := l.names[asset]; ok {
+		return name
+	}
+	return assetFileBaseFromKey("assets." + asset)
+}
+
+// nameFor is the {asset} value of a file found as asset name by another
+// layout: known assets get their usual spelling, others keep their name.
+func (l *mediaLayout) nameFor(name string) string {
+	lower := strings.ToLower(name)
+	if alias, ok := l.names[lower]; ok {
+		return alias
+	}
+	if canonical := assetFileBaseFromKey("assets." + lower); canonical != lower {
+		return canonical
+	}
+	return name
+}
+
+// assetPath is where the file of field key goes, without extension.
+func (l *mediaLayout) assetPath(metadataDir, romBase, key string) string {
+	return l.pathFor(metadataDir, romBase, l.assetName(key))
+}
+
+func (l *mediaLayout) pathFor(metadataDir, romBase, name string) string {
+	rel := strings.NewReplacer(layoutRomToken, romBase, layoutAssetToken, name).Replace(l.pattern)
+	return filepath.Join(metadataDir, filepath.FromSlash(rel))
+}
+
+// romDir returns the folder holding every media file of romBase, or "" when
+// the layout does not keep one folder per ROM.
+func (l *mediaLayout) romDir(metadataDir, romBase string) string {
+	dir, file := path.Split(l.pattern)
+	dir = path.Clean(dir)
+	if file != layoutAssetToken || path.Base(dir) != layoutRomToken || romBase == "" {
+		return ""
+	}
+	return filepath.Join(metadataDir, filepath.FromSlash(strings.Replace(dir, layoutRomToken, romBase, 1)))
+}
+
+// romRoot returns the folder holding the per-ROM folders of layouts like
+// media/{rom}/{asset}, or "" for other layouts.
+func (l *mediaLayout) romRoot(metadataDir string) string {
+	root := path.Dir(path.Dir(l.pattern))
+	if l.romDir(metadataDir, "rom") == "" || root == "." || strings.Contains(root, "{") {
+		return ""
+	}
+	return filepath.Join(metadataDir, filepath.FromSlash(root))
+}
+
+// findAssets lists the files of romBase laid out by l, sorted by path. Name
+// is the asset the file was found as. When {asset} names a folder, only
+// images and videos count, as such folders sit next to the ROMs.
+func (l *mediaLayout) findAssets(metadataDir, romBase string) []gameAssetFile {
+	if romBase == "" {
+		return nil
+	}
+	var out []gameAssetFile
+	l.walkAssets(metadataDir, romBase, func(_ string, file gameAssetFile) {
+		out = append(out, file)
+	})
+	return out
+}
+
+// indexAssets finds the files of every ROM laid out by l in a single walk,
+// keyed by ROM basename, each list sorted by path like findAssets.
+func (l *mediaLayout) indexAssets(metadataDir string) map[string][]gameAssetFile {
+	out := make(map[string][]gameAssetFile)
+	l.walkAssets(metadataDir, "", func(rom string, file gameAssetFile) {
+		out[rom] = append(out[rom], file)
+	})
+	return out
+}
+
+// walkAssets visits the files laid out by l under metadataDir together with
+// the ROM they belong to. With romBase set only the files of that ROM are
+// visited; with "" those of every ROM, a name that splits several ways
+// counting for each ROM it could be.
+func (l *mediaLayout) walkAssets(metadataDir, romBase string, visit func(rom string, file gameAssetFile)) {
+	segs := strings.Split(l.pattern, "/")
+	last := len(segs) - 1
+	strict := !strings.Contains(segs[last], layoutAssetToken)
+	matchers := make([]*segmentMatcher, len(segs))
+	for idx, seg := range segs {
+		matchers[idx] = newSegmentMatcher(seg, romBase)
+	}
+	var walk func(dir string, idx int, rom, asset string)
+	walk = func(dir string, idx int, rom, asset string) {
+		seg := segs[idx]
+		if idx < last && !strings.Contains(seg, "{") {
+			walk(filepath.Join(dir, seg), idx+1, rom, asset)
+			return
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			isDir := entry.IsDir()
+			if entry.Type()&os.ModeSymlink != 0 {
+				if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+					isDir = info.IsDir()
+				}
+			}
+			if strings.HasPrefix(name, ".") || isDir != (idx < last) {
+				continue
+			}
+			if idx == last {
+				if stem := strings.TrimSuffix(name, filepath.Ext(name)); stem != "" {
+					name = stem
+				}
+			}
+			for _, m := range matchers[idx].match(name) {
+				foundRom, found := rom, asset
+				if m.rom != "" {
+					foundRom = m.rom
+				}
+				if m.asset != "" {
+					found = m.asset
+				}
+				if idx < last {
+					walk(filepath.Join(dir, entry.Name()), idx+1, foundRom, found)
+					continue
+				}
+				kind := media.KindOfExt(entry.Name())
+				if strict && kind != media.KindImage && kind != media.KindVideo {
+					continue
+				}
+				if alias, ok := l.assets[strings.ToLower(found)]; ok {
+					found = alias
+				}
+				visit(foundRom, gameAssetFile{Name: found, Path: filepath.Join(dir, entry.Name())})
+			}
+		}
+	}
+	walk(metadataDir, 0, romBase, "")
+}
+
+// segmentMatch is one way a name fits a pattern segment.
+type segmentMatch struct {
+	rom, asset string
+}
+
+// segmentMatcher matches names against one pattern segment. A segment
+// holding {rom} matches the given ROM only or, when there is none, every
+// ROM the name could hold: the name is split at each position the literal
+// text around {rom} allows.
+type segmentMatcher struct {
+	fixed *regexp.Regexp
+	rom   string
+	// open matching, for {rom} without a ROM
+	before, after   string
+	head, tail      *regexp.Regexp
+	assetBeforeRoms bool
+}
+
+func newSegmentMatcher(seg, romBase string) *segmentMatcher {
+	idx := strings.Index(seg, layoutRomToken)
+	if idx < 0 || romBase != "" {
+		m := &segmentMatcher{fixed: segmentRegexp(seg, romBase)}
+		if idx >= 0 {
+			m.rom = romBase
+		}
+		return m
+	}
+	before, after := seg[:idx], seg[idx+len(layoutRomToken):]
+	return &segmentMatcher{
+		before:          before,
+		after:           after,
+		head:            segmentRegexp(before, ""),
+		tail:            segmentRegexp(after, ""),
+		assetBeforeRoms: strings.Contains(before, layoutAssetToken),
+	}
+}
+
+func (m *segmentMatcher) match(name string) []segmentMatch {
+	if m.fixed != nil {
+		found := m.fixed.FindStringSubmatch(name)
+		if found == nil {
+			return nil
+		}
+		res := segmentMatch{rom: m.rom}
+		if len(found) > 1 {
+			res.asset = found[1]
+		}
+		return []segmentMatch{res}
+	}
+	var out []segmentMatch
+	if m.assetBeforeRoms {
+		// {asset} comes first, so the ROM ends where the literal tail starts.
+		if !strings.HasSuffix(name, m.after) {
+			return nil
+		}
+		end := len(name) - len(m.after)
+		for start := 0; start < end; start++ {
+			if found := m.head.FindStringSubmatch(name[:start]); found != nil {
+				out = append(out, segmentMatch{rom: name[start:end], asset: found[1]})
+			}
+		}
+		return out
+	}
+	if !strings.HasPrefix(name, m.before) {
+		return nil
+	}
+	start := len(m.before)
+	for end := len(name); end > start; end-- {
+		if found := m.tail.FindStringSubmatch(name[end:]); found != nil {
+			res := segmentMatch{rom: name[start:end]}
+			if len(found) > 1 {
+				res.asset = found[1]
+			}
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// mediaAssetIndex serves findAssets for the games of one metadata directory
+// from a single walk per layout, so loading a collection lists the layout's
+// directories once rather than once per game.
+type mediaAssetIndex struct {
+	metadataDir string
+	byLayout    map[string]map[string][]gameAssetFile
+}
+
+func newMediaAssetIndex(metadataDir string) *mediaAssetIndex {
+	return &mediaAssetIndex{metadataDir: metadataDir, byLayout: make(map[string]map[string][]gameAssetFile)}
+}
+
+// findAssets is l.findAssets(metadataDir, romBase). A nil index, or one for
+// another directory, asks the file system directly.
+func (x *mediaAssetIndex) findAssets(l *mediaLayout, metadataDir, romBase string) []gameAssetFile {
+	if x == nil || metadataDir != x.metadataDir {
+		return l.findAssets(metadataDir, romBase)
+	}
+	if romBase == "" {
+		return nil
+	}
+	key := l.String()
+	files, ok := x.byLayout[key]
+	if !ok {
+		files = l.indexAssets(metadataDir)
+		x.byLayout[key] = files
+	}
+	return files[romBase]
+}
+
+// segmentRegexp matches one path segment of a pattern, capturing {asset}.
+func segmentRegexp(seg, romBase string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for seg != "" {
+		rom := strings.Index(seg, layoutRomToken)
+		asset := strings.Index(seg, layoutAssetToken)
+		switch {
+		case rom == 0:
+			b.WriteString(regexp.QuoteMeta(romBase))
+			seg = seg[len(layoutRomToken):]
+		case asset == 0:
+			b.WriteString("(.+?)")
+			seg = seg[len(layoutAssetToken):]
+		default:
+			next := len(seg)
+			for _, idx := range []int{rom, asset} {
+				if idx > 0 && idx < next {
+					next = idx
+				}
+			}
+			b.WriteString(regexp.QuoteMeta(seg[:next]))
+			seg = seg[next:]
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// collectionMediaLayout reads the x-media-layout of a collection block.
+func collectionMediaLayout(blk *metadata.Block, fallback *mediaLayout) (*mediaLayout, error) {
+	if blk != nil {
+		if entry := blk.Entry(mediaLayoutEntryKey); entry != nil && len(entry.Values) > 0 && strings.TrimSpace(entry.Values[0]) != "" {
+			return parseMediaLayout(entry.Values[0])
+		}
+	}
+	return layoutOrDefault(fallback), nil
+}
+
+// mediaLayoutFor returns the layout of the collection block belongs to, or
+// of the first collection when block is nil. An invalid x-media-layout
+// falls back, it is reported when the collections are loaded.
+func mediaLayoutFor(doc *metadata.Document, block *metadata.Block, fallback *mediaLayout) *mediaLayout {
+	var current *metadata.Block
+	for _, blk := range doc.Blocks {
+		if blk == nil {
+			continue
+		}
+		if blk.Kind == metadata.KindCollection {
+			if block == nil {
+				current = blk
+				break
+			}
+			current = blk
+		}
+		if blk == block {
+			break
+		}
+	}
+	layout, err := collectionMediaLayout(current, fallback)
+	if err != nil {
+		return layoutOrDefault(fallback)
+	}
+	return layout
+}
+
+// mediaMove moves a media file, or a whole per-ROM folder.
+type mediaMove struct {
+	From string
+	To   string
+}
+
+// planMediaRename lists the moves that keep the media of a game with its
+// ROM when the ROM base changes. Layouts with one folder per ROM move the
+// folder as a whole.
+func planMediaRename(layout *mediaLayout, metadataDir, oldBase, newBase string) []*mediaMove {
+	layout = layoutOrDefault(layout)
+	if dir := layout.romDir(metadataDir, oldBase); dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil
+		}
+		return []*mediaMove{{From: dir, To: layout.romDir(metadataDir, newBase)}}
+	}
+	var out []*mediaMove
+	for _, file := range layout.findAssets(metadataDir, oldBase) {
+		to := layout.pathFor(metadataDir, newBase, layout.nameFor(file.Name)) + filepath.Ext(file.Path)
+		out = append(out, &mediaMove{From: file.Path, To: to})
+	}
+	return out
+}
+
+// checkMediaMoves reports a move that would overwrite a file, including a
+// file of a folder merged into an existing one.
+func checkMediaMoves(moves []*mediaMove) error {
+	for _, move := range moves {
+		info, err := os.Stat(move.From)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			if _, err := os.Lstat(move.To); err == nil {
+				return fmt.Errorf("media file %s already exists", filepath.ToSlash(move.To))
+			}
+			continue
+		}
+		if _, err := os.Stat(move.To); err != nil {
+			continue
+		}
+		entries, err := os.ReadDir(move.From)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				return fmt.Errorf("media folder %s contains sub directory %s", filepath.ToSlash(move.From), entry.Name())
+			}
+			if _, err := os.Lstat(filepath.Join(move.To, entry.Name())); err == nil {
+				return fmt.Errorf("media file %s already exists", filepath.ToSlash(filepath.Join(move.To, entry.Name())))
+			}
+		}
+	}
+	return nil
+}
+
+// applyMediaMoves performs moves, checking first that no file is
+// overwritten. Folders merge into existing ones like orphan media do.
+func applyMediaMoves(tx *historyTx, moves []*mediaMove) error {
+	if err := checkMediaMoves(moves); err != nil {
+		return err
+	}
+	for _, move := range moves {
+		info, err := os.Stat(move.From)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = mergeMediaDir(tx, move.From, move.To)
+		} else {
+			err = tx.rename(move.From, move.To)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteMovedAssets points the assets.* values of block that referenced a
+// moved file, or a file in a moved folder, at the new location.
+func rewriteMovedAssets(metadataDir string, block *metadata.Block, moves []*mediaMove) error {
+	for _, entry := range block.Entries {
+		if entry == nil || !isAssetFieldKey(entry.Key) {
+			continue
+		}
+		for idx, value := range entry.Values {
+			resolved := resolveAssetPath(metadataDir, value)
+			for _, move := range moves {
+				target := ""
+				if resolved == move.From {
+					target = move.To
+				} else if rest, ok := strings.CutPrefix(resolved, move.From+string(os.PathSeparator)); ok {
+					target = filepath.Join(move.To, rest)
+				}
+				if target == "" {
+					continue
+				}
+				rel, err := filepath.Rel(metadataDir, target)
+				if err != nil {
+					return err
+				}
+				entry.Values[idx] = filepath.ToSlash(rel)
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+func TestParseMediaLayout(t *testing.T) {
+	l, err := parseMediaLayout(" {asset}/{rom} , Screenshot=snap,assets.boxfront=box")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	assert.Equal(t, "{asset}/{rom},boxfront=box,screenshot=snap", l.String())
+	assert.Equal(t, filepath.Join("/m", "box", "sf2"), l.assetPath("/m", "sf2", "assets.boxFront"))
+	assert.Equal(t, filepath.Join("/m", "logo", "sf2"), l.assetPath("/m", "sf2", "assets.logo"))
+	assert.Equal(t, "", l.romDir("/m", "sf2"))
+
+	def, err := parseMediaLayout("")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	assert.True(t, def.isDefault())
+	assert.Equal(t, filepath.Join("/m", "media", "sf2"), def.romDir("/m", "sf2"))
+	assert.Equal(t, filepath.Join("/m", "media"), def.romRoot("/m"))
+
+	for _, spec := range []string{"media/{rom}", "{rom}/{asset}/{asset}", "/abs/{rom}/{asset}", "../{rom}/{asset}", "{asset}/{rom},box", "{asset}/{rom},box=a/b"} {
+		_, err := parseMediaLayout(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestMediaLayoutFindAssets(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPNG(t, filepath.Join(dir, "box", "sf2.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(dir, "snap", "sf2.jpg"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(dir, "logo", "sf2.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(dir, "logo", "sf2 turbo.png"), 8, 8, false)
+	writeTestFile(t, filepath.Join(dir, "roms", "sf2.zip"), "rom")
+
+	l, err := parseMediaLayout("{asset}/{rom},boxfront=box,screenshot=snap")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := make(map[string]string)
+	for _, file := range l.findAssets(dir, "sf2") {
+		rel, _ := filepath.Rel(dir, file.Path)
+		got[file.Name] = filepath.ToSlash(rel)
+	}
+	assert.Equal(t, map[string]string{
+		"boxfront":   "box/sf2.png",
+		"screenshot": "snap/sf2.jpg",
+		"logo":       "logo/sf2.png",
+	}, got, "rom zips are not media")
+
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-media-layout: {asset}/{rom},boxfront=box,screenshot=snap\n\ngame: Street Fighter II\nfile: roms/sf2.zip\n")
+	doc, err := metadata.ParseMetadataFile(metaPath)
+	if err != nil {
+		t.Fatalf("parse metadata: %v", err)
+	}
+	layout := mediaLayoutFor(doc, doc.Blocks[1], nil)
+	assert.Equal(t, l.String(), layout.String())
+	_, fallback := resolveGameAssetFiles(nil, layout, dir, metadata.Game{}, "sf2")
+	assert.Equal(t, "box/sf2.png", fallback["boxfront"].Path)
+	orphans, err := findOrphanMedia(doc, dir, layout)
+	assert.NoError(t, err)
+	assert.Empty(t, orphans, "no per-rom folders")
+}
+
+func TestMediaAssetIndex(t *testing.T) {
+	roms := []string{"sf2", "sf2 turbo", "a-b"}
+	for _, spec := range []string{
+		"",
+		"{asset}/{rom},boxfront=box",
+		"media/{asset}/{rom}",
+		"art/{rom}-{asset}",
+		"art/{asset}-{rom}",
+		"{rom}/{asset}",
+	} {
+		l, err := parseMediaLayout(spec)
+		if err != nil {
+			t.Fatalf("parse %q: %v", spec, err)
+		}
+		dir := t.TempDir()
+		for _, rom := range roms {
+			for _, asset := range []string{"boxfront", "logo", "title-x"} {
+				writeTestPNG(t, l.assetPath(dir, rom, "assets."+asset)+".png", 4, 4, false)
+			}
+		}
+		writeTestFile(t, filepath.Join(dir, "roms", "sf2.zip"), "rom")
+
+		index := newMediaAssetIndex(dir)
+		assert.Len(t, l.findAssets(dir, "sf2"), 3, spec)
+		for _, rom := range append(roms, "missing", "") {
+			assert.Equal(t, l.findAssets(dir, rom), index.findAssets(l, dir, rom), "%q %q", spec, rom)
+		}
+		assert.Len(t, index.byLayout, 1, spec)
+	}
+}
+
+func TestMoveFileToMediaLayout(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	source := filepath.Join(t.TempDir(), "123__upload.png")
+	writeTestPNG(t, source, 8, 8, false)
+	block := &metadata.Block{Kind: metadata.KindGame, Entries: []*metadata.Entry{
+		{Key: "game", Values: []string{"Alpha"}},
+		{Key: "file", Values: []string{"alpha.zip"}},
+	}}
+	l, err := parseMediaLayout("media/{asset}/{rom}")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	rel, err := moveFileToMedia(nil, l, metaPath, block, "", source, filepath.Base(source), "assets.boxfront")
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	assert.Equal(t, "media/boxFront/alpha.png", rel)
+	assert.FileExists(t, filepath.Join(dir, "media", "boxFront", "alpha.png"))
+}
+
+func TestRelayoutMedia(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\nassets.boxfront: media/alpha/cover.png\n\ngame: Beta\nfile: beta.zip\n")
+	writeTestPNG(t, filepath.Join(root, "arcade", "media", "alpha", "cover.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "arcade", "media", "alpha", "screenshot.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "arcade", "media", "beta", "logo.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "arcade", "logo", "beta.png"), 8, 8, false)
+
+	to, err := parseMediaLayout("{asset}/{rom},boxfront=box,screenshot=snap")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	report, err := relayoutMedia(root, nil, to, false)
+	if err != nil {
+		t.Fatalf("relayout: %v", err)
+	}
+	var moves []string
+	for _, move := range report.Moves {
+		moves = append(moves, move.String())
+	}
+	assert.Equal(t, []string{
+		"Alpha: arcade/media/alpha/cover.png -> arcade/box/alpha.png",
+		"Alpha: arcade/media/alpha/screenshot.png -> arcade/snap/alpha.png",
+	}, moves)
+	if assert.Len(t, report.Conflicts, 1) {
+		assert.Equal(t, "arcade/logo/beta.png", report.Conflicts[0].To)
+	}
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "alpha", "cover.png"), "dry run moves nothing")
+
+	if _, err := relayoutMedia(root, nil, to, true); err != nil {
+		t.Fatalf("relayout: %v", err)
+	}
+	assert.FileExists(t, filepath.Join(root, "arcade", "box", "alpha.png"))
+	assert.NoDirExists(t, filepath.Join(root, "arcade", "media", "alpha"))
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "beta", "logo.png"))
+	data := readTestFile(t, metaPath)
+	assert.Contains(t, data, "assets.boxfront: box/alpha.png")
+	assert.Contains(t, data, "x-media-layout: {asset}/{rom},boxfront=box,screenshot=snap")
+
+	if err := os.Remove(filepath.Join(root, "arcade", "logo", "beta.png")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := relayoutMedia(root, nil, defaultMediaLayout(), true); err != nil {
+		t.Fatalf("relayout back: %v", err)
+	}
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "alpha", "boxFront.png"))
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "alpha", "screenshot.png"))
+	assert.NoDirExists(t, filepath.Join(root, "arcade", "box"))
+	data = readTestFile(t, metaPath)
+	assert.Contains(t, data, "assets.boxfront: media/alpha/boxFront.png")
+	assert.NotContains(t, data, "x-media-layout")
+}
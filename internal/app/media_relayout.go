@@ -0,0 +1,259 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+type MediaRelayoutCommand struct {
+	dir   string
+	from  string
+	to    string
+	apply bool
+}
+
+func NewMediaRelayoutCommand() *MediaRelayoutCommand {
+	return &MediaRelayoutCommand{}
+}
+
+func (c *MediaRelayoutCommand) Name() string { return "media-relayout" }
+
+func (c *MediaRelayoutCommand) Desc() string {
+	return "把已有媒体文件迁移到新的目录布局，并更新 metadata 引用与合集的 x-media-layout"
+}
+
+func (c *MediaRelayoutCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringVar(&c.from, "from", "", "原布局，默认使用各合集当前的 x-media-layout 或 "+defaultMediaLayoutPattern)
+	f.StringVar(&c.to, "to", "", "新布局，"+mediaLayoutFlagDesc)
+	f.BoolVar(&c.apply, "apply", false, "执行迁移，默认只打印计划")
+}
+
+func (c *MediaRelayoutCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("media-relayout requires --dir")
+	}
+	if strings.TrimSpace(c.to) == "" {
+		return errors.New("media-relayout requires --to")
+	}
+	if _, err := parseMediaLayout(c.to); err != nil {
+		return err
+	}
+	if strings.TrimSpace(c.from) != "" {
+		if _, err := parseMediaLayout(c.from); err != nil {
+			return err
+		}
+	}
+	logutil.GetLogger(ctx).Info("starting media relayout",
+		zap.String("dir", c.dir),
+		zap.String("from", c.from),
+		zap.String("to", c.to),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *MediaRelayoutCommand) Run(ctx context.Context) error {
+	var from *mediaLayout
+	if strings.TrimSpace(c.from) != "" {
+		from, _ = parseMediaLayout(c.from)
+	}
+	to, _ := parseMediaLayout(c.to)
+	report, err := relayoutMedia(c.dir, from, to, c.apply)
+	if err != nil {
+		return err
+	}
+	for _, move := range report.Moves {
+		fmt.Println(move.String())
+	}
+	for _, move := range report.Conflicts {
+		fmt.Printf("跳过 %s: 目标已存在\n", move.String())
+	}
+	logutil.GetLogger(ctx).Info("media relayout completed",
+		zap.Int("metadata_found", report.MetadataFiles),
+		zap.Int("files_moved", len(report.Moves)),
+		zap.Int("conflicts", len(report.Conflicts)),
+		zap.Strings("without_collection", report.NoCollection),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *MediaRelayoutCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("media-relayout", func() IRunner { return NewMediaRelayoutCommand() })
+}
+
+// relayoutMove is one media file moved to the new layout; paths are
+// relative to the root.
+type relayoutMove struct {
+	Game string
+	From string
+	To   string
+}
+
+func (m *relayoutMove) String() string {
+	return fmt.Sprintf("%s: %s -> %s", m.Game, m.From, m.To)
+}
+
+type relayoutReport struct {
+	MetadataFiles int
+	Moves         []*relayoutMove
+	// Conflicts are files left in place because their target exists.
+	Conflicts []*relayoutMove
+	// NoCollection lists metadata files without a collection block to
+	// record the new layout in; pass it with --media-layout instead.
+	NoCollection []string
+}
+
+// relayoutMedia moves the media of every game under root from layout from,
+// or the layout of its collection when from is nil, to layout to. With
+// apply set, assets.* values follow the files and collections record to in
+// x-media-layout.
+func relayoutMedia(root string, from, to *mediaLayout, apply bool) (*relayoutReport, error) {
+	files, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	report := &relayoutReport{MetadataFiles: len(files)}
+	for _, metadataPath := range files {
+		if err := relayoutMetadataMedia(root, metadataPath, from, to, apply, report); err != nil {
+			return nil, fmt.Errorf("relayout %s: %w", metadataPath, err)
+		}
+	}
+	return report, nil
+}
+
+func relayoutMetadataMedia(root, metadataPath string, from, to *mediaLayout, apply bool, report *relayoutReport) error {
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	relPath := func(p string) string {
+		if rel, err := filepath.Rel(root, p); err == nil {
+			return filepath.ToSlash(rel)
+		}
+		return filepath.ToSlash(p)
+	}
+	source := layoutOrDefault(from)
+	var moves []*mediaMove
+	var games []*metadata.Block
+	var collections []*metadata.Block
+	planned := make(map[string]struct{})
+	claimed := make(map[string]struct{})
+	for _, block := range doc.Blocks {
+		if block == nil {
+			continue
+		}
+		if block.Kind == metadata.KindCollection {
+			collections = append(collections, block)
+			if from == nil {
+				if source, err = collectionMediaLayout(block, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if block.Kind != metadata.KindGame {
+			continue
+		}
+		games = append(games, block)
+		romBase := deriveRomBase(extractBlockFiles(block))
+		if romBase == "" {
+			continue
+		}
+		keys := make(map[string]string)
+		for _, entry := range block.Entries {
+			if entry == nil || !isAssetFieldKey(entry.Key) {
+				continue
+			}
+			for _, value := range entry.Values {
+				if p := resolveAssetPath(metadataDir, value); p != "" {
+					keys[p] = entry.Key
+				}
+			}
+		}
+		for _, file := range source.findAssets(metadataDir, romBase) {
+			if _, ok := planned[file.Path]; ok {
+				continue
+			}
+			planned[file.Path] = struct{}{}
+			target := to.pathFor(metadataDir, romBase, to.nameFor(file.Name))
+			if key, ok := keys[file.Path]; ok {
+				target = to.assetPath(metadataDir, romBase, key)
+			}
+			target += filepath.Ext(file.Path)
+			if target == file.Path {
+				continue
+			}
+			move := &relayoutMove{Game: getBlockTitle(block), From: relPath(file.Path), To: relPath(target)}
+			_, taken := claimed[target]
+			if _, err := os.Lstat(target); err == nil || taken {
+				report.Conflicts = append(report.Conflicts, move)
+				continue
+			}
+			claimed[target] = struct{}{}
+			report.Moves = append(report.Moves, move)
+			moves = append(moves, &mediaMove{From: file.Path, To: target})
+		}
+	}
+	if len(collections) == 0 {
+		report.NoCollection = append(report.NoCollection, relPath(metadataPath))
+	}
+	if !apply {
+		return nil
+	}
+	if err := applyMediaMoves(nil, moves); err != nil {
+		return err
+	}
+	for _, move := range moves {
+		removeEmptyDirs(filepath.Dir(move.From), metadataDir)
+	}
+	for _, block := range games {
+		if err := rewriteMovedAssets(metadataDir, block, moves); err != nil {
+			return err
+		}
+	}
+	for _, block := range collections {
+		setCollectionMediaLayout(block, to)
+	}
+	return metadata.WriteMetadataFile(metadataPath, doc)
+}
+
+// setCollectionMediaLayout records l in x-media-layout, dropping the field
+// for the default layout.
+func setCollectionMediaLayout(block *metadata.Block, l *mediaLayout) {
+	if !l.isDefault() {
+		setBlockField(block, mediaLayoutEntryKey, []string{l.String()})
+		return
+	}
+	entries := block.Entries[:0]
+	for _, entry := range block.Entries {
+		if entry == nil || !strings.EqualFold(entry.Key, mediaLayoutEntryKey) {
+			entries = append(entries, entry)
+		}
+	}
+	block.Entries = entries
+}
+
+// removeEmptyDirs removes dir and its parents below stop while they are
+// empty.
+func removeEmptyDirs(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop+string(os.PathSeparator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,328 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/constant"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"github.com/xxxsen/retrog/internal/textdiff"
+	"go.uber.org/zap"
+)
+
+const replaceDiffContext = 3
+
+type ReplaceCommand struct {
+	dir         string
+	keys        []string
+	find        string
+	replacement string
+	regex       bool
+	apply       bool
+}
+
+func NewReplaceCommand() *ReplaceCommand {
+	return &ReplaceCommand{}
+}
+
+func (c *ReplaceCommand) Name() string { return "replace" }
+
+func (c *ReplaceCommand) Desc() string {
+	return "在 metadata.pegasus.txt 的指定字段中批量查找替换, 默认仅预览 diff"
+}
+
+func (c *ReplaceCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringSliceVar(&c.keys, "keys", nil, "需要替换的字段, 逗号分隔, 支持 assets.* 这样的前缀匹配")
+	f.StringVar(&c.find, "find", "", "查找内容")
+	f.StringVar(&c.replacement, "replace", "", "替换内容, 正则模式下支持 $1 引用分组")
+	f.BoolVar(&c.regex, "regex", false, "将 --find 作为正则表达式")
+	f.BoolVar(&c.apply, "apply", false, "写入修改, 默认仅输出 diff 预览")
+}
+
+func (c *ReplaceCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("replace requires --dir")
+	}
+	if len(c.keys) == 0 {
+		return errors.New("replace requires --keys")
+	}
+	if c.find == "" {
+		return errors.New("replace requires --find")
+	}
+	logutil.GetLogger(ctx).Info("starting replace",
+		zap.String("dir", c.dir),
+		zap.Strings("keys", c.keys),
+		zap.Bool("regex", c.regex),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *ReplaceCommand) Run(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	files, err := findMetadataFiles(c.dir)
+	if err != nil {
+		return err
+	}
+	plan, err := planMetadataReplace(c.dir, files, &metadataReplaceOptions{
+		Keys:        c.keys,
+		Find:        c.find,
+		Replacement: c.replacement,
+		Regex:       c.regex,
+	})
+	if err != nil {
+		return err
+	}
+	replaced := 0
+	for _, change := range plan {
+		fmt.Print(change.Diff)
+		replaced += change.Count
+	}
+	if c.apply {
+		if err := applyMetadataReplace(nil, plan); err != nil {
+			return err
+		}
+	}
+	logger.Info("replace completed",
+		zap.Int("metadata_found", len(files)),
+		zap.Int("metadata_changed", len(plan)),
+		zap.Int("values_replaced", replaced),
+		zap.Bool("applied", c.apply),
+	)
+	return nil
+}
+
+func (c *ReplaceCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("replace", func() IRunner { return NewReplaceCommand() })
+}
+
+type metadataReplaceOptions struct {
+	Keys        []string
+	Find        string
+	Replacement string
+	Regex       bool
+}
+
+// metadataReplaceChange describes the pending rewrite of a single metadata
+// file.
+type metadataReplaceChange struct {
+	Path    string
+	RelPath string
+	Count   int
+	Diff    string
+	// Hash identifies the content the change was planned against.
+	Hash     string
+	original []byte
+	doc      *metadata.Document
+}
+
+func findMetadataFiles(root string) ([]string, error) {
+	var out []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.EqualFold(d.Name(), constant.DefaultMetadataFile) {
+			out = append(out, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// planMetadataReplace runs the replacement on every file in memory and
+// returns the files that would change. Nothing is written, so a failure in
+// any file leaves all of them untouched.
+func planMetadataReplace(root string, files []string, opts *metadataReplaceOptions) ([]*metadataReplaceChange, error) {
+	matchKey, err := newKeyMatcher(opts.Keys)
+	if err != nil {
+		return nil, err
+	}
+	replacer, err := newValueReplacer(opts.Find, opts.Replacement, opts.Regex)
+	if err != nil {
+		return nil, err
+	}
+	var plan []*metadataReplaceChange
+	for _, path := range files {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read metadata %s: %w", path, err)
+		}
+		doc, err := metadata.ParseMetadataFile(path)
+		if err != nil {
+			return nil, err
+		}
+		count, err := replaceDocumentValues(doc, matchKey, replacer)
+		if err != nil {
+			return nil, fmt.Errorf("replace in %s: %w", path, err)
+		}
+		if count == 0 {
+			continue
+		}
+		// The diff is against the file on disk and the exact bytes
+		// WriteMetadataFile will write, so comments and formatting the
+		// rewrite drops show up in the preview.
+		after, err := metadata.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+		plan = append(plan, &metadataReplaceChange{
+			Path:     path,
+			RelPath:  rel,
+			Count:    count,
+			Diff:     textdiff.Unified("a/"+rel, "b/"+rel, string(original), string(after), replaceDiffContext),
+			Hash:     replaceContentHash(original),
+			original: original,
+			doc:      doc,
+		})
+	}
+	return plan, nil
+}
+
+// applyMetadataReplace writes every planned change, refusing to touch any
+// file when one of them was modified after the plan was made. The writes
+// are recorded in tx, or a transaction of their own when tx is nil, and
+// rolled back when one of them fails.
+func applyMetadataReplace(tx *historyTx, plan []*metadataReplaceChange) error {
+	for _, change := range plan {
+		current, err := os.ReadFile(change.Path)
+		if err != nil {
+			return fmt.Errorf("read metadata %s: %w", change.Path, err)
+		}
+		if !bytes.Equal(current, change.original) {
+			return fmt.Errorf("metadata %s changed since preview", change.Path)
+		}
+	}
+	if tx == nil {
+		tx = newHistoryTx("replace", "")
+	}
+	if err := writeMetadataReplace(tx, plan); err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			return fmt.Errorf("%w; rollback failed: %v", err, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func writeMetadataReplace(tx *historyTx, plan []*metadataReplaceChange) error {
+	for _, change := range plan {
+		if err := tx.snapshot(change.Path); err != nil {
+			return err
+		}
+		if err := metadata.WriteMetadataFile(change.Path, change.doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceContentHash fingerprints a metadata file as the preview saw it.
+func replaceContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// replaceDocumentValues replaces values of matching entries in place and
+// returns how many values changed. Values that become empty are dropped; a
+// block losing its collection or game name is reported as an error.
+func replaceDocumentValues(doc *metadata.Document, matchKey func(string) bool, replacer *valueReplacer) (int, error) {
+	count := 0
+	for idx, blk := range doc.Blocks {
+		if blk == nil {
+			continue
+		}
+		entries := blk.Entries[:0]
+		for _, entry := range blk.Entries {
+			if entry == nil || !matchKey(entry.Key) {
+				entries = append(entries, entry)
+				continue
+			}
+			values := entry.Values[:0]
+			for _, value := range entry.Values {
+				replaced := strings.TrimSpace(replacer.Replace(value))
+				if replaced != value {
+					count++
+				}
+				if replaced != "" {
+					values = append(values, replaced)
+				}
+			}
+			entry.Values = values
+			if len(values) == 0 {
+				if entry.Key == string(blk.Kind) {
+					return 0, fmt.Errorf("block %d would lose its %s name", idx+1, blk.Kind)
+				}
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		blk.Entries = entries
+	}
+	return count, nil
+}
+
+// newKeyMatcher matches metadata keys against the requested list. A trailing
+// "*" matches by prefix, e.g. "assets.*".
+func newKeyMatcher(keys []string) (func(string) bool, error) {
+	exact := make(map[string]struct{})
+	var prefixes []string
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		if strings.HasSuffix(key, "*") {
+			prefixes = append(prefixes, strings.TrimSuffix(key, "*"))
+			continue
+		}
+		key = metadata.NormalizeKey(key)
+		if key == xIndexEntryKey {
+			return nil, fmt.Errorf("field %s is read only", key)
+		}
+		exact[key] = struct{}{}
+	}
+	if len(exact) == 0 && len(prefixes) == 0 {
+		return nil, errors.New("keys must not be empty")
+	}
+	return func(key string) bool {
+		if key == xIndexEntryKey {
+			return false
+		}
+		if _, ok := exact[key]; ok {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
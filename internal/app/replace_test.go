@@ -0,0 +1,163 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanAndApplyMetadataReplace(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, `collection: Arcade
+launch: am start --es ROM "{file.path}" -n com\retroarch
+
+game: Alpha
+file: alpha.zip
+developer: CAPCOM
+assets.boxfront: old\media\alpha.png
+`)
+	untouched := filepath.Join(dir, "nes", "metadata.pegasus.txt")
+	writeTestFile(t, untouched, "collection: NES\n\ngame: Beta\nfile: beta.nes\n")
+
+	files, err := findMetadataFiles(dir)
+	if err != nil {
+		t.Fatalf("find metadata files: %v", err)
+	}
+	plan, err := planMetadataReplace(dir, files, &metadataReplaceOptions{
+		Keys:        []string{"launch", "assets.*"},
+		Find:        `\`,
+		Replacement: "/",
+	})
+	if err != nil {
+		t.Fatalf("plan replace: %v", err)
+	}
+	if !assert.Len(t, plan, 1) {
+		return
+	}
+	assert.Equal(t, "arcade/metadata.pegasus.txt", plan[0].RelPath)
+	assert.Equal(t, 2, plan[0].Count)
+	assert.Contains(t, plan[0].Diff, "+assets.boxfront: old/media/alpha.png")
+	assert.NotContains(t, plan[0].Diff, "-developer")
+
+	data, _ := os.ReadFile(metaPath)
+	assert.Contains(t, string(data), `old\media`, "planning must not write")
+
+	if err := applyMetadataReplace(nil, plan); err != nil {
+		t.Fatalf("apply replace: %v", err)
+	}
+	data, _ = os.ReadFile(metaPath)
+	assert.False(t, strings.Contains(string(data), `\`))
+	assert.Contains(t, string(data), "developer: CAPCOM")
+}
+
+func TestPlanMetadataReplaceRejectsEmptyTitle(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\n")
+	_, err := planMetadataReplace(dir, []string{metaPath}, &metadataReplaceOptions{
+		Keys:  []string{"game"},
+		Find:  "^.*$",
+		Regex: true,
+	})
+	assert.Error(t, err)
+}
+
+func TestApplyMetadataReplaceDetectsConcurrentEdit(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: capcom\n")
+	plan, err := planMetadataReplace(dir, []string{metaPath}, &metadataReplaceOptions{
+		Keys:        []string{"developers"},
+		Find:        "capcom",
+		Replacement: "Capcom",
+	})
+	if err != nil {
+		t.Fatalf("plan replace: %v", err)
+	}
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: CAPCOM\n")
+	assert.Error(t, applyMetadataReplace(nil, plan))
+}
+
+func TestApplyMetadataReplaceRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a", "metadata.pegasus.txt")
+	second := filepath.Join(dir, "b", "metadata.pegasus.txt")
+	original := "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: capcom\n"
+	writeTestFile(t, first, original)
+	writeTestFile(t, second, original)
+	plan, err := planMetadataReplace(dir, []string{first, second}, &metadataReplaceOptions{
+		Keys:        []string{"developer"},
+		Find:        "capcom",
+		Replacement: "Capcom",
+	})
+	if err != nil || len(plan) != 2 {
+		t.Fatalf("plan replace: %v", err)
+	}
+	// The second write fails after the first went through.
+	plan[1].doc = nil
+	assert.Error(t, applyMetadataReplace(nil, plan))
+	assert.Equal(t, original, readTestFile(t, first))
+	assert.Equal(t, original, readTestFile(t, second))
+}
+
+func TestCheckReplacePreview(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: capcom\n")
+	opts := &metadataReplaceOptions{Keys: []string{"developer"}, Find: "capcom", Replacement: "Capcom"}
+	preview, err := planMetadataReplace(dir, []string{metaPath}, opts)
+	if err != nil || len(preview) != 1 {
+		t.Fatalf("plan replace: %v", err)
+	}
+	hashes := map[string]string{filepath.ToSlash(metaPath): preview[0].Hash}
+	assert.NoError(t, checkReplacePreview(preview, hashes))
+	assert.Error(t, checkReplacePreview(preview, nil), "apply needs the preview hashes")
+
+	// An edit between preview and apply is planned again on apply, so the
+	// new plan carries another hash.
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: capcom\npublisher: capcom\n")
+	plan, err := planMetadataReplace(dir, []string{metaPath}, opts)
+	if err != nil || len(plan) != 1 {
+		t.Fatalf("plan replace: %v", err)
+	}
+	assert.Error(t, checkReplacePreview(plan, hashes))
+}
+
+func TestPlanMetadataReplaceDiffsFileOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "# my notes, keep me\ncollection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: capcom\n")
+	plan, err := planMetadataReplace(dir, []string{metaPath}, &metadataReplaceOptions{
+		Keys:        []string{"developer"},
+		Find:        "capcom",
+		Replacement: "Capcom",
+	})
+	if err != nil {
+		t.Fatalf("plan replace: %v", err)
+	}
+	if !assert.Len(t, plan, 1) {
+		return
+	}
+	assert.Contains(t, plan[0].Diff, "-# my notes, keep me", "dropped comment is previewed")
+	assert.Contains(t, plan[0].Diff, "@@ -1,")
+
+	if err := applyMetadataReplace(nil, plan); err != nil {
+		t.Fatalf("apply replace: %v", err)
+	}
+	// The file is short enough for a single hunk, whose new side must be
+	// exactly what was written.
+	var newSide strings.Builder
+	for _, line := range strings.SplitAfter(plan[0].Diff, "\n") {
+		if strings.HasPrefix(line, "+++") {
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "+") {
+			newSide.WriteString(line[1:])
+		}
+	}
+	assert.Equal(t, readTestFile(t, metaPath), newSide.String())
+}
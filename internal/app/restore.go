@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/constant"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+type RestoreCommand struct {
+	file    string
+	version string
+}
+
+func NewRestoreCommand() *RestoreCommand {
+	return &RestoreCommand{}
+}
+
+func (c *RestoreCommand) Name() string { return "restore" }
+
+func (c *RestoreCommand) Desc() string {
+	return "列出或恢复 metadata.pegasus.txt 的历史备份"
+}
+
+func (c *RestoreCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.file, "file", "", "metadata.pegasus.txt 文件路径或其所在目录")
+	f.StringVar(&c.version, "version", "", "需要恢复的备份, 可填写备份文件名或序号(1 为最新), 为空时仅列出备份")
+}
+
+func (c *RestoreCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.file) == "" {
+		return errors.New("restore requires --file")
+	}
+	if !strings.EqualFold(filepath.Base(c.file), constant.DefaultMetadataFile) {
+		c.file = filepath.Join(c.file, constant.DefaultMetadataFile)
+	}
+	return nil
+}
+
+func (c *RestoreCommand) Run(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	backups, err := metadata.ListBackups(c.file)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(c.version) == "" {
+		if len(backups) == 0 {
+			fmt.Printf("%s 没有备份\n", filepath.ToSlash(c.file))
+			return nil
+		}
+		for idx, backup := range backups {
+			fmt.Printf("%3d  %s  %10d  %s\n", idx+1, backup.Time.Local().Format(time.DateTime), backup.Size, backup.Name)
+		}
+		return nil
+	}
+	backup, err := selectBackup(backups, c.version)
+	if err != nil {
+		return err
+	}
+	if err := metadata.RestoreBackup(c.file, backup.Name); err != nil {
+		return err
+	}
+	logger.Info("metadata restored",
+		zap.String("file", filepath.ToSlash(c.file)),
+		zap.String("backup", backup.Name),
+		zap.Time("backup_time", backup.Time),
+	)
+	return nil
+}
+
+func (c *RestoreCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("restore", func() IRunner { return NewRestoreCommand() })
+}
+
+func selectBackup(backups []metadata.Backup, version string) (metadata.Backup, error) {
+	version = strings.TrimSpace(version)
+	if idx, err := strconv.Atoi(version); err == nil {
+		if idx < 1 || idx > len(backups) {
+			return metadata.Backup{}, fmt.Errorf("backup index %d out of range, %d backups available", idx, len(backups))
+		}
+		return backups[idx-1], nil
+	}
+	for _, backup := range backups {
+		if backup.Name == version {
+			return backup, nil
+		}
+	}
+	return metadata.Backup{}, fmt.Errorf("backup %s not found", version)
+}
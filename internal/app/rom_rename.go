@@ -0,0 +1,336 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+type RenameRomCommand struct {
+	dir       string
+	from      string
+	to        string
+	layoutArg string
+	apply     bool
+}
+
+func NewRenameRomCommand() *RenameRomCommand {
+	return &RenameRomCommand{}
+}
+
+func (c *RenameRomCommand) Name() string { return "rename-rom" }
+
+func (c *RenameRomCommand) Desc() string {
+	return "重命名 ROM 文件，同时移动 media 目录并更新 metadata 引用"
+}
+
+func (c *RenameRomCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringVar(&c.from, "from", "", "要重命名的 ROM 文件路径")
+	f.StringVar(&c.to, "to", "", "新的文件名，省略扩展名时沿用原扩展名")
+	f.StringVar(&c.layoutArg, "media-layout", defaultMediaLayoutPattern, mediaLayoutFlagDesc)
+	f.BoolVar(&c.apply, "apply", false, "执行重命名，默认只打印计划")
+}
+
+func (c *RenameRomCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("rename-rom requires --dir")
+	}
+	if strings.TrimSpace(c.from) == "" || strings.TrimSpace(c.to) == "" {
+		return errors.New("rename-rom requires --from and --to")
+	}
+	if _, err := parseMediaLayout(c.layoutArg); err != nil {
+		return err
+	}
+	logutil.GetLogger(ctx).Info("starting rom rename",
+		zap.String("from", c.from),
+		zap.String("to", c.to),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *RenameRomCommand) Run(ctx context.Context) error {
+	layout, err := parseMediaLayout(c.layoutArg)
+	if err != nil {
+		return err
+	}
+	plan, err := planRomRename(c.dir, c.from, c.to, layout)
+	if err != nil {
+		return err
+	}
+	fmt.Println(plan.String())
+	if c.apply {
+		if err := plan.apply(); err != nil {
+			return err
+		}
+	}
+	logutil.GetLogger(ctx).Info("rom rename completed",
+		zap.String("rom", plan.NewRom),
+		zap.Int("games_updated", plan.gameCount()),
+		zap.Int("media_moved", plan.mediaCount()),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *RenameRomCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("rename-rom", func() IRunner { return NewRenameRomCommand() })
+}
+
+// romRename renames a ROM file and moves the media of the games using it,
+// in every metadata file that references the ROM.
+type romRename struct {
+	OldRom string
+	NewRom string
+	Files  []*romRenameFile
+}
+
+// romRenameFile holds the games of one metadata file that use the ROM.
+type romRenameFile struct {
+	MetadataPath string
+	// Media lists the media moves that follow the ROM, empty when its
+	// media are shared with another game.
+	Media  []*mediaMove
+	Blocks []*metadata.Block
+	doc    *metadata.Document
+}
+
+func (r *romRename) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s -> %s", r.OldRom, r.NewRom)
+	for _, file := range r.Files {
+		for _, move := range file.Media {
+			fmt.Fprintf(&b, "\n%s -> %s", move.From, move.To)
+		}
+		for _, block := range file.Blocks {
+			fmt.Fprintf(&b, "\n更新 %s: %s", filepath.ToSlash(file.MetadataPath), getBlockTitle(block))
+		}
+	}
+	return b.String()
+}
+
+func (r *romRename) gameCount() int {
+	n := 0
+	for _, file := range r.Files {
+		n += len(file.Blocks)
+	}
+	return n
+}
+
+func (r *romRename) mediaCount() int {
+	n := 0
+	for _, file := range r.Files {
+		n += len(file.Media)
+	}
+	return n
+}
+
+// planRomRename finds the games under root whose file: points at from and
+// checks that the ROM can be renamed to the file name to. Media are found
+// with the layout of the collection, or fallback.
+func planRomRename(root, from, to string, fallback *mediaLayout) (*romRename, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	oldRom, err := filepath.Abs(from)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(oldRom); err != nil {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a file", from)
+	}
+	name := strings.TrimSpace(to)
+	if name != filepath.Base(name) || name != sanitizeFileComponent(name) {
+		return nil, fmt.Errorf("invalid file name %q", to)
+	}
+	if filepath.Ext(name) == "" {
+		name += filepath.Ext(oldRom)
+	}
+	newRom := filepath.Join(filepath.Dir(oldRom), name)
+	if newRom == oldRom {
+		return nil, errors.New("new name equals the current one")
+	}
+	if _, err := os.Lstat(newRom); err == nil {
+		return nil, fmt.Errorf("%s already exists", newRom)
+	}
+	files, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	plan := &romRename{OldRom: oldRom, NewRom: newRom}
+	oldBase, newBase := romBaseOf(oldRom), romBaseOf(newRom)
+	for _, metadataPath := range files {
+		doc, err := metadata.ParseMetadataFile(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		metadataDir := filepath.Dir(metadataPath)
+		var blocks []*metadata.Block
+		for _, block := range doc.Blocks {
+			if block == nil || block.Kind != metadata.KindGame {
+				continue
+			}
+			for _, value := range extractBlockFiles(block) {
+				if resolveAssetPath(metadataDir, value) == oldRom {
+					blocks = append(blocks, block)
+					break
+				}
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		file := &romRenameFile{MetadataPath: metadataPath, Blocks: blocks, doc: doc}
+		if !romBaseShared(doc, blocks, oldBase) {
+			file.Media = planMediaRename(mediaLayoutFor(doc, blocks[0], fallback), metadataDir, oldBase, newBase)
+		}
+		plan.Files = append(plan.Files, file)
+	}
+	if len(plan.Files) == 0 {
+		return nil, fmt.Errorf("no game under %s uses %s", root, from)
+	}
+	return plan, nil
+}
+
+// check verifies that nothing would be overwritten and that every directory
+// written to is writable, so apply does not stop half way for a reason known
+// up front.
+func (r *romRename) check() error {
+	if _, err := os.Lstat(r.NewRom); err == nil {
+		return fmt.Errorf("%s already exists", r.NewRom)
+	}
+	dirs := []string{filepath.Dir(r.OldRom)}
+	for _, file := range r.Files {
+		if err := checkMediaMoves(file.Media); err != nil {
+			return err
+		}
+		dirs = append(dirs, filepath.Dir(file.MetadataPath))
+		for _, move := range file.Media {
+			dirs = append(dirs, filepath.Dir(move.From))
+		}
+	}
+	for _, dir := range dirs {
+		if err := checkDirWritable(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply renames the media, writes the metadata files and renames the ROM
+// last. When a step fails, what was done is rolled back.
+func (r *romRename) apply() error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	tx := newHistoryTx("rename-rom", "")
+	if err := r.run(tx); err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			return fmt.Errorf("%w; rollback failed: %v", err, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *romRename) run(tx *historyTx) error {
+	for _, file := range r.Files {
+		if err := tx.snapshot(file.MetadataPath); err != nil {
+			return err
+		}
+		if err := applyMediaMoves(tx, file.Media); err != nil {
+			return err
+		}
+		metadataDir := filepath.Dir(file.MetadataPath)
+		for _, block := range file.Blocks {
+			for _, entry := range block.Entries {
+				if entry == nil || (entry.Key != "file" && entry.Key != "files") {
+					continue
+				}
+				for idx, value := range entry.Values {
+					if resolveAssetPath(metadataDir, value) == r.OldRom {
+						entry.Values[idx] = path.Join(path.Dir(normalizePathSeparators(value)), filepath.Base(r.NewRom))
+					}
+				}
+			}
+			if err := rewriteMovedAssets(metadataDir, block, file.Media); err != nil {
+				return err
+			}
+		}
+		if err := metadata.WriteMetadataFile(file.MetadataPath, file.doc); err != nil {
+			return err
+		}
+	}
+	return tx.rename(r.OldRom, r.NewRom)
+}
+
+// checkDirWritable reports whether files can be created in dir.
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".retrog-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", filepath.ToSlash(dir), err)
+	}
+	name := f.Name()
+	_ = f.Close()
+	return os.Remove(name)
+}
+
+func romBaseOf(p string) string {
+	return deriveRomBase([]string{filepath.ToSlash(p)})
+}
+
+// romBaseShared reports whether a game other than blocks derives its media
+// folder from romBase too, in which case the folder must stay.
+func romBaseShared(doc *metadata.Document, blocks []*metadata.Block, romBase string) bool {
+	for _, other := range doc.Blocks {
+		if other == nil || other.Kind != metadata.KindGame {
+			continue
+		}
+		mine := false
+		for _, block := range blocks {
+			if block == other {
+				mine = true
+				break
+			}
+		}
+		if !mine && deriveRomBase(extractBlockFiles(other)) == romBase {
+			return true
+		}
+	}
+	return false
+}
+
+// renameGameMedia moves the media of block to follow its ROM base when it
+// changed, and points the assets.* values of block at the new location.
+// Media still used by another game stay where they are.
+func renameGameMedia(tx *historyTx, layout *mediaLayout, metadataDir string, doc *metadata.Document, block *metadata.Block, oldBase, newBase string) error {
+	if oldBase == "" || newBase == "" || oldBase == newBase {
+		return nil
+	}
+	if romBaseShared(doc, []*metadata.Block{block}, oldBase) {
+		return nil
+	}
+	moves := planMediaRename(layout, metadataDir, oldBase, newBase)
+	if len(moves) == 0 {
+		return nil
+	}
+	if err := applyMediaMoves(tx, moves); err != nil {
+		return err
+	}
+	return rewriteMovedAssets(metadataDir, block, moves)
+}
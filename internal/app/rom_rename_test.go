@@ -0,0 +1,146 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateGameMovesMediaWithRom(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\nassets.boxfront: media/alpha/boxFront.png\nx-index-id: 1\n\ngame: Shared\nfile: shared.zip\nx-index-id: 2\n\ngame: Shared Alt\nfile: shared.7z\nx-index-id: 3\n")
+	writeTestPNG(t, filepath.Join(root, "media", "alpha", "boxFront.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "media", "alpha", "logo.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "media", "shared", "logo.png"), 8, 8, false)
+
+	c := &WebCommand{root: root}
+	err := c.updateGameMetadata(nil, metaPath, 1, "", []*fieldPayload{
+		{Key: "game", Values: []string{"Alpha"}},
+		{Key: "file", Values: []string{"alpha2.zip"}},
+		{Key: "assets.boxfront", Values: []string{"media/alpha/boxFront.png"}},
+		{Key: "x-index-id", Values: []string{"1"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("update alpha: %v", err)
+	}
+	assert.NoDirExists(t, filepath.Join(root, "media", "alpha"))
+	assert.FileExists(t, filepath.Join(root, "media", "alpha2", "logo.png"))
+	assert.Contains(t, readTestFile(t, metaPath), "assets.boxfront: media/alpha2/boxFront.png")
+
+	err = c.updateGameMetadata(nil, metaPath, 2, "", []*fieldPayload{
+		{Key: "game", Values: []string{"Shared"}},
+		{Key: "file", Values: []string{"renamed.zip"}},
+		{Key: "assets.boxfront", Values: []string{"media/shared/logo.png"}},
+		{Key: "x-index-id", Values: []string{"2"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("update shared: %v", err)
+	}
+	assert.FileExists(t, filepath.Join(root, "media", "shared", "logo.png"), "still used by Shared Alt")
+}
+
+func TestRenameRom(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: roms/alpha.zip\nassets.logo: media/alpha/logo.png\n")
+	writeTestFile(t, filepath.Join(root, "arcade", "roms", "alpha.zip"), "rom")
+	writeTestPNG(t, filepath.Join(root, "arcade", "media", "alpha", "logo.png"), 8, 8, false)
+	writeTestFile(t, filepath.Join(root, "arcade", "roms", "taken.zip"), "other")
+
+	from := filepath.Join(root, "arcade", "roms", "alpha.zip")
+	_, err := planRomRename(root, from, "taken", nil)
+	assert.Error(t, err, "target exists")
+	_, err = planRomRename(root, from, "../alpha", nil)
+	assert.Error(t, err, "names only")
+	_, err = planRomRename(root, filepath.Join(root, "arcade", "roms", "taken.zip"), "free", nil)
+	assert.Error(t, err, "no game uses it")
+
+	plan, err := planRomRename(root, from, "Alpha Mission", nil)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	assert.Equal(t, filepath.Join(root, "arcade", "roms", "Alpha Mission.zip"), plan.NewRom)
+	if assert.Len(t, plan.Files, 1) && assert.Len(t, plan.Files[0].Media, 1) {
+		assert.Equal(t, filepath.Join(root, "arcade", "media", "Alpha Mission"), plan.Files[0].Media[0].To)
+	}
+	if err := plan.apply(); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assert.NoFileExists(t, from)
+	assert.FileExists(t, plan.NewRom)
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "Alpha Mission", "logo.png"))
+	data := readTestFile(t, metaPath)
+	assert.Contains(t, data, "file: roms/Alpha Mission.zip")
+	assert.Contains(t, data, "assets.logo: media/Alpha Mission/logo.png")
+}
+
+func TestRenameRomAllMetadataFiles(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "lib")
+	arcadeMeta := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	favMeta := filepath.Join(root, "fav", "metadata.pegasus.txt")
+	writeTestFile(t, arcadeMeta, "# my arcade\ncollection: Arcade\n\ngame: Street Fighter II\nfile: sf2.zip\n")
+	writeTestFile(t, favMeta, "collection: Favourites\n\ngame: Street Fighter II\nfile: ../arcade/sf2.zip\nassets.logo: media/sf2/logo.png\n")
+	writeTestFile(t, filepath.Join(root, "arcade", "sf2.zip"), "rom")
+	writeTestPNG(t, filepath.Join(root, "arcade", "media", "sf2", "logo.png"), 8, 8, false)
+	writeTestPNG(t, filepath.Join(root, "fav", "media", "sf2", "logo.png"), 8, 8, false)
+
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.Chdir(base); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+	plan, err := planRomRename("lib", filepath.Join("lib", "arcade", "sf2.zip"), "sf2ce", nil)
+	if err != nil {
+		t.Fatalf("plan with relative dir: %v", err)
+	}
+	if !assert.Len(t, plan.Files, 2) {
+		return
+	}
+	assert.Equal(t, 2, plan.gameCount())
+	assert.Equal(t, 2, plan.mediaCount())
+
+	// A collision in the second file is found before anything moves.
+	writeTestPNG(t, filepath.Join(root, "fav", "media", "sf2ce", "logo.png"), 8, 8, false)
+	assert.Error(t, plan.apply())
+	assert.FileExists(t, filepath.Join(root, "arcade", "sf2.zip"))
+	assert.DirExists(t, filepath.Join(root, "arcade", "media", "sf2"))
+	if err := os.RemoveAll(filepath.Join(root, "fav", "media", "sf2ce")); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+
+	// A failure half way rolls back the files already handled.
+	if err := os.Rename(favMeta, favMeta+".bak"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	writeTestFile(t, filepath.Join(favMeta, "blocker"), "")
+	assert.Error(t, plan.apply())
+	assert.FileExists(t, filepath.Join(root, "arcade", "sf2.zip"))
+	assert.NoFileExists(t, filepath.Join(root, "arcade", "sf2ce.zip"))
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "sf2", "logo.png"))
+	assert.Equal(t, "# my arcade\ncollection: Arcade\n\ngame: Street Fighter II\nfile: sf2.zip\n", readTestFile(t, arcadeMeta))
+	if err := os.RemoveAll(favMeta); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if err := os.Rename(favMeta+".bak", favMeta); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	plan, err = planRomRename("lib", filepath.Join("lib", "arcade", "sf2.zip"), "sf2ce", nil)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if err := plan.apply(); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assert.FileExists(t, filepath.Join(root, "arcade", "sf2ce.zip"))
+	assert.Contains(t, readTestFile(t, arcadeMeta), "file: sf2ce.zip")
+	fav := readTestFile(t, favMeta)
+	assert.Contains(t, fav, "file: ../arcade/sf2ce.zip")
+	assert.Contains(t, fav, "assets.logo: media/sf2ce/logo.png")
+	assert.FileExists(t, filepath.Join(root, "fav", "media", "sf2ce", "logo.png"))
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "sf2ce", "logo.png"))
+}
@@ -0,0 +1,413 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/media"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"github.com/xxxsen/retrog/internal/scraper"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultScrapeRate     = time.Second
+	defaultScrapeCacheTTL = 30 * 24 * time.Hour
+	// maxCRCFileSize bounds the plain ROM files hashed for a CRC lookup;
+	// larger images are looked up by name only.
+	maxCRCFileSize = 64 << 20
+)
+
+type ScrapeCommand struct {
+	dir          string
+	provider     string
+	providerArgs []string
+	policy       string
+	rate         time.Duration
+	cacheDir     string
+	cacheTTL     time.Duration
+	layoutArg    string
+	apply        bool
+}
+
+func NewScrapeCommand() *ScrapeCommand {
+	return &ScrapeCommand{}
+}
+
+func (c *ScrapeCommand) Name() string { return "scrape" }
+
+func (c *ScrapeCommand) Desc() string {
+	return "从刮削源补全游戏简介、类型、开发商、发行日期与媒体文件"
+}
+
+func (c *ScrapeCommand) Init(f *pflag.FlagSet) {
+	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
+	f.StringVar(&c.provider, "provider", "", "刮削源: "+strings.Join(scraper.List(), " / "))
+	f.StringArrayVar(&c.providerArgs, "provider-arg", nil, "刮削源参数，格式 key=value，例如 dir=/data/scrape 或 url=http://host/api，可重复指定")
+	f.StringVar(&c.policy, "policy", string(scraper.PolicyFill), "冲突策略: fill(只补全空字段) / overwrite(覆盖已有值) / ask(逐项确认)")
+	f.DurationVar(&c.rate, "rate", defaultScrapeRate, "两次请求刮削源的最小间隔")
+	f.StringVar(&c.cacheDir, "cache-dir", defaultCacheDir(), "缓存目录，保存刮削结果与下载的媒体；留空则不缓存")
+	f.DurationVar(&c.cacheTTL, "cache-ttl", defaultScrapeCacheTTL, "刮削结果缓存有效期")
+	f.StringVar(&c.layoutArg, "media-layout", defaultMediaLayoutPattern, mediaLayoutFlagDesc)
+	f.BoolVar(&c.apply, "apply", false, "写入刮削结果，默认只打印计划")
+}
+
+func (c *ScrapeCommand) PreRun(ctx context.Context) error {
+	if strings.TrimSpace(c.dir) == "" {
+		return errors.New("scrape requires --dir")
+	}
+	if strings.TrimSpace(c.provider) == "" {
+		return errors.New("scrape requires --provider")
+	}
+	if _, err := scraper.ParsePolicy(c.policy); err != nil {
+		return err
+	}
+	if _, err := parseMediaLayout(c.layoutArg); err != nil {
+		return err
+	}
+	logutil.GetLogger(ctx).Info("starting scrape",
+		zap.String("dir", c.dir),
+		zap.String("provider", c.provider),
+		zap.String("policy", c.policy),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *ScrapeCommand) Run(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	s, err := newScraper(c.provider, c.providerArgs, c.cacheDir, c.rate, c.cacheTTL)
+	if err != nil {
+		return err
+	}
+	policy, _ := scraper.ParsePolicy(c.policy)
+	layout, _ := parseMediaLayout(c.layoutArg)
+	opts := &scrapeOptions{Policy: policy, Rules: media.DefaultRules(), Layout: layout, Apply: c.apply}
+	if policy == scraper.PolicyAsk {
+		in := bufio.NewReader(os.Stdin)
+		opts.Confirm = func(game string, change *scraper.Change) bool {
+			fmt.Printf("%s %s? [y/N] ", game, describeScrapeChange(change))
+			line, _ := in.ReadString('\n')
+			answer := strings.ToLower(strings.TrimSpace(line))
+			return answer == "y" || answer == "yes"
+		}
+	}
+	report, err := scrapeRoot(ctx, s, c.dir, opts)
+	if err != nil {
+		return err
+	}
+	logger.Info("scrape completed",
+		zap.Int("metadata_found", report.MetadataFiles),
+		zap.Int("games_checked", report.Games),
+		zap.Int("games_matched", report.Matched),
+		zap.Int("fields_changed", report.Changed),
+		zap.Int("media_skipped", report.Skipped),
+		zap.Bool("apply", c.apply),
+	)
+	return nil
+}
+
+func (c *ScrapeCommand) PostRun(ctx context.Context) error { return nil }
+
+func init() {
+	RegisterRunner("scrape", func() IRunner { return NewScrapeCommand() })
+}
+
+// newScraper resolves a provider from its name and key=value arguments and
+// wraps it with the rate limit and, when cacheDir is set, the result cache.
+func newScraper(name string, args []string, cacheDir string, rate, ttl time.Duration) (scraper.IScraper, error) {
+	parsed, err := scraper.ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	s, err := scraper.Resolve(name, parsed)
+	if err != nil {
+		return nil, err
+	}
+	s = scraper.RateLimit(s, rate)
+	if strings.TrimSpace(cacheDir) != "" {
+		s = scraper.Cache(s, filepath.Join(cacheDir, "scrape"), ttl)
+	}
+	return s, nil
+}
+
+type scrapeOptions struct {
+	Policy scraper.Policy
+	Rules  media.Rules
+	// Layout places downloaded media in collections without their own
+	// x-media-layout.
+	Layout *mediaLayout
+	Apply  bool
+	// Confirm is asked about every change under PolicyAsk.
+	Confirm func(game string, change *scraper.Change) bool
+}
+
+type scrapeReport struct {
+	MetadataFiles int
+	Games         int
+	Matched       int
+	Changed       int
+	Skipped       int
+}
+
+// scrapeRoot looks up every game under root and prints the changes the
+// policy allows. With Apply set, fields are written and media downloaded to
+// where the media layout puts them, one metadata write per file.
+func scrapeRoot(ctx context.Context, s scraper.IScraper, root string, opts *scrapeOptions) (*scrapeReport, error) {
+	files, err := findMetadataFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	report := &scrapeReport{MetadataFiles: len(files)}
+	for _, metadataPath := range files {
+		if err := scrapeMetadataFile(ctx, s, metadataPath, opts, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func scrapeMetadataFile(ctx context.Context, s scraper.IScraper, metadataPath string, opts *scrapeOptions, report *scrapeReport) error {
+	logger := logutil.GetLogger(ctx)
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	platform := documentPlatform(doc)
+	changed := false
+	for _, block := range doc.Blocks {
+		if block == nil || block.Kind != metadata.KindGame {
+			continue
+		}
+		report.Games++
+		title := getBlockTitle(block)
+		layout := mediaLayoutFor(doc, block, opts.Layout)
+		res, changes, err := scrapeGame(ctx, s, layout, metadataDir, platform, block, opts.Policy)
+		if err != nil {
+			return fmt.Errorf("scrape %s: %w", title, err)
+		}
+		if res == nil {
+			continue
+		}
+		report.Matched++
+		for _, change := range changes {
+			if opts.Confirm != nil && !opts.Confirm(title, change) {
+				continue
+			}
+			fmt.Printf("%s: %s\n", title, describeScrapeChange(change))
+			if !opts.Apply {
+				report.Changed++
+				continue
+			}
+			values := change.New
+			if change.Media != nil {
+				target := layout.assetPath(metadataDir, gameMediaBase(block), change.Key)
+				dest, err := downloadScrapedMedia(ctx, s, change.Media, opts.Rules.For(change.Key), filepath.Dir(target), filepath.Base(target))
+				if err != nil {
+					var rejected *mediaRejectedError
+					if !errors.As(err, &rejected) {
+						return fmt.Errorf("download %s for %s: %w", change.Media.URL, title, err)
+					}
+					logger.Warn("skip scraped media", zap.String("game", title), zap.String("field", change.Key), zap.Error(err))
+					report.Skipped++
+					continue
+				}
+				rel, err := filepath.Rel(metadataDir, dest)
+				if err != nil {
+					return err
+				}
+				values = []string{filepath.ToSlash(rel)}
+			}
+			setBlockField(block, change.Key, values)
+			report.Changed++
+			changed = true
+		}
+	}
+	if changed {
+		return metadata.WriteMetadataFile(metadataPath, doc)
+	}
+	return nil
+}
+
+// scrapeGame searches s for the game in block and plans the changes of the
+// best result. A nil result means the provider does not know the game.
+func scrapeGame(ctx context.Context, s scraper.IScraper, layout *mediaLayout, metadataDir, platform string, block *metadata.Block, policy scraper.Policy) (*scraper.Result, []*scraper.Change, error) {
+	results, err := s.Search(ctx, buildScrapeQuery(metadataDir, platform, block))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil, nil
+	}
+	res := results[0]
+	return res, scraper.Plan(blockFieldValues(layout, metadataDir, block), res, policy), nil
+}
+
+func buildScrapeQuery(metadataDir, platform string, block *metadata.Block) *scraper.Query {
+	files := extractBlockFiles(block)
+	return &scraper.Query{
+		RomName:  deriveRomBase(files),
+		CRC:      romCRC(findExistingRomPath(metadataDir, files)),
+		Title:    getBlockTitle(block),
+		Platform: platform,
+	}
+}
+
+// documentPlatform names the system of a metadata file after its first
+// collection.
+func documentPlatform(doc *metadata.Document) string {
+	cols, err := doc.Collections()
+	if err != nil || len(cols) == 0 {
+		return ""
+	}
+	return cols[0].Name
+}
+
+// romCRC returns the CRC32 of a ROM as a provider would list it: the stored
+// CRC of a single-file archive, or the checksum of a small plain file.
+// Multi-file archives and large images yield "".
+func romCRC(romPath string) string {
+	if romPath == "" {
+		return ""
+	}
+	romPath = filepath.FromSlash(romPath)
+	switch strings.ToLower(filepath.Ext(romPath)) {
+	case ".zip", ".7z":
+		entries, err := listArchiveEntries(romPath)
+		if err != nil || len(entries) != 1 {
+			return ""
+		}
+		return entries[0].CRC
+	}
+	info, err := os.Stat(romPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxCRCFileSize {
+		return ""
+	}
+	f, err := os.Open(romPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// blockFieldValues returns the fields of block keyed in lower case with
+// multi-line text decoded, plus the media found by name through layout,
+// which count as set.
+func blockFieldValues(layout *mediaLayout, metadataDir string, block *metadata.Block) map[string][]string {
+	out := make(map[string][]string)
+	for _, entry := range block.Entries {
+		if entry == nil {
+			continue
+		}
+		key := strings.ToLower(entry.Key)
+		for _, value := range entry.Values {
+			out[key] = append(out[key], normalizeFieldValueForDisplay(key, value))
+		}
+	}
+	_, fallback := resolveGameAssetFiles(nil, layout, metadataDir, metadata.Game{}, deriveRomBase(extractBlockFiles(block)))
+	for norm, field := range fallback {
+		key := "assets." + norm
+		if _, ok := out[key]; !ok {
+			out[key] = []string{field.Path}
+		}
+	}
+	return out
+}
+
+// gameMediaBase is the {rom} value of a game the way uploads derive it.
+func gameMediaBase(block *metadata.Block) string {
+	if base := deriveRomBase(extractBlockFiles(block)); base != "" {
+		return base
+	}
+	return sanitizeFileComponent(getBlockTitle(block))
+}
+
+// setBlockField replaces the values of key in block, appending the entry
+// when the game does not have it yet.
+func setBlockField(block *metadata.Block, key string, values []string) {
+	values = normalizeFieldValuesForKey(key, values)
+	if entry := block.Entry(key); entry != nil {
+		entry.Values = values
+		entry.Inline = len(values) == 1
+		return
+	}
+	block.Entries = append(block.Entries, &metadata.Entry{Key: key, Values: values, Inline: len(values) == 1})
+}
+
+func describeScrapeChange(change *scraper.Change) string {
+	after := strings.Join(change.New, " | ")
+	if change.Media != nil {
+		after = change.Media.URL
+	}
+	if len(change.Old) == 0 {
+		return fmt.Sprintf("%s = %s", change.Key, after)
+	}
+	return fmt.Sprintf("%s: %s -> %s", change.Key, strings.Join(change.Old, " | "), after)
+}
+
+// downloadScrapedMedia fetches m into dir as base plus the extension of its
+// content, checked against rule and re-encoded when it is over the limits.
+// Files that still break the rule are removed and reported as a
+// *mediaRejectedError.
+func downloadScrapedMedia(ctx context.Context, s scraper.IScraper, m *scraper.Media, rule media.Rule, dir, base string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	rc, err := s.FetchMedia(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	ext := ""
+	if u, err := url.Parse(m.URL); err == nil {
+		ext = strings.ToLower(path.Ext(u.Path))
+	}
+	tmp, err := os.CreateTemp(dir, ".scrape-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, rc)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	normalized, issues, err := media.Normalize(tmp.Name(), rule, media.ConvertAuto)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if len(issues) == 0 && media.FormatOfExt(normalized) == "" {
+		issues = []media.Issue{{Code: media.IssueUnknownFormat, Message: "file is not a known media format"}}
+	}
+	if len(issues) > 0 {
+		_ = os.Remove(normalized)
+		return "", &mediaRejectedError{issues: issues}
+	}
+	dest := filepath.Join(dir, base+filepath.Ext(normalized))
+	if err := os.Rename(normalized, dest); err != nil {
+		_ = os.Remove(normalized)
+		return "", err
+	}
+	return dest, nil
+}
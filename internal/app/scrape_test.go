@@ -0,0 +1,226 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/media"
+	"github.com/xxxsen/retrog/internal/scraper"
+)
+
+// writeScrapeSource builds a local provider directory with one game.
+func writeScrapeSource(t *testing.T) scraper.IScraper {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "alpha", "game.json"), `{
+  "title": "Alpha Mission",
+  "roms": ["alpha"],
+  "fields": {
+    "description": ["First line\nSecond line"],
+    "genre": ["Shooter"],
+    "developer": ["SNK"]
+  }
+}`)
+	writeTestPNG(t, filepath.Join(dir, "alpha", "boxFront.png"), 16, 16, false)
+	writeTestJPEG(t, filepath.Join(dir, "alpha", "logo.png"), 16, 16)
+	writeTestFile(t, filepath.Join(dir, "alpha", "marquee.png"), "<html><body>404</body></html>")
+	s, err := scraper.NewLocal(dir)
+	if err != nil {
+		t.Fatalf("new local scraper: %v", err)
+	}
+	return s
+}
+
+func TestScrapeRoot(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	original := "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\ndeveloper: Existing\n\ngame: Unknown\nfile: unknown.zip\n"
+	writeTestFile(t, metaPath, original)
+	writeTestPNG(t, filepath.Join(root, "arcade", "media", "alpha", "boxFront.png"), 8, 8, false)
+	s := writeScrapeSource(t)
+
+	opts := &scrapeOptions{Policy: scraper.PolicyFill, Rules: media.DefaultRules()}
+	report, err := scrapeRoot(context.Background(), s, root, opts)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	assert.Equal(t, 2, report.Games)
+	assert.Equal(t, 1, report.Matched)
+	assert.Equal(t, 4, report.Changed, "description, genre, logo and marquee")
+	assert.Equal(t, original, readTestFile(t, metaPath), "dry run writes nothing")
+
+	opts.Apply = true
+	report, err = scrapeRoot(context.Background(), s, root, opts)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	assert.Equal(t, 3, report.Changed)
+	assert.Equal(t, 1, report.Skipped, "the HTML marquee is rejected")
+	data := readTestFile(t, metaPath)
+	assert.Contains(t, data, "developer: Existing")
+	assert.Contains(t, data, "genre: Shooter")
+	assert.Contains(t, data, `description: First line\nSecond line`)
+	assert.Contains(t, data, "assets.logo: media/alpha/logo.jpg")
+	assert.NotContains(t, data, "assets.boxfront", "the file in media/alpha counts as set")
+	assert.NotContains(t, data, "marquee")
+	assert.FileExists(t, filepath.Join(root, "arcade", "media", "alpha", "logo.jpg"))
+	entries, _ := os.ReadDir(filepath.Join(root, "arcade", "media", "alpha"))
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+
+	opts.Policy = scraper.PolicyOverwrite
+	report, err = scrapeRoot(context.Background(), s, root, opts)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	data = readTestFile(t, metaPath)
+	assert.Contains(t, data, "developer: SNK")
+	assert.Contains(t, data, "assets.boxfront: media/alpha/boxFront.png")
+}
+
+func TestRomCRC(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "game.nes")
+	writeTestFile(t, plain, "hello")
+	assert.Equal(t, "3610a686", romCRC(plain))
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("game.nes")
+	_, _ = w.Write([]byte("hello"))
+	_ = zw.Close()
+	single := filepath.Join(dir, "game.zip")
+	writeTestFile(t, single, buf.String())
+	assert.Equal(t, "3610a686", romCRC(single))
+
+	assert.Equal(t, "", romCRC(filepath.Join(dir, "missing.nes")))
+}
+
+func TestHandleScrapeGame(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\ngenre: Existing\nx-index-id: 1\n")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("asset store: %v", err)
+	}
+	uploadDir := t.TempDir()
+	store.AddAllowedRoot(uploadDir)
+	c := &WebCommand{root: root, assets: store, uploadDir: uploadDir, mediaRules: media.DefaultRules(), scrapePolicy: string(scraper.PolicyFill)}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.handleScrapeGame(rec, httptest.NewRequest(http.MethodPost, "/api/games/scrape", strings.NewReader(body)))
+		return rec
+	}
+	body := `{"metadata_path":"` + filepath.ToSlash(metaPath) + `","x_index_id":1}`
+	assert.Equal(t, http.StatusNotFound, post(body).Code, "no scraper configured")
+
+	c.scraper = writeScrapeSource(t)
+	rec := post(body)
+	if !assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		return
+	}
+	var resp scrapeGameResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	assert.Equal(t, "local", resp.Provider)
+	assert.Equal(t, scraper.MatchRom, resp.Match)
+	byKey := make(map[string]*scrapeChangePayload)
+	for _, change := range resp.Changes {
+		byKey[change.Key] = change
+	}
+	assert.NotContains(t, byKey, "genre")
+	assert.Equal(t, []string{"SNK"}, byKey["developer"].New)
+	if boxfront := byKey["assets.boxfront"]; assert.NotNil(t, boxfront) && assert.NotNil(t, boxfront.Asset) {
+		assert.True(t, strings.HasPrefix(boxfront.New[0], stagedUploadPrefix))
+		assert.True(t, strings.HasSuffix(boxfront.New[0], "__boxFront.png"))
+		assert.FileExists(t, filepath.Join(uploadDir, strings.TrimPrefix(boxfront.New[0], stagedUploadPrefix)))
+	}
+	assert.NotEmpty(t, byKey["assets.marquee"].Error)
+	assert.Contains(t, readTestFile(t, metaPath), "genre: Existing", "scraping does not write")
+
+	rec = post(`{"metadata_path":"` + filepath.ToSlash(metaPath) + `","x_index_id":1,"policy":"overwrite"}`)
+	assert.Contains(t, rec.Body.String(), `"key":"genre"`)
+	assert.Equal(t, http.StatusBadRequest, post(`{"metadata_path":"`+filepath.ToSlash(metaPath)+`","x_index_id":1,"policy":"merge"}`).Code)
+}
+
+func TestRunScrapeJob(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "metadata.pegasus.txt")
+	original := "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\ngenre: Existing\nx-index-id: 1\n\ngame: Unknown\nfile: unknown.zip\nx-index-id: 2\n"
+	writeTestFile(t, metaPath, original)
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("asset store: %v", err)
+	}
+	history, err := newWebHistory(context.Background(), root, 0)
+	if err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store, uploadDir: t.TempDir(), history: history, events: newEventHub(),
+		mediaRules: media.DefaultRules(), scrapePolicy: string(scraper.PolicyAsk)}
+	edited := strings.Replace(original, "genre: Existing\n", "genre: Existing\npublisher: Edited\n", 1)
+	lookups := 0
+	c.scraper = &hookScraper{IScraper: writeScrapeSource(t), onSearch: func() {
+		lookups++
+		if lookups > 1 {
+			return
+		}
+		// The history lock is free while the scraper is asked, so an edit
+		// can land in the middle of the job.
+		if assert.True(t, c.history.writeMu.TryLock(), "lookups run without the history lock") {
+			c.history.writeMu.Unlock()
+		}
+		writeTestFile(t, metaPath, edited)
+	}}
+	if err := c.reloadCollections(context.Background()); err != nil {
+		t.Fatalf("load collections: %v", err)
+	}
+	job := &webJob{publish: func(*jobPayload) {}}
+	if err := c.runScrapeJob(context.Background(), job); err != nil {
+		t.Fatalf("scrape job: %v", err)
+	}
+	got := readTestFile(t, metaPath)
+	assert.Contains(t, got, "developer: SNK")
+	assert.Contains(t, got, "publisher: Edited", "edits made during the lookup are kept")
+	assert.Contains(t, got, "genre: Existing", "ask falls back to fill")
+	assert.FileExists(t, filepath.Join(root, "media", "alpha", "boxFront.png"))
+	assert.NotContains(t, got, "assets.marquee", "rejected media are skipped")
+	assert.Equal(t, 2, job.payload().Done)
+	assert.Equal(t, 2, job.payload().Total)
+	if _, game := c.findGamePayload(filepath.ToSlash(metaPath), 1); assert.NotNil(t, game) {
+		assert.Contains(t, game.Fields, &fieldPayload{Key: "developer", Values: []string{"SNK"}}, "the file is reloaded")
+	}
+
+	if _, err := c.history.undoLast(); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	assert.Equal(t, edited, readTestFile(t, metaPath))
+	assert.NoFileExists(t, filepath.Join(root, "media", "alpha", "boxFront.png"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, c.runScrapeJob(ctx, &webJob{publish: func(*jobPayload) {}}), context.Canceled)
+	assert.Equal(t, edited, readTestFile(t, metaPath))
+}
+
+// hookScraper calls onSearch before every search.
+type hookScraper struct {
+	scraper.IScraper
+	onSearch func()
+}
+
+func (s *hookScraper) Search(ctx context.Context, q *scraper.Query) ([]*scraper.Result, error) {
+	s.onSearch()
+	return s.IScraper.Search(ctx, q)
+}
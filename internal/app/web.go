@@ -9,27 +9,32 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"io/fs"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bodgit/sevenzip"
-	"github.com/google/shlex"
 	"github.com/spf13/pflag"
 	"github.com/xxxsen/common/logutil"
 	"github.com/xxxsen/retrog/internal/constant"
 	"github.com/xxxsen/retrog/internal/dat"
+	"github.com/xxxsen/retrog/internal/media"
 	"github.com/xxxsen/retrog/internal/metadata"
+	"github.com/xxxsen/retrog/internal/scraper"
 	"github.com/xxxsen/retrog/internal/sdk"
+	"github.com/xxxsen/retrog/internal/search"
 	"github.com/xxxsen/retrog/internal/webui"
 	"go.uber.org/zap"
 )
@@ -39,8 +44,12 @@ type WebCommand struct {
 	root            string
 	bind            string
 	datDir          string
-	fbneoDat        string
-	mameDat         string
+	coreDatArgs     []string
+	launchRules     []string
+	launchAnalyzer  *launchAnalyzer
+	appDir          string
+	coreDats        []*coreDatRule
+	romSets         map[string]*romSet
 	biosDir         string
 	ext             string
 	exts            []string
@@ -53,8 +62,36 @@ type WebCommand struct {
 	romStatusByGame map[string]*romStatusSummary
 	romStatusByPath map[string]*romStatusSummary
 	virtualSortMax  map[string]int
-	defsFBNeo       map[string]romDefInfo
-	defsMame        map[string]romDefInfo
+	romTesters      map[string]sdk.IRomTestSDK
+	watchMode       string
+	watchInterval   time.Duration
+	events          *eventHub
+	jobs            *jobManager
+	selfMu          sync.Mutex
+	selfWrites      map[string]*selfWrite
+	history         *webHistory
+	trashKeep       time.Duration
+	search          *search.Index
+	authBasicFile   string
+	authTokenFile   string
+	readonly        bool
+	auth            *webAuth
+	basePath        string
+	tlsCert         string
+	tlsKey          string
+	cacheDir        string
+	thumbs          *thumbnailer
+	mediaLimits     []string
+	mediaConvertArg string
+	mediaRules      media.Rules
+	mediaConvert    media.Convert
+	mediaLayoutArg  string
+	mediaLayout     *mediaLayout
+	scraperName     string
+	scraperArgs     []string
+	scrapePolicy    string
+	scrapeRate      time.Duration
+	scraper         scraper.IScraper
 }
 
 type collectionPayload struct {
@@ -71,6 +108,7 @@ type collectionPayload struct {
 	SortKey      string          `json:"sort_key"`
 	Extensions   []string        `json:"extensions,omitempty"`
 	Core         string          `json:"core,omitempty"`
+	Revision     string          `json:"revision"`
 	Fields       []*fieldPayload `json:"fields"`
 	Games        []*gamePayload  `json:"games"`
 }
@@ -89,6 +127,7 @@ type gamePayload struct {
 	RomEmoji    string          `json:"rom_status_emoji"`
 	HasBoxArt   bool            `json:"has_boxart"`
 	HasVideo    bool            `json:"has_video"`
+	Revision    string          `json:"revision"`
 	Fields      []*fieldPayload `json:"fields"`
 	Assets      []*assetPayload `json:"assets"`
 }
@@ -131,6 +170,7 @@ const stagedUploadPrefix = "__upload__/"
 type updateGameRequest struct {
 	MetadataPath string          `json:"metadata_path"`
 	XIndexID     int             `json:"x_index_id"`
+	Revision     string          `json:"revision,omitempty"`
 	Fields       []*fieldPayload `json:"fields"`
 	Removed      []*fieldPayload `json:"removed_fields"`
 }
@@ -155,6 +195,7 @@ type uploadMediaResponse struct {
 type updateCollectionRequest struct {
 	MetadataPath string          `json:"metadata_path"`
 	XIndexID     int             `json:"x_index_id"`
+	Revision     string          `json:"revision,omitempty"`
 	Fields       []*fieldPayload `json:"fields"`
 }
 
@@ -223,10 +264,14 @@ type romFileInfo struct {
 }
 
 type assetStore struct {
-	root  string
-	extra []string
-	mu    sync.RWMutex
-	files map[string]string
+	root    string
+	extra   []string
+	mu      sync.RWMutex
+	files   map[string]string
+	digests map[string]*assetDigest
+	// digestDir persists content hashes across restarts; "" keeps them in
+	// memory only.
+	digestDir string
 }
 
 func NewWebCommand() *WebCommand { return &WebCommand{bind: ":8080"} }
@@ -240,9 +285,29 @@ func (c *WebCommand) Desc() string {
 func (c *WebCommand) Init(f *pflag.FlagSet) {
 	f.StringVar(&c.dir, "dir", "", "ROM 根目录")
 	f.StringVar(&c.bind, "bind", ":8080", "HTTP 监听地址，例如 0.0.0.0:8080")
-	f.StringVar(&c.datDir, "dat", "", "DAT 文件目录，包含 fbneo.dat / mame.dat，用于校验 ROM")
+	f.StringVar(&c.datDir, "dat", "", "DAT 文件目录，包含 fbneo.dat / mame.dat / mame2003.dat 等，按合集的核心选择 DAT 校验 ROM")
+	f.StringArrayVar(&c.coreDatArgs, "core-dat", nil, coreDatFlagDesc)
+	f.StringArrayVar(&c.launchRules, "launch-rule", nil, launchRuleFlagDesc)
+	f.StringVar(&c.appDir, "appdir", "", appDirFlagDesc)
 	f.StringVar(&c.biosDir, "bios", "", "BIOS 目录，用于 rom 校验父/依赖")
 	f.StringVar(&c.ext, "ext", "zip,7z", "ROM 扫描扩展名，逗号分隔，例如 zip,7z")
+	f.StringVar(&c.watchMode, "watch", watchModeAuto, "监听目录外部变更: auto(优先 fsnotify，失败时轮询) / fsnotify / poll / off")
+	f.DurationVar(&c.watchInterval, "watch-interval", defaultWatchInterval, "轮询模式下的扫描间隔")
+	f.StringVar(&c.authBasicFile, "auth-basic-file", "", "Basic 认证账号文件，每行一个 user:password")
+	f.StringVar(&c.authTokenFile, "auth-token-file", "", "访问令牌文件，每行一个令牌；可通过 Authorization: Bearer 或 /?token= 登录")
+	f.BoolVar(&c.readonly, "readonly", false, "只读模式，拒绝所有修改请求")
+	f.StringVar(&c.basePath, "base-path", "", "反向代理子路径，例如 /retrog，所有页面与接口都挂在该路径下")
+	f.StringVar(&c.tlsCert, "tls-cert", "", "HTTPS 证书文件，需与 --tls-key 同时指定")
+	f.StringVar(&c.tlsKey, "tls-key", "", "HTTPS 私钥文件，需与 --tls-cert 同时指定")
+	f.StringArrayVar(&c.mediaLimits, "media-limit", nil, "覆盖媒体上传限制，格式 类型=大小[:宽x高]，例如 boxfront=8m:2048x2048，可重复指定")
+	f.StringVar(&c.mediaConvertArg, "media-convert", string(media.ConvertOff), "上传图片转换: off(超限直接拒绝) / auto(超限时缩放并重新编码) / png / jpeg(统一转换为该格式)")
+	f.StringVar(&c.mediaLayoutArg, "media-layout", defaultMediaLayoutPattern, mediaLayoutFlagDesc)
+	f.StringVar(&c.cacheDir, "cache-dir", defaultCacheDir(), "缓存目录，用于保存媒体缩略图、媒体内容哈希与刮削结果；留空则不生成缩略图")
+	f.StringVar(&c.scraperName, "scraper", "", "编辑页使用的刮削源: "+strings.Join(scraper.List(), " / ")+"；留空则不启用刮削")
+	f.StringArrayVar(&c.scraperArgs, "scraper-arg", nil, "刮削源参数，格式 key=value，可重复指定")
+	f.StringVar(&c.scrapePolicy, "scrape-policy", string(scraper.PolicyFill), "刮削默认冲突策略: fill / overwrite / ask")
+	f.DurationVar(&c.scrapeRate, "scrape-rate", defaultScrapeRate, "两次请求刮削源的最小间隔")
+	f.DurationVar(&c.trashKeep, "trash-keep", defaultTrashKeep, trashKeepFlagDesc)
 }
 
 func (c *WebCommand) PreRun(ctx context.Context) error {
@@ -269,6 +334,23 @@ func (c *WebCommand) PreRun(ctx context.Context) error {
 		return err
 	}
 	c.assets = store
+	if strings.TrimSpace(c.cacheDir) != "" {
+		thumbs, err := newThumbnailer(c.cacheDir)
+		if err != nil {
+			return err
+		}
+		c.thumbs = thumbs
+		store.digestDir = filepath.Join(c.cacheDir, "digests")
+		if err := os.MkdirAll(store.digestDir, 0o755); err != nil {
+			return err
+		}
+	}
+	history, err := newWebHistory(ctx, c.root, c.trashKeep)
+	if err != nil {
+		return err
+	}
+	history.marker = c
+	c.history = history
 	tempRoot := filepath.Join(os.TempDir(), "retrog_upload")
 	_ = os.RemoveAll(tempRoot)
 	if err := os.MkdirAll(tempRoot, 0o755); err != nil {
@@ -282,54 +364,143 @@ func (c *WebCommand) PreRun(ctx context.Context) error {
 		return err
 	}
 	c.exts = exts
+	if err := validateWatchMode(c.watchMode); err != nil {
+		return err
+	}
+	rules, err := parseMediaRules(c.mediaLimits)
+	if err != nil {
+		return err
+	}
+	c.mediaRules = rules
+	if c.mediaConvert, err = media.ParseConvert(c.mediaConvertArg); err != nil {
+		return err
+	}
+	if c.mediaLayout, err = parseMediaLayout(c.mediaLayoutArg); err != nil {
+		return err
+	}
+	if c.launchAnalyzer, err = newLaunchAnalyzer(c.launchRules); err != nil {
+		return err
+	}
+	if _, err := scraper.ParsePolicy(c.scrapePolicy); err != nil {
+		return err
+	}
+	if strings.TrimSpace(c.scraperName) != "" {
+		if c.scraper, err = newScraper(c.scraperName, c.scraperArgs, c.cacheDir, c.scrapeRate, defaultScrapeCacheTTL); err != nil {
+			return err
+		}
+	}
+	basePath, err := normalizeBasePath(c.basePath)
+	if err != nil {
+		return err
+	}
+	c.basePath = basePath
+	if (c.tlsCert == "") != (c.tlsKey == "") {
+		return errors.New("--tls-cert and --tls-key must be set together")
+	}
+	auth, err := newWebAuth(c.authBasicFile, c.authTokenFile, c.readonly)
+	if err != nil {
+		return err
+	}
+	auth.cookiePath = c.basePath + "/"
+	c.auth = auth
+	c.events = newEventHub()
+	c.search = newGameSearchIndex()
+	c.jobs = newJobManager(c.publishJob)
+	c.jobs.register(jobKindRomCheck, jobKindRomCheckDesc, c.runRomCheck)
+	if c.scraper != nil && !c.readonly {
+		c.jobs.register(jobKindScrape, jobKindScrapeDesc, c.runScrapeJob)
+	}
 	return nil
 }
 
 func (c *WebCommand) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	logger := logutil.GetLogger(ctx)
-	collections, err := loadCollections(ctx, c.root, c.assets)
+	collections, err := loadCollections(ctx, c.root, c.assets, c.collectionOptions())
 	if err != nil {
 		return err
 	}
-	logger.Info("starting rom check (may take a while)")
-	if err := c.applyRomChecks(ctx, collections); err != nil {
+	if err := c.initRomTesters(); err != nil {
 		return err
 	}
+	c.applyDefaultRomStatus(collections)
 	c.computeVirtualSortMax(collections)
 	c.setCollections(collections)
 	logger.Info("metadata loaded",
 		zap.Int("collection_count", len(collections)))
+	if err := c.startWatcher(ctx); err != nil {
+		return err
+	}
+	c.jobs.bind(ctx)
+	if len(c.romTesters) > 0 {
+		logger.Info("starting rom check in background")
+		if _, err := c.jobs.start(jobKindRomCheck); err != nil {
+			return err
+		}
+	} else {
+		logger.Info("rom check skipped (no dat provided)")
+	}
+
+	handler, err := c.newHandler()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Addr:    c.bind,
+		Handler: handler,
+	}
+	c.server = srv
+
+	if !c.auth.enabled() {
+		logger.Warn("web ui has no authentication, use --auth-basic-file or --auth-token-file before exposing it")
+	}
+	logger.Info("web ui ready",
+		zap.String("addr", srv.Addr),
+		zap.String("base_path", c.basePath+"/"),
+		zap.Bool("tls", c.tlsCert != ""),
+		zap.String("root", c.root),
+		zap.Bool("auth", c.auth.enabled()),
+		zap.Bool("readonly", c.readonly))
+	return c.serve(ctx, srv)
+}
 
+// newHandler registers every route under the base path and wraps them with
+// the auth checks.
+func (c *WebCommand) newHandler() (http.Handler, error) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", c.handleIndex)
 	staticFS, err := fs.Sub(webui.Content, "static")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
 	mux.HandleFunc("/api/collections/update", c.handleUpdateCollection)
+	mux.HandleFunc("/api/collections/launch-check", c.handleLaunchCheck)
+	mux.HandleFunc("/api/collections/orphans", c.handleOrphans)
+	mux.HandleFunc("/api/collections/orphans/adopt", c.handleAdoptOrphans)
+	mux.HandleFunc("/api/collections/orphans/media", c.handleOrphanMedia)
 	mux.HandleFunc("/api/collections", c.handleCollections)
+	mux.HandleFunc("/api/collections/", c.handleCollectionGames)
 	mux.HandleFunc("/api/assets/", c.handleAsset)
 	mux.HandleFunc("/api/games/update", c.handleUpdateGame)
 	mux.HandleFunc("/api/games/upload", c.handleUploadMedia)
 	mux.HandleFunc("/api/games/create", c.handleCreateGame)
 	mux.HandleFunc("/api/games/delete", c.handleDeleteGame)
 	mux.HandleFunc("/api/games/rominfo", c.handleRomInfo)
-
-	srv := &http.Server{
-		Addr:    c.bind,
-		Handler: mux,
-	}
-	c.server = srv
-
-	logger.Info("web ui ready",
-		zap.String("addr", srv.Addr),
-		zap.String("root", c.root))
-
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		return err
-	}
-	return nil
+	mux.HandleFunc("/api/games/batch", c.handleBatchUpdate)
+	mux.HandleFunc("/api/games/scrape", c.handleScrapeGame)
+	mux.HandleFunc("/api/games", c.handleGames)
+	mux.HandleFunc("/api/games/", c.handleGameDetail)
+	mux.HandleFunc("/api/search", c.handleSearch)
+	mux.HandleFunc("/api/metadata/replace", c.handleReplaceMetadata)
+	mux.HandleFunc("/api/history", c.handleHistory)
+	mux.HandleFunc("/api/history/undo", c.handleHistoryUndo)
+	mux.HandleFunc("/api/history/redo", c.handleHistoryRedo)
+	mux.HandleFunc("/api/events", c.handleEvents)
+	mux.HandleFunc("/api/jobs", c.handleJobs)
+	mux.HandleFunc("/api/jobs/", c.handleJob)
+	return c.auth.wrap(mountBasePath(c.basePath, mux)), nil
 }
 
 func (c *WebCommand) PostRun(ctx context.Context) error {
@@ -344,32 +515,24 @@ func (c *WebCommand) PostRun(ctx context.Context) error {
 
 func (c *WebCommand) prepareDatPaths(ctx context.Context) error {
 	logger := logutil.GetLogger(ctx)
-	needBios := false
-	datRoot := strings.TrimSpace(c.datDir)
-	if datRoot != "" {
-		fbneoCandidate := filepath.Join(datRoot, "fbneo.dat")
-		if _, err := os.Stat(fbneoCandidate); err == nil {
-			c.fbneoDat = fbneoCandidate
-		} else {
-			logger.Info("fbneo.dat not found in dat dir", zap.String("dat_dir", datRoot))
-		}
-		mameCandidate := filepath.Join(datRoot, "mame.dat")
-		if _, err := os.Stat(mameCandidate); err == nil {
-			c.mameDat = mameCandidate
-		} else {
-			logger.Info("mame.dat not found in dat dir", zap.String("dat_dir", datRoot))
-		}
+	c.datDir = strings.TrimSpace(c.datDir)
+	rules, err := parseCoreDatRules(c.coreDatArgs)
+	if err != nil {
+		return err
 	}
-	if strings.TrimSpace(c.fbneoDat) != "" {
-		needBios = true
-		c.defsFBNeo, _ = loadRomDefsFromFBNeo(c.fbneoDat)
+	sets, missing, err := loadRomSets(c.datDir, rules, len(c.coreDatArgs))
+	if err != nil {
+		return err
 	}
-	if strings.TrimSpace(c.mameDat) != "" {
-		needBios = true
-		c.defsMame, _ = loadRomDefsFromMame(c.mameDat)
+	c.coreDats, c.romSets = rules, sets
+	for _, set := range sets {
+		logger.Info("dat loaded", zap.String("name", set.Name), zap.String("path", set.Path), zap.String("version", set.Version))
 	}
-	if needBios && strings.TrimSpace(c.biosDir) == "" {
-		return errors.New("bios directory is required when fbneo/mame dat is provided")
+	if c.datDir != "" && len(missing) > 0 {
+		logger.Info("dat files not found in dat dir, their cores will not be checked", zap.String("dat_dir", c.datDir), zap.Strings("missing", missing))
+	}
+	if len(sets) > 0 && strings.TrimSpace(c.biosDir) == "" {
+		return errors.New("bios directory is required when a dat is provided")
 	}
 	if strings.TrimSpace(c.biosDir) != "" {
 		info, err := os.Stat(c.biosDir)
@@ -382,8 +545,8 @@ func (c *WebCommand) prepareDatPaths(ctx context.Context) error {
 	} else {
 		logger.Info("bios directory not provided; rom check will skip parent/bios completion")
 	}
-	if datRoot == "" && c.fbneoDat == "" && c.mameDat == "" {
-		logger.Info("dat directory not provided; rom check will be skipped. Provide --dat to enable fbneo/mame validation.")
+	if len(sets) == 0 {
+		logger.Info("no dat available; rom check will be skipped. Provide --dat to enable fbneo/mame validation.")
 	}
 	return nil
 }
@@ -398,8 +561,10 @@ func (c *WebCommand) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to load ui", http.StatusInternalServerError)
 		return
 	}
+	head := fmt.Sprintf("\n  <base href=\"%s/\">", html.EscapeString(c.basePath)) + c.auth.indexMeta() + c.scrapeMeta()
+	page := strings.Replace(string(data), "<head>", "<head>"+head, 1)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_, _ = w.Write(data)
+	_, _ = w.Write([]byte(page))
 }
 
 func (c *WebCommand) handleCollections(w http.ResponseWriter, r *http.Request) {
@@ -407,7 +572,11 @@ func (c *WebCommand) handleCollections(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	respondJSON(w, r, http.StatusOK, c.collectionsSnapshot())
+	cols := c.collectionsSnapshot()
+	if r.URL.Query().Get("view") == collectionsViewSummary {
+		cols = summarizeCollections(cols)
+	}
+	respondJSON(w, r, http.StatusOK, cols)
 }
 
 func (c *WebCommand) handleUpdateCollection(w http.ResponseWriter, r *http.Request) {
@@ -429,7 +598,13 @@ func (c *WebCommand) handleUpdateCollection(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "x_index_id must be positive", http.StatusBadRequest)
 		return
 	}
-	if err := c.updateCollectionMetadata(metadataPath, req.XIndexID, req.Fields); err != nil {
+	tx := c.history.begin("修改合集")
+	defer c.finishChange(r, tx)
+	if err := c.updateCollectionMetadata(tx, metadataPath, req.XIndexID, req.Revision, req.Fields); err != nil {
+		if errors.Is(err, errRevisionConflict) {
+			c.respondCollectionConflict(w, r, metadataPath, &req, err)
+			return
+		}
 		http.Error(w, fmt.Sprintf("update collection failed: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -445,21 +620,6 @@ func (c *WebCommand) handleUpdateCollection(w http.ResponseWriter, r *http.Reque
 	respondJSON(w, r, http.StatusOK, &collectionUpdateResponse{Collection: coll})
 }
 
-func (c *WebCommand) handleAsset(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/api/assets/")
-	if id == "" {
-		http.NotFound(w, r)
-		return
-	}
-	path, ok := c.assets.Lookup(id)
-	if !ok {
-		http.NotFound(w, r)
-		return
-	}
-	w.Header().Set("Cache-Control", "no-store, must-revalidate")
-	http.ServeFile(w, r, path)
-}
-
 func (c *WebCommand) handleUpdateGame(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -479,7 +639,13 @@ func (c *WebCommand) handleUpdateGame(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "x_index_id must be positive", http.StatusBadRequest)
 		return
 	}
-	if err := c.updateGameMetadata(metadataPath, req.XIndexID, req.Fields, req.Removed); err != nil {
+	tx := c.history.begin("修改游戏")
+	defer c.finishChange(r, tx)
+	if err := c.updateGameMetadata(tx, metadataPath, req.XIndexID, req.Revision, req.Fields, req.Removed); err != nil {
+		if errors.Is(err, errRevisionConflict) {
+			c.respondGameConflict(w, r, metadataPath, &req, err)
+			return
+		}
 		http.Error(w, fmt.Sprintf("update game failed: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -510,7 +676,9 @@ func (c *WebCommand) handleCreateGame(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	xIndexID, err := c.createGame(metadataPath, req.XIndexID, req.Fields)
+	tx := c.history.begin("新增游戏")
+	defer c.finishChange(r, tx)
+	xIndexID, err := c.createGame(tx, metadataPath, req.XIndexID, req.Fields)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("create game failed: %v", err), http.StatusInternalServerError)
 		return
@@ -581,6 +749,18 @@ func (c *WebCommand) handleUploadMedia(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("stage media failed: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if normalized, err := c.normalizeStagedMedia(fieldKey, stagedPath); err != nil {
+		var rejected *mediaRejectedError
+		if errors.As(err, &rejected) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, fmt.Sprintf("stage media failed: %v", err), http.StatusInternalServerError)
+		return
+	} else if normalized != stagedPath {
+		stagedPath = normalized
+		token = stagedUploadPrefix + filepath.Base(normalized)
+	}
 	payload, err := c.buildStagedAssetPayload(stagedPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("build asset payload failed: %v", err), http.StatusInternalServerError)
@@ -674,7 +854,9 @@ func (c *WebCommand) handleDeleteGame(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "x_index_id must be positive", http.StatusBadRequest)
 		return
 	}
-	if err := c.deleteGame(metadataPath, req.XIndexID, req.RemoveFiles); err != nil {
+	tx := c.history.begin("删除游戏")
+	defer c.finishChange(r, tx)
+	if err := c.deleteGame(tx, metadataPath, req.XIndexID, req.RemoveFiles); err != nil {
 		http.Error(w, fmt.Sprintf("delete game failed: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -711,14 +893,14 @@ func (c *WebCommand) buildStagedAssetPayload(path string) (*assetPayload, error)
 	if c.assets == nil {
 		return nil, errors.New("asset store not initialized")
 	}
-	id, err := c.assets.Register(path)
+	url, err := c.assets.URL(path)
 	if err != nil {
 		return nil, err
 	}
 	return &assetPayload{
 		Name:     filepath.Base(path),
 		Type:     detectAssetType(path),
-		URL:      "/api/assets/" + id,
+		URL:      url,
 		FileName: filepath.Base(path),
 	}, nil
 }
@@ -732,6 +914,7 @@ func (c *WebCommand) collectionsSnapshot() []*collectionPayload {
 func (c *WebCommand) setCollections(cols []*collectionPayload) {
 	c.dataMu.Lock()
 	defer c.dataMu.Unlock()
+	c.syncSearchIndex(c.collections, cols)
 	c.collections = cols
 }
 
@@ -771,7 +954,7 @@ func (c *WebCommand) computeVirtualSortMax(cols []*collectionPayload) {
 }
 
 func (c *WebCommand) reloadCollections(ctx context.Context) error {
-	cols, err := loadCollections(ctx, c.root, c.assets)
+	cols, err := loadCollections(ctx, c.root, c.assets, c.collectionOptions())
 	if err != nil {
 		return err
 	}
@@ -783,11 +966,11 @@ func (c *WebCommand) reloadCollections(ctx context.Context) error {
 
 func (c *WebCommand) applyStoredRomStatus(cols []*collectionPayload) {
 	for _, coll := range cols {
-		family := coreFamily(coll.Core)
+		setName := c.romSetName(coll.Core)
 		for _, game := range coll.Games {
 			status := c.romStatusForGame(coll.MetadataPath, game.XIndexID)
 			if status == nil {
-				if family == "" {
+				if setName == "" {
 					game.RomStatus = ""
 					game.RomEmoji = ""
 					continue
@@ -866,8 +1049,19 @@ func (c *WebCommand) resolveMetadataPath(meta string) (string, error) {
 	return fsPath, nil
 }
 
-func loadCollections(ctx context.Context, root string, store *assetStore) ([]*collectionPayload, error) {
-	logger := logutil.GetLogger(ctx)
+// collectionOptions carries the command settings that shape collection views.
+type collectionOptions struct {
+	// layout is the media layout of collections without their own.
+	layout *mediaLayout
+	// launch derives the core of a collection from its launch command.
+	launch *launchAnalyzer
+}
+
+func (c *WebCommand) collectionOptions() collectionOptions {
+	return collectionOptions{layout: c.mediaLayout, launch: c.launchAnalyzer}
+}
+
+func loadCollections(ctx context.Context, root string, store *assetStore, opts collectionOptions) ([]*collectionPayload, error) {
 	var result []*collectionPayload
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -879,34 +1073,7 @@ func loadCollections(ctx context.Context, root string, store *assetStore) ([]*co
 		if !strings.EqualFold(d.Name(), constant.DefaultMetadataFile) {
 			return nil
 		}
-		doc, err := metadata.ParseMetadataFile(path)
-		if err != nil {
-			logger.Error("parse metadata failed", zap.String("path", path), zap.Error(err))
-			return nil
-		}
-		collChanged, err := ensureCollectionIndexes(doc)
-		if err != nil {
-			logger.Error("ensure collection indexes failed", zap.String("path", path), zap.Error(err))
-			return nil
-		}
-		gameChanged, err := ensureGameIndexes(doc)
-		if err != nil {
-			logger.Error("ensure game indexes failed", zap.String("path", path), zap.Error(err))
-			return nil
-		}
-		if collChanged || gameChanged {
-			if err := metadata.WriteMetadataFile(path, doc); err != nil {
-				logger.Error("write metadata failed", zap.String("path", path), zap.Error(err))
-				return nil
-			}
-			logger.Info("metadata updated with x-index-id", zap.String("path", path))
-		}
-		colls, err := buildCollections(doc, path, root, store, logger)
-		if err != nil {
-			logger.Error("build collection view failed", zap.String("path", path), zap.Error(err))
-			return nil
-		}
-		result = append(result, colls...)
+		result = append(result, loadMetadataCollections(ctx, path, root, store, opts)...)
 		return nil
 	})
 	if err != nil {
@@ -915,7 +1082,49 @@ func loadCollections(ctx context.Context, root string, store *assetStore) ([]*co
 	sort.Slice(result, func(i, j int) bool {
 		return compareCollectionSortKey(result[i], result[j])
 	})
-	for _, coll := range result {
+	finalizeCollections(result)
+	return result, nil
+}
+
+// loadMetadataCollections parses a single metadata file, assigning missing
+// x-index-id values, and returns its collection views. Errors are logged and
+// yield no collections, like a broken file during a full scan.
+func loadMetadataCollections(ctx context.Context, path, root string, store *assetStore, opts collectionOptions) []*collectionPayload {
+	logger := logutil.GetLogger(ctx)
+	doc, err := metadata.ParseMetadataFile(path)
+	if err != nil {
+		logger.Error("parse metadata failed", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+	collChanged, err := ensureCollectionIndexes(doc)
+	if err != nil {
+		logger.Error("ensure collection indexes failed", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+	gameChanged, err := ensureGameIndexes(doc)
+	if err != nil {
+		logger.Error("ensure game indexes failed", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+	if collChanged || gameChanged {
+		if err := metadata.WriteMetadataFile(path, doc); err != nil {
+			logger.Error("write metadata failed", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		logger.Info("metadata updated with x-index-id", zap.String("path", path))
+	}
+	colls, err := buildCollections(doc, path, root, store, opts, logger)
+	if err != nil {
+		logger.Error("build collection view failed", zap.String("path", path), zap.Error(err))
+		return nil
+	}
+	return colls
+}
+
+// finalizeCollections sorts the games of freshly built collections and
+// assigns their stable ids.
+func finalizeCollections(cols []*collectionPayload) {
+	for _, coll := range cols {
 		coll.ID = buildCollectionID(coll.MetadataPath, coll.Index)
 		sort.Slice(coll.Games, func(i, j int) bool {
 			return compareGameSortKey(coll.Games[i], coll.Games[j])
@@ -924,93 +1133,165 @@ func loadCollections(ctx context.Context, root string, store *assetStore) ([]*co
 			game.ID = buildGameID(coll.ID, game.Index)
 		}
 	}
-	return result, nil
 }
 
-func (c *WebCommand) applyRomChecks(ctx context.Context, cols []*collectionPayload) error {
-	logger := logutil.GetLogger(ctx)
+// initRomTesters builds a tester for every loaded DAT.
+func (c *WebCommand) initRomTesters() error {
+	testers := make(map[string]sdk.IRomTestSDK)
+	for name, set := range c.romSets {
+		t, err := newRomSetTester(set)
+		if err != nil {
+			return fmt.Errorf("init %s tester: %w", name, err)
+		}
+		testers[name] = t
+	}
 	c.romMu.Lock()
+	c.romTesters = testers
 	c.romStatusByGame = make(map[string]*romStatusSummary)
 	c.romStatusByPath = make(map[string]*romStatusSummary)
 	c.romMu.Unlock()
+	return nil
+}
 
-	testers := make(map[string]sdk.IRomTestSDK)
-	if strings.TrimSpace(c.fbneoDat) != "" {
-		t, err := sdk.NewFBNeoTestSDK(c.fbneoDat)
-		if err != nil {
-			return fmt.Errorf("init fbneo tester: %w", err)
-		}
-		testers["fbneo"] = t
-	}
-	if strings.TrimSpace(c.mameDat) != "" {
-		t, err := sdk.NewMameTestSDK(c.mameDat)
-		if err != nil {
-			return fmt.Errorf("init mame tester: %w", err)
-		}
-		testers["mame"] = t
-	}
-	if len(testers) == 0 {
-		c.applyDefaultRomStatus(cols)
-		logger.Info("rom check skipped (no dat provided)")
-		return nil
-	}
+type gameRef struct {
+	metadataPath string
+	xIndexID     int
+}
 
-	resultsByFamily := make(map[string]map[string]*romStatusSummary)
-	for family, tester := range testers {
-		exts := c.exts
-		if len(exts) == 0 {
-			exts = collectFamilyExtensions(cols, family)
+// runRomCheck verifies the archive of every game against the DAT of its
+// collection's core. It runs as a background job and fills in the per-game
+// status as results arrive.
+func (c *WebCommand) runRomCheck(ctx context.Context, job *webJob) error {
+	logger := logutil.GetLogger(ctx)
+	c.romMu.RLock()
+	testers := c.romTesters
+	c.romMu.RUnlock()
+	if len(testers) == 0 {
+		return errors.New("no dat provided, rom check requires --dat")
+	}
+	names := make([]string, 0, len(testers))
+	for name := range testers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cols := c.collectionsSnapshot()
+	for idx, setName := range names {
+		job.setStage(idx, len(names))
+		games := make(map[string][]gameRef)
+		var files []string
+		for _, coll := range cols {
+			if c.romSetName(coll.Core) != setName {
+				continue
+			}
+			for _, game := range coll.Games {
+				key := normalizeRomPathKey(game.RomPath)
+				if key == "" {
+					continue
+				}
+				if _, ok := games[key]; !ok {
+					files = append(files, filepath.FromSlash(game.RomPath))
+				}
+				games[key] = append(games[key], gameRef{metadataPath: coll.MetadataPath, xIndexID: game.XIndexID})
+			}
 		}
-		res, err := tester.TestDir(stdContextAdapter{ctx}, c.root, c.biosDir, exts)
-		if err != nil {
-			return fmt.Errorf("rom check (%s) failed: %w", family, err)
+		if len(files) == 0 {
+			continue
+		}
+		var pending []gameRef
+		lastFlush := time.Now()
+		flush := func(done, total int) {
+			patches := c.patchGameRomStatus(pending)
+			pending = nil
+			lastFlush = time.Now()
+			c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckProgress, Done: done, Total: total, Games: patches})
 		}
-		familyMap := make(map[string]*romStatusSummary)
-		for _, item := range res.List {
+		progress := func(done, total int, item *sdk.RomFileTestResult) {
 			key := normalizeRomPathKey(item.FilePath)
 			summary := summarizeRomResult(item)
-			familyMap[key] = summary
+			c.romMu.Lock()
+			c.romStatusByPath[key] = summary
+			c.romMu.Unlock()
+			for _, ref := range games[key] {
+				c.setRomStatusForGame(ref.metadataPath, ref.xIndexID, summary)
+				pending = append(pending, ref)
+			}
+			job.setProgress(done, total, fmt.Sprintf("%s %d/%d", setName, done, total))
+			if done == total || time.Since(lastFlush) >= jobPublishInterval {
+				flush(done, total)
+			}
+		}
+		exts := c.romTestExts(cols, setName)
+		c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckStarted})
+		res, err := testers[setName].TestFilesProgress(stdContextAdapter{ctx}, c.root, c.biosDir, files, exts, progress)
+		if len(pending) > 0 {
+			flush(job.payload().Done, job.payload().Total)
 		}
-		resultsByFamily[family] = familyMap
-		c.romMu.Lock()
-		for k, v := range familyMap {
-			c.romStatusByPath[k] = v
+		if err != nil {
+			c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckFailed, Error: err.Error()})
+			return fmt.Errorf("rom check (%s) failed: %w", setName, err)
 		}
-		c.romMu.Unlock()
-		logger.Info("rom check completed", zap.String("family", family), zap.Int("count", len(familyMap)))
+		c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckFinished, Count: len(res.List)})
+		logger.Info("rom check completed", zap.String("dat", setName), zap.Int("count", len(res.List)))
 	}
+	return nil
+}
 
-	for _, coll := range cols {
-		family := coreFamily(coll.Core)
-		for _, game := range coll.Games {
-			if family == "" {
-				game.RomStatus = ""
-				game.RomEmoji = ""
-				c.setRomStatusForGame(coll.MetadataPath, game.XIndexID, nil)
+// patchGameRomStatus copies the collections and games in refs whose stored
+// status changed and swaps them in. Published payloads are never modified in
+// place, as they may be serialised concurrently.
+func (c *WebCommand) patchGameRomStatus(refs []gameRef) []*gameRomStatusPayload {
+	if len(refs) == 0 {
+		return nil
+	}
+	wanted := make(map[string]struct{}, len(refs))
+	for _, ref := range refs {
+		wanted[buildGameKey(ref.metadataPath, ref.xIndexID)] = struct{}{}
+	}
+	c.history.writeMu.Lock()
+	defer c.history.writeMu.Unlock()
+	cols := c.collectionsSnapshot()
+	var patches []*gameRomStatusPayload
+	for idx, coll := range cols {
+		var games []*gamePayload
+		for gameIdx, game := range coll.Games {
+			if _, ok := wanted[buildGameKey(coll.MetadataPath, game.XIndexID)]; !ok {
 				continue
 			}
-			status := &romStatusSummary{Status: romStatusNotTested, Emoji: "🔘"}
-			if family != "" {
-				if m, ok := resultsByFamily[family]; ok {
-					key := normalizeRomPathKey(game.RomPath)
-					if res, ok := m[key]; ok && key != "" {
-						status = res
-					}
-				}
+			status := c.romStatusForGame(coll.MetadataPath, game.XIndexID)
+			if status == nil || (game.RomStatus == string(status.Status) && game.RomEmoji == status.Emoji) {
+				continue
 			}
-			game.RomStatus = string(status.Status)
-			game.RomEmoji = status.Emoji
-			c.setRomStatusForGame(coll.MetadataPath, game.XIndexID, status)
+			if games == nil {
+				games = append([]*gamePayload(nil), coll.Games...)
+			}
+			updated := *game
+			updated.RomStatus = string(status.Status)
+			updated.RomEmoji = status.Emoji
+			games[gameIdx] = &updated
+			patches = append(patches, &gameRomStatusPayload{
+				MetadataPath: coll.MetadataPath,
+				XIndexID:     game.XIndexID,
+				RomStatus:    updated.RomStatus,
+				RomEmoji:     updated.RomEmoji,
+			})
+		}
+		if games != nil {
+			updated := *coll
+			updated.Games = games
+			cols[idx] = &updated
 		}
 	}
-	return nil
+	if len(patches) > 0 {
+		c.setCollections(cols)
+	}
+	return patches
 }
 
 func (c *WebCommand) applyDefaultRomStatus(cols []*collectionPayload) {
 	for _, coll := range cols {
-		family := coreFamily(coll.Core)
+		setName := c.romSetName(coll.Core)
 		for _, game := range coll.Games {
-			if family == "" {
+			if setName == "" {
 				game.RomStatus = ""
 				game.RomEmoji = ""
 				c.setRomStatusForGame(coll.MetadataPath, game.XIndexID, nil)
@@ -1023,22 +1304,17 @@ func (c *WebCommand) applyDefaultRomStatus(cols []*collectionPayload) {
 	}
 }
 
-func coreFamily(core string) string {
-	core = strings.ToLower(strings.TrimSpace(core))
-	switch {
-	case strings.HasPrefix(core, "fbneo"):
-		return "fbneo"
-	case strings.HasPrefix(core, "mame"):
-		return "mame"
-	default:
-		return ""
+func (c *WebCommand) romTestExts(cols []*collectionPayload, setName string) []string {
+	if len(c.exts) > 0 {
+		return c.exts
 	}
+	return c.collectRomSetExtensions(cols, setName)
 }
 
-func collectFamilyExtensions(cols []*collectionPayload, family string) []string {
+func (c *WebCommand) collectRomSetExtensions(cols []*collectionPayload, setName string) []string {
 	seen := make(map[string]struct{})
 	for _, coll := range cols {
-		if coll == nil || coreFamily(coll.Core) != family {
+		if coll == nil || c.romSetName(coll.Core) != setName {
 			continue
 		}
 		for _, ext := range coll.Extensions {
@@ -1241,7 +1517,7 @@ func blockXIndexID(blk *metadata.Block) int {
 	return 0
 }
 
-func buildCollections(doc *metadata.Document, metadataPath, root string, store *assetStore, logger *zap.Logger) ([]*collectionPayload, error) {
+func buildCollections(doc *metadata.Document, metadataPath, root string, store *assetStore, opts collectionOptions, logger *zap.Logger) ([]*collectionPayload, error) {
 	metadataDir := filepath.Dir(metadataPath)
 	dirName := filepath.Base(metadataDir)
 	relDir, err := filepath.Rel(root, metadataDir)
@@ -1257,6 +1533,8 @@ func buildCollections(doc *metadata.Document, metadataPath, root string, store *
 	gameIdx := 0
 	collectionOrder := -1
 	var current *collectionPayload
+	currentLayout := layoutOrDefault(opts.layout)
+	assetIndex := newMediaAssetIndex(metadataDir)
 	var result []*collectionPayload
 
 	for _, blk := range doc.Blocks {
@@ -1280,6 +1558,12 @@ func buildCollections(doc *metadata.Document, metadataPath, root string, store *
 			if name == "" {
 				name = dirName
 			}
+			layout, err := collectionMediaLayout(blk, opts.layout)
+			if err != nil {
+				logger.Warn("invalid media layout, using the default", zap.String("collection", name), zap.Error(err))
+				layout = layoutOrDefault(opts.layout)
+			}
+			currentLayout = layout
 			current = &collectionPayload{
 				Index:        collectionOrder,
 				XIndexID:     blockXIndexID(blk),
@@ -1290,7 +1574,8 @@ func buildCollections(doc *metadata.Document, metadataPath, root string, store *
 				RelativePath: relDir,
 				SortKey:      formatSortByValue(typed.SortBy),
 				Extensions:   parseCollectionExtensions(blk),
-				Core:         deriveCore(typed.Launch),
+				Core:         opts.launch.analyze(typed.Launch),
+				Revision:     blockRevision(blk),
 				Fields:       convertBlockFields(blk),
 			}
 			result = append(result, current)
@@ -1329,7 +1614,7 @@ func buildCollections(doc *metadata.Document, metadataPath, root string, store *
 				display = fmt.Sprintf("%s (%s)", title, romPath)
 			}
 			romBase := deriveRomBase(typed.Files)
-			assets, fallbackFields := collectGameAssets(metadataDir, typed, romBase, romMissing, store, logger)
+			assets, fallbackFields := collectGameAssets(assetIndex, currentLayout, metadataDir, typed, romBase, romMissing, store, logger)
 			fields = appendFallbackAssetFields(fields, fallbackFields)
 			hasBoxArt := fieldExists(fields, "assets.boxfront")
 			if !hasBoxArt {
@@ -1347,6 +1632,7 @@ func buildCollections(doc *metadata.Document, metadataPath, root string, store *
 				RomMissing:  romMissing,
 				HasBoxArt:   hasBoxArt,
 				HasVideo:    hasVideo,
+				Revision:    blockRevision(blk),
 				Fields:      fields,
 				Assets:      assets,
 			}
@@ -1597,7 +1883,10 @@ func normalizeSortKey(game *gamePayload) string {
 	return strings.ToLower(game.DisplayName)
 }
 
-func (c *WebCommand) updateGameMetadata(metadataPath string, xIndexID int, fields []*fieldPayload, removed []*fieldPayload) error {
+func (c *WebCommand) updateGameMetadata(tx *historyTx, metadataPath string, xIndexID int, revision string, fields []*fieldPayload, removed []*fieldPayload) error {
+	if err := tx.snapshot(metadataPath); err != nil {
+		return err
+	}
 	doc, err := metadata.ParseMetadataFile(metadataPath)
 	if err != nil {
 		return err
@@ -1606,11 +1895,15 @@ func (c *WebCommand) updateGameMetadata(metadataPath string, xIndexID int, field
 	if err != nil {
 		return err
 	}
-	fields, err = c.materializeStagedFields(metadataPath, doc, block, fields)
+	if err := checkBlockRevision(block, revision); err != nil {
+		return err
+	}
+	oldRomBase := deriveRomBase(extractBlockFiles(block))
+	fields, err = c.materializeStagedFields(tx, metadataPath, doc, block, fields)
 	if err != nil {
 		return err
 	}
-	if err := c.handleRemovedFields(metadataPath, doc, block, removed); err != nil {
+	if err := c.handleRemovedFields(tx, metadataPath, doc, block, removed); err != nil {
 		return err
 	}
 	order, updates, err := combineFieldValues(fields, "game")
@@ -1625,14 +1918,34 @@ func (c *WebCommand) updateGameMetadata(metadataPath string, xIndexID int, field
 		return err
 	}
 	block.Entries = entries
+	// A new ROM name would orphan the media of the old one, take them along.
+	if err := renameGameMedia(tx, mediaLayoutFor(doc, block, c.mediaLayout), filepath.Dir(metadataPath), doc, block, oldRomBase, deriveRomBase(extractBlockFiles(block))); err != nil {
+		return err
+	}
 	return metadata.WriteMetadataFile(metadataPath, doc)
 }
 
-func (c *WebCommand) createGame(metadataPath string, xIndexID int, fields []*fieldPayload) (int, error) {
+func (c *WebCommand) createGame(tx *historyTx, metadataPath string, xIndexID int, fields []*fieldPayload) (int, error) {
+	if err := tx.snapshot(metadataPath); err != nil {
+		return 0, err
+	}
 	doc, err := metadata.ParseMetadataFile(metadataPath)
 	if err != nil {
 		return 0, err
 	}
+	xIndexID, err = c.appendGameBlock(tx, metadataPath, doc, xIndexID, fields, validateRequiredGameFields)
+	if err != nil {
+		return 0, err
+	}
+	if err := metadata.WriteMetadataFile(metadataPath, doc); err != nil {
+		return 0, err
+	}
+	return xIndexID, nil
+}
+
+// appendGameBlock adds a new game block built from fields to doc without
+// writing it; the block is dropped again when validation fails.
+func (c *WebCommand) appendGameBlock(tx *historyTx, metadataPath string, doc *metadata.Document, xIndexID int, fields []*fieldPayload, validate func(map[string][]string) error) (int, error) {
 	if _, _, err := findGameBlockByIndexID(doc, xIndexID); err == nil {
 		return 0, fmt.Errorf("x-index-id %d already exists", xIndexID)
 	}
@@ -1642,28 +1955,30 @@ func (c *WebCommand) createGame(metadataPath string, xIndexID int, fields []*fie
 	blk := &metadata.Block{Kind: metadata.KindGame}
 	doc.Blocks = append(doc.Blocks, blk)
 	setBlockXIndexID(blk, xIndexID)
-	// inject sort-by default for virtual group if missing
-	fields = c.ensureSortByDefault(metadataPath, fields)
-	fields, err = c.materializeStagedFields(metadataPath, doc, blk, fields)
+	entries, err := c.buildNewGameEntries(tx, metadataPath, doc, blk, fields, validate)
 	if err != nil {
+		doc.Blocks = doc.Blocks[:len(doc.Blocks)-1]
 		return 0, err
 	}
-	order, updates, err := combineFieldValues(fields, "game")
+	blk.Entries = entries
+	return xIndexID, nil
+}
+
+func (c *WebCommand) buildNewGameEntries(tx *historyTx, metadataPath string, doc *metadata.Document, blk *metadata.Block, fields []*fieldPayload, validate func(map[string][]string) error) ([]*metadata.Entry, error) {
+	// inject sort-by default for virtual group if missing
+	fields = c.ensureSortByDefault(metadataPath, fields)
+	fields, err := c.materializeStagedFields(tx, metadataPath, doc, blk, fields)
 	if err != nil {
-		return 0, err
-	}
-	if err := validateRequiredGameFields(updates); err != nil {
-		return 0, err
+		return nil, err
 	}
-	entries, err := rebuildBlockEntries(blk.Entries, order, updates, "game")
+	order, updates, err := combineFieldValues(fields, "game")
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	blk.Entries = entries
-	if err := metadata.WriteMetadataFile(metadataPath, doc); err != nil {
-		return 0, err
+	if err := validate(updates); err != nil {
+		return nil, err
 	}
-	return xIndexID, nil
+	return rebuildBlockEntries(blk.Entries, order, updates, "game")
 }
 
 func nextGameIndexID(doc *metadata.Document) int {
@@ -1684,7 +1999,10 @@ func nextGameIndexID(doc *metadata.Document) int {
 	return maxID + 1
 }
 
-func (c *WebCommand) deleteGame(metadataPath string, xIndexID int, removeFiles bool) error {
+func (c *WebCommand) deleteGame(tx *historyTx, metadataPath string, xIndexID int, removeFiles bool) error {
+	if err := tx.snapshot(metadataPath); err != nil {
+		return err
+	}
 	doc, err := metadata.ParseMetadataFile(metadataPath)
 	if err != nil {
 		return err
@@ -1694,7 +2012,7 @@ func (c *WebCommand) deleteGame(metadataPath string, xIndexID int, removeFiles b
 		return err
 	}
 	if removeFiles {
-		c.removeGameFiles(metadataPath, doc, block)
+		c.removeGameFiles(tx, metadataPath, doc, block)
 	}
 	doc.Blocks = append(doc.Blocks[:idx], doc.Blocks[idx+1:]...)
 	return metadata.WriteMetadataFile(metadataPath, doc)
@@ -1730,7 +2048,10 @@ func findCollectionBlockByIndexID(doc *metadata.Document, xIndexID int) (*metada
 	return nil, -1, fmt.Errorf("collection with x-index-id %d not found", xIndexID)
 }
 
-func (c *WebCommand) updateCollectionMetadata(metadataPath string, xIndexID int, fields []*fieldPayload) error {
+func (c *WebCommand) updateCollectionMetadata(tx *historyTx, metadataPath string, xIndexID int, revision string, fields []*fieldPayload) error {
+	if err := tx.snapshot(metadataPath); err != nil {
+		return err
+	}
 	doc, err := metadata.ParseMetadataFile(metadataPath)
 	if err != nil {
 		return err
@@ -1739,6 +2060,9 @@ func (c *WebCommand) updateCollectionMetadata(metadataPath string, xIndexID int,
 	if err != nil {
 		return err
 	}
+	if err := checkBlockRevision(block, revision); err != nil {
+		return err
+	}
 	order, updates, err := combineFieldValues(fields, "collection")
 	if err != nil {
 		return err
@@ -1891,7 +2215,7 @@ func firstFileValueFromFields(fields []*fieldPayload) string {
 	return ""
 }
 
-func (c *WebCommand) materializeStagedFields(metadataPath string, doc *metadata.Document, block *metadata.Block, fields []*fieldPayload) ([]*fieldPayload, error) {
+func (c *WebCommand) materializeStagedFields(tx *historyTx, metadataPath string, doc *metadata.Document, block *metadata.Block, fields []*fieldPayload) ([]*fieldPayload, error) {
 	if c == nil || c.uploadDir == "" {
 		return fields, nil
 	}
@@ -1905,7 +2229,7 @@ func (c *WebCommand) materializeStagedFields(metadataPath string, doc *metadata.
 			if !strings.HasPrefix(value, stagedUploadPrefix) {
 				continue
 			}
-			rel, err := c.finalizeStagedFile(metadataPath, doc, block, key, value, pendingFile)
+			rel, err := c.finalizeStagedFile(tx, metadataPath, doc, block, key, value, pendingFile)
 			if err != nil {
 				return nil, err
 			}
@@ -1915,7 +2239,7 @@ func (c *WebCommand) materializeStagedFields(metadataPath string, doc *metadata.
 	return fields, nil
 }
 
-func (c *WebCommand) handleRemovedFields(metadataPath string, doc *metadata.Document, block *metadata.Block, removed []*fieldPayload) error {
+func (c *WebCommand) handleRemovedFields(tx *historyTx, metadataPath string, doc *metadata.Document, block *metadata.Block, removed []*fieldPayload) error {
 	if len(removed) == 0 {
 		return nil
 	}
@@ -1935,13 +2259,13 @@ func (c *WebCommand) handleRemovedFields(metadataPath string, doc *metadata.Docu
 				}
 				continue
 			}
-			c.deleteFieldFile(metadataPath, doc, block, key, value)
+			c.deleteFieldFile(tx, metadataPath, doc, block, key, value)
 		}
 	}
 	return nil
 }
 
-func (c *WebCommand) removeGameFiles(metadataPath string, doc *metadata.Document, block *metadata.Block) {
+func (c *WebCommand) removeGameFiles(tx *historyTx, metadataPath string, doc *metadata.Document, block *metadata.Block) {
 	if block == nil {
 		return
 	}
@@ -1953,17 +2277,17 @@ func (c *WebCommand) removeGameFiles(metadataPath string, doc *metadata.Document
 		switch {
 		case key == "file" || key == "files":
 			for _, value := range entry.Values {
-				c.deleteFieldFile(metadataPath, doc, block, key, value)
+				c.deleteFieldFile(tx, metadataPath, doc, block, key, value)
 			}
 		case strings.HasPrefix(key, "assets."):
 			for _, value := range entry.Values {
-				c.deleteFieldFile(metadataPath, doc, block, key, value)
+				c.deleteFieldFile(tx, metadataPath, doc, block, key, value)
 			}
 		}
 	}
 }
 
-func (c *WebCommand) deleteFieldFile(metadataPath string, doc *metadata.Document, block *metadata.Block, key, value string) {
+func (c *WebCommand) deleteFieldFile(tx *historyTx, metadataPath string, doc *metadata.Document, block *metadata.Block, key, value string) {
 	metadataDir := filepath.Dir(metadataPath)
 	target := filepath.Join(metadataDir, filepath.FromSlash(value))
 	target = filepath.Clean(target)
@@ -1974,17 +2298,25 @@ func (c *WebCommand) deleteFieldFile(metadataPath string, doc *metadata.Document
 	case strings.HasPrefix(key, "assets."):
 		mediaDir := filepath.Join(metadataDir, "media") + string(os.PathSeparator)
 		if strings.HasPrefix(target, mediaDir) {
-			_ = os.Remove(target)
+			_ = tx.remove(target)
+			return
+		}
+		layout := mediaLayoutFor(doc, block, c.mediaLayout)
+		for _, file := range layout.findAssets(metadataDir, deriveRomBase(extractBlockFiles(block))) {
+			if file.Path == target {
+				_ = tx.remove(target)
+				return
+			}
 		}
 	case key == "file" || key == "files":
 		allowed := allowedExtensionsForGame(doc, block)
 		if len(allowed) == 0 || containsExtension(allowed, normalizeExtension(filepath.Ext(target))) {
-			_ = os.Remove(target)
+			_ = tx.remove(target)
 		}
 	}
 }
 
-func (c *WebCommand) finalizeStagedFile(metadataPath string, doc *metadata.Document, block *metadata.Block, key, token, pendingFile string) (string, error) {
+func (c *WebCommand) finalizeStagedFile(tx *historyTx, metadataPath string, doc *metadata.Document, block *metadata.Block, key, token, pendingFile string) (string, error) {
 	if c.uploadDir == "" {
 		return "", errors.New("upload directory not initialized")
 	}
@@ -1998,10 +2330,10 @@ func (c *WebCommand) finalizeStagedFile(metadataPath string, doc *metadata.Docum
 	}
 	switch {
 	case strings.HasPrefix(key, "assets."):
-		return moveFileToMedia(metadataPath, block, pendingFile, source, stagedName, key)
+		return moveFileToMedia(tx, mediaLayoutFor(doc, block, c.mediaLayout), metadataPath, block, pendingFile, source, stagedName, key)
 	case key == "file" || key == "files":
 		allowed := allowedExtensionsForGame(doc, block)
-		return moveFileToRom(metadataPath, source, stagedName, allowed)
+		return moveFileToRom(tx, metadataPath, source, stagedName, allowed)
 	default:
 		return "", fmt.Errorf("field %s does not support uploads", key)
 	}
@@ -2180,7 +2512,7 @@ func (c *WebCommand) nextSortByForKey(key string) string {
 	return fmt.Sprintf("%06d", next)
 }
 
-func moveFileToMedia(metadataPath string, block *metadata.Block, pendingFile string, sourcePath, stagedName, assetKey string) (string, error) {
+func moveFileToMedia(tx *historyTx, layout *mediaLayout, metadataPath string, block *metadata.Block, pendingFile string, sourcePath, stagedName, assetKey string) (string, error) {
 	metadataDir := filepath.Dir(metadataPath)
 	romBase := deriveRomBase(extractBlockFiles(block))
 	if romBase == "" {
@@ -2189,20 +2521,16 @@ func moveFileToMedia(metadataPath string, block *metadata.Block, pendingFile str
 	if romBase == "" {
 		romBase = sanitizeFileComponent(getBlockTitle(block))
 	}
-	mediaDir := filepath.Join(metadataDir, "media")
-	if romBase != "" {
-		mediaDir = filepath.Join(mediaDir, romBase)
-	}
-	targetBase := assetFileBaseFromKey(assetKey)
-	return moveFileToDir(metadataDir, mediaDir, sourcePath, stagedName, nil, targetBase)
+	target := layoutOrDefault(layout).assetPath(metadataDir, romBase, assetKey)
+	return moveFileToDir(tx, metadataDir, filepath.Dir(target), sourcePath, stagedName, nil, filepath.Base(target))
 }
 
-func moveFileToRom(metadataPath string, sourcePath, stagedName string, allowedExt []string) (string, error) {
+func moveFileToRom(tx *historyTx, metadataPath string, sourcePath, stagedName string, allowedExt []string) (string, error) {
 	metadataDir := filepath.Dir(metadataPath)
-	return moveFileToDir(metadataDir, metadataDir, sourcePath, stagedName, allowedExt, "")
+	return moveFileToDir(tx, metadataDir, metadataDir, sourcePath, stagedName, allowedExt, "")
 }
 
-func moveFileToDir(metadataDir, targetDir, sourcePath, stagedName string, allowedExt []string, preferredBase string) (string, error) {
+func moveFileToDir(tx *historyTx, metadataDir, targetDir, sourcePath, stagedName string, allowedExt []string, preferredBase string) (string, error) {
 	if err := os.MkdirAll(targetDir, 0o755); err != nil {
 		return "", err
 	}
@@ -2227,6 +2555,11 @@ func moveFileToDir(metadataDir, targetDir, sourcePath, stagedName string, allowe
 		}
 	}
 	destPath := filepath.Join(targetDir, baseName)
+	if _, err := os.Lstat(destPath); err == nil {
+		if err := tx.remove(destPath); err != nil {
+			return "", err
+		}
+	}
 	if err := os.Rename(sourcePath, destPath); err != nil {
 		if err := copyFileContents(sourcePath, destPath); err != nil {
 			return "", err
@@ -2235,6 +2568,7 @@ func moveFileToDir(metadataDir, targetDir, sourcePath, stagedName string, allowe
 			return "", err
 		}
 	}
+	tx.created(destPath)
 	rel, err := filepath.Rel(metadataDir, destPath)
 	if err != nil {
 		rel = destPath
@@ -2584,11 +2918,11 @@ func convertParents(parents []sdk.ParentInfo) []parentPayload {
 	return out
 }
 
-func loadRomDefsFromFBNeo(datPath string) (map[string]romDefInfo, error) {
+func loadRomDefsFromFBNeo(datPath string) (map[string]romDefInfo, string, error) {
 	parser := dat.NewParser()
 	df, err := parser.ParseFile(datPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	result := make(map[string]romDefInfo)
 	for _, g := range df.Games {
@@ -2601,14 +2935,14 @@ func loadRomDefsFromFBNeo(datPath string) (map[string]romDefInfo, error) {
 			IsBios: strings.EqualFold(strings.TrimSpace(g.IsBios), "yes"),
 		}
 	}
-	return result, nil
+	return result, df.Header.Version, nil
 }
 
-func loadRomDefsFromMame(datPath string) (map[string]romDefInfo, error) {
+func loadRomDefsFromMame(datPath string) (map[string]romDefInfo, string, error) {
 	parser := dat.NewMameParser()
 	df, err := parser.ParseFile(datPath)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	result := make(map[string]romDefInfo)
 	for _, m := range df.Machines {
@@ -2621,22 +2955,19 @@ func loadRomDefsFromMame(datPath string) (map[string]romDefInfo, error) {
 			IsBios: strings.EqualFold(strings.TrimSpace(m.IsBios), "yes"),
 		}
 	}
-	return result, nil
+	return result, df.Version(), nil
 }
 
 func (c *WebCommand) parentChainFromDefs(core string, romName string) []sdk.ParentInfo {
-	family := coreFamily(core)
-	if family == "" {
+	set := c.romSetForCore(core)
+	if set == nil {
 		return nil
 	}
 	name := strings.ToLower(strings.TrimSpace(romName))
 	if name == "" {
 		return nil
 	}
-	defs := c.defsFBNeo
-	if family == "mame" {
-		defs = c.defsMame
-	}
+	defs := set.defs
 	if defs == nil {
 		return nil
 	}
@@ -2684,12 +3015,19 @@ func deriveRomBase(files []string) string {
 	return ""
 }
 
-func collectGameAssets(metadataDir string, game metadata.Game, romBase string, romMissing bool, store *assetStore, logger *zap.Logger) ([]*assetPayload, map[string]fallbackAssetField) {
-	type assetCandidate struct {
-		name string
-		path string
-	}
-	resolved := make(map[string]assetCandidate)
+// gameAssetFile is one media file of a game, referenced by an assets.* field
+// or found by name in media/<rombase>/.
+type gameAssetFile struct {
+	Name string
+	Path string
+}
+
+// resolveGameAssetFiles lists the media files of game sorted by name.
+// Metadata fields win over files laid out for romBase of the same name; the
+// files found only on disk are also returned as fallback fields. assets, when
+// not nil, serves the layout lookups for metadataDir.
+func resolveGameAssetFiles(assets *mediaAssetIndex, layout *mediaLayout, metadataDir string, game metadata.Game, romBase string) ([]gameAssetFile, map[string]fallbackAssetField) {
+	resolved := make(map[string]gameAssetFile)
 	fallbackValues := make(map[string]fallbackAssetField)
 	metadataKeys := make(map[string]struct{})
 	for name, assetPath := range game.Assets {
@@ -2697,75 +3035,37 @@ func collectGameAssets(metadataDir string, game metadata.Game, romBase string, r
 		if norm == "" {
 			continue
 		}
-		resolved[norm] = assetCandidate{name: name, path: resolveAssetPath(metadataDir, assetPath)}
+		resolved[norm] = gameAssetFile{Name: name, Path: resolveAssetPath(metadataDir, assetPath)}
 		metadataKeys[norm] = struct{}{}
 	}
-	if romBase != "" {
-		mediaDir := filepath.Join(metadataDir, "media", romBase)
-		entries, err := os.ReadDir(mediaDir)
-		if err == nil {
-			for _, entry := range entries {
-				if entry.IsDir() {
-					continue
-				}
-				key := entry.Name()
-				cleanKey := strings.TrimSuffix(key, filepath.Ext(key))
-				if cleanKey == "" {
-					cleanKey = key
-				}
-				norm := normalizeAssetKey(cleanKey)
-				if norm == "" {
-					continue
-				}
-				if _, exists := resolved[norm]; exists {
-					continue
-				}
-				absPath := filepath.Join(mediaDir, key)
-				resolved[norm] = assetCandidate{name: cleanKey, path: absPath}
-				rel, relErr := filepath.Rel(metadataDir, absPath)
-				if relErr != nil {
-					rel = absPath
-				}
-				fallbackValues[norm] = fallbackAssetField{
-					Name: cleanKey,
-					Path: filepath.ToSlash(rel),
-				}
-			}
-		}
-	}
-
-	names := make([]string, 0, len(resolved))
-	for _, candidate := range resolved {
-		names = append(names, candidate.name)
-	}
-	sort.Slice(names, func(i, j int) bool {
-		return strings.ToLower(names[i]) < strings.ToLower(names[j])
-	})
-
-	var out []*assetPayload
-	for _, name := range names {
-		norm := normalizeAssetKey(name)
-		candidate, ok := resolved[norm]
-		if !ok {
+	for _, file := range assets.findAssets(layoutOrDefault(layout), metadataDir, romBase) {
+		norm := normalizeAssetKey(file.Name)
+		if norm == "" {
 			continue
 		}
-		if candidate.path == "" {
+		if _, exists := resolved[norm]; exists {
 			continue
 		}
-		id, err := store.Register(candidate.path)
-		if err != nil {
-			if !romMissing { //rom不存在, 那么就没必要打这个日志了, 总不能rom不存在, 但是media存在吧...
-				logger.Warn("skip asset", zap.String("game", game.Title), zap.String("asset", candidate.name), zap.String("path", candidate.path), zap.Error(err))
-			}
-			continue
+		resolved[norm] = file
+		rel, relErr := filepath.Rel(metadataDir, file.Path)
+		if relErr != nil {
+			rel = file.Path
+		}
+		fallbackValues[norm] = fallbackAssetField{
+			Name: file.Name,
+			Path: filepath.ToSlash(rel),
 		}
-		out = append(out, &assetPayload{
-			Name:     candidate.name,
-			Type:     detectAssetType(candidate.path),
-			URL:      "/api/assets/" + id,
-			FileName: filepath.Base(candidate.path),
-		})
 	}
+
+	files := make([]gameAssetFile, 0, len(resolved))
+	for _, file := range resolved {
+		if file.Path != "" {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
+	})
 	// remove fallback entries that already existed in metadata
 	for norm, entry := range fallbackValues {
 		if _, ok := metadataKeys[norm]; ok {
@@ -2776,6 +3076,27 @@ func collectGameAssets(metadataDir string, game metadata.Game, romBase string, r
 			delete(fallbackValues, norm)
 		}
 	}
+	return files, fallbackValues
+}
+
+func collectGameAssets(index *mediaAssetIndex, layout *mediaLayout, metadataDir string, game metadata.Game, romBase string, romMissing bool, store *assetStore, logger *zap.Logger) ([]*assetPayload, map[string]fallbackAssetField) {
+	files, fallbackValues := resolveGameAssetFiles(index, layout, metadataDir, game, romBase)
+	var out []*assetPayload
+	for _, file := range files {
+		url, err := store.URL(file.Path)
+		if err != nil {
+			if !romMissing { //rom不存在, 那么就没必要打这个日志了, 总不能rom不存在, 但是media存在吧...
+				logger.Warn("skip asset", zap.String("game", game.Title), zap.String("asset", file.Name), zap.String("path", file.Path), zap.Error(err))
+			}
+			continue
+		}
+		out = append(out, &assetPayload{
+			Name:     file.Name,
+			Type:     detectAssetType(file.Path),
+			URL:      url,
+			FileName: filepath.Base(file.Path),
+		})
+	}
 	return out, fallbackValues
 }
 
@@ -2822,7 +3143,7 @@ func newAssetStore(root string) (*assetStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &assetStore{root: filepath.Clean(abs), files: make(map[string]string)}, nil
+	return &assetStore{root: filepath.Clean(abs), files: make(map[string]string), digests: make(map[string]*assetDigest)}, nil
 }
 
 func (s *assetStore) Register(path string) (string, error) {
@@ -2852,6 +3173,21 @@ func (s *assetStore) Register(path string) (string, error) {
 	return id, nil
 }
 
+// URL registers path and returns its asset URL, versioned by the file's
+// fingerprint so the browser may cache it for good: a changed file gets a
+// new URL on the next reload.
+func (s *assetStore) URL(path string) (string, error) {
+	id, err := s.Register(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return assetURL(id, assetVersion(info)), nil
+}
+
 func (s *assetStore) Lookup(id string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -2900,23 +3236,6 @@ func buildGameID(collectionID string, idx int) string {
 	return fmt.Sprintf("%s-game-%d", collectionID, idx)
 }
 
-func deriveCore(launch string) string {
-	launch = strings.TrimSpace(launch)
-	if launch == "" || !strings.Contains(strings.ToLower(launch), "retroarch") {
-		return ""
-	}
-	normalizedLaunch := strings.ReplaceAll(launch, "\\", "/")
-	args, err := shlex.Split(normalizedLaunch)
-	if err != nil || len(args) == 0 {
-		return ""
-	}
-	fs := pflag.NewFlagSet("launch", pflag.ContinueOnError)
-	fs.ParseErrorsWhitelist.UnknownFlags = true
-	corePath := fs.StringP("libretro", "L", "", "libretro core")
-	_ = fs.Parse(args)
-	return coreNameFromPath(*corePath)
-}
-
 func coreNameFromPath(p string) string {
 	p = strings.Trim(p, "\"'")
 	p = normalizePath(p)
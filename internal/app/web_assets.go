@@ -0,0 +1,170 @@
+package app
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	assetVersionParam = "v"
+	// assetImmutableCache lets the browser keep a versioned asset for a year
+	// without asking again; the URL changes whenever the file does.
+	assetImmutableCache = "public, max-age=31536000, immutable"
+	// assetRevalidateCache applies to unversioned or stale URLs, which are
+	// checked against the ETag on every use.
+	assetRevalidateCache = "no-cache"
+)
+
+// assetVideoTypes covers video formats missing from Go's built-in MIME
+// table, which would otherwise depend on the host's mime.types.
+var assetVideoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+}
+
+// assetVersion fingerprints a file by size and modification time. It is
+// computed for every media file on each load, so it must not read the file;
+// any write through retrog or a file copy changes it.
+func assetVersion(info os.FileInfo) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:6])
+}
+
+// assetDigest is the content hash of a file together with the size and
+// modification time it was computed for.
+type assetDigest struct {
+	size    int64
+	modTime int64
+	digest  string
+}
+
+// digest returns the content hash of path, used as its ETag so a touched
+// but unchanged file still revalidates. It is computed on the first request
+// for the file and kept in memory and, when the store has a cache
+// directory, on disk across restarts, until the size or modification time
+// changes.
+func (s *assetStore) digest(path string, info os.FileInfo) (string, error) {
+	size, modTime := info.Size(), info.ModTime().UnixNano()
+	s.mu.RLock()
+	d, ok := s.digests[path]
+	s.mu.RUnlock()
+	if ok && d.size == size && d.modTime == modTime {
+		return d.digest, nil
+	}
+	cacheFile := ""
+	if s.digestDir != "" {
+		sum := sha1.Sum([]byte(path))
+		cacheFile = filepath.Join(s.digestDir, hex.EncodeToString(sum[:]))
+		if data, err := os.ReadFile(cacheFile); err == nil {
+			var cached assetDigest
+			if _, err := fmt.Sscanf(string(data), "%d %d %s", &cached.size, &cached.modTime, &cached.digest); err == nil && cached.size == size && cached.modTime == modTime {
+				d = &cached
+			}
+		}
+	}
+	if d == nil || d.size != size || d.modTime != modTime {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		h := sha1.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		d = &assetDigest{size: size, modTime: modTime, digest: hex.EncodeToString(h.Sum(nil)[:8])}
+		if cacheFile != "" {
+			if err := os.WriteFile(cacheFile, []byte(fmt.Sprintf("%d %d %s\n", d.size, d.modTime, d.digest)), 0o644); err != nil {
+				return "", err
+			}
+		}
+	}
+	s.mu.Lock()
+	s.digests[path] = d
+	s.mu.Unlock()
+	return d.digest, nil
+}
+
+func assetURL(id, version string) string {
+	return "api/assets/" + id + "?" + assetVersionParam + "=" + version
+}
+
+// handleAsset serves a registered media file with an ETag and
+// Last-Modified, and Range support for seeking in videos. Requests carrying
+// the current version may be cached indefinitely. With `w` set, images are
+// scaled down to that width through the thumbnail cache.
+func (c *WebCommand) handleAsset(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/assets/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	path, ok := c.assets.Lookup(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	width, err := parseThumbWidth(r.URL.Query().Get(thumbWidthParam))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	version := assetVersion(info)
+	etag, err := c.assets.digest(path, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if width > 0 && c.thumbs != nil && isThumbnailSource(path) {
+		thumb, err := c.thumbs.thumbnail(path, version, width)
+		switch {
+		case err == nil:
+			tf, err := os.Open(thumb)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer tf.Close()
+			path, f = thumb, tf
+			etag = fmt.Sprintf("%s-w%d", etag, width)
+		case errors.Is(err, errThumbNotNeeded):
+		default:
+			logutil.GetLogger(r.Context()).Warn("render thumbnail failed", zap.String("path", path), zap.Error(err))
+		}
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	if r.URL.Query().Get(assetVersionParam) == version {
+		w.Header().Set("Cache-Control", assetImmutableCache)
+	} else {
+		w.Header().Set("Cache-Control", assetRevalidateCache)
+	}
+	if ctype, ok := assetVideoTypes[strings.ToLower(filepath.Ext(path))]; ok {
+		w.Header().Set("Content-Type", ctype)
+	}
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), f)
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAssetCaching(t *testing.T) {
+	root := t.TempDir()
+	video := filepath.Join(root, "media", "game", "video.mp4")
+	writeTestFile(t, video, "0123456789")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store}
+	url, err := store.URL(video)
+	if err != nil {
+		t.Fatalf("asset url: %v", err)
+	}
+	serve := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+target, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		c.handleAsset(rec, req)
+		return rec
+	}
+
+	rec := serve(url, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, assetImmutableCache, rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "video/mp4", rec.Header().Get("Content-Type"))
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, rec.Header().Get("Last-Modified"))
+
+	rec = serve(url, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = serve(url, http.Header{"Range": {"bytes=2-5"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "2345", rec.Body.String())
+
+	// Touching the file changes the URL, but the content hash ETag still
+	// matches, so the old URL revalidates without a download.
+	touched := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(video, touched, touched); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	same, err := store.URL(video)
+	if err != nil {
+		t.Fatalf("asset url: %v", err)
+	}
+	assert.NotEqual(t, url, same)
+	rec = serve(url, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, assetRevalidateCache, rec.Header().Get("Cache-Control"))
+
+	// Replacing the file changes its URL; the old one must revalidate.
+	writeTestFile(t, video, "changed content")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(video, later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	next, err := store.URL(video)
+	if err != nil {
+		t.Fatalf("asset url: %v", err)
+	}
+	assert.NotEqual(t, url, next)
+	assert.Equal(t, strings.SplitN(url, "?", 2)[0], strings.SplitN(next, "?", 2)[0], "id is stable")
+	rec = serve(url, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, assetRevalidateCache, rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "changed content", rec.Body.String())
+}
+
+func TestAssetDigestCache(t *testing.T) {
+	root := t.TempDir()
+	logo := filepath.Join(root, "media", "game", "logo.png")
+	writeTestFile(t, logo, "logo")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	store.digestDir = t.TempDir()
+	info, err := os.Stat(logo)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	digest, err := store.digest(logo, info)
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	entries, err := os.ReadDir(store.digestDir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one persisted digest, got %v %v", entries, err)
+	}
+
+	// A new store, as after a restart, takes the digest from disk while the
+	// size and modification time match.
+	cacheFile := filepath.Join(store.digestDir, entries[0].Name())
+	writeTestFile(t, cacheFile, fmt.Sprintf("%d %d cached\n", info.Size(), info.ModTime().UnixNano()))
+	restarted, _ := newAssetStore(root)
+	restarted.digestDir = store.digestDir
+	cached, err := restarted.digest(logo, info)
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	assert.Equal(t, "cached", cached)
+
+	writeTestFile(t, logo, "new logo")
+	info, _ = os.Stat(logo)
+	changed, err := restarted.digest(logo, info)
+	if err != nil {
+		t.Fatalf("digest: %v", err)
+	}
+	assert.NotEqual(t, digest, changed)
+	assert.NotEqual(t, "cached", changed)
+}
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	csrfHeader      = "X-Retrog-CSRF"
+	authTokenCookie = "retrog_token"
+	authTokenParam  = "token"
+	authRealm       = "retrog"
+)
+
+// webAuth guards every route of the web server: it checks credentials when
+// a basic auth or token file is configured, rejects writes in read-only
+// mode and requires the CSRF token on every unsafe request.
+type webAuth struct {
+	users      map[string]string
+	tokens     []string
+	csrfToken  string
+	readonly   bool
+	cookiePath string
+}
+
+func newWebAuth(basicFile, tokenFile string, readonly bool) (*webAuth, error) {
+	a := &webAuth{readonly: readonly, cookiePath: "/"}
+	if basicFile != "" {
+		lines, err := readAuthFile(basicFile)
+		if err != nil {
+			return nil, err
+		}
+		a.users = make(map[string]string, len(lines))
+		for idx, line := range lines {
+			user, password, ok := strings.Cut(line, ":")
+			if !ok || user == "" || password == "" {
+				return nil, fmt.Errorf("%s: entry %d is not user:password", basicFile, idx+1)
+			}
+			a.users[user] = password
+		}
+	}
+	if tokenFile != "" {
+		lines, err := readAuthFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens = lines
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	a.csrfToken = token
+	return a, nil
+}
+
+// readAuthFile returns the non-empty lines of path, skipping # comments. An
+// auth file without entries is an error, as it would lock everyone out.
+func readAuthFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s has no entries", path)
+	}
+	return out, nil
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (a *webAuth) enabled() bool {
+	return len(a.users) > 0 || len(a.tokens) > 0
+}
+
+func (a *webAuth) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, want := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *webAuth) validUser(user, password string) bool {
+	want, ok := a.users[user]
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// wrap applies authentication, read-only mode and CSRF checks to next.
+func (a *webAuth) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer := false
+		if a.enabled() {
+			var ok bool
+			ok, bearer = a.authenticate(w, r)
+			if !ok {
+				return
+			}
+		}
+		if !isSafeMethod(r.Method) {
+			if a.readonly {
+				http.Error(w, "read-only mode", http.StatusForbidden)
+				return
+			}
+			// A bearer token is never sent by the browser on its own, so
+			// such requests cannot be forged by another site.
+			if !bearer && subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(a.csrfToken)) != 1 {
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks, in order, a bearer token, the token cookie, a token
+// in the query string and basic auth credentials. A valid query token is
+// moved into a cookie and the browser redirected to the clean URL, so the
+// token does not linger in the address bar. It reports whether the request
+// may proceed and whether it used a bearer token; on failure the response
+// has been written.
+func (a *webAuth) authenticate(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && a.validToken(strings.TrimSpace(token)) {
+		return true, true
+	}
+	if cookie, err := r.Cookie(authTokenCookie); err == nil && a.validToken(cookie.Value) {
+		return true, false
+	}
+	if token := r.URL.Query().Get(authTokenParam); token != "" && a.validToken(token) && r.Method == http.MethodGet {
+		http.SetCookie(w, &http.Cookie{
+			Name:     authTokenCookie,
+			Value:    token,
+			Path:     a.cookiePath,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+			Expires:  time.Now().Add(30 * 24 * time.Hour),
+		})
+		clean := *r.URL
+		query := clean.Query()
+		query.Del(authTokenParam)
+		clean.RawQuery = query.Encode()
+		http.Redirect(w, r, clean.RequestURI(), http.StatusSeeOther)
+		return false, false
+	}
+	if user, password, ok := r.BasicAuth(); ok && a.validUser(user, password) {
+		return true, false
+	}
+	if len(a.users) > 0 {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", authRealm))
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false, false
+}
+
+// indexMeta is injected into the page head so app.js can send the CSRF
+// token and hide editing controls in read-only mode.
+func (a *webAuth) indexMeta() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n  <meta name=\"retrog-csrf\" content=\"%s\">", html.EscapeString(a.csrfToken))
+	if a.readonly {
+		b.WriteString("\n  <meta name=\"retrog-readonly\" content=\"1\">")
+	}
+	return b.String()
+}
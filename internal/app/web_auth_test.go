@@ -0,0 +1,88 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebAuth(t *testing.T) {
+	dir := t.TempDir()
+	basicFile := filepath.Join(dir, "users")
+	tokenFile := filepath.Join(dir, "tokens")
+	writeTestFile(t, basicFile, "# household\nalice:secret\n")
+	writeTestFile(t, tokenFile, "tok-1\n")
+	auth, err := newWebAuth(basicFile, tokenFile, false)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/collections", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/collections", nil)
+	req.SetBasicAuth("alice", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, serve(req).Code)
+	req.SetBasicAuth("alice", "secret")
+	assert.Equal(t, http.StatusNoContent, serve(req).Code)
+
+	// A basic auth write still needs the CSRF token of the page.
+	req = httptest.NewRequest(http.MethodPost, "/api/games/delete", strings.NewReader("{}"))
+	req.SetBasicAuth("alice", "secret")
+	assert.Equal(t, http.StatusForbidden, serve(req).Code)
+	req.Header.Set(csrfHeader, auth.csrfToken)
+	assert.Equal(t, http.StatusNoContent, serve(req).Code)
+
+	// Bearer tokens are not ambient credentials, so no CSRF token is needed.
+	req = httptest.NewRequest(http.MethodPost, "/api/games/delete", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer tok-1")
+	assert.Equal(t, http.StatusNoContent, serve(req).Code)
+
+	// A query token becomes a cookie and the token leaves the URL.
+	rec = serve(httptest.NewRequest(http.MethodGet, "/?token=tok-1&x=1", nil))
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/?x=1", rec.Header().Get("Location"))
+	cookies := rec.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.True(t, cookies[0].HttpOnly)
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookies[0])
+		assert.Equal(t, http.StatusNoContent, serve(req).Code)
+	}
+}
+
+func TestWebAuthReadonly(t *testing.T) {
+	auth, err := newWebAuth("", "", true)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/collections", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code, "no auth configured")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/games/update", strings.NewReader("{}"))
+	req.Header.Set(csrfHeader, auth.csrfToken)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, auth.indexMeta(), `name="retrog-readonly"`)
+
+	_, err = newWebAuth(filepath.Join(t.TempDir(), "missing"), "", false)
+	assert.Error(t, err)
+}
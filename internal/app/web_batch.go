@@ -0,0 +1,452 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+type batchTarget struct {
+	MetadataPath string `json:"metadata_path"`
+	XIndexIDs    []int  `json:"x_index_ids"`
+}
+
+type batchOperation struct {
+	Op          string   `json:"op"`
+	Field       string   `json:"field"`
+	Values      []string `json:"values,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	Regex       bool     `json:"regex,omitempty"`
+}
+
+type batchUpdateRequest struct {
+	Targets    []*batchTarget    `json:"targets"`
+	Operations []*batchOperation `json:"operations"`
+}
+
+type batchGameResult struct {
+	MetadataPath string `json:"metadata_path"`
+	XIndexID     int    `json:"x_index_id"`
+	Title        string `json:"title,omitempty"`
+	Changed      bool   `json:"changed"`
+	Error        string `json:"error,omitempty"`
+}
+
+type batchUpdateResponse struct {
+	Results     []*batchGameResult   `json:"results"`
+	Collections []*collectionPayload `json:"collections"`
+}
+
+const (
+	batchOpSet     = "set"
+	batchOpAppend  = "append"
+	batchOpRemove  = "remove"
+	batchOpReplace = "replace"
+)
+
+// compiledBatchOperation is a validated batchOperation ready to be applied to
+// game blocks.
+type compiledBatchOperation struct {
+	op       string
+	key      string
+	values   []string
+	replacer *valueReplacer
+}
+
+func (c *WebCommand) handleBatchUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req batchUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	ops, err := compileBatchOperations(req.Operations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targets, order, err := c.groupBatchTargets(req.Targets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tx := c.history.begin("批量编辑")
+	defer c.finishChange(r, tx)
+	if err := tx.snapshot(order...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var results []*batchGameResult
+	for _, metadataPath := range order {
+		res, err := applyBatchToFile(metadataPath, targets[metadataPath], ops)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("batch update %s failed: %v", filepath.ToSlash(metadataPath), err), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, res...)
+	}
+	if err := c.reloadCollections(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("reload collections failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	resp := &batchUpdateResponse{Results: results}
+	for _, metadataPath := range order {
+		resp.Collections = append(resp.Collections, c.findCollectionsByPath(filepath.ToSlash(metadataPath))...)
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
+func (c *WebCommand) groupBatchTargets(targets []*batchTarget) (map[string][]int, []string, error) {
+	grouped := make(map[string][]int)
+	seen := make(map[string]struct{})
+	var order []string
+	for _, target := range targets {
+		if target == nil {
+			continue
+		}
+		metadataPath, err := c.resolveMetadataPath(target.MetadataPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := grouped[metadataPath]; !ok {
+			order = append(order, metadataPath)
+			grouped[metadataPath] = nil
+		}
+		for _, id := range target.XIndexIDs {
+			if id <= 0 {
+				return nil, nil, errors.New("x_index_id must be positive")
+			}
+			key := buildGameKey(metadataPath, id)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			grouped[metadataPath] = append(grouped[metadataPath], id)
+		}
+	}
+	if len(seen) == 0 {
+		return nil, nil, errors.New("targets must select at least one game")
+	}
+	return grouped, order, nil
+}
+
+func (c *WebCommand) findCollectionsByPath(metadataPath string) []*collectionPayload {
+	metadataPath = filepath.ToSlash(metadataPath)
+	c.dataMu.RLock()
+	defer c.dataMu.RUnlock()
+	var out []*collectionPayload
+	for _, coll := range c.collections {
+		if coll != nil && filepath.ToSlash(coll.MetadataPath) == metadataPath {
+			out = append(out, coll)
+		}
+	}
+	return out
+}
+
+func compileBatchOperations(ops []*batchOperation) ([]*compiledBatchOperation, error) {
+	if len(ops) == 0 {
+		return nil, errors.New("operations must not be empty")
+	}
+	out := make([]*compiledBatchOperation, 0, len(ops))
+	for idx, op := range ops {
+		if op == nil {
+			continue
+		}
+		key := metadata.NormalizeKey(op.Field)
+		if key == "" {
+			return nil, fmt.Errorf("operation %d: field is required", idx+1)
+		}
+		if key == xIndexEntryKey {
+			return nil, fmt.Errorf("operation %d: field %s is read only", idx+1, key)
+		}
+		compiled := &compiledBatchOperation{
+			op:  strings.ToLower(strings.TrimSpace(op.Op)),
+			key: key,
+		}
+		switch compiled.op {
+		case batchOpSet, batchOpAppend, batchOpRemove:
+			compiled.values = normalizeFieldValuesForKey(key, op.Values)
+			if compiled.op != batchOpSet && len(compiled.values) == 0 {
+				return nil, fmt.Errorf("operation %d: values are required for %s", idx+1, compiled.op)
+			}
+		case batchOpReplace:
+			replacer, err := newValueReplacer(op.Pattern, op.Replacement, op.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", idx+1, err)
+			}
+			compiled.replacer = replacer
+		default:
+			return nil, fmt.Errorf("operation %d: unsupported op %q", idx+1, op.Op)
+		}
+		out = append(out, compiled)
+	}
+	return out, nil
+}
+
+// applyBatchToFile parses metadataPath once, applies ops to every selected
+// game and writes the file back once when anything changed. A game whose
+// operations fail keeps its original entries.
+func applyBatchToFile(metadataPath string, ids []int, ops []*compiledBatchOperation) ([]*batchGameResult, error) {
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	slashPath := filepath.ToSlash(metadataPath)
+	results := make([]*batchGameResult, 0, len(ids))
+	dirty := false
+	for _, id := range ids {
+		res := &batchGameResult{MetadataPath: slashPath, XIndexID: id}
+		results = append(results, res)
+		block, _, err := findGameBlockByIndexID(doc, id)
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		res.Title = getBlockTitle(block)
+		entries, changed, err := applyBatchOperations(block.Entries, ops)
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if err := validateMinimalGameFields(entriesToFieldMap(entries)); err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		block.Entries = entries
+		res.Changed = true
+		res.Title = getBlockTitle(block)
+		dirty = true
+	}
+	if dirty {
+		if err := metadata.WriteMetadataFile(metadataPath, doc); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// applyBatchOperations applies ops to a copy of entries and reports whether
+// any value changed.
+func applyBatchOperations(entries []*metadata.Entry, ops []*compiledBatchOperation) ([]*metadata.Entry, bool, error) {
+	out := make([]*metadata.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		out = append(out, &metadata.Entry{
+			Key:    entry.Key,
+			Values: append([]string(nil), entry.Values...),
+			Inline: entry.Inline,
+		})
+	}
+	changed := false
+	for _, op := range ops {
+		var current []string
+		for _, entry := range out {
+			if entry.Key == op.key {
+				current = append(current, entry.Values...)
+			}
+		}
+		next, err := applyBatchOperation(op, current)
+		if err != nil {
+			return nil, false, err
+		}
+		if equalStrings(current, next) {
+			continue
+		}
+		out = replaceEntryValues(out, op.key, next)
+		changed = true
+	}
+	return out, changed, nil
+}
+
+func applyBatchOperation(op *compiledBatchOperation, current []string) ([]string, error) {
+	csv := isCSVFieldKey(op.key)
+	switch op.op {
+	case batchOpSet:
+		if csv {
+			return joinCSVValues(splitCSVValues(op.values)), nil
+		}
+		return append([]string(nil), op.values...), nil
+	case batchOpAppend:
+		if csv {
+			items := splitCSVValues(current)
+			for _, item := range splitCSVValues(op.values) {
+				if !containsFold(items, item) {
+					items = append(items, item)
+				}
+			}
+			return joinCSVValues(items), nil
+		}
+		next := append([]string(nil), current...)
+		for _, value := range op.values {
+			if !containsString(next, value) {
+				next = append(next, value)
+			}
+		}
+		return next, nil
+	case batchOpRemove:
+		if csv {
+			var items []string
+			for _, item := range splitCSVValues(current) {
+				if !containsFold(splitCSVValues(op.values), item) {
+					items = append(items, item)
+				}
+			}
+			return joinCSVValues(items), nil
+		}
+		var next []string
+		for _, value := range current {
+			if !containsString(op.values, value) {
+				next = append(next, value)
+			}
+		}
+		return next, nil
+	case batchOpReplace:
+		var next []string
+		for _, value := range current {
+			replaced := strings.TrimSpace(op.replacer.Replace(value))
+			if replaced != "" {
+				next = append(next, replaced)
+			}
+		}
+		return next, nil
+	default:
+		return nil, fmt.Errorf("unsupported op %q", op.op)
+	}
+}
+
+// replaceEntryValues stores values into the first entry named key, dropping
+// any duplicate entries, or appends a new entry when key is absent.
+func replaceEntryValues(entries []*metadata.Entry, key string, values []string) []*metadata.Entry {
+	out := make([]*metadata.Entry, 0, len(entries)+1)
+	placed := false
+	for _, entry := range entries {
+		if entry.Key != key {
+			out = append(out, entry)
+			continue
+		}
+		if placed || len(values) == 0 {
+			continue
+		}
+		entry.Values = values
+		entry.Inline = len(values) == 1
+		out = append(out, entry)
+		placed = true
+	}
+	if !placed && len(values) > 0 {
+		out = append(out, &metadata.Entry{Key: key, Values: values, Inline: len(values) == 1})
+	}
+	return out
+}
+
+func entriesToFieldMap(entries []*metadata.Entry) map[string][]string {
+	out := make(map[string][]string)
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		out[entry.Key] = append(out[entry.Key], entry.Values...)
+	}
+	return out
+}
+
+func isCSVFieldKey(key string) bool {
+	switch metadata.NormalizeKey(key) {
+	case "genre", "tag", "developer", "publisher":
+		return true
+	default:
+		return false
+	}
+}
+
+func splitCSVValues(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if trimmed := strings.TrimSpace(part); trimmed != "" {
+				out = append(out, trimmed)
+			}
+		}
+	}
+	return out
+}
+
+func joinCSVValues(items []string) []string {
+	if len(items) == 0 {
+		return nil
+	}
+	return []string{strings.Join(items, ", ")}
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// valueReplacer performs a literal or regular expression replacement on a
+// single metadata value.
+type valueReplacer struct {
+	literal     string
+	re          *regexp.Regexp
+	replacement string
+}
+
+func newValueReplacer(pattern, replacement string, useRegex bool) (*valueReplacer, error) {
+	if pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	if !useRegex {
+		return &valueReplacer{literal: pattern, replacement: replacement}, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return &valueReplacer{re: re, replacement: replacement}, nil
+}
+
+func (r *valueReplacer) Replace(value string) string {
+	if r.re != nil {
+		return r.re.ReplaceAllString(value, r.replacement)
+	}
+	return strings.ReplaceAll(value, r.literal, r.replacement)
+}
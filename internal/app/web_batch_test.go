@@ -0,0 +1,88 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+func TestApplyBatchToFile(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, `collection: Arcade
+
+game: Alpha
+file: alpha.zip
+genre: Action, Shooter
+developer: CAPCOM
+x-index-id: 1
+
+game: Beta
+file: beta.zip
+developer: Capcom Co.
+x-index-id: 2
+`)
+
+	ops, err := compileBatchOperations([]*batchOperation{
+		{Op: "append", Field: "genres", Values: []string{"Arcade, action"}},
+		{Op: "remove", Field: "genre", Values: []string{"shooter"}},
+		{Op: "replace", Field: "developer", Pattern: `(?i)^capcom.*$`, Replacement: "Capcom", Regex: true},
+	})
+	if err != nil {
+		t.Fatalf("compile operations: %v", err)
+	}
+	results, err := applyBatchToFile(metaPath, []int{1, 2, 3}, ops)
+	if err != nil {
+		t.Fatalf("apply batch: %v", err)
+	}
+	if assert.Len(t, results, 3) {
+		assert.True(t, results[0].Changed)
+		assert.True(t, results[1].Changed)
+		assert.NotEmpty(t, results[2].Error)
+	}
+
+	doc, err := metadata.ParseMetadataFile(metaPath)
+	if err != nil {
+		t.Fatalf("parse metadata: %v", err)
+	}
+	alpha, _, err := findGameBlockByIndexID(doc, 1)
+	if err != nil {
+		t.Fatalf("find alpha: %v", err)
+	}
+	assert.Equal(t, []string{"Action, Arcade"}, alpha.Entry("genre").Values)
+	assert.Equal(t, []string{"Capcom"}, alpha.Entry("developer").Values)
+	beta, _, err := findGameBlockByIndexID(doc, 2)
+	if err != nil {
+		t.Fatalf("find beta: %v", err)
+	}
+	assert.Equal(t, []string{"Arcade, action"}, beta.Entry("genre").Values)
+	assert.Equal(t, []string{"Capcom"}, beta.Entry("developer").Values)
+}
+
+func TestApplyBatchKeepsRequiredFields(t *testing.T) {
+	dir := t.TempDir()
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, `collection: Arcade
+
+game: Alpha
+file: alpha.zip
+x-index-id: 1
+`)
+	ops, err := compileBatchOperations([]*batchOperation{{Op: "set", Field: "file"}})
+	if err != nil {
+		t.Fatalf("compile operations: %v", err)
+	}
+	results, err := applyBatchToFile(metaPath, []int{1}, ops)
+	if err != nil {
+		t.Fatalf("apply batch: %v", err)
+	}
+	if assert.Len(t, results, 1) {
+		assert.False(t, results[0].Changed)
+		assert.NotEmpty(t, results[0].Error)
+	}
+
+	_, err = compileBatchOperations([]*batchOperation{{Op: "set", Field: "x-index-id", Values: []string{"9"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxxsen/retrog/internal/constant"
+)
+
+const (
+	eventBufferSize   = 64
+	eventPingInterval = 30 * time.Second
+
+	eventCollectionsChanged = "collections.changed"
+	eventRomCheck           = "romcheck.progress"
+
+	// eventClientHeader identifies the browser tab behind a request, so the
+	// tab can skip the echo of its own changes.
+	eventClientHeader = "X-Retrog-Client"
+
+	eventSourceWeb  = "web"
+	eventSourceDisk = "disk"
+
+	romCheckStarted  = "started"
+	romCheckProgress = "progress"
+	romCheckFinished = "finished"
+	romCheckFailed   = "failed"
+)
+
+// webEvent is a message pushed to every browser connected to /api/events.
+type webEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// collectionsChangedEvent carries the new state of every collection of the
+// touched metadata files. Removed lists metadata paths without collections.
+type collectionsChangedEvent struct {
+	Collections []*collectionPayload `json:"collections"`
+	Removed     []string             `json:"removed,omitempty"`
+	Source      string               `json:"source"`
+	Label       string               `json:"label,omitempty"`
+	Client      string               `json:"client,omitempty"`
+}
+
+// romCheckEvent reports a ROM check. Progress events carry the games whose
+// status changed since the previous one.
+type romCheckEvent struct {
+	Family string                  `json:"family"`
+	State  string                  `json:"state"`
+	Files  int                     `json:"files,omitempty"`
+	Count  int                     `json:"count,omitempty"`
+	Done   int                     `json:"done,omitempty"`
+	Total  int                     `json:"total,omitempty"`
+	Games  []*gameRomStatusPayload `json:"games,omitempty"`
+	Error  string                  `json:"error,omitempty"`
+}
+
+type gameRomStatusPayload struct {
+	MetadataPath string `json:"metadata_path"`
+	XIndexID     int    `json:"x_index_id"`
+	RomStatus    string `json:"rom_status"`
+	RomEmoji     string `json:"rom_status_emoji"`
+}
+
+// eventHub fans events out to subscribers. Slow subscribers miss events
+// rather than blocking the publisher.
+type eventHub struct {
+	mu        sync.Mutex
+	subs      map[chan *webEvent]struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan *webEvent]struct{}), done: make(chan struct{})}
+}
+
+// close ends every event stream, letting the server shut down.
+func (h *eventHub) close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+func (h *eventHub) subscribe() (<-chan *webEvent, func()) {
+	ch := make(chan *webEvent, eventBufferSize)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *eventHub) publish(evt *webEvent) {
+	if h == nil || evt == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+func (c *WebCommand) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, cancel := c.events.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.events.done:
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case evt := <-events:
+			data, err := json.Marshal(evt.Data)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// finishChange closes a web operation and tells every browser about the
+// collections it touched.
+func (c *WebCommand) finishChange(r *http.Request, tx *historyTx) {
+	c.finishChangeFrom(r.Header.Get(eventClientHeader), tx)
+}
+
+// finishChangeFrom records tx and publishes the change on behalf of client,
+// "" for changes made by background jobs.
+func (c *WebCommand) finishChangeFrom(client string, tx *historyTx) {
+	entry := c.history.finish(tx)
+	if entry == nil {
+		return
+	}
+	c.publishEntryChange(client, entry, entry.label)
+}
+
+func (c *WebCommand) publishEntryChange(client string, entry *historyEntry, label string) {
+	paths := c.history.entryPaths(entry)
+	current := c.collectionsSnapshot()
+	metaPaths := affectedMetadataPaths(current, paths)
+	if len(metaPaths) == 0 {
+		return
+	}
+	evt := &collectionsChangedEvent{
+		Collections: make([]*collectionPayload, 0),
+		Source:      eventSourceWeb,
+		Label:       label,
+		Client:      client,
+	}
+	seen := make(map[string]struct{})
+	for _, coll := range current {
+		if _, ok := metaPaths[filepath.ToSlash(coll.MetadataPath)]; ok {
+			evt.Collections = append(evt.Collections, summarizeCollection(coll))
+			seen[filepath.ToSlash(coll.MetadataPath)] = struct{}{}
+		}
+	}
+	for path := range metaPaths {
+		if _, ok := seen[path]; !ok {
+			evt.Removed = append(evt.Removed, path)
+		}
+	}
+	sort.Strings(evt.Removed)
+	c.events.publish(&webEvent{Type: eventCollectionsChanged, Data: evt})
+}
+
+// affectedMetadataPaths maps changed library paths to the slash separated
+// metadata files describing them: changed metadata files themselves, the
+// metadata file of the directory a path lives in, and metadata files below a
+// changed directory.
+func affectedMetadataPaths(cols []*collectionPayload, paths []string) map[string]struct{} {
+	out := make(map[string]struct{})
+	for _, path := range paths {
+		if strings.EqualFold(filepath.Base(path), constant.DefaultMetadataFile) {
+			out[filepath.ToSlash(path)] = struct{}{}
+		}
+		for _, coll := range cols {
+			metaPath := filepath.FromSlash(coll.MetadataPath)
+			if isWithinDir(path, filepath.Dir(metaPath)) || isWithinDir(metaPath, path) {
+				out[coll.MetadataPath] = struct{}{}
+			}
+		}
+	}
+	return out
+}
+
+func (c *WebCommand) publishRomCheck(evt *romCheckEvent) {
+	c.events.publish(&webEvent{Type: eventRomCheck, Data: evt})
+}
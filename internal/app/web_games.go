@@ -0,0 +1,281 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGamePageLimit = 100
+	maxGamePageLimit     = 1000
+
+	collectionsViewSummary = "summary"
+)
+
+const (
+	missingFilterAll     = ""
+	missingFilterOnly    = "only"
+	missingFilterExclude = "exclude"
+)
+
+// gameQuery filters the games returned by the paginated endpoints.
+type gameQuery struct {
+	terms    []string
+	statuses map[string]struct{}
+	missing  string
+	offset   int
+	limit    int
+}
+
+// gamePageItem is a game summary tagged with the collection it belongs to.
+type gamePageItem struct {
+	CollectionID string `json:"collection_id"`
+	gamePayload
+}
+
+type gameListRef struct {
+	collectionID string
+	game         *gamePayload
+}
+
+type gamePageResponse struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Games  []*gamePageItem `json:"games"`
+}
+
+type gameDetailResponse struct {
+	CollectionID string       `json:"collection_id"`
+	Game         *gamePayload `json:"game"`
+}
+
+// parseGameQuery reads offset, limit, q, status and missing from the query
+// string. q is split on whitespace and every term must match; status is a
+// comma separated list of ROM states.
+func parseGameQuery(values url.Values) (*gameQuery, error) {
+	q := &gameQuery{limit: defaultGamePageLimit}
+	if raw := strings.TrimSpace(values.Get("offset")); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", raw)
+		}
+		q.offset = offset
+	}
+	if raw := strings.TrimSpace(values.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", raw)
+		}
+		q.limit = min(limit, maxGamePageLimit)
+	}
+	q.terms = strings.Fields(strings.ToLower(values.Get("q")))
+	for _, status := range strings.Split(values.Get("status"), ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		if status == "" {
+			continue
+		}
+		switch romStatus(status) {
+		case romStatusGreen, romStatusYellow, romStatusRed, romStatusNotTested:
+		default:
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+		if q.statuses == nil {
+			q.statuses = make(map[string]struct{})
+		}
+		q.statuses[status] = struct{}{}
+	}
+	switch strings.ToLower(strings.TrimSpace(values.Get("missing"))) {
+	case "", "all":
+		q.missing = missingFilterAll
+	case "1", "true", "only":
+		q.missing = missingFilterOnly
+	case "0", "false", "exclude":
+		q.missing = missingFilterExclude
+	default:
+		return nil, fmt.Errorf("invalid missing %q", values.Get("missing"))
+	}
+	return q, nil
+}
+
+func (q *gameQuery) match(game *gamePayload) bool {
+	if q.statuses != nil {
+		if _, ok := q.statuses[game.RomStatus]; !ok {
+			return false
+		}
+	}
+	switch q.missing {
+	case missingFilterOnly:
+		if !game.RomMissing {
+			return false
+		}
+	case missingFilterExclude:
+		if game.RomMissing {
+			return false
+		}
+	}
+	if len(q.terms) == 0 {
+		return true
+	}
+	text := gameSearchText(game)
+	for _, term := range q.terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// gameSearchText is the lower-cased text a query is matched against: the
+// title, the ROM name and the description fields.
+func gameSearchText(game *gamePayload) string {
+	parts := []string{game.Title, game.DisplayName, game.RelRomPath}
+	for _, field := range game.Fields {
+		if field == nil {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(field.Key))
+		if isMultilineTextKey(key) || key == "file" || key == "files" {
+			parts = append(parts, field.Values...)
+		}
+	}
+	return strings.ToLower(strings.Join(parts, "\n"))
+}
+
+// page filters games and cuts the requested window out of the matches.
+func (q *gameQuery) page(refs []gameListRef) *gamePageResponse {
+	resp := &gamePageResponse{Offset: q.offset, Limit: q.limit, Games: make([]*gamePageItem, 0)}
+	for _, ref := range refs {
+		if !q.match(ref.game) {
+			continue
+		}
+		if resp.Total >= q.offset && len(resp.Games) < q.limit {
+			resp.Games = append(resp.Games, &gamePageItem{CollectionID: ref.collectionID, gamePayload: *summarizeGame(ref.game)})
+		}
+		resp.Total++
+	}
+	return resp
+}
+
+// summarizeGame drops the fields and assets of a game; the browser fetches
+// them from /api/games/{id} when the game is opened.
+func summarizeGame(game *gamePayload) *gamePayload {
+	out := *game
+	out.Fields = nil
+	out.Assets = nil
+	return &out
+}
+
+func summarizeCollection(coll *collectionPayload) *collectionPayload {
+	out := *coll
+	out.Games = make([]*gamePayload, 0, len(coll.Games))
+	for _, game := range coll.Games {
+		out.Games = append(out.Games, summarizeGame(game))
+	}
+	return &out
+}
+
+func summarizeCollections(cols []*collectionPayload) []*collectionPayload {
+	out := make([]*collectionPayload, 0, len(cols))
+	for _, coll := range cols {
+		out = append(out, summarizeCollection(coll))
+	}
+	return out
+}
+
+func (c *WebCommand) findCollectionByID(id string) *collectionPayload {
+	for _, coll := range c.collectionsSnapshot() {
+		if coll.ID == id {
+			return coll
+		}
+	}
+	return nil
+}
+
+func (c *WebCommand) findGameByID(id string) (*collectionPayload, *gamePayload) {
+	for _, coll := range c.collectionsSnapshot() {
+		if !strings.HasPrefix(id, coll.ID+"-") {
+			continue
+		}
+		for _, game := range coll.Games {
+			if game.ID == id {
+				return coll, game
+			}
+		}
+	}
+	return nil, nil
+}
+
+func collectionGameRefs(coll *collectionPayload) []gameListRef {
+	out := make([]gameListRef, 0, len(coll.Games))
+	for _, game := range coll.Games {
+		out = append(out, gameListRef{collectionID: coll.ID, game: game})
+	}
+	return out
+}
+
+// handleCollectionGames serves /api/collections/{id}/games.
+func (c *WebCommand) handleCollectionGames(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/collections/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	if action != "games" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := parseGameQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	coll := c.findCollectionByID(id)
+	if coll == nil {
+		http.Error(w, "collection not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, query.page(collectionGameRefs(coll)))
+}
+
+// handleGames searches the games of every collection.
+func (c *WebCommand) handleGames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query, err := parseGameQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var refs []gameListRef
+	for _, coll := range c.collectionsSnapshot() {
+		refs = append(refs, collectionGameRefs(coll)...)
+	}
+	respondJSON(w, r, http.StatusOK, query.page(refs))
+}
+
+// handleGameDetail serves /api/games/{id} with the fields and assets that
+// the summary views leave out.
+func (c *WebCommand) handleGameDetail(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/games/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	coll, game := c.findGameByID(id)
+	if game == nil {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, &gameDetailResponse{CollectionID: coll.ID, Game: game})
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGameQuery(t *testing.T) {
+	q, err := parseGameQuery(url.Values{"limit": {"5000"}, "q": {" Street  FIGHTER "}, "status": {"green, red"}, "missing": {"exclude"}})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	assert.Equal(t, maxGamePageLimit, q.limit)
+	assert.Equal(t, []string{"street", "fighter"}, q.terms)
+	assert.Len(t, q.statuses, 2)
+	assert.Equal(t, missingFilterExclude, q.missing)
+
+	for _, values := range []url.Values{
+		{"offset": {"-1"}},
+		{"limit": {"0"}},
+		{"status": {"blue"}},
+		{"missing": {"maybe"}},
+	} {
+		_, err := parseGameQuery(values)
+		assert.Error(t, err, "%v", values)
+	}
+}
+
+func TestCollectionGamesPaging(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, filepath.Join(root, "arcade", "sf2.zip"), "x")
+	writeTestFile(t, filepath.Join(root, "arcade", "kof98.zip"), "x")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\n"+
+		"game: Street Fighter II\nfile: sf2.zip\nx-index-id: 1\n\n"+
+		"game: King of Fighters 98\nfile: kof98.zip\ndescription: SNK dream match\nx-index-id: 2\n\n"+
+		"game: Metal Slug\nfile: mslug.zip\nx-index-id: 3\n")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store}
+	if err := c.reloadCollections(context.Background()); err != nil {
+		t.Fatalf("load collections: %v", err)
+	}
+	coll := c.findCollectionByPath(filepath.ToSlash(metaPath))
+	if coll == nil {
+		t.Fatalf("collection not loaded")
+	}
+
+	get := func(target string) *gamePageResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		c.handleCollectionGames(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", target, rec.Code, rec.Body.String())
+		}
+		var resp gamePageResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return &resp
+	}
+	base := "/api/collections/" + coll.ID + "/games"
+
+	page := get(base + "?limit=2")
+	assert.Equal(t, 3, page.Total)
+	if assert.Len(t, page.Games, 2) {
+		assert.Equal(t, coll.ID, page.Games[0].CollectionID)
+		assert.Nil(t, page.Games[0].Fields, "pages carry no details")
+	}
+	assert.Len(t, get(base+"?offset=2&limit=2").Games, 1)
+
+	page = get(base + "?q=dream")
+	if assert.Equal(t, 1, page.Total) {
+		assert.Equal(t, "King of Fighters 98", page.Games[0].Title, "description is searched")
+	}
+	assert.Equal(t, 1, get(base+"?q=sf2").Total, "rom name is searched")
+	assert.Equal(t, 1, get(base+"?missing=only").Total)
+	assert.Equal(t, 2, get(base+"?missing=exclude").Total)
+
+	rec := httptest.NewRecorder()
+	c.handleCollectionGames(rec, httptest.NewRequest(http.MethodGet, "/api/collections/nope/games", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	c.handleGameDetail(rec, httptest.NewRequest(http.MethodGet, "/api/games/"+page.Games[0].ID, nil))
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		var detail gameDetailResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+			t.Fatalf("decode detail: %v", err)
+		}
+		assert.Equal(t, coll.ID, detail.CollectionID)
+		assert.NotEmpty(t, detail.Game.Fields)
+	}
+}
@@ -0,0 +1,642 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"go.uber.org/zap"
+)
+
+const (
+	historyTrashDirName = ".retrog/trash"
+	historyLimit        = 50
+
+	defaultTrashKeep  = 7 * 24 * time.Hour
+	trashKeepFlagDesc = "回收站保留时间：Web 界面删除或覆盖的文件会移入 <dir>/.retrog/trash，每次启动时清理超过该时间的内容并记录日志；0 表示永久保留"
+)
+
+var errHistoryConflict = errors.New("files changed since this operation, refusing to overwrite")
+
+type historyEntryPayload struct {
+	ID    int      `json:"id"`
+	Label string   `json:"label"`
+	Time  string   `json:"time"`
+	Files []string `json:"files"`
+	Moved int      `json:"moved"`
+}
+
+type historyPayload struct {
+	Undo []*historyEntryPayload `json:"undo"`
+	Redo []*historyEntryPayload `json:"redo"`
+}
+
+type historyActionResponse struct {
+	History     *historyPayload      `json:"history"`
+	Entry       *historyEntryPayload `json:"entry"`
+	Collections []*collectionPayload `json:"collections"`
+}
+
+// historyFileChange keeps the full content of a small text file, in practice
+// a metadata file, before and after an operation.
+type historyFileChange struct {
+	path          string
+	before, after []byte
+	existedBefore bool
+	existsAfter   bool
+}
+
+// historyMove records a rename performed by an operation. Deleted files are
+// moved into the trash and created files are recorded as if they came from
+// the trash, so every file operation is reversible by swapping from and to.
+type historyMove struct {
+	from, to string
+}
+
+type historyEntry struct {
+	id       int
+	label    string
+	time     time.Time
+	files    []*historyFileChange
+	moves    []*historyMove
+	trashDir string
+}
+
+// webHistory is the in-memory undo/redo journal of the web UI. Every
+// operation holds writeMu from begin to finish, which also serialises the
+// mutating handlers against each other.
+type webHistory struct {
+	writeMu   sync.Mutex
+	mu        sync.Mutex
+	root      string
+	trashRoot string
+	nextID    int
+	undo      []*historyEntry
+	redo      []*historyEntry
+	// marker, when set, hears about every path before it is written.
+	marker selfWriteMarker
+}
+
+// selfWriteMarker keeps the file watcher from reporting the web UI's own
+// writes as external changes.
+type selfWriteMarker interface {
+	// markPendingWrites is called before paths are written.
+	markPendingWrites(paths []string)
+	// markSelfWrites is called with the final state of paths once the
+	// operation is done, before the write lock is released.
+	markSelfWrites(paths []string)
+}
+
+// historyTx collects the changes of a single operation. A nil *historyTx is
+// valid and performs the file operations without recording them.
+type historyTx struct {
+	entry   *historyEntry
+	seen    map[string]struct{}
+	slots   int
+	marker  selfWriteMarker
+	touched []string
+}
+
+// newWebHistory creates the journal for root. The journal only lives in
+// memory, but the trash outlives it so files deleted in the UI can still be
+// recovered by hand: every run trashes into its own directory, and those of
+// runs idle for longer than keep are purged. A zero keep never purges.
+func newWebHistory(ctx context.Context, root string, keep time.Duration) (*webHistory, error) {
+	trashBase := filepath.Join(root, filepath.FromSlash(historyTrashDirName))
+	now := time.Now()
+	if keep > 0 {
+		if err := purgeTrash(ctx, trashBase, now.Add(-keep)); err != nil {
+			return nil, err
+		}
+	}
+	session := now.Format("20060102-150405") + "-" + strconv.Itoa(os.Getpid())
+	return &webHistory{root: root, trashRoot: filepath.Join(trashBase, session)}, nil
+}
+
+// purgeTrash removes the run directories under trashBase in which nothing
+// changed since before, logging every file it deletes.
+func purgeTrash(ctx context.Context, trashBase string, before time.Time) error {
+	logger := logutil.GetLogger(ctx)
+	entries, err := os.ReadDir(trashBase)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read trash %s: %w", trashBase, err)
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(trashBase, entry.Name())
+		var newest time.Time
+		var files []string
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+			if !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("scan trash %s: %w", dir, err)
+		}
+		if !newest.Before(before) {
+			continue
+		}
+		for _, file := range files {
+			logger.Info("purge trashed file", zap.String("path", file), zap.Time("trashed", newest))
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("purge trash %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func (h *webHistory) begin(label string) *historyTx {
+	h.writeMu.Lock()
+	h.mu.Lock()
+	h.nextID++
+	id := h.nextID
+	h.mu.Unlock()
+	tx := newHistoryTx(label, filepath.Join(h.trashRoot, strconv.Itoa(id)))
+	tx.entry.id = id
+	tx.marker = h.marker
+	return tx
+}
+
+// newHistoryTx starts recording an operation outside the web history, so
+// commands can roll back a failed operation. Removed files go to trashDir.
+func newHistoryTx(label, trashDir string) *historyTx {
+	return &historyTx{
+		entry: &historyEntry{
+			label:    label,
+			time:     time.Now(),
+			trashDir: trashDir,
+		},
+		seen: make(map[string]struct{}),
+	}
+}
+
+// finish records whatever tx changed, even when the operation failed half
+// way, and releases the write lock. It returns the recorded entry, or nil
+// when nothing changed.
+func (h *webHistory) finish(tx *historyTx) *historyEntry {
+	defer h.writeMu.Unlock()
+	if h.marker != nil && len(tx.touched) > 0 {
+		h.marker.markSelfWrites(tx.touched)
+	}
+	entry := tx.entry
+	files := entry.files[:0]
+	for _, change := range entry.files {
+		data, exists, err := readHistoryFile(change.path)
+		if err != nil {
+			continue
+		}
+		change.after, change.existsAfter = data, exists
+		if change.existedBefore == change.existsAfter && bytes.Equal(change.before, change.after) {
+			continue
+		}
+		files = append(files, change)
+	}
+	entry.files = files
+	if len(entry.files) == 0 && len(entry.moves) == 0 {
+		_ = os.RemoveAll(entry.trashDir)
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.undo = append(h.undo, entry)
+	// Entries that can no longer be replayed leave their trash behind; it is
+	// purged with the run after --trash-keep.
+	if len(h.undo) > historyLimit {
+		h.undo = h.undo[1:]
+	}
+	h.redo = nil
+	return entry
+}
+
+// snapshot remembers the current content of path before it is modified.
+func (tx *historyTx) snapshot(paths ...string) error {
+	if tx == nil {
+		return nil
+	}
+	for _, path := range paths {
+		if _, ok := tx.seen[path]; ok {
+			continue
+		}
+		data, exists, err := readHistoryFile(path)
+		if err != nil {
+			return err
+		}
+		tx.seen[path] = struct{}{}
+		tx.entry.files = append(tx.entry.files, &historyFileChange{path: path, before: data, existedBefore: exists})
+		tx.touch(path)
+	}
+	return nil
+}
+
+// remove deletes path, or moves it into the trash when recording.
+func (tx *historyTx) remove(path string) error {
+	if tx == nil {
+		return os.RemoveAll(path)
+	}
+	tx.touch(path)
+	slot := tx.trashSlot(path)
+	if err := moveHistoryPath(path, slot); err != nil {
+		return err
+	}
+	tx.entry.moves = append(tx.entry.moves, &historyMove{from: path, to: slot})
+	return nil
+}
+
+// rename moves from to to.
+func (tx *historyTx) rename(from, to string) error {
+	tx.touch(from, to)
+	if err := moveHistoryPath(from, to); err != nil {
+		return err
+	}
+	if tx != nil {
+		tx.entry.moves = append(tx.entry.moves, &historyMove{from: from, to: to})
+	}
+	return nil
+}
+
+// created records that path did not exist before the operation.
+func (tx *historyTx) created(path string) {
+	if tx == nil {
+		return
+	}
+	tx.touch(path)
+	tx.entry.moves = append(tx.entry.moves, &historyMove{from: tx.trashSlot(path), to: path})
+}
+
+// touch tells the watcher paths are about to change. Files created
+// without a snapshot are only known once written, which the final marks
+// of finish cover as well.
+func (tx *historyTx) touch(paths ...string) {
+	if tx == nil {
+		return
+	}
+	tx.touched = append(tx.touched, paths...)
+	if tx.marker != nil {
+		tx.marker.markPendingWrites(paths)
+	}
+}
+
+// rollback reverts the moves and file changes recorded so far, newest
+// first, after an operation failed half way.
+func (tx *historyTx) rollback() error {
+	var errs []error
+	for idx := len(tx.entry.moves) - 1; idx >= 0; idx-- {
+		move := tx.entry.moves[idx]
+		if err := moveHistoryPath(move.to, move.from); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, change := range tx.entry.files {
+		if err := writeHistoryFile(change.path, change.before, change.existedBefore); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	tx.entry.moves, tx.entry.files = nil, nil
+	return errors.Join(errs...)
+}
+
+func (tx *historyTx) trashSlot(path string) string {
+	tx.slots++
+	return filepath.Join(tx.entry.trashDir, strconv.Itoa(tx.slots), filepath.Base(path))
+}
+
+func (h *webHistory) undoLast() (*historyEntry, error) {
+	return h.replay(&h.undo, &h.redo, false)
+}
+
+func (h *webHistory) redoLast() (*historyEntry, error) {
+	return h.replay(&h.redo, &h.undo, true)
+}
+
+// replay pops the newest entry of from, applies it in the given direction and
+// pushes it onto to. Nothing is touched when the files on disk no longer
+// match the state the entry expects, and a step failing half way undoes
+// the steps before it.
+func (h *webHistory) replay(from, to *[]*historyEntry, forward bool) (*historyEntry, error) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	h.mu.Lock()
+	if len(*from) == 0 {
+		h.mu.Unlock()
+		return nil, nil
+	}
+	entry := (*from)[len(*from)-1]
+	h.mu.Unlock()
+
+	if err := checkHistoryEntry(entry, forward); err != nil {
+		return nil, err
+	}
+	if h.marker != nil {
+		paths := h.entryPaths(entry)
+		h.marker.markPendingWrites(paths)
+		defer h.marker.markSelfWrites(paths)
+	}
+	// applied holds the inverse of every step done so far, so a failure half
+	// way leaves the files as they were, like historyTx.rollback does.
+	var applied []func() error
+	fail := func(err error) (*historyEntry, error) {
+		var errs []error
+		for idx := len(applied) - 1; idx >= 0; idx-- {
+			if rbErr := applied[idx](); rbErr != nil {
+				errs = append(errs, rbErr)
+			}
+		}
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("%w; rollback failed: %v", err, errors.Join(errs...))
+		}
+		return nil, err
+	}
+	writeFile := func(change *historyFileChange, data []byte, exists bool, prev []byte, prevExists bool) error {
+		if err := writeHistoryFile(change.path, data, exists); err != nil {
+			return err
+		}
+		applied = append(applied, func() error { return writeHistoryFile(change.path, prev, prevExists) })
+		return nil
+	}
+	move := func(from, to string) error {
+		if err := moveHistoryPath(from, to); err != nil {
+			return err
+		}
+		applied = append(applied, func() error { return moveHistoryPath(to, from) })
+		return nil
+	}
+	if forward {
+		for _, change := range entry.files {
+			if err := writeFile(change, change.after, change.existsAfter, change.before, change.existedBefore); err != nil {
+				return fail(err)
+			}
+		}
+		for _, m := range entry.moves {
+			if err := move(m.from, m.to); err != nil {
+				return fail(err)
+			}
+		}
+	} else {
+		for idx := len(entry.moves) - 1; idx >= 0; idx-- {
+			m := entry.moves[idx]
+			if err := move(m.to, m.from); err != nil {
+				return fail(err)
+			}
+		}
+		for _, change := range entry.files {
+			if err := writeFile(change, change.before, change.existedBefore, change.after, change.existsAfter); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, entry)
+	return entry, nil
+}
+
+func checkHistoryEntry(entry *historyEntry, forward bool) error {
+	for _, change := range entry.files {
+		data, exists, err := readHistoryFile(change.path)
+		if err != nil {
+			return err
+		}
+		expected, expectExists := change.after, change.existsAfter
+		if forward {
+			expected, expectExists = change.before, change.existedBefore
+		}
+		if exists != expectExists || !bytes.Equal(data, expected) {
+			return fmt.Errorf("%w: %s", errHistoryConflict, filepath.ToSlash(change.path))
+		}
+	}
+	// Moves may reuse a path (an overwritten file is trashed and the new one
+	// created in its place), so existence is tracked while walking the moves
+	// in the order they will be replayed.
+	state := make(map[string]bool)
+	exists := func(path string) bool {
+		if v, ok := state[path]; ok {
+			return v
+		}
+		_, err := os.Lstat(path)
+		return err == nil
+	}
+	for idx := range entry.moves {
+		move := entry.moves[idx]
+		src, dst := move.from, move.to
+		if !forward {
+			move = entry.moves[len(entry.moves)-1-idx]
+			src, dst = move.to, move.from
+		}
+		if !exists(src) {
+			return fmt.Errorf("%w: %s", errHistoryConflict, filepath.ToSlash(src))
+		}
+		if exists(dst) {
+			return fmt.Errorf("%w: %s", errHistoryConflict, filepath.ToSlash(dst))
+		}
+		state[src], state[dst] = false, true
+	}
+	return nil
+}
+
+func readHistoryFile(path string) ([]byte, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func writeHistoryFile(path string, data []byte, exists bool) error {
+	if !exists {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return metadata.WriteFileAtomic(path, data, 0o644)
+}
+
+// moveHistoryPath renames from to to, creating parent directories and
+// falling back to copying across filesystems.
+func moveHistoryPath(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	info, statErr := os.Lstat(from)
+	if statErr != nil {
+		return err
+	}
+	if _, statErr := os.Lstat(to); statErr == nil {
+		return err
+	}
+	switch {
+	case info.Mode().IsRegular():
+		if err := copyFileContents(from, to); err != nil {
+			return err
+		}
+		return os.Remove(from)
+	case info.IsDir():
+		if err := copyHistoryTree(from, to); err != nil {
+			_ = os.RemoveAll(to)
+			return fmt.Errorf("move %s to %s: %w", from, to, err)
+		}
+		return os.RemoveAll(from)
+	default:
+		return fmt.Errorf("move %s to %s: %w", from, to, err)
+	}
+}
+
+// copyHistoryTree copies the directory from to the new directory to. Only
+// regular files and directories are supported.
+func copyHistoryTree(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type().IsRegular():
+			return copyFileContents(path, target)
+		default:
+			return fmt.Errorf("cannot copy %s: not a regular file", path)
+		}
+	})
+}
+
+func (h *webHistory) snapshot() *historyPayload {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := &historyPayload{
+		Undo: make([]*historyEntryPayload, 0, len(h.undo)),
+		Redo: make([]*historyEntryPayload, 0, len(h.redo)),
+	}
+	for idx := len(h.undo) - 1; idx >= 0; idx-- {
+		out.Undo = append(out.Undo, h.entryPayload(h.undo[idx]))
+	}
+	for idx := len(h.redo) - 1; idx >= 0; idx-- {
+		out.Redo = append(out.Redo, h.entryPayload(h.redo[idx]))
+	}
+	return out
+}
+
+func (h *webHistory) entryPayload(entry *historyEntry) *historyEntryPayload {
+	if entry == nil {
+		return nil
+	}
+	payload := &historyEntryPayload{
+		ID:    entry.id,
+		Label: entry.label,
+		Time:  entry.time.Format(time.RFC3339),
+		Files: make([]string, 0, len(entry.files)),
+		Moved: len(entry.moves),
+	}
+	for _, change := range entry.files {
+		rel, err := filepath.Rel(h.root, change.path)
+		if err != nil {
+			rel = change.path
+		}
+		payload.Files = append(payload.Files, filepath.ToSlash(rel))
+	}
+	return payload
+}
+
+// entryPaths lists the library paths touched by entry, leaving out the
+// trash slots.
+func (h *webHistory) entryPaths(entry *historyEntry) []string {
+	var out []string
+	for _, change := range entry.files {
+		out = append(out, change.path)
+	}
+	for _, move := range entry.moves {
+		for _, path := range []string{move.from, move.to} {
+			if !isWithinDir(path, h.trashRoot) {
+				out = append(out, path)
+			}
+		}
+	}
+	return out
+}
+
+func (c *WebCommand) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, c.history.snapshot())
+}
+
+func (c *WebCommand) handleHistoryUndo(w http.ResponseWriter, r *http.Request) {
+	c.handleHistoryReplay(w, r, "撤销", c.history.undoLast)
+}
+
+func (c *WebCommand) handleHistoryRedo(w http.ResponseWriter, r *http.Request) {
+	c.handleHistoryReplay(w, r, "重做", c.history.redoLast)
+}
+
+func (c *WebCommand) handleHistoryReplay(w http.ResponseWriter, r *http.Request, action string, replay func() (*historyEntry, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entry, err := replay()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errHistoryConflict) {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if entry == nil {
+		http.Error(w, "nothing to replay", http.StatusConflict)
+		return
+	}
+	if err := c.reloadCollections(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("reload collections failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	c.publishEntryChange(r.Header.Get(eventClientHeader), entry, fmt.Sprintf("%s: %s", action, entry.label))
+	respondJSON(w, r, http.StatusOK, &historyActionResponse{
+		History:     c.history.snapshot(),
+		Entry:       c.history.entryPayload(entry),
+		Collections: summarizeCollections(c.collectionsSnapshot()),
+	})
+}
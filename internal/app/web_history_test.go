@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebHistoryUndoRedo(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "metadata.pegasus.txt")
+	romPath := filepath.Join(root, "alpha.zip")
+	boxPath := filepath.Join(root, "media", "alpha", "boxFront.png")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\n")
+	writeTestFile(t, romPath, "rom")
+	writeTestFile(t, boxPath, "old box")
+	writeTestFile(t, filepath.Join(root, ".retrog", "trash", "7", "stale"), "x")
+
+	history, err := newWebHistory(context.Background(), root, defaultTrashKeep)
+	if err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	assert.FileExists(t, filepath.Join(root, ".retrog", "trash", "7", "stale"), "recent trash survives a restart")
+
+	tx := history.begin("删除游戏")
+	if err := tx.snapshot(metaPath); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	writeTestFile(t, metaPath, "collection: Arcade\n")
+	if err := tx.remove(romPath); err != nil {
+		t.Fatalf("remove rom: %v", err)
+	}
+	// Overwrite the box art: trash the old file and record the new one.
+	if err := tx.remove(boxPath); err != nil {
+		t.Fatalf("remove box: %v", err)
+	}
+	writeTestFile(t, boxPath, "new box")
+	tx.created(boxPath)
+	history.finish(tx)
+
+	// An operation without changes is not recorded.
+	history.finish(history.begin("noop"))
+
+	snapshot := history.snapshot()
+	if assert.Len(t, snapshot.Undo, 1) {
+		assert.Equal(t, "删除游戏", snapshot.Undo[0].Label)
+		assert.Equal(t, []string{"metadata.pegasus.txt"}, snapshot.Undo[0].Files)
+		assert.Equal(t, 3, snapshot.Undo[0].Moved)
+	}
+
+	entry, err := history.undoLast()
+	if err != nil || entry == nil {
+		t.Fatalf("undo: %v", err)
+	}
+	assert.Equal(t, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\n", readTestFile(t, metaPath))
+	assert.Equal(t, "rom", readTestFile(t, romPath))
+	assert.Equal(t, "old box", readTestFile(t, boxPath))
+
+	entry, err = history.redoLast()
+	if err != nil || entry == nil {
+		t.Fatalf("redo: %v", err)
+	}
+	assert.Equal(t, "collection: Arcade\n", readTestFile(t, metaPath))
+	assert.Equal(t, "new box", readTestFile(t, boxPath))
+	_, err = os.Stat(romPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// External edits block the undo instead of being overwritten.
+	writeTestFile(t, metaPath, "collection: Edited\n")
+	_, err = history.undoLast()
+	assert.True(t, errors.Is(err, errHistoryConflict))
+	assert.Equal(t, "collection: Edited\n", readTestFile(t, metaPath))
+	assert.Len(t, history.snapshot().Undo, 1)
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestWebHistoryReplayRollsBack(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "metadata.pegasus.txt")
+	romPath := filepath.Join(root, "alpha.zip")
+	writeTestFile(t, metaPath, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\n")
+	writeTestFile(t, romPath, "rom")
+
+	history, err := newWebHistory(context.Background(), root, defaultTrashKeep)
+	if err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	tx := history.begin("删除游戏")
+	if err := tx.snapshot(metaPath); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	writeTestFile(t, metaPath, "collection: Arcade\n")
+	if err := tx.remove(romPath); err != nil {
+		t.Fatalf("remove rom: %v", err)
+	}
+	entry := history.finish(tx)
+	if _, err := history.undoLast(); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+
+	// Redo writes the metadata first, then fails to trash the rom because
+	// a file now sits where the trash slot's directory goes.
+	slot := entry.moves[0].to
+	if err := os.RemoveAll(entry.trashDir); err != nil {
+		t.Fatalf("clear trash: %v", err)
+	}
+	writeTestFile(t, filepath.Dir(slot), "in the way")
+	_, err = history.redoLast()
+	assert.Error(t, err)
+	assert.Equal(t, "collection: Arcade\n\ngame: Alpha\nfile: alpha.zip\n", readTestFile(t, metaPath), "metadata write rolled back")
+	assert.Equal(t, "rom", readTestFile(t, romPath))
+	assert.Len(t, history.snapshot().Redo, 1, "entry stays redoable")
+}
+
+func TestWebHistoryPurgesOldTrash(t *testing.T) {
+	root := t.TempDir()
+	old := filepath.Join(root, ".retrog", "trash", "20000101-000000-1")
+	recent := filepath.Join(root, ".retrog", "trash", "recent")
+	writeTestFile(t, filepath.Join(old, "1", "1", "alpha.zip"), "rom")
+	writeTestFile(t, filepath.Join(recent, "1", "1", "beta.zip"), "rom")
+	stale := time.Now().Add(-30 * 24 * time.Hour)
+	for _, p := range []string{filepath.Join(old, "1", "1", "alpha.zip"), filepath.Join(old, "1", "1"), filepath.Join(old, "1"), old} {
+		if err := os.Chtimes(p, stale, stale); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	if _, err := newWebHistory(context.Background(), root, 0); err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	assert.DirExists(t, old, "zero keep never purges")
+
+	history, err := newWebHistory(context.Background(), root, defaultTrashKeep)
+	if err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	assert.NoDirExists(t, old)
+	assert.FileExists(t, filepath.Join(recent, "1", "1", "beta.zip"))
+	assert.NotEqual(t, filepath.Join(root, ".retrog", "trash"), history.trashRoot, "each run trashes into its own directory")
+}
+
+func TestCopyHistoryTree(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "media", "alpha")
+	writeTestFile(t, filepath.Join(from, "logo.png"), "logo")
+	writeTestFile(t, filepath.Join(from, "sub", "video.mp4"), "video")
+	to := filepath.Join(dir, "trash", "alpha")
+	if err := copyHistoryTree(from, to); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	assert.Equal(t, "logo", readTestFile(t, filepath.Join(to, "logo.png")))
+	assert.Equal(t, "video", readTestFile(t, filepath.Join(to, "sub", "video.mp4")))
+}
@@ -0,0 +1,364 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	jobStateRunning   = "running"
+	jobStateSucceeded = "succeeded"
+	jobStateFailed    = "failed"
+	jobStateCanceled  = "canceled"
+
+	jobHistoryLimit     = 50
+	jobPublishInterval  = 250 * time.Millisecond
+	eventJobUpdated     = "job.updated"
+	jobKindRomCheck     = "romcheck"
+	jobKindRomCheckDesc = "ROM 校验"
+	jobKindScrape       = "scrape"
+	jobKindScrapeDesc   = "刮削全部游戏"
+)
+
+var (
+	errJobRunning  = errors.New("job already running")
+	errJobNotFound = errors.New("job not found")
+)
+
+// jobFunc does the work of a job, reporting progress through job.
+type jobFunc func(ctx context.Context, job *webJob) error
+
+// jobKind describes a job that can be started from /api/jobs.
+type jobKind struct {
+	label string
+	run   jobFunc
+}
+
+type jobPayload struct {
+	ID         string  `json:"id"`
+	Kind       string  `json:"kind"`
+	Label      string  `json:"label"`
+	State      string  `json:"state"`
+	Progress   float64 `json:"progress"`
+	Done       int     `json:"done"`
+	Total      int     `json:"total"`
+	Message    string  `json:"message,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	StartedAt  string  `json:"started_at"`
+	FinishedAt string  `json:"finished_at,omitempty"`
+}
+
+type startJobRequest struct {
+	Kind string `json:"kind"`
+}
+
+// webJob is a running or finished background job.
+type webJob struct {
+	mu          sync.Mutex
+	id          string
+	kind        string
+	label       string
+	state       string
+	stage       int
+	stages      int
+	done        int
+	total       int
+	message     string
+	err         string
+	startedAt   time.Time
+	finishedAt  time.Time
+	cancel      context.CancelFunc
+	lastPublish time.Time
+	publish     func(*jobPayload)
+}
+
+// setStage starts stage out of stages equally weighted parts of the job.
+func (j *webJob) setStage(stage, stages int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stage, j.stages = stage, stages
+	j.done, j.total = 0, 0
+}
+
+// setProgress records done out of total units of work in the current stage.
+// Updates are published at most every jobPublishInterval.
+func (j *webJob) setProgress(done, total int, message string) {
+	j.mu.Lock()
+	j.done, j.total, j.message = done, total, message
+	now := time.Now()
+	if now.Sub(j.lastPublish) < jobPublishInterval && done < total {
+		j.mu.Unlock()
+		return
+	}
+	j.lastPublish = now
+	payload := j.payloadLocked()
+	j.mu.Unlock()
+	j.publish(payload)
+}
+
+func (j *webJob) finish(err error) {
+	j.mu.Lock()
+	j.finishedAt = time.Now()
+	switch {
+	case err == nil:
+		j.state = jobStateSucceeded
+	case errors.Is(err, context.Canceled):
+		j.state = jobStateCanceled
+	default:
+		j.state = jobStateFailed
+		j.err = err.Error()
+	}
+	payload := j.payloadLocked()
+	j.mu.Unlock()
+	j.publish(payload)
+}
+
+func (j *webJob) payload() *jobPayload {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.payloadLocked()
+}
+
+func (j *webJob) payloadLocked() *jobPayload {
+	out := &jobPayload{
+		ID:        j.id,
+		Kind:      j.kind,
+		Label:     j.label,
+		State:     j.state,
+		Done:      j.done,
+		Total:     j.total,
+		Message:   j.message,
+		Error:     j.err,
+		StartedAt: j.startedAt.Format(time.RFC3339),
+	}
+	stages := j.stages
+	if stages <= 0 {
+		stages = 1
+	}
+	switch {
+	case j.state == jobStateSucceeded:
+		out.Progress = 100
+	case j.total > 0:
+		out.Progress = (float64(j.stage) + float64(j.done)/float64(j.total)) * 100 / float64(stages)
+	default:
+		out.Progress = float64(j.stage) * 100 / float64(stages)
+	}
+	if !j.finishedAt.IsZero() {
+		out.FinishedAt = j.finishedAt.Format(time.RFC3339)
+	}
+	return out
+}
+
+// jobManager runs background jobs, at most one per kind at a time, and
+// keeps the most recent ones for the UI.
+type jobManager struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	nextID  int
+	jobs    []*webJob
+	kinds   map[string]*jobKind
+	publish func(*jobPayload)
+}
+
+func newJobManager(publish func(*jobPayload)) *jobManager {
+	return &jobManager{
+		ctx:     context.Background(),
+		kinds:   make(map[string]*jobKind),
+		publish: publish,
+	}
+}
+
+// register makes kind startable through /api/jobs.
+func (m *jobManager) register(kind, label string, run jobFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[kind] = &jobKind{label: label, run: run}
+}
+
+// bind sets the context every job runs under; cancelling it stops them all.
+func (m *jobManager) bind(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ctx = ctx
+}
+
+func (m *jobManager) start(kind string) (*webJob, error) {
+	m.mu.Lock()
+	def, ok := m.kinds[kind]
+	if !ok {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+	for _, job := range m.jobs {
+		if job.kind == kind && job.payload().State == jobStateRunning {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s", errJobRunning, job.id)
+		}
+	}
+	m.nextID++
+	ctx, cancel := context.WithCancel(m.ctx)
+	job := &webJob{
+		id:        strconv.Itoa(m.nextID),
+		kind:      kind,
+		label:     def.label,
+		state:     jobStateRunning,
+		startedAt: time.Now(),
+		cancel:    cancel,
+		publish:   m.publish,
+	}
+	m.jobs = append(m.jobs, job)
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	job.publish(job.payload())
+	go func() {
+		defer cancel()
+		logger := logutil.GetLogger(ctx)
+		logger.Info("job started", zap.String("id", job.id), zap.String("kind", kind))
+		err := def.run(ctx, job)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("job failed", zap.String("id", job.id), zap.String("kind", kind), zap.Error(err))
+		} else {
+			logger.Info("job finished", zap.String("id", job.id), zap.String("kind", kind), zap.Error(err))
+		}
+		job.finish(err)
+	}()
+	return job, nil
+}
+
+// pruneLocked drops the oldest finished jobs beyond jobHistoryLimit.
+func (m *jobManager) pruneLocked() {
+	extra := len(m.jobs) - jobHistoryLimit
+	if extra <= 0 {
+		return
+	}
+	kept := m.jobs[:0]
+	for _, job := range m.jobs {
+		if extra > 0 && job.payload().State != jobStateRunning {
+			extra--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	m.jobs = kept
+}
+
+func (m *jobManager) get(id string) *webJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func (m *jobManager) cancel(id string) (*webJob, error) {
+	job := m.get(id)
+	if job == nil {
+		return nil, errJobNotFound
+	}
+	job.cancel()
+	return job, nil
+}
+
+// list returns the jobs newest first.
+func (m *jobManager) list() []*jobPayload {
+	m.mu.Lock()
+	jobs := append([]*webJob(nil), m.jobs...)
+	m.mu.Unlock()
+	out := make([]*jobPayload, 0, len(jobs))
+	for idx := len(jobs) - 1; idx >= 0; idx-- {
+		out = append(out, jobs[idx].payload())
+	}
+	return out
+}
+
+// kindsPayload lists the startable job kinds, sorted by kind.
+func (m *jobManager) kindsPayload() []*jobKindPayload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*jobKindPayload, 0, len(m.kinds))
+	for kind, def := range m.kinds {
+		out = append(out, &jobKindPayload{Kind: kind, Label: def.label})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out
+}
+
+type jobKindPayload struct {
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+type jobListResponse struct {
+	Jobs  []*jobPayload     `json:"jobs"`
+	Kinds []*jobKindPayload `json:"kinds"`
+}
+
+func (c *WebCommand) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, r, http.StatusOK, &jobListResponse{Jobs: c.jobs.list(), Kinds: c.jobs.kindsPayload()})
+	case http.MethodPost:
+		var req startJobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		job, err := c.jobs.start(strings.TrimSpace(req.Kind))
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errJobRunning) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		respondJSON(w, r, http.StatusAccepted, job.payload())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJob serves /api/jobs/{id} and /api/jobs/{id}/cancel.
+func (c *WebCommand) handleJob(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job := c.jobs.get(id)
+		if job == nil {
+			http.Error(w, errJobNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		respondJSON(w, r, http.StatusOK, job.payload())
+	case action == "cancel" && r.Method == http.MethodPost:
+		job, err := c.jobs.cancel(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		respondJSON(w, r, http.StatusOK, job.payload())
+	case action == "" || action == "cancel":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (c *WebCommand) publishJob(payload *jobPayload) {
+	c.events.publish(&webEvent{Type: eventJobUpdated, Data: payload})
+}
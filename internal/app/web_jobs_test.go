@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobManagerRunAndCancel(t *testing.T) {
+	var mu sync.Mutex
+	var updates []*jobPayload
+	m := newJobManager(func(p *jobPayload) {
+		mu.Lock()
+		updates = append(updates, p)
+		mu.Unlock()
+	})
+	started := make(chan struct{})
+	m.register("wait", "等待", func(ctx context.Context, job *webJob) error {
+		job.setStage(1, 2)
+		job.setProgress(1, 2, "half")
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	m.register("quick", "快速", func(ctx context.Context, job *webJob) error {
+		return errors.New("boom")
+	})
+
+	_, err := m.start("missing")
+	assert.Error(t, err)
+
+	job, err := m.start("wait")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	<-started
+	assert.InDelta(t, 75, job.payload().Progress, 0.001, "second of two stages, half done")
+	_, err = m.start("wait")
+	assert.True(t, errors.Is(err, errJobRunning), "got %v", err)
+
+	if _, err := m.cancel(job.id); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	failed, err := m.start("quick")
+	if err != nil {
+		t.Fatalf("start quick: %v", err)
+	}
+	waitJobState(t, job, jobStateCanceled)
+	waitJobState(t, failed, jobStateFailed)
+	assert.Equal(t, "boom", failed.payload().Error)
+
+	list := m.list()
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, failed.id, list[0].ID, "newest first")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotEmpty(t, updates)
+}
+
+func waitJobState(t *testing.T, job *webJob, state string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if job.payload().State == state {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s state %s, want %s", job.id, job.payload().State, state)
+}
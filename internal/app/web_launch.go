@@ -0,0 +1,52 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+)
+
+type launchCheckRequest struct {
+	MetadataPath string `json:"metadata_path"`
+	XIndexID     int    `json:"x_index_id"`
+	Launch       string `json:"launch"`
+	WorkDir      string `json:"workdir"`
+}
+
+// handleLaunchCheck dry-runs the launch and workdir being edited in the
+// collection editor against the first game of the collection. Nothing is
+// written.
+func (c *WebCommand) handleLaunchCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req launchCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	metadataPath, err := c.resolveMetadataPath(req.MetadataPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	coll := c.findCollectionByIndex(filepath.ToSlash(metadataPath), req.XIndexID)
+	if coll == nil {
+		http.Error(w, "collection not found", http.StatusNotFound)
+		return
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	sample := ""
+	for _, game := range coll.Games {
+		if game != nil && game.RomPath != "" {
+			sample = filepath.FromSlash(game.RomPath)
+			break
+		}
+	}
+	if sample == "" {
+		sample = launchSampleFile(metadataDir, coll.Extensions)
+	}
+	respondJSON(w, r, http.StatusOK, checkLaunch(c.launchAnalyzer, metadataDir, req.Launch, req.WorkDir, sample, c.appDir))
+}
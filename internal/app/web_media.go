@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/media"
+)
+
+// mediaRejectedError lists why an upload cannot be used for its field.
+type mediaRejectedError struct {
+	issues []media.Issue
+}
+
+func (e *mediaRejectedError) Error() string {
+	msgs := make([]string, 0, len(e.issues))
+	for _, issue := range e.issues {
+		msgs = append(msgs, issue.Message)
+	}
+	return "media rejected: " + strings.Join(msgs, "; ")
+}
+
+// parseMediaRules applies --media-limit overrides to the default rules.
+func parseMediaRules(specs []string) (media.Rules, error) {
+	rules := media.DefaultRules()
+	for _, spec := range specs {
+		if err := rules.Set(spec); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// normalizeStagedMedia validates a staged upload for an assets.* field and
+// fixes what it can, per --media-convert. The staged file may be renamed
+// when its extension did not match its content, so the returned path
+// replaces stagedPath. Uploads that still break the field's rule are
+// removed and reported as a *mediaRejectedError.
+func (c *WebCommand) normalizeStagedMedia(fieldKey, stagedPath string) (string, error) {
+	if !isAssetFieldKey(fieldKey) {
+		return stagedPath, nil
+	}
+	path, issues, err := media.Normalize(stagedPath, c.mediaRules.For(fieldKey), c.mediaConvert)
+	if err != nil {
+		_ = os.Remove(stagedPath)
+		return "", fmt.Errorf("inspect media: %w", err)
+	}
+	if len(issues) > 0 {
+		_ = os.Remove(path)
+		return "", &mediaRejectedError{issues: issues}
+	}
+	return path, nil
+}
@@ -0,0 +1,585 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/constant"
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+type orphanRomPayload struct {
+	File    string `json:"file"`
+	RelPath string `json:"rel_path"`
+	Title   string `json:"title"`
+	Size    int64  `json:"size"`
+}
+
+type orphanMediaPayload struct {
+	Name   string          `json:"name"`
+	Path   string          `json:"path"`
+	Files  []string        `json:"files"`
+	Assets []*assetPayload `json:"assets,omitempty"`
+}
+
+type metadataOrphansPayload struct {
+	MetadataPath string                `json:"metadata_path"`
+	RelativePath string                `json:"relative_path"`
+	Roms         []*orphanRomPayload   `json:"roms"`
+	Media        []*orphanMediaPayload `json:"media"`
+}
+
+type adoptOrphanRequest struct {
+	MetadataPath string   `json:"metadata_path"`
+	Files        []string `json:"files"`
+}
+
+type orphanMediaRequest struct {
+	MetadataPath string `json:"metadata_path"`
+	Name         string `json:"name"`
+	Action       string `json:"action"`
+	XIndexID     int    `json:"x_index_id"`
+}
+
+type orphanActionResponse struct {
+	Collection *collectionPayload       `json:"collection"`
+	Orphans    *metadataOrphansPayload  `json:"orphans"`
+	Created    []int                    `json:"created,omitempty"`
+	Failed     []*orphanAdoptionFailure `json:"failed,omitempty"`
+}
+
+type orphanAdoptionFailure struct {
+	File    string `json:"file"`
+	Message string `json:"message"`
+}
+
+const mediaDirName = "media"
+
+func (c *WebCommand) handleOrphans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var paths []string
+	if meta := strings.TrimSpace(r.URL.Query().Get("metadata_path")); meta != "" {
+		metadataPath, err := c.resolveMetadataPath(meta)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		paths = append(paths, metadataPath)
+	} else {
+		paths = c.metadataPaths()
+	}
+	result := make([]*metadataOrphansPayload, 0, len(paths))
+	for _, p := range paths {
+		item, err := c.scanOrphans(p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("scan orphans failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		result = append(result, item)
+	}
+	respondJSON(w, r, http.StatusOK, result)
+}
+
+func (c *WebCommand) handleAdoptOrphans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req adoptOrphanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	metadataPath, err := c.resolveMetadataPath(req.MetadataPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) == 0 {
+		http.Error(w, "files is required", http.StatusBadRequest)
+		return
+	}
+	tx := c.history.begin("创建孤立 ROM 游戏")
+	defer c.finishChange(r, tx)
+	created, failed, err := c.adoptOrphanRoms(tx, metadataPath, req.Files)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("adopt orphan roms failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	c.respondOrphanAction(w, r, metadataPath, created, failed)
+}
+
+func (c *WebCommand) handleOrphanMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req orphanMediaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	metadataPath, err := c.resolveMetadataPath(req.MetadataPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := strings.ToLower(strings.TrimSpace(req.Action))
+	switch action {
+	case "delete":
+		tx := c.history.begin("删除媒体目录")
+		defer c.finishChange(r, tx)
+		err = c.deleteOrphanMedia(tx, metadataPath, req.Name)
+	case "attach":
+		if req.XIndexID <= 0 {
+			http.Error(w, "x_index_id must be positive", http.StatusBadRequest)
+			return
+		}
+		tx := c.history.begin("关联媒体目录")
+		defer c.finishChange(r, tx)
+		err = c.attachOrphanMedia(tx, metadataPath, req.Name, req.XIndexID)
+	default:
+		http.Error(w, fmt.Sprintf("unsupported action %q", req.Action), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("orphan media action failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	c.respondOrphanAction(w, r, metadataPath, nil, nil)
+}
+
+func (c *WebCommand) respondOrphanAction(w http.ResponseWriter, r *http.Request, metadataPath string, created []int, failed []*orphanAdoptionFailure) {
+	if err := c.reloadCollections(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("reload collections failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	orphans, err := c.scanOrphans(metadataPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("scan orphans failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, &orphanActionResponse{
+		Collection: c.findCollectionByPath(filepath.ToSlash(metadataPath)),
+		Orphans:    orphans,
+		Created:    created,
+		Failed:     failed,
+	})
+}
+
+func (c *WebCommand) metadataPaths() []string {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, coll := range c.collectionsSnapshot() {
+		if coll == nil {
+			continue
+		}
+		p := filepath.FromSlash(coll.MetadataPath)
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (c *WebCommand) scanOrphans(metadataPath string) (*metadataOrphansPayload, error) {
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	relDir, err := filepath.Rel(c.root, metadataDir)
+	if err != nil {
+		relDir = metadataDir
+	}
+	result := &metadataOrphansPayload{
+		MetadataPath: filepath.ToSlash(metadataPath),
+		RelativePath: filepath.ToSlash(relDir),
+		Roms:         []*orphanRomPayload{},
+		Media:        []*orphanMediaPayload{},
+	}
+	roms, err := findOrphanRoms(doc, metadataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, rom := range roms {
+		if rel, err := filepath.Rel(c.root, filepath.Join(metadataDir, filepath.FromSlash(rom.File))); err == nil {
+			rom.RelPath = filepath.ToSlash(rel)
+		}
+		result.Roms = append(result.Roms, rom)
+	}
+	media, err := findOrphanMedia(doc, metadataDir, mediaLayoutFor(doc, nil, c.mediaLayout))
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range media {
+		for _, name := range item.Files {
+			abs := filepath.Join(metadataDir, filepath.FromSlash(item.Path), name)
+			url, err := c.assets.URL(abs)
+			if err != nil {
+				continue
+			}
+			item.Assets = append(item.Assets, &assetPayload{
+				Name:     strings.TrimSuffix(name, filepath.Ext(name)),
+				Type:     detectAssetType(abs),
+				URL:      url,
+				FileName: name,
+			})
+		}
+		result.Media = append(result.Media, item)
+	}
+	return result, nil
+}
+
+// findOrphanRoms lists files matching the collection extensions that no game
+// block references through file:/files:.
+func findOrphanRoms(doc *metadata.Document, metadataDir string) ([]*orphanRomPayload, error) {
+	var exts []string
+	ignored := make(map[string]struct{})
+	for _, blk := range doc.Blocks {
+		if blk == nil || blk.Kind != metadata.KindCollection {
+			continue
+		}
+		for _, ext := range parseCollectionExtensions(blk) {
+			if !containsExtension(exts, ext) {
+				exts = append(exts, ext)
+			}
+		}
+		for _, entry := range blk.EntriesByKey("ignore-file") {
+			for _, value := range entry.Values {
+				if key := resolveRomPath(metadataDir, []string{value}); key != "" {
+					ignored[normalizeRomPathKey(key)] = struct{}{}
+				}
+			}
+		}
+	}
+	if len(exts) == 0 {
+		return nil, nil
+	}
+	referenced := referencedRomKeys(doc, metadataDir)
+	var out []*orphanRomPayload
+	err := filepath.WalkDir(metadataDir, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if d.IsDir() {
+			if p == metadataDir {
+				return nil
+			}
+			if isSkippedScanDir(metadataDir, p, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(d.Name(), constant.DefaultMetadataFile) {
+			return nil
+		}
+		if !containsExtension(exts, normalizeExtension(filepath.Ext(d.Name()))) {
+			return nil
+		}
+		key := normalizeRomPathKey(p)
+		if _, ok := referenced[key]; ok {
+			return nil
+		}
+		if _, ok := ignored[key]; ok {
+			return nil
+		}
+		rel, err := filepath.Rel(metadataDir, p)
+		if err != nil {
+			return nil
+		}
+		var size int64
+		if info, err := d.Info(); err == nil {
+			size = info.Size()
+		}
+		out = append(out, &orphanRomPayload{
+			File:  filepath.ToSlash(rel),
+			Title: romNameFromPath(p),
+			Size:  size,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].File) < strings.ToLower(out[j].File)
+	})
+	return out, nil
+}
+
+// findOrphanMedia lists the per-ROM media folders of layout that neither
+// match the ROM base of a game nor hold an asset referenced by one. Layouts
+// without per-ROM folders have no orphans.
+func findOrphanMedia(doc *metadata.Document, metadataDir string, layout *mediaLayout) ([]*orphanMediaPayload, error) {
+	mediaRoot := layoutOrDefault(layout).romRoot(metadataDir)
+	if mediaRoot == "" {
+		return nil, nil
+	}
+	rootRel, err := filepath.Rel(metadataDir, mediaRoot)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(mediaRoot)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	used := make(map[string]struct{})
+	for _, blk := range doc.Blocks {
+		if blk == nil || blk.Kind != metadata.KindGame {
+			continue
+		}
+		if base := deriveRomBase(extractBlockFiles(blk)); base != "" {
+			used[strings.ToLower(base)] = struct{}{}
+		}
+		for _, entry := range blk.Entries {
+			if entry == nil || !isAssetFieldKey(entry.Key) {
+				continue
+			}
+			for _, value := range entry.Values {
+				if name := mediaFolderOfAsset(mediaRoot, resolveAssetPath(metadataDir, value)); name != "" {
+					used[strings.ToLower(name)] = struct{}{}
+				}
+			}
+		}
+	}
+	var out []*orphanMediaPayload
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, ok := used[strings.ToLower(entry.Name())]; ok {
+			continue
+		}
+		item := &orphanMediaPayload{
+			Name:  entry.Name(),
+			Path:  filepath.ToSlash(filepath.Join(rootRel, entry.Name())),
+			Files: []string{},
+		}
+		files, err := os.ReadDir(filepath.Join(mediaRoot, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsDir() {
+				item.Files = append(item.Files, f.Name())
+			}
+		}
+		out = append(out, item)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name)
+	})
+	return out, nil
+}
+
+func referencedRomKeys(doc *metadata.Document, metadataDir string) map[string]struct{} {
+	out := make(map[string]struct{})
+	for _, blk := range doc.Blocks {
+		if blk == nil || blk.Kind != metadata.KindGame {
+			continue
+		}
+		for _, value := range extractBlockFiles(blk) {
+			if p := resolveRomPath(metadataDir, []string{value}); p != "" {
+				out[normalizeRomPathKey(p)] = struct{}{}
+			}
+		}
+	}
+	return out
+}
+
+// isSkippedScanDir reports whether the orphan scan leaves directory p out:
+// hidden folders, the media folder and nested collections, whose files
+// belong to their own metadata file.
+func isSkippedScanDir(metadataDir, p, name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	if filepath.Dir(p) == metadataDir && strings.EqualFold(name, mediaDirName) {
+		return true
+	}
+	return hasMetadataFile(p)
+}
+
+// hasMetadataFile reports whether dir holds a metadata file, matched
+// case-insensitively like the collection walk does.
+func hasMetadataFile(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(entry.Name(), constant.DefaultMetadataFile) {
+			return true
+		}
+	}
+	return false
+}
+
+func mediaFolderOfAsset(mediaRoot, assetPath string) string {
+	if assetPath == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(mediaRoot, assetPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+func (c *WebCommand) adoptOrphanRoms(tx *historyTx, metadataPath string, files []string) ([]int, []*orphanAdoptionFailure, error) {
+	if err := tx.snapshot(metadataPath); err != nil {
+		return nil, nil, err
+	}
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	orphans, err := findOrphanRoms(doc, metadataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	available := make(map[string]*orphanRomPayload, len(orphans))
+	for _, item := range orphans {
+		available[item.File] = item
+	}
+	var created []int
+	var failed []*orphanAdoptionFailure
+	for _, file := range files {
+		file = filepath.ToSlash(strings.TrimSpace(file))
+		item, ok := available[file]
+		if !ok {
+			failed = append(failed, &orphanAdoptionFailure{File: file, Message: "not an orphan rom"})
+			continue
+		}
+		delete(available, file)
+		fields := []*fieldPayload{
+			{Key: "game", Values: []string{item.Title}},
+			{Key: "file", Values: []string{item.File}},
+		}
+		xIndexID, err := c.appendGameBlock(tx, metadataPath, doc, 0, fields, validateMinimalGameFields)
+		if err != nil {
+			failed = append(failed, &orphanAdoptionFailure{File: file, Message: err.Error()})
+			continue
+		}
+		created = append(created, xIndexID)
+	}
+	if len(created) == 0 {
+		return created, failed, nil
+	}
+	if err := metadata.WriteMetadataFile(metadataPath, doc); err != nil {
+		return nil, nil, err
+	}
+	return created, failed, nil
+}
+
+func validateMinimalGameFields(fields map[string][]string) error {
+	if len(trimAndFilter(fields["game"])) == 0 {
+		return errors.New("game field is required")
+	}
+	if len(trimAndFilter(fields["file"])) == 0 {
+		return errors.New("file field is required")
+	}
+	return nil
+}
+
+func (c *WebCommand) orphanMediaDir(metadataPath, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name != sanitizeFileComponent(name) {
+		return "", fmt.Errorf("invalid media folder %q", name)
+	}
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return "", err
+	}
+	layout := mediaLayoutFor(doc, nil, c.mediaLayout)
+	orphans, err := findOrphanMedia(doc, filepath.Dir(metadataPath), layout)
+	if err != nil {
+		return "", err
+	}
+	for _, item := range orphans {
+		if item.Name == name {
+			return filepath.Join(layout.romRoot(filepath.Dir(metadataPath)), name), nil
+		}
+	}
+	return "", fmt.Errorf("media folder %s is not an orphan", name)
+}
+
+func (c *WebCommand) deleteOrphanMedia(tx *historyTx, metadataPath, name string) error {
+	dir, err := c.orphanMediaDir(metadataPath, name)
+	if err != nil {
+		return err
+	}
+	return tx.remove(dir)
+}
+
+func (c *WebCommand) attachOrphanMedia(tx *historyTx, metadataPath, name string, xIndexID int) error {
+	source, err := c.orphanMediaDir(metadataPath, name)
+	if err != nil {
+		return err
+	}
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	block, _, err := findGameBlockByIndexID(doc, xIndexID)
+	if err != nil {
+		return err
+	}
+	romBase := deriveRomBase(extractBlockFiles(block))
+	if romBase == "" {
+		return errors.New("game has no rom file to derive media folder")
+	}
+	target := mediaLayoutFor(doc, block, c.mediaLayout).romDir(filepath.Dir(metadataPath), romBase)
+	if target == "" {
+		return errors.New("media layout has no per-rom folders")
+	}
+	return mergeMediaDir(tx, source, target)
+}
+
+// mergeMediaDir moves every file of source into target without overwriting
+// files already present there, and removes source once it is empty.
+func mergeMediaDir(tx *historyTx, source, target string) error {
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		return tx.rename(source, target)
+	}
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			return fmt.Errorf("media folder %s contains sub directory %s", filepath.ToSlash(source), entry.Name())
+		}
+		if _, err := os.Stat(filepath.Join(target, entry.Name())); err == nil {
+			return fmt.Errorf("media file %s already exists", filepath.ToSlash(filepath.Join(target, entry.Name())))
+		}
+	}
+	for _, entry := range entries {
+		if err := tx.rename(filepath.Join(source, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(source)
+}
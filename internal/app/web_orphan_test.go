@@ -0,0 +1,94 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+func TestFindOrphanRomsAndMedia(t *testing.T) {
+	dir := t.TempDir()
+	content := `collection: Arcade
+extension: zip
+
+game: Known
+file: known.zip
+assets.boxfront: media/custom/boxFront.png
+`
+	metaPath := filepath.Join(dir, "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, content)
+	writeTestFile(t, filepath.Join(dir, "known.zip"), "x")
+	writeTestFile(t, filepath.Join(dir, "lost.zip"), "x")
+	writeTestFile(t, filepath.Join(dir, "sub", "nested.zip"), "x")
+	writeTestFile(t, filepath.Join(dir, "readme.txt"), "x")
+	writeTestFile(t, filepath.Join(dir, "sub", "inner", "metadata.pegasus.txt"), "collection: Inner\nextension: zip\n")
+	writeTestFile(t, filepath.Join(dir, "sub", "inner", "other.zip"), "x")
+	writeTestFile(t, filepath.Join(dir, "media", "known", "boxFront.png"), "x")
+	writeTestFile(t, filepath.Join(dir, "media", "custom", "boxFront.png"), "x")
+	writeTestFile(t, filepath.Join(dir, "media", "stale", "boxFront.png"), "x")
+	writeTestFile(t, filepath.Join(dir, "media", "stale.zip"), "x")
+
+	doc, err := metadata.ParseMetadataFile(metaPath)
+	if err != nil {
+		t.Fatalf("parse metadata: %v", err)
+	}
+
+	roms, err := findOrphanRoms(doc, dir)
+	if err != nil {
+		t.Fatalf("find orphan roms: %v", err)
+	}
+	var files []string
+	for _, rom := range roms {
+		files = append(files, rom.File)
+	}
+	assert.Equal(t, []string{"lost.zip", "sub/nested.zip"}, files)
+	assert.Equal(t, "lost", roms[0].Title)
+
+	media, err := findOrphanMedia(doc, dir, nil)
+	if err != nil {
+		t.Fatalf("find orphan media: %v", err)
+	}
+	if assert.Len(t, media, 1) {
+		assert.Equal(t, "stale", media[0].Name)
+		assert.Equal(t, []string{"boxFront.png"}, media[0].Files)
+	}
+}
+
+func TestMergeMediaDirRejectsConflicts(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "media", "old")
+	target := filepath.Join(dir, "media", "new")
+	writeTestFile(t, filepath.Join(source, "boxFront.png"), "old")
+	writeTestFile(t, filepath.Join(source, "video.mp4"), "old")
+	writeTestFile(t, filepath.Join(target, "boxFront.png"), "new")
+
+	if err := mergeMediaDir(nil, source, target); err == nil {
+		t.Fatalf("expected conflict error")
+	}
+	_, err := os.Stat(filepath.Join(source, "video.mp4"))
+	assert.NoError(t, err, "conflicting merge must not move any file")
+
+	if err := os.Remove(filepath.Join(target, "boxFront.png")); err != nil {
+		t.Fatalf("remove target file: %v", err)
+	}
+	if err := mergeMediaDir(nil, source, target); err != nil {
+		t.Fatalf("merge media dir: %v", err)
+	}
+	_, err = os.Stat(source)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(target, "video.mp4"))
+	assert.NoError(t, err)
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+type replaceMetadataRequest struct {
+	MetadataPath string   `json:"metadata_path,omitempty"`
+	Keys         []string `json:"keys"`
+	Find         string   `json:"find"`
+	Replacement  string   `json:"replacement"`
+	Regex        bool     `json:"regex"`
+	Apply        bool     `json:"apply"`
+	// Hashes maps each metadata_path of the preview to its hash. Apply
+	// requires them, so only the previewed changes are ever written.
+	Hashes map[string]string `json:"hashes,omitempty"`
+}
+
+type replaceFilePayload struct {
+	MetadataPath string `json:"metadata_path"`
+	RelPath      string `json:"rel_path"`
+	Count        int    `json:"count"`
+	Diff         string `json:"diff"`
+	Hash         string `json:"hash"`
+}
+
+type replaceMetadataResponse struct {
+	Files       []*replaceFilePayload `json:"files"`
+	Applied     bool                  `json:"applied"`
+	Collections []*collectionPayload  `json:"collections,omitempty"`
+}
+
+func (c *WebCommand) handleReplaceMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req replaceMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	files := c.metadataPaths()
+	if strings.TrimSpace(req.MetadataPath) != "" {
+		metadataPath, err := c.resolveMetadataPath(req.MetadataPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		files = []string{metadataPath}
+	}
+	plan, err := planMetadataReplace(c.root, files, &metadataReplaceOptions{
+		Keys:        req.Keys,
+		Find:        req.Find,
+		Replacement: req.Replacement,
+		Regex:       req.Regex,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := &replaceMetadataResponse{Files: make([]*replaceFilePayload, 0, len(plan))}
+	for _, change := range plan {
+		resp.Files = append(resp.Files, &replaceFilePayload{
+			MetadataPath: filepath.ToSlash(change.Path),
+			RelPath:      change.RelPath,
+			Count:        change.Count,
+			Diff:         change.Diff,
+			Hash:         change.Hash,
+		})
+	}
+	if req.Apply {
+		if err := checkReplacePreview(plan, req.Hashes); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	if req.Apply && len(plan) > 0 {
+		tx := c.history.begin("查找替换")
+		defer c.finishChange(r, tx)
+		if err := applyMetadataReplace(tx, plan); err != nil {
+			http.Error(w, fmt.Sprintf("apply replace failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := c.reloadCollections(r.Context()); err != nil {
+			http.Error(w, fmt.Sprintf("reload collections failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		resp.Applied = true
+		resp.Collections = summarizeCollections(c.collectionsSnapshot())
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
+// checkReplacePreview makes sure plan changes exactly the files of the
+// preview, each still holding the content it was previewed with.
+func checkReplacePreview(plan []*metadataReplaceChange, hashes map[string]string) error {
+	if len(plan) != len(hashes) {
+		return fmt.Errorf("files to change differ from preview, preview again")
+	}
+	for _, change := range plan {
+		if hash, ok := hashes[filepath.ToSlash(change.Path)]; !ok || hash != change.Hash {
+			return fmt.Errorf("metadata %s changed since preview, preview again", change.RelPath)
+		}
+	}
+	return nil
+}
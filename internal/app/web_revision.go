@@ -0,0 +1,138 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+// errRevisionConflict reports that a block changed on disk after the client
+// loaded it.
+var errRevisionConflict = errors.New("revision conflict")
+
+// blockRevision fingerprints the rendered content of a single block, so a
+// change to one game does not invalidate the others in the same file.
+func blockRevision(blk *metadata.Block) string {
+	data, err := metadata.Marshal(&metadata.Document{Blocks: []*metadata.Block{blk}})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkBlockRevision compares the revision the client edited against the
+// block on disk. An empty revision skips the check for older clients.
+func checkBlockRevision(blk *metadata.Block, revision string) error {
+	revision = strings.TrimSpace(revision)
+	if revision == "" {
+		return nil
+	}
+	if current := blockRevision(blk); current != revision {
+		return fmt.Errorf("%w: expected %s, found %s", errRevisionConflict, revision, current)
+	}
+	return nil
+}
+
+// fieldDiff is one key whose value on disk differs from the submitted one.
+type fieldDiff struct {
+	Key       string   `json:"key"`
+	Current   []string `json:"current"`
+	Submitted []string `json:"submitted"`
+}
+
+type gameConflictResponse struct {
+	Error      string             `json:"error"`
+	Revision   string             `json:"revision"`
+	Collection *collectionPayload `json:"collection,omitempty"`
+	Game       *gamePayload       `json:"game,omitempty"`
+	Diff       []*fieldDiff       `json:"diff"`
+}
+
+type collectionConflictResponse struct {
+	Error      string             `json:"error"`
+	Revision   string             `json:"revision"`
+	Collection *collectionPayload `json:"collection,omitempty"`
+	Diff       []*fieldDiff       `json:"diff"`
+}
+
+// diffFields lists the keys whose values differ between the current and the
+// submitted field lists, in the order they first appear.
+func diffFields(current, submitted []*fieldPayload) []*fieldDiff {
+	var order []string
+	currentMap := fieldValuesByKey(current, &order)
+	submittedMap := fieldValuesByKey(submitted, &order)
+	out := make([]*fieldDiff, 0)
+	for _, key := range order {
+		cur, sub := currentMap[key], submittedMap[key]
+		if equalStrings(cur, sub) {
+			continue
+		}
+		out = append(out, &fieldDiff{Key: key, Current: cur, Submitted: sub})
+	}
+	return out
+}
+
+func fieldValuesByKey(fields []*fieldPayload, order *[]string) map[string][]string {
+	out := make(map[string][]string)
+	for _, field := range fields {
+		if field == nil {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(field.Key))
+		if key == "" {
+			continue
+		}
+		if _, ok := out[key]; !ok {
+			out[key] = []string{}
+			if !containsString(*order, key) {
+				*order = append(*order, key)
+			}
+		}
+		out[key] = append(out[key], field.Values...)
+	}
+	return out
+}
+
+func (c *WebCommand) respondGameConflict(w http.ResponseWriter, r *http.Request, metadataPath string, req *updateGameRequest, cause error) {
+	if err := c.reloadCollections(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("reload collections failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	coll, game := c.findGamePayload(filepath.ToSlash(metadataPath), req.XIndexID)
+	if coll == nil || game == nil {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, r, http.StatusConflict, &gameConflictResponse{
+		Error:      cause.Error(),
+		Revision:   game.Revision,
+		Collection: coll,
+		Game:       game,
+		Diff:       diffFields(game.Fields, req.Fields),
+	})
+}
+
+func (c *WebCommand) respondCollectionConflict(w http.ResponseWriter, r *http.Request, metadataPath string, req *updateCollectionRequest, cause error) {
+	if err := c.reloadCollections(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("reload collections failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	coll := c.findCollectionByIndex(filepath.ToSlash(metadataPath), req.XIndexID)
+	if coll == nil {
+		http.Error(w, "collection not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, r, http.StatusConflict, &collectionConflictResponse{
+		Error:      cause.Error(),
+		Revision:   coll.Revision,
+		Collection: coll,
+		Diff:       diffFields(coll.Fields, req.Fields),
+	})
+}
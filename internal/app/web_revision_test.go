@@ -0,0 +1,85 @@
+package app
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xxxsen/retrog/internal/metadata"
+)
+
+func TestUpdateGameRejectsStaleRevision(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "metadata.pegasus.txt")
+	original := "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\nx-index-id: 1\n\ngame: Beta\nfile: beta.zip\nx-index-id: 2\n"
+	writeTestFile(t, metaPath, original)
+
+	doc, err := metadata.ParseMetadataFile(metaPath)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	alpha, _, err := findGameBlockByIndexID(doc, 1)
+	if err != nil {
+		t.Fatalf("find alpha: %v", err)
+	}
+	beta, _, err := findGameBlockByIndexID(doc, 2)
+	if err != nil {
+		t.Fatalf("find beta: %v", err)
+	}
+	alphaRev, betaRev := blockRevision(alpha), blockRevision(beta)
+	assert.NotEqual(t, alphaRev, betaRev)
+
+	c := &WebCommand{root: root}
+	// Someone else edits Beta: Alpha's revision is still valid.
+	err = c.updateGameMetadata(nil, metaPath, 2, betaRev, []*fieldPayload{
+		{Key: "game", Values: []string{"Beta"}},
+		{Key: "file", Values: []string{"beta.zip"}},
+		{Key: "assets.boxFront", Values: []string{"media/beta/boxFront.png"}},
+		{Key: "x-index-id", Values: []string{"2"}},
+		{Key: "developer", Values: []string{"Capcom"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("update beta: %v", err)
+	}
+	err = c.updateGameMetadata(nil, metaPath, 1, alphaRev, []*fieldPayload{
+		{Key: "game", Values: []string{"Alpha"}},
+		{Key: "file", Values: []string{"alpha.zip"}},
+		{Key: "assets.boxFront", Values: []string{"media/alpha/boxFront.png"}},
+		{Key: "x-index-id", Values: []string{"1"}},
+		{Key: "genre", Values: []string{"Shooter"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("update alpha: %v", err)
+	}
+
+	// A tab still holding the first Beta revision is rejected untouched.
+	before := readTestFile(t, metaPath)
+	err = c.updateGameMetadata(nil, metaPath, 2, betaRev, []*fieldPayload{
+		{Key: "game", Values: []string{"Beta Stale"}},
+		{Key: "file", Values: []string{"beta.zip"}},
+		{Key: "assets.boxFront", Values: []string{"media/beta/boxFront.png"}},
+		{Key: "x-index-id", Values: []string{"2"}},
+	}, nil)
+	assert.True(t, errors.Is(err, errRevisionConflict), "got %v", err)
+	assert.Equal(t, before, readTestFile(t, metaPath))
+}
+
+func TestDiffFields(t *testing.T) {
+	current := []*fieldPayload{
+		{Key: "game", Values: []string{"Beta"}},
+		{Key: "developer", Values: []string{"Capcom"}},
+		{Key: "genre", Values: []string{"Shooter"}},
+	}
+	submitted := []*fieldPayload{
+		{Key: "game", Values: []string{"Beta Stale"}},
+		{Key: "Genre", Values: []string{"Shooter"}},
+		{Key: "publisher", Values: []string{"SNK"}},
+	}
+	diff := diffFields(current, submitted)
+	assert.Equal(t, []*fieldDiff{
+		{Key: "game", Current: []string{"Beta"}, Submitted: []string{"Beta Stale"}},
+		{Key: "developer", Current: []string{"Capcom"}, Submitted: nil},
+		{Key: "publisher", Current: nil, Submitted: []string{"SNK"}},
+	}, diff)
+}
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/retrog/internal/metadata"
+	"github.com/xxxsen/retrog/internal/scraper"
+	"go.uber.org/zap"
+)
+
+type scrapeGameRequest struct {
+	MetadataPath string `json:"metadata_path"`
+	XIndexID     int    `json:"x_index_id"`
+	Policy       string `json:"policy,omitempty"`
+}
+
+// scrapeChangePayload is a proposed field value. Media are downloaded into
+// the upload directory, so New holds a staged token that /api/games/update
+// moves into media/ like an upload; Error explains a file that was rejected.
+type scrapeChangePayload struct {
+	Key   string        `json:"key"`
+	Old   []string      `json:"old,omitempty"`
+	New   []string      `json:"new,omitempty"`
+	Asset *assetPayload `json:"asset,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+type scrapeGameResponse struct {
+	Provider string                 `json:"provider"`
+	Title    string                 `json:"title,omitempty"`
+	Match    string                 `json:"match,omitempty"`
+	Changes  []*scrapeChangePayload `json:"changes"`
+}
+
+func (c *WebCommand) scrapeMeta() string {
+	if c.scraper == nil {
+		return ""
+	}
+	return fmt.Sprintf("\n  <meta name=\"retrog-scraper\" content=\"%s\">\n  <meta name=\"retrog-scrape-policy\" content=\"%s\">",
+		html.EscapeString(c.scraper.Name()), html.EscapeString(c.scrapePolicy))
+}
+
+// handleScrapeGame looks a game up with the configured scraper and returns
+// the changes for the edit form. Nothing is written until the form is saved.
+func (c *WebCommand) handleScrapeGame(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.scraper == nil {
+		http.Error(w, "scraper not configured", http.StatusNotFound)
+		return
+	}
+	var req scrapeGameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	metadataPath, err := c.resolveMetadataPath(req.MetadataPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policyArg := req.Policy
+	if policyArg == "" {
+		policyArg = c.scrapePolicy
+	}
+	policy, err := scraper.ParsePolicy(policyArg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("load metadata failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	block, _, err := findGameBlockByIndexID(doc, req.XIndexID)
+	if err != nil {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+	res, changes, err := scrapeGame(r.Context(), c.scraper, mediaLayoutFor(doc, block, c.mediaLayout), filepath.Dir(metadataPath), documentPlatform(doc), block, policy)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("scrape failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	resp := &scrapeGameResponse{Provider: c.scraper.Name(), Changes: []*scrapeChangePayload{}}
+	if res == nil {
+		respondJSON(w, r, http.StatusOK, resp)
+		return
+	}
+	resp.Title = res.Title
+	resp.Match = res.Match
+	for _, change := range changes {
+		item := &scrapeChangePayload{Key: change.Key, Old: change.Old, New: change.New}
+		if change.Media != nil {
+			if err := c.stageScrapedMedia(r, change, item); err != nil {
+				var rejected *mediaRejectedError
+				if !errors.As(err, &rejected) {
+					http.Error(w, fmt.Sprintf("download media failed: %v", err), http.StatusBadGateway)
+					return
+				}
+				item.Error = err.Error()
+			}
+		}
+		resp.Changes = append(resp.Changes, item)
+	}
+	respondJSON(w, r, http.StatusOK, resp)
+}
+
+func (c *WebCommand) stageScrapedMedia(r *http.Request, change *scraper.Change, item *scrapeChangePayload) error {
+	base := fmt.Sprintf("%d__%s", time.Now().UnixNano(), assetFileBaseFromKey(change.Key))
+	dest, err := downloadScrapedMedia(r.Context(), c.scraper, change.Media, c.mediaRules.For(change.Key), c.uploadDir, base)
+	if err != nil {
+		return err
+	}
+	payload, err := c.buildStagedAssetPayload(dest)
+	if err != nil {
+		return err
+	}
+	item.New = []string{stagedUploadPrefix + filepath.Base(dest)}
+	item.Asset = payload
+	return nil
+}
+
+// scrapedGame is what the lookup phase of the scrape job found for one
+// game: the best result and the media already staged in the upload
+// directory, by field.
+type scrapedGame struct {
+	xIndexID int
+	result   *scraper.Result
+	staged   map[string]string
+}
+
+// runScrapeJob scrapes every game of the library with the configured scraper
+// and policy; "ask" falls back to "fill" as nobody is there to answer. The
+// lookups and downloads of a metadata file run without the history lock,
+// which is only taken to write that file, so each file is one undoable
+// history entry and web edits go on while the job runs. A canceled or
+// failed job keeps the files finished so far.
+func (c *WebCommand) runScrapeJob(ctx context.Context, job *webJob) error {
+	if c.scraper == nil {
+		return errors.New("scraper not configured")
+	}
+	policy, err := scraper.ParsePolicy(c.scrapePolicy)
+	if err != nil {
+		return err
+	}
+	if policy == scraper.PolicyAsk {
+		policy = scraper.PolicyFill
+	}
+	var files []string
+	seen := make(map[string]struct{})
+	total := 0
+	for _, coll := range c.collectionsSnapshot() {
+		total += len(coll.Games)
+		metadataPath := filepath.FromSlash(coll.MetadataPath)
+		if _, ok := seen[metadataPath]; ok {
+			continue
+		}
+		seen[metadataPath] = struct{}{}
+		files = append(files, metadataPath)
+	}
+	done := 0
+	for _, metadataPath := range files {
+		games, err := c.lookupScrapeJobFile(ctx, job, metadataPath, policy, &done, total)
+		// What was found before a failure or cancel is still written.
+		if len(games) > 0 {
+			if applyErr := c.applyScrapeJobFile(ctx, metadataPath, games, policy); applyErr != nil {
+				return applyErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupScrapeJobFile searches the scraper for the games of one metadata
+// file and stages the media they would get. Nothing in the library is
+// touched.
+func (c *WebCommand) lookupScrapeJobFile(ctx context.Context, job *webJob, metadataPath string, policy scraper.Policy, done *int, total int) ([]*scrapedGame, error) {
+	logger := logutil.GetLogger(ctx)
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	platform := documentPlatform(doc)
+	var games []*scrapedGame
+	for _, block := range doc.Blocks {
+		if block == nil || block.Kind != metadata.KindGame {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return games, err
+		}
+		title := getBlockTitle(block)
+		res, changes, err := scrapeGame(ctx, c.scraper, mediaLayoutFor(doc, block, c.mediaLayout), metadataDir, platform, block, policy)
+		if err != nil {
+			return games, fmt.Errorf("scrape %s: %w", title, err)
+		}
+		if id := blockXIndexID(block); res != nil && len(changes) > 0 && id > 0 {
+			game := &scrapedGame{xIndexID: id, result: res, staged: make(map[string]string)}
+			games = append(games, game)
+			for _, change := range changes {
+				if change.Media == nil {
+					continue
+				}
+				base := fmt.Sprintf("%d__%s", time.Now().UnixNano(), assetFileBaseFromKey(change.Key))
+				staged, err := downloadScrapedMedia(ctx, c.scraper, change.Media, c.mediaRules.For(change.Key), c.uploadDir, base)
+				if err != nil {
+					var rejected *mediaRejectedError
+					if !errors.As(err, &rejected) {
+						return games, fmt.Errorf("download %s for %s: %w", change.Media.URL, title, err)
+					}
+					logger.Warn("skip scraped media", zap.String("game", title), zap.String("field", change.Key), zap.Error(err))
+					continue
+				}
+				game.staged[change.Key] = staged
+			}
+		}
+		*done++
+		job.setProgress(*done, total, title)
+	}
+	return games, nil
+}
+
+// applyScrapeJobFile writes the found games into metadataPath under the
+// history lock. The file is parsed again and the changes planned against it,
+// so edits made during the lookup are kept as the policy says; media fields
+// that were not downloaded are left alone and unused downloads dropped.
+func (c *WebCommand) applyScrapeJobFile(ctx context.Context, metadataPath string, games []*scrapedGame, policy scraper.Policy) error {
+	defer func() {
+		for _, game := range games {
+			for _, staged := range game.staged {
+				_ = os.Remove(staged)
+			}
+		}
+	}()
+	tx := c.history.begin(jobKindScrapeDesc)
+	err := c.writeScrapeJobFile(tx, metadataPath, games, policy)
+	if err != nil {
+		if rbErr := tx.rollback(); rbErr != nil {
+			err = fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+	}
+	entry := c.history.finish(tx)
+	if entry == nil {
+		return err
+	}
+	c.reloadMetadataFiles(ctx, []string{metadataPath}, nil)
+	c.publishEntryChange("", entry, entry.label)
+	return err
+}
+
+func (c *WebCommand) writeScrapeJobFile(tx *historyTx, metadataPath string, games []*scrapedGame, policy scraper.Policy) error {
+	if err := tx.snapshot(metadataPath); err != nil {
+		return err
+	}
+	doc, err := metadata.ParseMetadataFile(metadataPath)
+	if err != nil {
+		return err
+	}
+	metadataDir := filepath.Dir(metadataPath)
+	changed := false
+	for _, game := range games {
+		block, _, err := findGameBlockByIndexID(doc, game.xIndexID)
+		if err != nil {
+			continue
+		}
+		layout := mediaLayoutFor(doc, block, c.mediaLayout)
+		for _, change := range scraper.Plan(blockFieldValues(layout, metadataDir, block), game.result, policy) {
+			values := change.New
+			if change.Media != nil {
+				staged, ok := game.staged[change.Key]
+				if !ok {
+					continue
+				}
+				for _, old := range change.Old {
+					c.deleteFieldFile(tx, metadataPath, doc, block, change.Key, old)
+				}
+				rel, err := moveFileToMedia(tx, layout, metadataPath, block, "", staged, filepath.Base(staged), change.Key)
+				if err != nil {
+					return err
+				}
+				delete(game.staged, change.Key)
+				values = []string{rel}
+			}
+			setBlockField(block, change.Key, values)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return metadata.WriteMetadataFile(metadataPath, doc)
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/search"
+)
+
+// searchFieldWeights ranks title and ROM name matches above matches in the
+// other metadata fields.
+var searchFieldWeights = map[string]float64{
+	"title": 3,
+	"file":  2,
+}
+
+func newGameSearchIndex() *search.Index {
+	return search.New(searchFieldWeights)
+}
+
+// gameSearchDocument maps a game to the fields /api/search can query. Every
+// metadata key is searchable under its own name; descriptions are folded
+// into "description" and the ROM status is a keyword for `status:red`.
+func gameSearchDocument(coll *collectionPayload, game *gamePayload) *search.Document {
+	doc := &search.Document{
+		ID: game.ID,
+		Fields: map[string][]string{
+			"title":      {game.Title},
+			"collection": {coll.Name, coll.DirName},
+		},
+		Keywords: map[string][]string{
+			"status": {game.RomStatus},
+		},
+	}
+	if game.RelRomPath != "" {
+		doc.Fields["file"] = append(doc.Fields["file"], path.Base(game.RelRomPath))
+	}
+	for _, field := range game.Fields {
+		if field == nil {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(field.Key))
+		switch {
+		case key == "" || key == "game" || key == "launch" || key == "x-index-id":
+			continue
+		case isAssetFieldKey(key) || isSortByKey(key):
+			continue
+		case key == "file" || key == "files":
+			key = "file"
+		case isMultilineTextKey(key):
+			key = "description"
+		}
+		doc.Fields[key] = append(doc.Fields[key], field.Values...)
+	}
+	return doc
+}
+
+// syncSearchIndex re-indexes the games of next whose searchable content
+// differs from prev and drops the games that are gone. A full reload after
+// an edit hands over new payloads for every game, so unchanged games are
+// recognised by their block revision rather than by pointer.
+func (c *WebCommand) syncSearchIndex(prev, next []*collectionPayload) {
+	if c.search == nil {
+		return
+	}
+	type indexed struct {
+		coll *collectionPayload
+		game *gamePayload
+	}
+	stale := make(map[string]indexed)
+	for _, coll := range prev {
+		for _, game := range coll.Games {
+			stale[game.ID] = indexed{coll: coll, game: game}
+		}
+	}
+	for _, coll := range next {
+		for _, game := range coll.Games {
+			old, ok := stale[game.ID]
+			delete(stale, game.ID)
+			if ok && sameSearchDocument(old.coll, old.game, coll, game) {
+				continue
+			}
+			c.search.Add(gameSearchDocument(coll, game))
+		}
+	}
+	for id := range stale {
+		c.search.Remove(id)
+	}
+}
+
+func sameSearchDocument(oldColl *collectionPayload, oldGame *gamePayload, coll *collectionPayload, game *gamePayload) bool {
+	if oldGame == game && oldColl == coll {
+		return true
+	}
+	return oldGame.Revision == game.Revision &&
+		oldGame.Revision != "" &&
+		oldGame.RomStatus == game.RomStatus &&
+		oldGame.RelRomPath == game.RelRomPath &&
+		oldColl.Name == coll.Name &&
+		oldColl.DirName == coll.DirName
+}
+
+// handleSearch serves /api/search. q is a free text query that may contain
+// `field:value` parts; collection limits results to one collection, and
+// offset, limit, status and missing work as in /api/games. Without q every
+// game is listed in collection order.
+func (c *WebCommand) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	values := r.URL.Query()
+	query, err := parseGameQuery(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.terms = nil
+	collectionID := strings.TrimSpace(values.Get("collection"))
+	text := strings.TrimSpace(values.Get("q"))
+
+	var refs []gameListRef
+	cols := c.collectionsSnapshot()
+	if text == "" || c.search == nil {
+		for _, coll := range cols {
+			if collectionID == "" || coll.ID == collectionID {
+				refs = append(refs, collectionGameRefs(coll)...)
+			}
+		}
+		respondJSON(w, r, http.StatusOK, query.page(refs))
+		return
+	}
+	games := make(map[string]gameListRef)
+	for _, coll := range cols {
+		if collectionID != "" && coll.ID != collectionID {
+			continue
+		}
+		for _, ref := range collectionGameRefs(coll) {
+			games[ref.game.ID] = ref
+		}
+	}
+	for _, res := range c.search.Search(text) {
+		if ref, ok := games[res.ID]; ok {
+			refs = append(refs, ref)
+		}
+	}
+	respondJSON(w, r, http.StatusOK, query.page(refs))
+}
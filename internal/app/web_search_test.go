@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleSearch(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\n"+
+		"game: Street Fighter II\nfile: sf2.zip\ndeveloper: Capcom\nx-index-id: 1\n\n"+
+		"game: 街头霸王 Zero\nfile: sfa.zip\ndeveloper: Capcom\ndescription: Prequel to the World Warrior\nx-index-id: 2\n\n"+
+		"game: Metal Slug\nfile: mslug.zip\ndeveloper: Nazca\nx-index-id: 3\n")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store, search: newGameSearchIndex()}
+	ctx := context.Background()
+	if err := c.reloadCollections(ctx); err != nil {
+		t.Fatalf("load collections: %v", err)
+	}
+
+	titles := func(q string) []string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		c.handleSearch(rec, httptest.NewRequest(http.MethodGet, "/api/search?q="+url.QueryEscape(q), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", q, rec.Code, rec.Body.String())
+		}
+		var resp gamePageResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		out := make([]string, 0, len(resp.Games))
+		for _, game := range resp.Games {
+			out = append(out, game.Title)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"Street Fighter II", "街头霸王 Zero"}, titles("developer:capcom"))
+	assert.Equal(t, []string{"街头霸王 Zero"}, titles("jtbw"))
+	assert.Equal(t, []string{"街头霸王 Zero"}, titles("warrior"))
+	assert.Equal(t, []string{"Metal Slug"}, titles("mslug"))
+	assert.Equal(t, []string{"Metal Slug"}, titles("metl slug"))
+	assert.Len(t, titles(""), 3)
+
+	// Edits reach the index through the reload that follows them.
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\n"+
+		"game: Street Fighter II\nfile: sf2.zip\ndeveloper: Capcom\nx-index-id: 1\n\n"+
+		"game: Metal Slug\nfile: mslug.zip\ndeveloper: SNK\nx-index-id: 3\n")
+	if err := c.reloadCollections(ctx); err != nil {
+		t.Fatalf("reload collections: %v", err)
+	}
+	assert.Equal(t, []string{"Metal Slug"}, titles("developer:snk"))
+	assert.Empty(t, titles("jtbw"))
+	assert.Empty(t, titles("nazca"))
+}
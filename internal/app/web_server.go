@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+)
+
+const webShutdownTimeout = 10 * time.Second
+
+// normalizeBasePath turns "retrog/", "/retrog" and "/retrog/" into
+// "/retrog"; an empty path or "/" serves from the root.
+func normalizeBasePath(raw string) (string, error) {
+	trimmed := strings.Trim(strings.TrimSpace(raw), "/")
+	if trimmed == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(trimmed, "?#\\\"<>") {
+		return "", fmt.Errorf("invalid base path %q", raw)
+	}
+	for _, part := range strings.Split(trimmed, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid base path %q", raw)
+		}
+	}
+	return "/" + trimmed, nil
+}
+
+// mountBasePath serves handler below basePath, with the prefix stripped, and
+// redirects the bare prefix to its trailing slash form so relative URLs in
+// the page resolve below it.
+func mountBasePath(basePath string, handler http.Handler) http.Handler {
+	if basePath == "" {
+		return handler
+	}
+	root := http.NewServeMux()
+	root.Handle(basePath+"/", http.StripPrefix(basePath, handler))
+	root.Handle(basePath, http.RedirectHandler(basePath+"/", http.StatusMovedPermanently))
+	return root
+}
+
+// serve runs srv until it fails or ctx is cancelled, then shuts it down
+// gracefully: event streams are closed so they do not hold the shutdown
+// open, and in-flight requests get webShutdownTimeout to finish.
+func (c *WebCommand) serve(ctx context.Context, srv *http.Server) error {
+	logger := logutil.GetLogger(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		if c.tlsCert != "" {
+			errCh <- srv.ListenAndServeTLS(c.tlsCert, c.tlsKey)
+			return
+		}
+		errCh <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+	logger.Info("shutting down web ui")
+	c.events.close()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("web ui shutdown incomplete", zap.Error(err))
+		return srv.Close()
+	}
+	logger.Info("web ui stopped")
+	return nil
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	for raw, want := range map[string]string{
+		"":         "",
+		"/":        "",
+		"retrog":   "/retrog",
+		"/retrog/": "/retrog",
+		"/a/b":     "/a/b",
+	} {
+		got, err := normalizeBasePath(raw)
+		if err != nil {
+			t.Fatalf("%q: %v", raw, err)
+		}
+		assert.Equal(t, want, got, raw)
+	}
+	for _, raw := range []string{"/a/../b", "/a//b", "/a?x"} {
+		_, err := normalizeBasePath(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestHandlerUnderBasePath(t *testing.T) {
+	auth, err := newWebAuth("", "", false)
+	if err != nil {
+		t.Fatalf("new auth: %v", err)
+	}
+	c := &WebCommand{basePath: "/retrog", auth: auth, events: newEventHub()}
+	handler, err := c.newHandler()
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	rec := get("/retrog/")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Contains(t, rec.Body.String(), `<base href="/retrog/">`)
+		assert.Contains(t, rec.Body.String(), `href="static/styles.css"`)
+	}
+	assert.Equal(t, http.StatusOK, get("/retrog/static/app.js").Code)
+	rec = get("/retrog")
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "/retrog/", rec.Header().Get("Location"))
+	assert.Equal(t, http.StatusNotFound, get("/static/app.js").Code)
+}
+
+func TestServeShutsDownOnCancel(t *testing.T) {
+	c := &WebCommand{events: newEventHub()}
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.HandlerFunc(c.handleEvents)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.serve(ctx, srv) }()
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(webShutdownTimeout):
+		t.Fatalf("serve did not return after cancel")
+	}
+	select {
+	case <-c.events.done:
+	default:
+		t.Fatalf("event streams were not closed")
+	}
+}
@@ -0,0 +1,191 @@
+package app
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xxxsen/retrog/internal/media"
+)
+
+const (
+	thumbWidthParam = "w"
+	minThumbWidth   = 64
+	maxThumbWidth   = 1024
+	// thumbWidthStep snaps requested widths so arbitrary values cannot fill
+	// the cache with near-identical files.
+	thumbWidthStep = 64
+	// maxThumbSourcePixels refuses to decode images that would need an
+	// unreasonable amount of memory on a small NAS.
+	maxThumbSourcePixels = 64 << 20
+	thumbJPEGQuality     = 82
+)
+
+var errThumbNotNeeded = errors.New("thumbnail not needed")
+
+// thumbnailExts are the formats decoded for thumbnails; anything else is
+// served as is.
+var thumbnailExts = map[string]struct{}{
+	".png":  {},
+	".jpg":  {},
+	".jpeg": {},
+	".gif":  {},
+	".webp": {},
+}
+
+// thumbnailer resizes images on demand and keeps the results in a disk
+// cache keyed by source path, source version and width. Decoding is limited
+// to one image per CPU, and concurrent requests for the same thumbnail wait
+// for a single render.
+type thumbnailer struct {
+	dir      string
+	sem      chan struct{}
+	mu       sync.Mutex
+	inflight map[string]chan struct{}
+}
+
+func newThumbnailer(dir string) (*thumbnailer, error) {
+	dir = filepath.Join(dir, "thumbs")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &thumbnailer{
+		dir:      dir,
+		sem:      make(chan struct{}, runtime.NumCPU()),
+		inflight: make(map[string]chan struct{}),
+	}, nil
+}
+
+// defaultCacheDir is the user cache directory, or the temp directory when
+// the user has none.
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "retrog")
+	}
+	return filepath.Join(os.TempDir(), "retrog_cache")
+}
+
+// parseThumbWidth reads the requested width, snapped up to thumbWidthStep
+// and clamped to the supported range. It returns 0 when none was asked for.
+func parseThumbWidth(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
+	}
+	width, err := strconv.Atoi(raw)
+	if err != nil || width <= 0 {
+		return 0, fmt.Errorf("invalid width %q", raw)
+	}
+	width = (width + thumbWidthStep - 1) / thumbWidthStep * thumbWidthStep
+	return min(max(width, minThumbWidth), maxThumbWidth), nil
+}
+
+func isThumbnailSource(path string) bool {
+	_, ok := thumbnailExts[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// thumbnail returns the cached thumbnail of src at width, rendering it
+// first if needed. errThumbNotNeeded means the source should be served
+// unchanged: it is already narrow enough or too large to decode.
+func (t *thumbnailer) thumbnail(src, version string, width int) (string, error) {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%d", src, version, width)))
+	key := hex.EncodeToString(sum[:])
+	base := filepath.Join(t.dir, key[:2], key)
+	for {
+		for _, ext := range []string{".jpg", ".png"} {
+			if _, err := os.Stat(base + ext); err == nil {
+				return base + ext, nil
+			}
+		}
+		if _, err := os.Stat(base + ".orig"); err == nil {
+			return "", errThumbNotNeeded
+		}
+		t.mu.Lock()
+		wait, busy := t.inflight[key]
+		if !busy {
+			done := make(chan struct{})
+			t.inflight[key] = done
+			t.mu.Unlock()
+			path, err := t.render(src, base, width)
+			t.mu.Lock()
+			delete(t.inflight, key)
+			t.mu.Unlock()
+			close(done)
+			return path, err
+		}
+		t.mu.Unlock()
+		<-wait
+	}
+}
+
+func (t *thumbnailer) render(src, base string, width int) (string, error) {
+	t.sem <- struct{}{}
+	defer func() { <-t.sem }()
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(base), 0o755); err != nil {
+		return "", err
+	}
+	if cfg.Width <= width || cfg.Width*cfg.Height > maxThumbSourcePixels {
+		// Remember the decision so the header is not parsed again.
+		if err := os.WriteFile(base+".orig", nil, 0o644); err != nil {
+			return "", err
+		}
+		return "", errThumbNotNeeded
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+	bounds := img.Bounds()
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	opaque := media.Opaque(img)
+	dst := media.Scale(img, width, height)
+
+	ext := ".png"
+	if opaque {
+		ext = ".jpg"
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(base), ".thumb-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if opaque {
+		err = jpeg.Encode(tmp, dst, &jpeg.Options{Quality: thumbJPEGQuality})
+	} else {
+		err = png.Encode(tmp, dst)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), base+ext); err != nil {
+		return "", err
+	}
+	return base + ext, nil
+}
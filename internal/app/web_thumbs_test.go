@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestPNG(t *testing.T, path string, width, height int, alpha bool) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255}
+			if alpha && x < width/2 {
+				c.A = 64
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write png: %v", err)
+	}
+}
+
+func TestParseThumbWidth(t *testing.T) {
+	for raw, want := range map[string]int{"": 0, "1": minThumbWidth, "200": 256, "256": 256, "5000": maxThumbWidth} {
+		got, err := parseThumbWidth(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, want, got, raw)
+	}
+	for _, raw := range []string{"abc", "0", "-5"} {
+		_, err := parseThumbWidth(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestHandleAssetThumbnail(t *testing.T) {
+	root := t.TempDir()
+	boxart := filepath.Join(root, "media", "game", "boxart.png")
+	logo := filepath.Join(root, "media", "game", "logo.png")
+	small := filepath.Join(root, "media", "game", "small.png")
+	writeTestPNG(t, boxart, 600, 300, false)
+	writeTestPNG(t, logo, 600, 300, true)
+	writeTestPNG(t, small, 40, 40, false)
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	thumbs, err := newThumbnailer(t.TempDir())
+	if err != nil {
+		t.Fatalf("new thumbnailer: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store, thumbs: thumbs}
+	serve := func(path, width string) *httptest.ResponseRecorder {
+		url, err := store.URL(path)
+		if err != nil {
+			t.Fatalf("asset url: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/"+url+"&w="+width, nil)
+		rec := httptest.NewRecorder()
+		c.handleAsset(rec, req)
+		return rec
+	}
+
+	rec := serve(boxart, "200")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Header().Get("ETag"), "-w256")
+	img, err := jpeg.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	assert.Equal(t, image.Pt(256, 128), img.Bounds().Size())
+
+	// The second request is served from the cache.
+	files, _ := filepath.Glob(filepath.Join(thumbs.dir, "*", "*.jpg"))
+	assert.Len(t, files, 1)
+	again := serve(boxart, "256")
+	assert.Equal(t, rec.Body.Bytes(), again.Body.Bytes())
+
+	rec = serve(logo, "128")
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	img, err = png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	assert.Equal(t, image.Pt(128, 64), img.Bounds().Size())
+
+	// Images narrower than the request are served unchanged.
+	original, _ := os.ReadFile(small)
+	rec = serve(small, "128")
+	assert.Equal(t, original, rec.Body.Bytes())
+
+	rec = serve(boxart, "wide")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,556 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	watchModeAuto     = "auto"
+	watchModeFSNotify = "fsnotify"
+	watchModePoll     = "poll"
+	watchModeOff      = "off"
+
+	defaultWatchInterval = 10 * time.Second
+	watchDebounce        = 500 * time.Millisecond
+	watchQueueSize       = 256
+	selfWriteTTL         = time.Minute
+)
+
+// changeSource reports paths below the library root that were created,
+// modified or removed.
+type changeSource interface {
+	run(ctx context.Context, changed chan<- string) error
+}
+
+func validateWatchMode(mode string) error {
+	switch mode {
+	case watchModeAuto, watchModeFSNotify, watchModePoll, watchModeOff:
+		return nil
+	}
+	return fmt.Errorf("invalid --watch %q, expected auto, fsnotify, poll or off", mode)
+}
+
+// skipWatchPath reports whether path lies in a hidden file or directory below
+// root, such as .retrog or the temporary files of an atomic write.
+func skipWatchPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// startWatcher follows the library for changes made outside the web UI,
+// e.g. over SMB or by another tool, and reloads the affected collections.
+func (c *WebCommand) startWatcher(ctx context.Context) error {
+	logger := logutil.GetLogger(ctx)
+	var source changeSource
+	mode := c.watchMode
+	switch mode {
+	case watchModeOff:
+		logger.Info("library watch disabled")
+		return nil
+	case watchModePoll:
+		source = &pollWatcher{root: c.root, interval: c.watchInterval}
+	default:
+		mode = watchModeFSNotify
+		w, err := newNotifyWatcher(c.root)
+		if err != nil {
+			if c.watchMode == watchModeFSNotify {
+				return fmt.Errorf("init fsnotify watcher: %w", err)
+			}
+			logger.Warn("fsnotify unavailable, fallback to polling",
+				zap.Duration("interval", c.watchInterval), zap.Error(err))
+			source = &pollWatcher{root: c.root, interval: c.watchInterval}
+			mode = watchModePoll
+		} else {
+			source = w
+		}
+	}
+	changed := make(chan string, watchQueueSize)
+	go func() {
+		if err := source.run(ctx, changed); err != nil && ctx.Err() == nil {
+			logger.Error("library watch stopped", zap.Error(err))
+		}
+	}()
+	go c.watchLoop(ctx, changed)
+	logger.Info("library watch started", zap.String("mode", mode))
+	return nil
+}
+
+// watchLoop collects changed paths until the library has been quiet for
+// watchDebounce, so a copy of many files is handled as one reload.
+func (c *WebCommand) watchLoop(ctx context.Context, changed <-chan string) {
+	pending := make(map[string]struct{})
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path := <-changed:
+			pending[path] = struct{}{}
+			fire = time.After(watchDebounce)
+		case <-fire:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			pending = make(map[string]struct{})
+			fire = nil
+			sort.Strings(paths)
+			c.applyFileChanges(ctx, paths)
+		}
+	}
+}
+
+// applyFileChanges reloads the metadata files affected by paths, re-tests
+// changed archives and notifies connected browsers.
+func (c *WebCommand) applyFileChanges(ctx context.Context, paths []string) {
+	logger := logutil.GetLogger(ctx)
+	paths = c.dropSelfWrites(paths)
+	if len(paths) == 0 {
+		return
+	}
+	current := c.collectionsSnapshot()
+	metaSet := affectedMetadataPaths(current, paths)
+	retested := c.retestArchives(ctx, current, paths)
+	if len(metaSet) == 0 {
+		return
+	}
+	metaPaths := make([]string, 0, len(metaSet))
+	for path := range metaSet {
+		metaPaths = append(metaPaths, filepath.FromSlash(path))
+	}
+	sort.Strings(metaPaths)
+	updated, removed := c.reloadMetadataFiles(ctx, metaPaths, retested)
+	logger.Info("library changed on disk",
+		zap.Int("path_count", len(paths)),
+		zap.Int("metadata_count", len(metaPaths)),
+		zap.Int("retested", len(retested)))
+	c.events.publish(&webEvent{
+		Type: eventCollectionsChanged,
+		Data: &collectionsChangedEvent{Collections: summarizeCollections(updated), Removed: removed, Source: eventSourceDisk},
+	})
+}
+
+// retestArchives runs the DAT check again for the changed archives and
+// returns the normalised keys whose stored status was replaced. Each archive
+// is tested against the DAT of the collection it belongs to.
+func (c *WebCommand) retestArchives(ctx context.Context, cols []*collectionPayload, paths []string) map[string]struct{} {
+	logger := logutil.GetLogger(ctx)
+	c.romMu.RLock()
+	testers := c.romTesters
+	c.romMu.RUnlock()
+	retested := make(map[string]struct{})
+	for setName, tester := range testers {
+		exts := c.romTestExts(cols, setName)
+		var files []string
+		for _, path := range paths {
+			if hasExtension(path, exts) && c.romSetOfPath(cols, path) == setName {
+				files = append(files, path)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckStarted, Files: len(files)})
+		res, err := tester.TestFiles(stdContextAdapter{ctx}, c.root, c.biosDir, files, exts)
+		if err != nil {
+			logger.Error("rom recheck failed", zap.String("dat", setName), zap.Error(err))
+			c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckFailed, Files: len(files), Error: err.Error()})
+			continue
+		}
+		c.romMu.Lock()
+		for _, path := range files {
+			key := normalizeRomPathKey(path)
+			delete(c.romStatusByPath, key)
+			retested[key] = struct{}{}
+		}
+		for _, item := range res.List {
+			c.romStatusByPath[normalizeRomPathKey(item.FilePath)] = summarizeRomResult(item)
+		}
+		c.romMu.Unlock()
+		c.publishRomCheck(&romCheckEvent{Family: setName, State: romCheckFinished, Files: len(files), Count: len(res.List)})
+	}
+	return retested
+}
+
+// romSetOfPath names the DAT an archive is checked against: the one of the
+// collection whose game uses it, else of the collection with the deepest
+// metadata directory holding it, as for an archive not listed yet.
+func (c *WebCommand) romSetOfPath(cols []*collectionPayload, path string) string {
+	key := normalizeRomPathKey(path)
+	best, bestLen := "", -1
+	for _, coll := range cols {
+		if coll == nil {
+			continue
+		}
+		for _, game := range coll.Games {
+			if normalizeRomPathKey(game.RomPath) == key {
+				return c.romSetName(coll.Core)
+			}
+		}
+		dir := normalizeRomPathKey(filepath.Dir(coll.MetadataPath))
+		if strings.HasPrefix(key, dir+"/") && len(dir) > bestLen {
+			best, bestLen = c.romSetName(coll.Core), len(dir)
+		}
+	}
+	return best
+}
+
+// reloadMetadataFiles re-parses the given metadata files and swaps their
+// collections in place, leaving every other collection untouched. It returns
+// the new collections and the metadata paths whose collections are gone.
+func (c *WebCommand) reloadMetadataFiles(ctx context.Context, paths []string, retested map[string]struct{}) ([]*collectionPayload, []string) {
+	c.history.writeMu.Lock()
+	defer c.history.writeMu.Unlock()
+
+	replaced := make(map[string]struct{}, len(paths))
+	updated := make([]*collectionPayload, 0)
+	var removed []string
+	for _, path := range paths {
+		slashPath := filepath.ToSlash(path)
+		replaced[slashPath] = struct{}{}
+		var cols []*collectionPayload
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			cols = loadMetadataCollections(ctx, path, c.root, c.assets, c.collectionOptions())
+		}
+		if len(cols) == 0 {
+			removed = append(removed, slashPath)
+			continue
+		}
+		c.markSelfWrites([]string{path})
+		finalizeCollections(cols)
+		updated = append(updated, cols...)
+	}
+	c.refreshGameRomStatus(updated, retested)
+	c.applyStoredRomStatus(updated)
+
+	next := append([]*collectionPayload(nil), updated...)
+	for _, coll := range c.collectionsSnapshot() {
+		if _, ok := replaced[filepath.ToSlash(coll.MetadataPath)]; !ok {
+			next = append(next, coll)
+		}
+	}
+	sort.Slice(next, func(i, j int) bool {
+		return compareCollectionSortKey(next[i], next[j])
+	})
+	c.computeVirtualSortMax(next)
+	c.setCollections(next)
+	return updated, removed
+}
+
+// refreshGameRomStatus updates the per-game status of reloaded games whose
+// archive was re-tested or which have not been seen before.
+func (c *WebCommand) refreshGameRomStatus(cols []*collectionPayload, retested map[string]struct{}) {
+	for _, coll := range cols {
+		if c.romSetName(coll.Core) == "" {
+			continue
+		}
+		for _, game := range coll.Games {
+			key := normalizeRomPathKey(game.RomPath)
+			if key == "" {
+				continue
+			}
+			if _, ok := retested[key]; !ok && c.romStatusForGame(coll.MetadataPath, game.XIndexID) != nil {
+				continue
+			}
+			status := c.romStatusForPath(game.RomPath)
+			if status == nil {
+				status = &romStatusSummary{Status: romStatusNotTested, Emoji: "🔘"}
+			}
+			c.setRomStatusForGame(coll.MetadataPath, game.XIndexID, status)
+		}
+	}
+}
+
+// selfWrite is the state a path had right after the web UI changed it.
+// A pending write is still in progress, so any state counts as its own.
+type selfWrite struct {
+	stamp   fileStamp
+	exists  bool
+	pending bool
+	at      time.Time
+}
+
+func statStamp(path string) (fileStamp, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fileStamp{}, false
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime().UnixNano()}, true
+}
+
+// markSelfWrites remembers the current state of paths, so the watcher does
+// not report the web UI's own writes as external changes.
+func (c *WebCommand) markSelfWrites(paths []string) {
+	c.recordSelfWrites(paths, false)
+}
+
+// markPendingWrites marks paths the web UI is about to write, so the watcher
+// ignores them until markSelfWrites records how the write left them.
+func (c *WebCommand) markPendingWrites(paths []string) {
+	c.recordSelfWrites(paths, true)
+}
+
+func (c *WebCommand) recordSelfWrites(paths []string, pending bool) {
+	now := time.Now()
+	c.selfMu.Lock()
+	defer c.selfMu.Unlock()
+	if c.selfWrites == nil {
+		c.selfWrites = make(map[string]*selfWrite)
+	}
+	for path, item := range c.selfWrites {
+		if now.Sub(item.at) > selfWriteTTL {
+			delete(c.selfWrites, path)
+		}
+	}
+	for _, path := range paths {
+		item := &selfWrite{pending: pending, at: now}
+		if !pending {
+			item.stamp, item.exists = statStamp(path)
+		}
+		c.selfWrites[path] = item
+	}
+}
+
+// dropSelfWrites filters out paths that, or whose parent directory, still
+// look exactly as the web UI left them.
+func (c *WebCommand) dropSelfWrites(paths []string) []string {
+	c.selfMu.Lock()
+	defer c.selfMu.Unlock()
+	if len(c.selfWrites) == 0 {
+		return paths
+	}
+	matches := func(path string) bool {
+		item, ok := c.selfWrites[path]
+		if !ok {
+			return false
+		}
+		if item.pending {
+			return true
+		}
+		stamp, exists := statStamp(path)
+		return exists == item.exists && (!exists || stamp == item.stamp)
+	}
+	out := paths[:0:0]
+	for _, path := range paths {
+		own := false
+		for p := path; isWithinDir(p, c.root) && p != c.root; p = filepath.Dir(p) {
+			if matches(p) {
+				own = true
+				break
+			}
+		}
+		if !own {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+func isWithinDir(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func hasExtension(path string, exts []string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if ext == "" {
+		return false
+	}
+	for _, item := range exts {
+		if strings.TrimPrefix(strings.ToLower(item), ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWatcher follows the library through fsnotify. Directories are
+// watched one by one, so new directories are added as they appear.
+type notifyWatcher struct {
+	root string
+	w    *fsnotify.Watcher
+}
+
+func newNotifyWatcher(root string) (*notifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	n := &notifyWatcher{root: root, w: w}
+	if err := n.addTree(root, nil); err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// addTree watches dir and its visible sub directories. Files found on the way
+// are reported to changed when it is set, which covers a directory that was
+// moved into the library as a whole.
+func (n *notifyWatcher) addTree(dir string, changed chan<- string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if skipWatchPath(n.root, path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if changed != nil {
+				changed <- path
+			}
+			return nil
+		}
+		return n.w.Add(path)
+	})
+}
+
+func (n *notifyWatcher) run(ctx context.Context, changed chan<- string) error {
+	logger := logutil.GetLogger(ctx)
+	defer n.w.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-n.w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op == fsnotify.Chmod || skipWatchPath(n.root, ev.Name) {
+				continue
+			}
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					// The files inside are reported by addTree.
+					if err := n.addTree(ev.Name, changed); err != nil {
+						logger.Warn("watch new directory failed", zap.String("path", ev.Name), zap.Error(err))
+					}
+					continue
+				}
+			}
+			select {
+			case changed <- ev.Name:
+			case <-ctx.Done():
+				return nil
+			}
+		case err, ok := <-n.w.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Warn("fsnotify error", zap.Error(err))
+		}
+	}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime int64
+}
+
+// pollWatcher compares size and modification time of every visible file
+// once per interval, for file systems without change notifications.
+type pollWatcher struct {
+	root     string
+	interval time.Duration
+}
+
+func (p *pollWatcher) scan() (map[string]fileStamp, error) {
+	out := make(map[string]fileStamp)
+	err := filepath.WalkDir(p.root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if skipWatchPath(p.root, path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		out[path] = fileStamp{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		return nil
+	})
+	return out, err
+}
+
+// diffStamps lists the paths that were added, changed or removed between two
+// scans.
+func diffStamps(prev, next map[string]fileStamp) []string {
+	var out []string
+	for path, stamp := range next {
+		if old, ok := prev[path]; !ok || old != stamp {
+			out = append(out, path)
+		}
+	}
+	for path := range prev {
+		if _, ok := next[path]; !ok {
+			out = append(out, path)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (p *pollWatcher) run(ctx context.Context, changed chan<- string) error {
+	logger := logutil.GetLogger(ctx)
+	interval := p.interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	prev, err := p.scan()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		next, err := p.scan()
+		if err != nil {
+			logger.Warn("poll library failed", zap.Error(err))
+			continue
+		}
+		for _, path := range diffStamps(prev, next) {
+			select {
+			case changed <- path:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		prev = next
+	}
+}
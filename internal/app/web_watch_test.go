@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFileChangesReloadsOnlyAffectedMetadata(t *testing.T) {
+	root := t.TempDir()
+	arcadeMeta := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	nesMeta := filepath.Join(root, "nes", "metadata.pegasus.txt")
+	writeTestFile(t, arcadeMeta, "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\nx-index-id: 1\n")
+	writeTestFile(t, nesMeta, "collection: NES\nx-index-id: 1\n\ngame: Mario\nfile: mario.nes\nx-index-id: 1\n")
+
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	history, err := newWebHistory(context.Background(), root, defaultTrashKeep)
+	if err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store, history: history, events: newEventHub()}
+	ctx := context.Background()
+	if err := c.reloadCollections(ctx); err != nil {
+		t.Fatalf("load collections: %v", err)
+	}
+	before := c.findCollectionByPath(filepath.ToSlash(nesMeta))
+	if before == nil {
+		t.Fatalf("nes collection not loaded")
+	}
+	events, cancel := c.events.subscribe()
+	defer cancel()
+
+	writeTestFile(t, arcadeMeta, "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\nx-index-id: 1\n\ngame: Beta\nfile: beta.zip\nx-index-id: 2\n")
+	c.applyFileChanges(ctx, []string{arcadeMeta})
+
+	assert.Same(t, before, c.findCollectionByPath(filepath.ToSlash(nesMeta)), "untouched collection is kept")
+	arcade := c.findCollectionByPath(filepath.ToSlash(arcadeMeta))
+	if assert.NotNil(t, arcade) {
+		assert.Len(t, arcade.Games, 2)
+	}
+	evt := <-events
+	assert.Equal(t, eventCollectionsChanged, evt.Type)
+	changed := evt.Data.(*collectionsChangedEvent)
+	if assert.Len(t, changed.Collections, 1) {
+		assert.Equal(t, "Arcade", changed.Collections[0].Name)
+	}
+
+	// Removing the directory drops its collection.
+	if err := os.RemoveAll(filepath.Join(root, "nes")); err != nil {
+		t.Fatalf("remove nes: %v", err)
+	}
+	c.applyFileChanges(ctx, []string{filepath.Join(root, "nes")})
+	assert.Nil(t, c.findCollectionByPath(filepath.ToSlash(nesMeta)))
+	assert.Len(t, c.collectionsSnapshot(), 1)
+	evt = <-events
+	assert.Equal(t, []string{filepath.ToSlash(nesMeta)}, evt.Data.(*collectionsChangedEvent).Removed)
+}
+
+func TestPollWatcherDiff(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.zip"), "a")
+	writeTestFile(t, filepath.Join(root, "b.zip"), "b")
+	writeTestFile(t, filepath.Join(root, ".retrog", "backups", "x"), "x")
+
+	p := &pollWatcher{root: root}
+	prev, err := p.scan()
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	assert.Len(t, prev, 2, "hidden directories are skipped")
+
+	writeTestFile(t, filepath.Join(root, "a.zip"), "changed")
+	writeTestFile(t, filepath.Join(root, "c.zip"), "c")
+	if err := os.Remove(filepath.Join(root, "b.zip")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	next, err := p.scan()
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	assert.Equal(t, []string{
+		filepath.Join(root, "a.zip"),
+		filepath.Join(root, "b.zip"),
+		filepath.Join(root, "c.zip"),
+	}, diffStamps(prev, next))
+}
+
+func TestFinishChangePublishesAndSuppressesEcho(t *testing.T) {
+	root := t.TempDir()
+	metaPath := filepath.Join(root, "arcade", "metadata.pegasus.txt")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\ngame: Alpha\nfile: alpha.zip\nx-index-id: 1\n")
+	store, err := newAssetStore(root)
+	if err != nil {
+		t.Fatalf("new asset store: %v", err)
+	}
+	history, err := newWebHistory(context.Background(), root, defaultTrashKeep)
+	if err != nil {
+		t.Fatalf("new history: %v", err)
+	}
+	c := &WebCommand{root: root, assets: store, history: history, events: newEventHub()}
+	history.marker = c
+	ctx := context.Background()
+	if err := c.reloadCollections(ctx); err != nil {
+		t.Fatalf("load collections: %v", err)
+	}
+	events, cancel := c.events.subscribe()
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/games/update", nil)
+	req.Header.Set(eventClientHeader, "tab-1")
+	tx := c.history.begin("修改游戏")
+	if err := tx.snapshot(metaPath); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n\ngame: Alpha 2\nfile: alpha.zip\nx-index-id: 1\n")
+	// The watcher may fire while the handler is still reloading.
+	assert.Empty(t, c.dropSelfWrites([]string{metaPath}), "write in progress")
+	if err := c.reloadCollections(ctx); err != nil {
+		t.Fatalf("reload collections: %v", err)
+	}
+	c.finishChange(req, tx)
+
+	evt := <-events
+	changed := evt.Data.(*collectionsChangedEvent)
+	assert.Equal(t, eventSourceWeb, changed.Source)
+	assert.Equal(t, "tab-1", changed.Client)
+	assert.Equal(t, "修改游戏", changed.Label)
+	if assert.Len(t, changed.Collections, 1) {
+		assert.Equal(t, "Alpha 2", changed.Collections[0].Games[0].Title)
+	}
+
+	// The watcher ignores the write it was already told about, but not a
+	// later external edit.
+	assert.Empty(t, c.dropSelfWrites([]string{metaPath}))
+	if _, err := c.history.undoLast(); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	assert.Empty(t, c.dropSelfWrites([]string{metaPath}), "undo is an own write too")
+	writeTestFile(t, metaPath, "collection: Arcade\nx-index-id: 1\n")
+	assert.Equal(t, []string{metaPath}, c.dropSelfWrites([]string{metaPath}))
+}
@@ -2,10 +2,14 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"os"
 
 	"github.com/xxxsen/retrog/internal/app"
+	"github.com/xxxsen/retrog/internal/config"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/xxxsen/common/logutil"
 	"go.uber.org/zap"
 )
@@ -15,6 +19,11 @@ var rootCmd = &cobra.Command{
 	Short: "Migrate Pegasus ROMs to S3 and manage metadata",
 }
 
+var (
+	configPath  string
+	profileName string
+)
+
 // Execute runs the CLI.
 func Execute() error {
 	if err := rootCmd.Execute(); err != nil {
@@ -24,7 +33,71 @@ func Execute() error {
 	return nil
 }
 
+// applyConfig fills the flags of cmd that were not given on the command
+// line from the environment and the config file.
+func applyConfig(ctx context.Context, cmd *cobra.Command) error {
+	explicit := configPath
+	if explicit == "" {
+		explicit = os.Getenv(config.EnvName("config"))
+	}
+	profile := profileName
+	if profile == "" {
+		profile = os.Getenv(config.EnvName("profile"))
+	}
+	dir := os.Getenv(config.EnvName("dir"))
+	if f := cmd.Flags().Lookup("dir"); f != nil && f.Changed {
+		dir = f.Value.String()
+	}
+	path, err := config.Find(explicit, dir)
+	if err != nil {
+		return err
+	}
+	values := map[string][]string{}
+	if path != "" {
+		file, err := config.Load(path)
+		if err != nil {
+			return err
+		}
+		if values, err = file.Resolve(profile, cmd.Name()); err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+		if err := checkConfigKeys(file, values); err != nil {
+			return fmt.Errorf("config %s: %w", path, err)
+		}
+		logutil.GetLogger(ctx).Info("config loaded", zap.String("path", path), zap.String("profile", profile))
+	} else if profile != "" {
+		return fmt.Errorf("profile %s requires a config file", profile)
+	}
+	return config.Apply(cmd.LocalNonPersistentFlags(), values, os.Getenv)
+}
+
+// checkConfigKeys rejects settings and command sections no command knows,
+// which are most likely typos.
+func checkConfigKeys(file *config.File, values map[string][]string) error {
+	commands := make(map[string]struct{})
+	flags := make(map[string]struct{})
+	for _, cmd := range rootCmd.Commands() {
+		commands[cmd.Name()] = struct{}{}
+		cmd.LocalNonPersistentFlags().VisitAll(func(f *pflag.Flag) {
+			flags[f.Name] = struct{}{}
+		})
+	}
+	for _, name := range file.CommandNames() {
+		if _, ok := commands[name]; !ok {
+			return fmt.Errorf("unknown command %q", name)
+		}
+	}
+	for key := range values {
+		if _, ok := flags[key]; !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "配置文件，默认依次查找 <dir>/retrog.yaml、./retrog.yaml 与用户配置目录下的 retrog/retrog.yaml")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "配置文件中使用的 profile，默认使用配置文件的 profile 字段")
 	for _, r := range app.RunnerList() {
 		rinst := app.MustResolveRunner(r)
 		runner := rinst
@@ -33,6 +106,9 @@ func init() {
 			Short: runner.Desc(),
 			RunE: func(cmd *cobra.Command, args []string) error {
 				ctx := context.Background()
+				if err := applyConfig(ctx, cmd); err != nil {
+					return err
+				}
 				if err := runner.PreRun(ctx); err != nil {
 					return err
 				}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FileName is the name of the config file looked up in the ROM root,
+	// the working directory and the user config directory.
+	FileName = "retrog.yaml"
+	// EnvPrefix prefixes the environment variables that override settings:
+	// --media-layout is read from RETROG_MEDIA_LAYOUT.
+	EnvPrefix = "RETROG_"
+)
+
+// Settings maps flag names, without the leading dashes, to values. A value
+// is a scalar, a list for repeatable flags, or a map written as key=value
+// items, so media-limit: {boxfront: 8m} reads as --media-limit=boxfront=8m.
+type Settings map[string]interface{}
+
+// Section holds settings for every command plus per-command overrides.
+type Section struct {
+	Commands map[string]Settings `yaml:"commands"`
+	Settings Settings            `yaml:",inline"`
+}
+
+// File is the content of retrog.yaml:
+//
+//	profile: home            # profile used without --profile
+//	cache-dir: /var/cache/retrog
+//	commands:
+//	  web:
+//	    bind: 0.0.0.0:8080
+//	profiles:
+//	  home:
+//	    dir: /data/roms
+//	    media-layout: "{asset}/{rom}"
+//	  handheld:
+//	    dir: /mnt/sdcard/roms
+//	    commands:
+//	      web:
+//	        readonly: true
+type File struct {
+	Profile  string              `yaml:"profile"`
+	Profiles map[string]*Section `yaml:"profiles"`
+	Commands map[string]Settings `yaml:"commands"`
+	Settings Settings            `yaml:",inline"`
+}
+
+// Load reads a config file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config %s: %w", path, err)
+	}
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("decode config %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Find returns the config file to use: explicit when set, otherwise the
+// first of <dir>/retrog.yaml, ./retrog.yaml and
+// $XDG_CONFIG_HOME/retrog/retrog.yaml that exists. It returns "" when there
+// is none.
+func Find(explicit, dir string) (string, error) {
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", fmt.Errorf("config %s: %w", explicit, err)
+		}
+		return explicit, nil
+	}
+	var candidates []string
+	if dir != "" {
+		candidates = append(candidates, filepath.Join(dir, FileName))
+	}
+	candidates = append(candidates, FileName)
+	if base, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(base, "retrog", FileName))
+	}
+	for _, p := range candidates {
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, nil
+		}
+	}
+	return "", nil
+}
+
+// Resolve flattens the settings of command under profile, later ones
+// winning: top level, top-level commands, profile, profile commands. An
+// empty profile selects the default one of the file, if any.
+func (f *File) Resolve(profile, command string) (map[string][]string, error) {
+	if profile == "" {
+		profile = f.Profile
+	}
+	sections := []Settings{f.Settings, f.Commands[command]}
+	if profile != "" {
+		p, ok := f.Profiles[profile]
+		if !ok || p == nil {
+			return nil, fmt.Errorf("unknown config profile %q", profile)
+		}
+		sections = append(sections, p.Settings, p.Commands[command])
+	}
+	out := make(map[string][]string)
+	for _, s := range sections {
+		for key, value := range s {
+			values, err := settingValues(value)
+			if err != nil {
+				return nil, fmt.Errorf("setting %s: %w", key, err)
+			}
+			out[key] = values
+		}
+	}
+	return out, nil
+}
+
+// CommandNames lists the commands the file has a section for.
+func (f *File) CommandNames() []string {
+	seen := make(map[string]struct{})
+	for name := range f.Commands {
+		seen[name] = struct{}{}
+	}
+	for _, p := range f.Profiles {
+		if p == nil {
+			continue
+		}
+		for name := range p.Commands {
+			seen[name] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func settingValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return []string{""}, nil
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out := make([]string, 0, len(v))
+		for _, key := range keys {
+			s, err := scalarValue(v[key])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, key+"="+s)
+		}
+		return out, nil
+	default:
+		s, err := scalarValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+}
+
+func scalarValue(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", errors.New("nested lists and maps are not supported")
+	case nil:
+		return "", nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// EnvName is the environment variable that overrides flag name.
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Apply sets the flags of fs that were not given on the command line, from
+// the environment first and values second. An environment variable holds
+// a single value, also for repeatable flags.
+func Apply(fs *pflag.FlagSet, values map[string][]string, getenv func(string) string) error {
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		vals, ok := values[f.Name]
+		if env := getenv(EnvName(f.Name)); env != "" {
+			vals, ok = []string{env}, true
+		}
+		if !ok {
+			return
+		}
+		if setErr := setFlag(f, vals); setErr != nil {
+			err = fmt.Errorf("invalid value for --%s: %w", f.Name, setErr)
+		}
+	})
+	return err
+}
+
+func setFlag(f *pflag.Flag, values []string) error {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return sv.Replace(values)
+	}
+	if len(values) != 1 {
+		return fmt.Errorf("want a single value, got %d", len(values))
+	}
+	return f.Value.Set(values[0])
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleConfig = `profile: home
+cache-dir: /var/cache/retrog
+media-layout: media/{rom}/{asset}
+commands:
+  web:
+    bind: 0.0.0.0:8080
+profiles:
+  home:
+    dir: /data/roms
+    media-limit:
+      boxfront: 8m
+      logo: 2m:1024x1024
+  handheld:
+    dir: /mnt/sdcard/roms
+    media-layout: "{asset}/{rom}"
+    commands:
+      web:
+        readonly: true
+        scraper-arg: [dir=/a, dir=/b]
+`
+
+func writeConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, FileName)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return p
+}
+
+func TestResolve(t *testing.T) {
+	f, err := Load(writeConfig(t, t.TempDir(), sampleConfig))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	assert.Equal(t, []string{"web"}, f.CommandNames())
+
+	values, err := f.Resolve("", "web")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	assert.Equal(t, map[string][]string{
+		"cache-dir":    {"/var/cache/retrog"},
+		"media-layout": {"media/{rom}/{asset}"},
+		"bind":         {"0.0.0.0:8080"},
+		"dir":          {"/data/roms"},
+		"media-limit":  {"boxfront=8m", "logo=2m:1024x1024"},
+	}, values, "default profile")
+
+	values, err = f.Resolve("handheld", "web")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	assert.Equal(t, []string{"/mnt/sdcard/roms"}, values["dir"])
+	assert.Equal(t, []string{"{asset}/{rom}"}, values["media-layout"])
+	assert.Equal(t, []string{"true"}, values["readonly"])
+	assert.Equal(t, []string{"dir=/a", "dir=/b"}, values["scraper-arg"])
+
+	values, err = f.Resolve("handheld", "scrape")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	assert.NotContains(t, values, "bind", "web only")
+	assert.NotContains(t, values, "readonly", "web only")
+
+	_, err = f.Resolve("missing", "web")
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("chdir: %v", err)
+	}
+
+	p, err := Find("", root)
+	assert.NoError(t, err)
+	assert.Equal(t, "", p, "no config anywhere")
+
+	userDir, _ := os.UserConfigDir()
+	if err := os.MkdirAll(filepath.Join(userDir, "retrog"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	user := writeConfig(t, filepath.Join(userDir, "retrog"), "")
+	p, _ = Find("", root)
+	assert.Equal(t, user, p)
+
+	writeConfig(t, ".", "")
+	p, _ = Find("", root)
+	assert.Equal(t, FileName, p)
+
+	inRoot := writeConfig(t, root, "")
+	p, _ = Find("", root)
+	assert.Equal(t, inRoot, p)
+
+	p, _ = Find(user, root)
+	assert.Equal(t, user, p)
+	_, err = Find(filepath.Join(root, "missing.yaml"), root)
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	fs := pflag.NewFlagSet("web", pflag.ContinueOnError)
+	bind := fs.String("bind", ":8080", "")
+	dir := fs.String("dir", "", "")
+	readonly := fs.Bool("readonly", false, "")
+	rate := fs.Duration("scrape-rate", time.Second, "")
+	limits := fs.StringArray("media-limit", []string{"boxfront=1m"}, "")
+	layout := fs.String("media-layout", "media/{rom}/{asset}", "")
+	if err := fs.Parse([]string{"--dir=/cli"}); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	env := map[string]string{"RETROG_BIND": "127.0.0.1:9000"}
+	err := Apply(fs, map[string][]string{
+		"bind":        {"0.0.0.0:8080"},
+		"dir":         {"/config"},
+		"readonly":    {"true"},
+		"scrape-rate": {"2s"},
+		"media-limit": {"boxfront=8m", "logo=2m"},
+	}, func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	assert.Equal(t, "127.0.0.1:9000", *bind, "env over config")
+	assert.Equal(t, "/cli", *dir, "flags over everything")
+	assert.True(t, *readonly)
+	assert.Equal(t, 2*time.Second, *rate)
+	assert.Equal(t, []string{"boxfront=8m", "logo=2m"}, *limits, "config replaces the default list")
+	assert.Equal(t, "media/{rom}/{asset}", *layout, "default kept")
+
+	err = Apply(fs, map[string][]string{"readonly": {"maybe"}}, func(string) string { return "" })
+	assert.Error(t, err)
+	err = Apply(fs, map[string][]string{"media-layout": {"a", "b"}}, func(string) string { return "" })
+	assert.Error(t, err)
+}
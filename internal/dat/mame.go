@@ -34,14 +34,31 @@ func (p MameParser) Parse(r io.Reader) (*MameDataFile, error) {
 	if err := decoder.Decode(&df); err != nil {
 		return nil, fmt.Errorf("decode mame dat: %w", err)
 	}
+	if df.XMLName.Local != "datafile" && df.XMLName.Local != "mame" {
+		return nil, fmt.Errorf("decode mame dat: unexpected root element <%s>", df.XMLName.Local)
+	}
+	df.Machines = append(df.Machines, df.Games...)
+	df.Games = nil
 	return &df, nil
 }
 
-// MameDataFile is the root node of a MAME DAT file.
+// MameDataFile is the root node of a MAME DAT file: a <datafile>, or the
+// <mame> output of -listxml. Releases before 0.162 name machines <game>;
+// Parse moves them to Machines.
 type MameDataFile struct {
-	XMLName  xml.Name      `xml:"datafile"`
+	XMLName  xml.Name
+	Build    string        `xml:"build,attr,omitempty"`
 	Header   MameHeader    `xml:"header"`
 	Machines []MameMachine `xml:"machine"`
+	Games    []MameMachine `xml:"game"`
+}
+
+// Version returns the header version, or the build of -listxml output.
+func (df *MameDataFile) Version() string {
+	if df.Header.Version != "" {
+		return df.Header.Version
+	}
+	return df.Build
 }
 
 // MameHeader carries top-level metadata for the DAT.
@@ -78,3 +78,26 @@ func TestMameParserParse(t *testing.T) {
 		t.Fatalf("unexpected software list: %+v", machine.SoftwareList)
 	}
 }
+
+func TestMameParserParseListXML(t *testing.T) {
+	const listxml = `<?xml version="1.0"?>
+<mame build="0.78 (mame2003)">
+  <game name="sf2" romof="sf2">
+    <rom name="sf2e.30g" size="131072" crc="fe39ee33"/>
+  </game>
+</mame>`
+	df, err := NewMameParser().Parse(strings.NewReader(listxml))
+	if err != nil {
+		t.Fatalf("expected parser to succeed, got error: %v", err)
+	}
+	if df.Version() != "0.78 (mame2003)" {
+		t.Fatalf("unexpected version %q", df.Version())
+	}
+	if machine := df.FindMachine("sf2"); machine == nil || len(machine.Roms) != 1 {
+		t.Fatalf("expected <game> entries as machines, got %+v", df.Machines)
+	}
+
+	if _, err := NewMameParser().Parse(strings.NewReader(`<softwarelist name="nes"/>`)); err == nil {
+		t.Fatalf("expected unknown root element to fail")
+	}
+}
@@ -0,0 +1,119 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// Issue codes reported by Check.
+const (
+	IssueCorrupt            = "corrupt"
+	IssueUnknownFormat      = "unknown_format"
+	IssueMislabelled        = "mislabelled"
+	IssueWrongKind          = "wrong_kind"
+	IssueFileTooLarge       = "file_too_large"
+	IssueDimensionsTooLarge = "dimensions_too_large"
+)
+
+// Issue is one problem found with a media file.
+type Issue struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string { return i.Message }
+
+// Info describes a media file by its content.
+type Info struct {
+	Path   string
+	Format string
+	Kind   Kind
+	Size   int64
+	Width  int
+	Height int
+}
+
+// Inspect sniffs the format of path and, for images, reads their
+// dimensions. A header that cannot be decoded yields an IssueCorrupt.
+func Inspect(path string) (*Info, []Issue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	format, err := Sniff(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &Info{Path: path, Format: format, Kind: KindOf(format), Size: stat.Size()}
+	if info.Kind != KindImage {
+		return info, nil, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return info, []Issue{{Code: IssueCorrupt, Message: fmt.Sprintf("%s image cannot be decoded", format)}}, nil
+	}
+	info.Width, info.Height = cfg.Width, cfg.Height
+	return info, nil, nil
+}
+
+// Check inspects path and reports where it breaks rule: undecodable
+// content, a format the extension does not match, the wrong kind of media
+// for the field, or limits exceeded.
+func Check(path string, rule Rule) (*Info, []Issue, error) {
+	info, issues, err := Inspect(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	claimed := FormatOfExt(path)
+	switch {
+	case info.Format == FormatHTML || info.Format == FormatText:
+		issues = append(issues, Issue{Code: IssueUnknownFormat, Message: fmt.Sprintf("file is %s, not media", info.Format)})
+	case info.Format == FormatUnknown:
+		if claimed != "" && formats[claimed].kind != KindOther {
+			issues = append(issues, Issue{Code: IssueCorrupt, Message: fmt.Sprintf("content is not a valid %s file", claimed)})
+		}
+	case claimed != info.Format:
+		issues = append(issues, Issue{Code: IssueMislabelled, Message: fmt.Sprintf("%s file named %s", info.Format, filepath.Ext(path))})
+	}
+	if rule.Kind != KindAny && info.Format != FormatUnknown && info.Kind != rule.Kind {
+		issues = append(issues, Issue{Code: IssueWrongKind, Message: fmt.Sprintf("expected %s, got %s", rule.Kind, info.Format)})
+	}
+	if rule.MaxBytes > 0 && info.Size > rule.MaxBytes {
+		issues = append(issues, Issue{Code: IssueFileTooLarge, Message: fmt.Sprintf("%s exceeds %s", FormatSize(info.Size), FormatSize(rule.MaxBytes))})
+	}
+	if exceedsDimensions(info, rule) {
+		issues = append(issues, Issue{Code: IssueDimensionsTooLarge, Message: fmt.Sprintf("%dx%d exceeds %dx%d", info.Width, info.Height, rule.MaxWidth, rule.MaxHeight)})
+	}
+	return info, issues, nil
+}
+
+func exceedsDimensions(info *Info, rule Rule) bool {
+	return (rule.MaxWidth > 0 && info.Width > rule.MaxWidth) || (rule.MaxHeight > 0 && info.Height > rule.MaxHeight)
+}
+
+// HasIssue reports whether issues contains code.
+func HasIssue(issues []Issue, code string) bool {
+	for _, issue := range issues {
+		if issue.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Convert selects how Normalize re-encodes images.
+type Convert string
+
+const (
+	// ConvertOff never re-encodes; files over the limits are rejected.
+	ConvertOff Convert = "off"
+	// ConvertAuto re-encodes only images over the limits, keeping JPEGs
+	// and opaque images as JPEG and everything else as PNG.
+	ConvertAuto Convert = "auto"
+	// ConvertPNG and ConvertJPEG re-encode every image to that format.
+	ConvertPNG  Convert = "png"
+	ConvertJPEG Convert = "jpeg"
+)
+
+const (
+	jpegQuality = 90
+	// maxDecodePixels keeps Normalize from decoding images that would need
+	// an unreasonable amount of memory.
+	maxDecodePixels = 100 << 20
+)
+
+// ParseConvert validates a conversion mode; "" means ConvertOff.
+func ParseConvert(raw string) (Convert, error) {
+	switch mode := Convert(strings.ToLower(strings.TrimSpace(raw))); mode {
+	case "":
+		return ConvertOff, nil
+	case ConvertOff, ConvertAuto, ConvertPNG, ConvertJPEG:
+		return mode, nil
+	case "jpg":
+		return ConvertJPEG, nil
+	default:
+		return "", fmt.Errorf("invalid media conversion %q, want off, auto, png or jpeg", raw)
+	}
+}
+
+// Normalize fixes what it can of path for rule: a mislabelled file gets the
+// extension of its real format, and depending on mode images are scaled
+// down to the rule's dimensions and re-encoded. It returns the path the file
+// ends up at, which differs from path when the extension changed, and the
+// issues left unresolved. An existing file is never overwritten: when the
+// new name is taken a numbered one such as "box-2.png" is used instead.
+func Normalize(path string, rule Rule, mode Convert) (string, []Issue, error) {
+	info, issues, err := Check(path, rule)
+	if err != nil {
+		return "", nil, err
+	}
+	if HasIssue(issues, IssueCorrupt) || HasIssue(issues, IssueUnknownFormat) || HasIssue(issues, IssueWrongKind) {
+		return path, issues, nil
+	}
+	if target := convertTarget(info, issues, mode); target != "" {
+		dest, err := reencode(info, rule, target)
+		if err != nil {
+			return "", nil, err
+		}
+		path = dest
+	} else if HasIssue(issues, IssueMislabelled) {
+		dest, err := freeName(path, replaceExt(path, Ext(info.Format)))
+		if err != nil {
+			return "", nil, err
+		}
+		if err := os.Rename(path, dest); err != nil {
+			return "", nil, err
+		}
+		path = dest
+	}
+	_, issues, err = Check(path, rule)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, issues, nil
+}
+
+// convertTarget picks the format to re-encode to, "auto" to choose one
+// from the decoded image, or "" to leave the content alone.
+func convertTarget(info *Info, issues []Issue, mode Convert) string {
+	if info.Kind != KindImage || info.Width*info.Height > maxDecodePixels {
+		return ""
+	}
+	overLimit := HasIssue(issues, IssueFileTooLarge) || HasIssue(issues, IssueDimensionsTooLarge)
+	switch mode {
+	case ConvertPNG:
+		if info.Format == FormatPNG && !overLimit {
+			return ""
+		}
+		return FormatPNG
+	case ConvertJPEG:
+		if info.Format == FormatJPEG && !overLimit {
+			return ""
+		}
+		return FormatJPEG
+	case ConvertAuto:
+		// Re-encoding a GIF would drop its animation.
+		if info.Format == FormatGIF {
+			return ""
+		}
+		if !overLimit {
+			return ""
+		}
+		return string(ConvertAuto)
+	}
+	return ""
+}
+
+func reencode(info *Info, rule Rule, target string) (string, error) {
+	f, err := os.Open(info.Path)
+	if err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if target == string(ConvertAuto) {
+		target = FormatPNG
+		if info.Format == FormatJPEG || Opaque(img) {
+			target = FormatJPEG
+		}
+	}
+	bounds := img.Bounds()
+	if width, height := fit(bounds.Dx(), bounds.Dy(), rule.MaxWidth, rule.MaxHeight); width != bounds.Dx() || height != bounds.Dy() {
+		img = Scale(img, width, height)
+	}
+	dest, err := freeName(info.Path, replaceExt(info.Path, Ext(target)))
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".media-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = Encode(tmp, img, target)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", err
+	}
+	if dest != info.Path {
+		if err := os.Remove(info.Path); err != nil {
+			return "", err
+		}
+	}
+	return dest, nil
+}
+
+// fit scales width x height down to fit within maxWidth x maxHeight,
+// keeping the aspect ratio. Zero limits are ignored.
+func fit(width, height, maxWidth, maxHeight int) (int, int) {
+	if maxWidth > 0 && width > maxWidth {
+		height = max(1, height*maxWidth/width)
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width = max(1, width*maxHeight/height)
+		height = maxHeight
+	}
+	return width, height
+}
+
+// Scale resizes img to width x height.
+func Scale(img image.Image, width, height int) image.Image {
+	var dst draw.Image
+	if Opaque(img) {
+		dst = image.NewRGBA(image.Rect(0, 0, width, height))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, width, height))
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Opaque reports whether img is known to have no transparent pixels.
+func Opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// Encode writes img as PNG or JPEG.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("cannot encode %s", format)
+	}
+}
+
+// freeName returns dest, or when another file than path already exists
+// there, the first of dest with "-2", "-3"... before the extension that is
+// free.
+func freeName(path, dest string) (string, error) {
+	src, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	ext := filepath.Ext(dest)
+	stem := strings.TrimSuffix(dest, ext)
+	for n := 2; ; n++ {
+		info, err := os.Lstat(dest)
+		if errors.Is(err, fs.ErrNotExist) {
+			return dest, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		// dest is path itself, maybe under another case of its name.
+		if os.SameFile(src, info) {
+			return dest, nil
+		}
+		dest = fmt.Sprintf("%s-%d%s", stem, n, ext)
+	}
+}
+
+func replaceExt(path, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
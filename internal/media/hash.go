@@ -0,0 +1,151 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Fingerprint identifies an image exactly, by content hash, and
+// approximately, by perceptual hashes that survive resizing and
+// re-encoding.
+type Fingerprint struct {
+	SHA256 string
+	DHash  uint64
+	PHash  uint64
+}
+
+// FingerprintFile hashes the file at path. Perceptual hashes are only
+// computed for decodable images; for anything else they are zero and ok is
+// false.
+func FingerprintFile(path string) (fp *Fingerprint, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, false, err
+	}
+	fp = &Fingerprint{SHA256: hex.EncodeToString(h.Sum(nil))}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width*cfg.Height > maxDecodePixels {
+		return fp, false, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fp, false, nil
+	}
+	fp.DHash = DHash(img)
+	fp.PHash = PHash(img)
+	return fp, true, nil
+}
+
+// Distance is the number of differing bits between two perceptual hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// DHash is a difference hash: each bit tells whether a pixel of a 9x8
+// grayscale thumbnail is brighter than its right neighbour.
+func DHash(img image.Image) uint64 {
+	gray := grayscale(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// PHash is a DCT hash: each bit tells whether one of the 64 lowest
+// frequencies of a 32x32 grayscale thumbnail is above their median.
+func PHash(img image.Image) uint64 {
+	const size, low = 32, 8
+	gray := grayscale(img, size, size)
+	pixels := make([][]float64, size)
+	for y := range pixels {
+		pixels[y] = make([]float64, size)
+		for x := range pixels[y] {
+			pixels[y][x] = float64(gray.GrayAt(x, y).Y)
+		}
+	}
+	coeffs := dct2D(pixels, low)
+	values := make([]float64, 0, low*low)
+	for y := 0; y < low; y++ {
+		values = append(values, coeffs[y]...)
+	}
+	// The DC term is the average brightness and would skew the median.
+	sorted := append([]float64(nil), values[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for _, v := range values {
+		hash <<= 1
+		if v > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+func grayscale(img image.Image, width, height int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// dct2D returns the top-left low x low coefficients of the type-II DCT of
+// the square matrix in.
+func dct2D(in [][]float64, low int) [][]float64 {
+	n := len(in)
+	cos := make([][]float64, low)
+	for u := range cos {
+		cos[u] = make([]float64, n)
+		for x := range cos[u] {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+		}
+	}
+	// Transform the rows first, keeping only the low frequencies.
+	rows := make([][]float64, n)
+	for y := range rows {
+		rows[y] = make([]float64, low)
+		for u := 0; u < low; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += in[y][x] * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	out := make([][]float64, low)
+	for v := range out {
+		out[v] = make([]float64, low)
+		for u := 0; u < low; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			out[v][u] = sum
+		}
+	}
+	return out
+}
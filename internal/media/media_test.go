@@ -0,0 +1,202 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeTestImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	return buf.Bytes()
+}
+
+func writeFile(t *testing.T, path string, data []byte) string {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func issueCodes(issues []Issue) []string {
+	var out []string
+	for _, issue := range issues {
+		out = append(out, issue.Code)
+	}
+	return out
+}
+
+func TestSniff(t *testing.T) {
+	cases := map[string][]byte{
+		FormatPNG:  encodeTestImage(t, FormatPNG, 4, 4),
+		FormatJPEG: encodeTestImage(t, FormatJPEG, 4, 4),
+		FormatGIF:  []byte("GIF89a\x01\x00\x01\x00"),
+		FormatWebP: []byte("RIFF\x00\x00\x00\x00WEBPVP8 "),
+		FormatMP4:  []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"),
+		FormatMOV:  []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"),
+		FormatWebM: []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"),
+		FormatHTML: []byte("<!DOCTYPE html><html><body>not found</body></html>"),
+	}
+	for want, data := range cases {
+		got, err := Sniff(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	art := Rule{Kind: KindImage, MaxBytes: 1 << 20, MaxWidth: 64, MaxHeight: 64}
+
+	_, issues, err := Check(writeFile(t, filepath.Join(dir, "ok.png"), encodeTestImage(t, FormatPNG, 32, 16)), art)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+
+	info, issues, err := Check(writeFile(t, filepath.Join(dir, "photo.png"), encodeTestImage(t, FormatJPEG, 32, 16)), art)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJPEG, info.Format)
+	assert.Equal(t, []string{IssueMislabelled}, issueCodes(issues))
+
+	_, issues, _ = Check(writeFile(t, filepath.Join(dir, "page.png"), []byte("<html><body>404</body></html>")), art)
+	assert.Contains(t, issueCodes(issues), IssueUnknownFormat)
+
+	_, issues, _ = Check(writeFile(t, filepath.Join(dir, "broken.png"), []byte("\x89PNG\r\n\x1a\ngarbage")), art)
+	assert.Equal(t, []string{IssueCorrupt}, issueCodes(issues))
+
+	_, issues, _ = Check(writeFile(t, filepath.Join(dir, "big.png"), encodeTestImage(t, FormatPNG, 128, 32)), art)
+	assert.Equal(t, []string{IssueDimensionsTooLarge}, issueCodes(issues))
+
+	_, issues, _ = Check(writeFile(t, filepath.Join(dir, "clip.mp4"), []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00")), art)
+	assert.Equal(t, []string{IssueWrongKind}, issueCodes(issues))
+
+	_, issues, _ = Check(filepath.Join(dir, "ok.png"), Rule{MaxBytes: 10})
+	assert.Equal(t, []string{IssueFileTooLarge}, issueCodes(issues))
+}
+
+func TestNormalize(t *testing.T) {
+	dir := t.TempDir()
+	art := Rule{Kind: KindImage, MaxWidth: 64, MaxHeight: 64}
+
+	path, issues, err := Normalize(writeFile(t, filepath.Join(dir, "photo.png"), encodeTestImage(t, FormatJPEG, 32, 16)), art, ConvertOff)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Equal(t, filepath.Join(dir, "photo.jpg"), path)
+	assert.NoFileExists(t, filepath.Join(dir, "photo.png"))
+
+	big := writeFile(t, filepath.Join(dir, "big.png"), encodeTestImage(t, FormatPNG, 256, 128))
+	_, issues, err = Normalize(big, art, ConvertOff)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{IssueDimensionsTooLarge}, issueCodes(issues))
+
+	path, issues, err = Normalize(big, art, ConvertAuto)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	// The test image is opaque, so auto mode picks JPEG.
+	assert.Equal(t, filepath.Join(dir, "big.jpg"), path)
+	info, _, err := Inspect(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, info.Width)
+	assert.Equal(t, 32, info.Height)
+
+	path, issues, err = Normalize(path, art, ConvertPNG)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Equal(t, filepath.Join(dir, "big.png"), path)
+
+	taken := writeFile(t, filepath.Join(dir, "taken.jpg"), []byte("keep"))
+	path, issues, err = Normalize(writeFile(t, filepath.Join(dir, "taken.png"), encodeTestImage(t, FormatJPEG, 8, 8)), art, ConvertOff)
+	assert.NoError(t, err)
+	assert.Empty(t, issues)
+	assert.Equal(t, filepath.Join(dir, "taken-2.jpg"), path)
+	data, _ := os.ReadFile(taken)
+	assert.Equal(t, "keep", string(data))
+
+	path, _, err = Normalize(writeFile(t, filepath.Join(dir, "taken.gif"), encodeTestImage(t, FormatPNG, 256, 128)), art, ConvertJPEG)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "taken-3.jpg"), path)
+	data, _ = os.ReadFile(taken)
+	assert.Equal(t, "keep", string(data))
+}
+
+func TestRules(t *testing.T) {
+	rules := DefaultRules()
+	assert.Equal(t, KindImage, rules.For("assets.boxFront").Kind)
+	assert.Equal(t, KindVideo, rules.For("assets.video").Kind)
+	assert.Equal(t, KindAny, rules.For("assets.manual").Kind)
+
+	assert.NoError(t, rules.Set("boxfront=8m:2048x1024"))
+	got := rules.For("assets.boxfront")
+	assert.Equal(t, Rule{Kind: KindImage, MaxBytes: 8 << 20, MaxWidth: 2048, MaxHeight: 1024}, got)
+	assert.NoError(t, rules.Set("assets.video=1GiB"))
+	assert.Equal(t, int64(1<<30), rules.For("assets.video").MaxBytes)
+	assert.NoError(t, rules.Set("logo=:0x0"))
+	assert.Equal(t, 0, rules.For("assets.logo").MaxWidth)
+
+	assert.Error(t, rules.Set("boxfront"))
+	assert.Error(t, rules.Set("boxfront=big"))
+	assert.Error(t, rules.Set("boxfront=1m:wide"))
+}
+
+func TestPerceptualHash(t *testing.T) {
+	pattern := func(width, height int, invert bool) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				// Diagonal bands give both hashes something to see.
+				v := uint8((x*255/width + (y*255/height)/2) % 256)
+				if (x*8/width+y*8/height)%2 == 0 {
+					v = 255 - v/2
+				}
+				if invert {
+					v = 255 - v
+				}
+				img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+			}
+		}
+		return img
+	}
+	original := pattern(400, 300, false)
+	resized := Scale(original, 120, 90)
+	other := pattern(400, 300, true)
+
+	assert.LessOrEqual(t, Distance(DHash(original), DHash(resized)), 6)
+	assert.LessOrEqual(t, Distance(PHash(original), PHash(resized)), 6)
+	assert.Greater(t, Distance(DHash(original), DHash(other)), 20)
+	assert.Greater(t, Distance(PHash(original), PHash(other)), 20)
+
+	dir := t.TempDir()
+	path := writeFile(t, filepath.Join(dir, "a.png"), encodeTestImage(t, FormatPNG, 64, 64))
+	fp, ok, err := FingerprintFile(path)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Len(t, fp.SHA256, 64)
+	fp, ok, err = FingerprintFile(writeFile(t, filepath.Join(dir, "a.txt"), []byte("text")))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NotEmpty(t, fp.SHA256)
+}
@@ -0,0 +1,121 @@
+package media
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rule limits what an asset field accepts. Zero limits are not checked.
+type Rule struct {
+	Kind      Kind
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Rules maps asset names, the part after "assets." in lower case, to their
+// rule. The "*" entry applies to names without a rule of their own.
+type Rules map[string]Rule
+
+const fallbackRule = "*"
+
+// DefaultRules returns the built-in limits: box art and screenshots are
+// images of at most 10 MiB and 4096px, logos and marquees are smaller, and
+// videos may be up to 200 MiB.
+func DefaultRules() Rules {
+	art := Rule{Kind: KindImage, MaxBytes: 10 << 20, MaxWidth: 4096, MaxHeight: 4096}
+	small := Rule{Kind: KindImage, MaxBytes: 5 << 20, MaxWidth: 2048, MaxHeight: 2048}
+	video := Rule{Kind: KindVideo, MaxBytes: 200 << 20}
+	rules := Rules{
+		fallbackRule: {MaxBytes: 200 << 20},
+		"video":      video,
+		"videos":     video,
+	}
+	for _, name := range []string{"boxfront", "boxback", "boxspine", "boxfull", "boxart", "boxside",
+		"cartridge", "cart", "disc", "bezel", "screenshot", "screenshots", "titlescreen", "background"} {
+		rules[name] = art
+	}
+	for _, name := range []string{"logo", "wheel", "marquee", "screenmarquee"} {
+		rules[name] = small
+	}
+	return rules
+}
+
+// For returns the rule of an asset field such as "assets.boxFront".
+func (r Rules) For(key string) Rule {
+	name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(key)), "assets.")
+	if rule, ok := r[name]; ok {
+		return rule
+	}
+	return r[fallbackRule]
+}
+
+// Set overrides one rule from a spec of the form
+// `name=<size>[:<width>x<height>]`, e.g. `boxfront=8m:2048x2048` or
+// `video=500m`. Either limit may be left empty, and a limit of 0 disables
+// it. The kind of an existing rule is kept.
+func (r Rules) Set(spec string) error {
+	name, value, ok := strings.Cut(spec, "=")
+	name = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "assets.")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid media limit %q, want name=size[:WxH]", spec)
+	}
+	rule := r.For(name)
+	size, dims, hasDims := strings.Cut(strings.TrimSpace(value), ":")
+	if size = strings.TrimSpace(size); size != "" {
+		n, err := ParseSize(size)
+		if err != nil {
+			return fmt.Errorf("invalid media limit %q: %w", spec, err)
+		}
+		rule.MaxBytes = n
+	}
+	if dims = strings.TrimSpace(dims); hasDims && dims != "" {
+		w, h, ok := strings.Cut(strings.ToLower(dims), "x")
+		width, werr := strconv.Atoi(strings.TrimSpace(w))
+		height, herr := strconv.Atoi(strings.TrimSpace(h))
+		if !ok || werr != nil || herr != nil || width < 0 || height < 0 {
+			return fmt.Errorf("invalid media limit %q: bad dimensions %q", spec, dims)
+		}
+		rule.MaxWidth, rule.MaxHeight = width, height
+	}
+	r[name] = rule
+	return nil
+}
+
+// ParseSize reads a byte count with an optional k, m or g suffix (base
+// 1024), e.g. "512k" or "8MB".
+func ParseSize(raw string) (int64, error) {
+	text := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw)), "b"), "i")
+	shift := 0
+	switch {
+	case strings.HasSuffix(text, "k"):
+		shift = 10
+	case strings.HasSuffix(text, "m"):
+		shift = 20
+	case strings.HasSuffix(text, "g"):
+		shift = 30
+	}
+	if shift > 0 {
+		text = text[:len(text)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", raw)
+	}
+	return n << shift, nil
+}
+
+// FormatSize renders n bytes for messages, e.g. "30.0 MiB".
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
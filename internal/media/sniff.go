@@ -0,0 +1,150 @@
+// Package media inspects, validates and normalises the box art, videos and
+// other files referenced by `assets.*` metadata fields.
+package media
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Kind groups formats by how they are displayed.
+type Kind string
+
+const (
+	KindAny   Kind = ""
+	KindImage Kind = "image"
+	KindVideo Kind = "video"
+	KindOther Kind = "other"
+)
+
+// Formats recognised by Sniff.
+const (
+	FormatPNG     = "png"
+	FormatJPEG    = "jpeg"
+	FormatGIF     = "gif"
+	FormatWebP    = "webp"
+	FormatBMP     = "bmp"
+	FormatMP4     = "mp4"
+	FormatMOV     = "mov"
+	FormatWebM    = "webm"
+	FormatMKV     = "mkv"
+	FormatAVI     = "avi"
+	FormatPDF     = "pdf"
+	FormatHTML    = "html"
+	FormatText    = "text"
+	FormatUnknown = "unknown"
+)
+
+type formatInfo struct {
+	kind Kind
+	exts []string
+}
+
+// formats lists the extensions each format may carry; the first one is used
+// when a mislabelled file is renamed.
+var formats = map[string]formatInfo{
+	FormatPNG:  {kind: KindImage, exts: []string{".png"}},
+	FormatJPEG: {kind: KindImage, exts: []string{".jpg", ".jpeg", ".jpe"}},
+	FormatGIF:  {kind: KindImage, exts: []string{".gif"}},
+	FormatWebP: {kind: KindImage, exts: []string{".webp"}},
+	FormatBMP:  {kind: KindImage, exts: []string{".bmp"}},
+	FormatMP4:  {kind: KindVideo, exts: []string{".mp4", ".m4v"}},
+	FormatMOV:  {kind: KindVideo, exts: []string{".mov"}},
+	FormatWebM: {kind: KindVideo, exts: []string{".webm"}},
+	FormatMKV:  {kind: KindVideo, exts: []string{".mkv"}},
+	FormatAVI:  {kind: KindVideo, exts: []string{".avi"}},
+	FormatPDF:  {kind: KindOther, exts: []string{".pdf"}},
+}
+
+// sniffLen is how much of a file Sniff looks at.
+const sniffLen = 512
+
+// Sniff identifies the format of r from its leading bytes, regardless of
+// the file name.
+func Sniff(r io.Reader) (string, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return sniffBytes(buf[:n]), nil
+}
+
+func sniffBytes(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(head, []byte{0xff, 0xd8, 0xff}):
+		return FormatJPEG
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return FormatGIF
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return FormatWebP
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("AVI ")):
+		return FormatAVI
+	case bytes.HasPrefix(head, []byte("BM")) && len(head) >= 26:
+		return FormatBMP
+	case len(head) >= 12 && bytes.Equal(head[4:8], []byte("ftyp")):
+		if bytes.Equal(head[8:12], []byte("qt  ")) {
+			return FormatMOV
+		}
+		return FormatMP4
+	case bytes.HasPrefix(head, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		if bytes.Contains(head, []byte("webm")) {
+			return FormatWebM
+		}
+		return FormatMKV
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return FormatPDF
+	}
+	ctype := http.DetectContentType(head)
+	switch {
+	case strings.HasPrefix(ctype, "text/html"), strings.HasPrefix(ctype, "text/xml"):
+		return FormatHTML
+	case strings.HasPrefix(ctype, "text/"):
+		return FormatText
+	}
+	return FormatUnknown
+}
+
+// KindOf returns the kind of a sniffed format; unrecognised formats are
+// KindOther.
+func KindOf(format string) Kind {
+	if info, ok := formats[format]; ok {
+		return info.kind
+	}
+	return KindOther
+}
+
+// KindOfExt guesses the kind of a file from its extension.
+func KindOfExt(path string) Kind {
+	if format := FormatOfExt(path); format != "" {
+		return formats[format].kind
+	}
+	return KindOther
+}
+
+// FormatOfExt returns the format a file name claims to be, or "" when the
+// extension is not one of the recognised media formats.
+func FormatOfExt(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for format, info := range formats {
+		for _, candidate := range info.exts {
+			if candidate == ext {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// Ext returns the preferred extension of format, or "" if it has none.
+func Ext(format string) string {
+	if info, ok := formats[format]; ok {
+		return info.exts[0]
+	}
+	return ""
+}
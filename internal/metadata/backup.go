@@ -0,0 +1,179 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupDirName is the directory, relative to a metadata file, holding
+	// its previous versions.
+	BackupDirName = ".retrog/backups"
+	// DefaultBackupKeep is the number of backups kept per metadata file.
+	DefaultBackupKeep = 10
+
+	backupTimeLayout = "20060102T150405.000000000"
+)
+
+// BackupKeep controls how many backups WriteMetadataFile keeps for every
+// file. Zero or a negative value disables backups.
+var BackupKeep = DefaultBackupKeep
+
+// Backup describes a saved version of a metadata file.
+type Backup struct {
+	Name string
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// WriteFileAtomic replaces path with data without ever exposing a partially
+// written file: data goes to a temporary file in the same directory which is
+// fsynced and then renamed over path.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file for %s: %w", path, err)
+	}
+	tmpName := tmp.Name()
+	cleanup := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("write temp file for %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return fmt.Errorf("chmod temp file for %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return fmt.Errorf("sync temp file for %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("close temp file for %s: %w", path, err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes the directory entry of a rename. Not every platform allows
+// syncing directories, so failures are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+func backupDir(path string) string {
+	return filepath.Join(filepath.Dir(path), filepath.FromSlash(BackupDirName))
+}
+
+// backupFile stores the current content of path before it is overwritten by
+// data and prunes old backups. Missing or unchanged files are not backed up.
+func backupFile(path string, data []byte) error {
+	if BackupKeep <= 0 {
+		return nil
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read metadata %s for backup: %w", path, err)
+	}
+	if bytes.Equal(current, data) {
+		return nil
+	}
+	dir := backupDir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("ensure backup dir %s: %w", dir, err)
+	}
+	name := filepath.Base(path) + "." + time.Now().UTC().Format(backupTimeLayout)
+	if err := WriteFileAtomic(filepath.Join(dir, name), current, 0o644); err != nil {
+		return err
+	}
+	return pruneBackups(path, BackupKeep)
+}
+
+func pruneBackups(path string, keep int) error {
+	backups, err := ListBackups(path)
+	if err != nil {
+		return err
+	}
+	for idx := keep; idx < len(backups); idx++ {
+		if err := os.Remove(backups[idx].Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove old backup %s: %w", backups[idx].Path, err)
+		}
+	}
+	return nil
+}
+
+// ListBackups returns the backups of the metadata file at path, newest first.
+func ListBackups(path string) ([]Backup, error) {
+	dir := backupDir(path)
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read backup dir %s: %w", dir, err)
+	}
+	prefix := filepath.Base(path) + "."
+	var out []Backup
+	for _, item := range items {
+		if item.IsDir() || !strings.HasPrefix(item.Name(), prefix) {
+			continue
+		}
+		ts, err := time.Parse(backupTimeLayout, strings.TrimPrefix(item.Name(), prefix))
+		if err != nil {
+			continue
+		}
+		info, err := item.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, Backup{
+			Name: item.Name(),
+			Path: filepath.Join(dir, item.Name()),
+			Time: ts,
+			Size: info.Size(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out, nil
+}
+
+// RestoreBackup replaces the metadata file at path with the named backup.
+// The content being replaced is itself backed up first, so a restore can be
+// undone by restoring again.
+func RestoreBackup(path, name string) error {
+	if name == "" || filepath.Base(name) != name {
+		return fmt.Errorf("invalid backup name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(backupDir(path), name))
+	if err != nil {
+		return fmt.Errorf("read backup %s: %w", name, err)
+	}
+	if err := backupFile(path, data); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0o644)
+}
@@ -0,0 +1,106 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetadataFileKeepsBackups(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metadata.pegasus.txt")
+	for _, title := range []string{"First", "Second", "Third"} {
+		doc := &Document{Blocks: []*Block{{Kind: KindGame, Entries: []*Entry{{Key: "game", Values: []string{title}, Inline: true}}}}}
+		if err := WriteMetadataFile(path, doc); err != nil {
+			t.Fatalf("write metadata: %v", err)
+		}
+	}
+	// Writing unchanged content does not add a backup.
+	doc, err := ParseMetadataFile(path)
+	if err != nil {
+		t.Fatalf("parse metadata: %v", err)
+	}
+	if err := WriteMetadataFile(path, doc); err != nil {
+		t.Fatalf("write metadata: %v", err)
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		t.Fatalf("list backups: %v", err)
+	}
+	if !assert.Len(t, backups, 2) {
+		return
+	}
+	assert.Equal(t, "game: Second\n", readFile(t, backups[0].Path))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), ".tmp-", "temp files must be cleaned up")
+	}
+
+	if err := RestoreBackup(path, backups[1].Name); err != nil {
+		t.Fatalf("restore backup: %v", err)
+	}
+	assert.Equal(t, "game: First\n", readFile(t, path))
+
+	backups, err = ListBackups(path)
+	if err != nil {
+		t.Fatalf("list backups: %v", err)
+	}
+	if assert.Len(t, backups, 3) {
+		assert.Equal(t, "game: Third\n", readFile(t, backups[0].Path), "restore backs up the replaced content")
+	}
+
+	assert.Error(t, RestoreBackup(path, "../metadata.pegasus.txt"))
+}
+
+func TestPruneBackups(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metadata.pegasus.txt")
+	backupPath := filepath.Join(dir, filepath.FromSlash(BackupDirName))
+	if err := os.MkdirAll(backupPath, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	names := []string{
+		"metadata.pegasus.txt.20240101T000000.000000000",
+		"metadata.pegasus.txt.20240102T000000.000000000",
+		"metadata.pegasus.txt.20240103T000000.000000000",
+		"other.txt.20240103T000000.000000000",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(backupPath, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write backup: %v", err)
+		}
+	}
+	if err := pruneBackups(path, 2); err != nil {
+		t.Fatalf("prune backups: %v", err)
+	}
+	backups, err := ListBackups(path)
+	if err != nil {
+		t.Fatalf("list backups: %v", err)
+	}
+	if assert.Len(t, backups, 2) {
+		assert.Equal(t, names[2], backups[0].Name)
+		assert.Equal(t, names[1], backups[1].Name)
+	}
+	_, err = os.Stat(filepath.Join(backupPath, names[3]))
+	assert.NoError(t, err)
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
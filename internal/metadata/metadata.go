@@ -228,17 +228,34 @@ func ParseMetadataFile(path string) (*Document, error) {
 }
 
 // WriteMetadataFile serialises a Document back to disk following the Pegasus format.
+// The file is replaced atomically and its previous content is kept under
+// BackupDirName next to it.
 func WriteMetadataFile(path string, doc *Document) error {
-	if doc == nil {
-		return fmt.Errorf("metadata document is nil")
-	}
 	if strings.TrimSpace(path) == "" {
 		return fmt.Errorf("metadata output path is empty")
 	}
+	data, err := Marshal(doc)
+	if err != nil {
+		return err
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return fmt.Errorf("ensure metadata dir %s: %w", path, err)
 	}
+	if err := backupFile(path, data); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, data, 0o644); err != nil {
+		return fmt.Errorf("write metadata %s: %w", path, err)
+	}
+	return nil
+}
 
+// Marshal renders a Document in the Pegasus format exactly as
+// WriteMetadataFile stores it.
+func Marshal(doc *Document) ([]byte, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("metadata document is nil")
+	}
 	var buf bytes.Buffer
 	for i, blk := range doc.Blocks {
 		if blk == nil {
@@ -269,11 +286,7 @@ func WriteMetadataFile(path string, doc *Document) error {
 			}
 		}
 	}
-
-	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
-		return fmt.Errorf("write metadata %s: %w", path, err)
-	}
-	return nil
+	return buf.Bytes(), nil
 }
 
 // Entry returns the first entry for key.
@@ -465,6 +478,12 @@ func firstRune(s string) rune {
 	return 0
 }
 
+// NormalizeKey lower-cases key and maps known aliases to their canonical name,
+// matching how ParseMetadataFile stores entry keys.
+func NormalizeKey(key string) string {
+	return normalizeKey(strings.ToLower(strings.TrimSpace(key)))
+}
+
 func normalizeKey(in string) string {
 	if v, ok := defaultFieldMapping[in]; ok {
 		return v
@@ -0,0 +1,103 @@
+package scraper
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type cached struct {
+	IScraper
+	dir string
+	ttl time.Duration
+}
+
+type cacheEntry struct {
+	Time    time.Time `json:"time"`
+	Results []*Result `json:"results"`
+}
+
+// Cache keeps the search results and media of s under dir, so re-running
+// a scrape does not query the provider again. Search results expire after
+// ttl; media never does, as a provider URL names fixed content. Wrap the
+// rate limiter with the cache, so hits are not delayed.
+func Cache(s IScraper, dir string, ttl time.Duration) IScraper {
+	return &cached{IScraper: s, dir: filepath.Join(dir, s.Name()), ttl: ttl}
+}
+
+func cacheKey(parts ...string) string {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *cached) Search(ctx context.Context, q *Query) ([]*Result, error) {
+	key := cacheKey(q.RomName, q.CRC, q.Title, q.Platform)
+	path := filepath.Join(c.dir, "search", key[:2], key+".json")
+	if data, err := os.ReadFile(path); err == nil {
+		var entry cacheEntry
+		if json.Unmarshal(data, &entry) == nil && (c.ttl <= 0 || time.Since(entry.Time) < c.ttl) {
+			return entry.Results, nil
+		}
+	}
+	results, err := c.IScraper.Search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&cacheEntry{Time: time.Now(), Results: results})
+	if err == nil {
+		// A cache that cannot be written only costs another lookup.
+		_ = writeCacheFile(path, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	return results, nil
+}
+
+func (c *cached) FetchMedia(ctx context.Context, m *Media) (io.ReadCloser, error) {
+	key := cacheKey(m.URL)
+	path := filepath.Join(c.dir, "media", key[:2], key)
+	if f, err := os.Open(path); err == nil {
+		return f, nil
+	}
+	rc, err := c.IScraper.FetchMedia(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := writeCacheFile(path, func(w io.Writer) error {
+		_, err := io.Copy(w, rc)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func writeCacheFile(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,116 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const httpTimeout = 30 * time.Second
+
+// httpScraper queries a metadata service speaking a small JSON protocol:
+//
+//	GET <url>/search?rom=sf2&crc=...&title=...&platform=...
+//	{"results": [{"title": "...", "match": "rom", "fields": {...}, "media": [{"asset": "boxfront", "url": "..."}]}]}
+//
+// Media URLs may be relative to <url>. It fronts self-hosted mirrors and
+// adapters for public databases, and an httptest server in tests.
+type httpScraper struct {
+	base   *url.URL
+	token  string
+	client *http.Client
+}
+
+type httpSearchResponse struct {
+	Results []*Result `json:"results"`
+}
+
+func init() {
+	Register("http", func(args map[string]string) (IScraper, error) {
+		raw := args["url"]
+		if raw == "" {
+			return nil, errors.New("http scraper requires url=<base url>")
+		}
+		return NewHTTP(raw, args["token"])
+	})
+}
+
+// NewHTTP returns a provider for the service at baseURL. A non-empty token
+// is sent as a bearer token.
+func NewHTTP(baseURL, token string) (IScraper, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid scraper url %q", baseURL)
+	}
+	return &httpScraper{base: base, token: token, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+func (s *httpScraper) Name() string { return "http" }
+
+func (s *httpScraper) get(ctx context.Context, target *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Media may live on other hosts, which must not see the token.
+	if s.token != "" && target.Host == s.base.Host {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", target.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (s *httpScraper) Search(ctx context.Context, q *Query) ([]*Result, error) {
+	target := s.base.JoinPath("search")
+	params := url.Values{}
+	for key, value := range map[string]string{"rom": q.RomName, "crc": q.CRC, "title": q.Title, "platform": q.Platform} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+	target.RawQuery = params.Encode()
+	resp, err := s.get(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body httpSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode search response: %w", err)
+	}
+	for _, res := range body.Results {
+		for _, m := range res.Media {
+			if ref, err := url.Parse(m.URL); err == nil {
+				m.URL = s.base.ResolveReference(ref).String()
+			}
+		}
+	}
+	SortResults(body.Results)
+	return body.Results, nil
+}
+
+func (s *httpScraper) FetchMedia(ctx context.Context, m *Media) (io.ReadCloser, error) {
+	target, err := url.Parse(m.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.get(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,61 @@
+package scraper
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+type rateLimited struct {
+	IScraper
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// RateLimit spaces the requests made through s at least interval apart,
+// across goroutines, so bulk scrapes stay within a provider's limits.
+func RateLimit(s IScraper, interval time.Duration) IScraper {
+	if interval <= 0 {
+		return s
+	}
+	return &rateLimited{IScraper: s, interval: interval}
+}
+
+func (r *rateLimited) wait(ctx context.Context) error {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *rateLimited) Search(ctx context.Context, q *Query) ([]*Result, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.IScraper.Search(ctx, q)
+}
+
+func (r *rateLimited) FetchMedia(ctx context.Context, m *Media) (io.ReadCloser, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.IScraper.FetchMedia(ctx, m)
+}
@@ -0,0 +1,188 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xxxsen/retrog/internal/media"
+)
+
+const localInfoFile = "game.json"
+
+// localInfo is the optional game.json of a local provider entry.
+type localInfo struct {
+	Title  string              `json:"title"`
+	Roms   []string            `json:"roms"`
+	CRCs   []string            `json:"crcs"`
+	Fields map[string][]string `json:"fields"`
+	// Media maps asset names to files in the entry directory, for files not
+	// named after their asset.
+	Media map[string]string `json:"media"`
+}
+
+type localGame struct {
+	title  string
+	roms   map[string]struct{}
+	crcs   map[string]struct{}
+	fields map[string][]string
+	media  []*Media
+}
+
+// localScraper serves metadata from a directory with one subdirectory per
+// game:
+//
+//	<dir>/sf2/game.json      {"title": "...", "roms": ["sf2"], "crcs": [...], "fields": {"genre": ["Fighting"]}}
+//	<dir>/sf2/boxFront.png
+//	<dir>/sf2/video.mp4
+//
+// Images and videos are offered as the asset they are named after. Without
+// a game.json the directory name is both the title and the ROM name, so a
+// media pack from another frontend works as is.
+type localScraper struct {
+	root  string
+	games []*localGame
+}
+
+func init() {
+	Register("local", func(args map[string]string) (IScraper, error) {
+		dir := args["dir"]
+		if dir == "" {
+			return nil, errors.New("local scraper requires dir=<path>")
+		}
+		return NewLocal(dir)
+	})
+}
+
+// NewLocal indexes the game directories under dir.
+func NewLocal(dir string) (IScraper, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	s := &localScraper{root: root}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		game, err := loadLocalGame(root, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		s.games = append(s.games, game)
+	}
+	return s, nil
+}
+
+func loadLocalGame(root, name string) (*localGame, error) {
+	dir := filepath.Join(root, name)
+	info := &localInfo{}
+	if data, err := os.ReadFile(filepath.Join(dir, localInfoFile)); err == nil {
+		if err := json.Unmarshal(data, info); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", filepath.Join(dir, localInfoFile), err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	game := &localGame{
+		title:  info.Title,
+		roms:   make(map[string]struct{}),
+		crcs:   make(map[string]struct{}),
+		fields: make(map[string][]string),
+	}
+	if game.title == "" {
+		game.title = name
+	}
+	if len(info.Roms) == 0 {
+		info.Roms = []string{name}
+	}
+	for _, rom := range info.Roms {
+		game.roms[strings.ToLower(strings.TrimSpace(rom))] = struct{}{}
+	}
+	for _, crc := range info.CRCs {
+		game.crcs[strings.ToLower(strings.TrimSpace(crc))] = struct{}{}
+	}
+	for key, values := range info.Fields {
+		game.fields[strings.ToLower(key)] = values
+	}
+	assets := make(map[string]string)
+	mapped := make(map[string]struct{})
+	for asset, file := range info.Media {
+		url := filepath.ToSlash(filepath.Join(name, file))
+		assets[strings.ToLower(asset)] = url
+		mapped[url] = struct{}{}
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || file.Name() == localInfoFile {
+			continue
+		}
+		kind := media.KindOfExt(file.Name())
+		if kind != media.KindImage && kind != media.KindVideo {
+			continue
+		}
+		url := name + "/" + file.Name()
+		if _, ok := mapped[url]; ok {
+			continue
+		}
+		asset := strings.ToLower(strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())))
+		if _, ok := assets[asset]; !ok {
+			assets[asset] = url
+		}
+	}
+	names := make([]string, 0, len(assets))
+	for asset := range assets {
+		names = append(names, asset)
+	}
+	sort.Strings(names)
+	for _, asset := range names {
+		game.media = append(game.media, &Media{Asset: asset, URL: assets[asset]})
+	}
+	return game, nil
+}
+
+func (s *localScraper) Name() string { return "local" }
+
+func (s *localScraper) Search(ctx context.Context, q *Query) ([]*Result, error) {
+	crc := strings.ToLower(strings.TrimSpace(q.CRC))
+	rom := strings.ToLower(strings.TrimSpace(q.RomName))
+	title := NormalizeTitle(q.Title)
+	var out []*Result
+	for _, game := range s.games {
+		match := ""
+		if _, ok := game.crcs[crc]; ok && crc != "" {
+			match = MatchCRC
+		} else if _, ok := game.roms[rom]; ok && rom != "" {
+			match = MatchRom
+		} else if title != "" && NormalizeTitle(game.title) == title {
+			match = MatchTitle
+		}
+		if match == "" {
+			continue
+		}
+		out = append(out, &Result{Title: game.title, Match: match, Fields: game.fields, Media: game.media})
+	}
+	SortResults(out)
+	return out, nil
+}
+
+func (s *localScraper) FetchMedia(ctx context.Context, m *Media) (io.ReadCloser, error) {
+	path := filepath.Join(s.root, filepath.FromSlash(m.URL))
+	if !strings.HasPrefix(path, s.root+string(os.PathSeparator)) {
+		return nil, fmt.Errorf("media %q is outside the scraper directory", m.URL)
+	}
+	return os.Open(path)
+}
@@ -0,0 +1,86 @@
+package scraper
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Policy decides which existing values a result may replace.
+type Policy string
+
+const (
+	// PolicyFill only fills fields that are empty.
+	PolicyFill Policy = "fill"
+	// PolicyOverwrite replaces every field the result has a value for.
+	PolicyOverwrite Policy = "overwrite"
+	// PolicyAsk proposes the same changes as PolicyOverwrite, each to be
+	// confirmed by the user.
+	PolicyAsk Policy = "ask"
+)
+
+// ParsePolicy validates a policy name; "" means PolicyFill.
+func ParsePolicy(raw string) (Policy, error) {
+	switch policy := Policy(strings.ToLower(strings.TrimSpace(raw))); policy {
+	case "":
+		return PolicyFill, nil
+	case PolicyFill, PolicyOverwrite, PolicyAsk:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid scrape policy %q, want fill, overwrite or ask", raw)
+	}
+}
+
+// Change is a proposed update of one field. Media changes carry the file to
+// download instead of New.
+type Change struct {
+	Key   string   `json:"key"`
+	Old   []string `json:"old,omitempty"`
+	New   []string `json:"new,omitempty"`
+	Media *Media   `json:"media,omitempty"`
+}
+
+// Plan lists the changes res makes to a game whose fields, keyed in lower
+// case, are current. Only Fields and assets.* are considered.
+func Plan(current map[string][]string, res *Result, policy Policy) []*Change {
+	var out []*Change
+	for _, key := range Fields {
+		values := nonEmpty(res.Fields[key])
+		if len(values) == 0 {
+			continue
+		}
+		old := nonEmpty(current[key])
+		if slices.Equal(old, values) || (policy == PolicyFill && len(old) > 0) {
+			continue
+		}
+		out = append(out, &Change{Key: key, Old: old, New: values})
+	}
+	seen := make(map[string]struct{})
+	for _, m := range res.Media {
+		asset := strings.ToLower(strings.TrimSpace(m.Asset))
+		if asset == "" || m.URL == "" {
+			continue
+		}
+		key := "assets." + asset
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		old := nonEmpty(current[key])
+		if policy == PolicyFill && len(old) > 0 {
+			continue
+		}
+		out = append(out, &Change{Key: key, Old: old, Media: m})
+	}
+	return out
+}
+
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			out = append(out, value)
+		}
+	}
+	return out
+}
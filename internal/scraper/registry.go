@@ -0,0 +1,50 @@
+package scraper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Factory builds a provider from its `key=value` arguments.
+type Factory func(args map[string]string) (IScraper, error)
+
+var providerRegistry = map[string]Factory{}
+
+// Register registers a provider factory by name.
+func Register(name string, factory Factory) {
+	providerRegistry[name] = factory
+}
+
+// Resolve builds the provider registered under name.
+func Resolve(name string, args map[string]string) (IScraper, error) {
+	factory, ok := providerRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("scraper %s not registered, available: %s", name, strings.Join(List(), ", "))
+	}
+	return factory(args)
+}
+
+// List returns the registered provider names.
+func List() []string {
+	out := make([]string, 0, len(providerRegistry))
+	for name := range providerRegistry {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ParseArgs turns `key=value` flags into provider arguments.
+func ParseArgs(pairs []string) (map[string]string, error) {
+	args := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid scraper argument %q, want key=value", pair)
+		}
+		args[key] = strings.TrimSpace(value)
+	}
+	return args, nil
+}
@@ -0,0 +1,104 @@
+// Package scraper looks up game metadata and media from pluggable
+// providers, with rate limiting, a result cache and policies deciding which
+// existing values a result may replace.
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Fields are the metadata keys a scrape fills, besides assets.*.
+var Fields = []string{"description", "genre", "developer", "release"}
+
+// Match tells how a result was found; better matches come first.
+const (
+	MatchCRC   = "crc"
+	MatchRom   = "rom"
+	MatchTitle = "title"
+)
+
+// Query describes the game to look up. Providers use whichever parts they
+// support, preferring the CRC, then the ROM name, then the title.
+type Query struct {
+	// RomName is the ROM file name without extension, e.g. "sf2".
+	RomName string `json:"rom_name,omitempty"`
+	// CRC is the CRC32 of the ROM in lower-case hex, when known.
+	CRC   string `json:"crc,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Platform is the collection name, a hint for providers covering
+	// several systems.
+	Platform string `json:"platform,omitempty"`
+}
+
+func (q *Query) String() string {
+	return fmt.Sprintf("rom=%q crc=%q title=%q platform=%q", q.RomName, q.CRC, q.Title, q.Platform)
+}
+
+// Media is a downloadable file for an asset such as "boxfront". URL is
+// opaque outside the provider that returned it.
+type Media struct {
+	Asset string `json:"asset"`
+	URL   string `json:"url"`
+}
+
+// Result is one game found by a provider.
+type Result struct {
+	Title  string              `json:"title"`
+	Match  string              `json:"match"`
+	Fields map[string][]string `json:"fields,omitempty"`
+	Media  []*Media            `json:"media,omitempty"`
+}
+
+// IScraper is a metadata provider.
+type IScraper interface {
+	Name() string
+	// Search returns the games matching q, best match first.
+	Search(ctx context.Context, q *Query) ([]*Result, error)
+	// FetchMedia opens a file listed in a Result of this provider.
+	FetchMedia(ctx context.Context, m *Media) (io.ReadCloser, error)
+}
+
+var (
+	bracketPattern = regexp.MustCompile(`[(\[][^)\]]*[)\]]`)
+)
+
+// NormalizeTitle reduces a title for matching: region and revision tags in
+// brackets are dropped, as are case, punctuation and spacing, so
+// "Street Fighter II (World 910522)" and "street fighter ii" compare equal.
+func NormalizeTitle(title string) string {
+	title = bracketPattern.ReplaceAllString(title, " ")
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func matchRank(match string) int {
+	switch match {
+	case MatchCRC:
+		return 0
+	case MatchRom:
+		return 1
+	case MatchTitle:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// SortResults orders results by match quality, keeping the provider's
+// order among equal matches.
+func SortResults(results []*Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return matchRank(results[i].Match) < matchRank(results[j].Match)
+	})
+}
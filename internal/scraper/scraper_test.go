@@ -0,0 +1,177 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func fetch(t *testing.T, s IScraper, m *Media) string {
+	t.Helper()
+	rc, err := s.FetchMedia(context.Background(), m)
+	if err != nil {
+		t.Fatalf("fetch media: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read media: %v", err)
+	}
+	return string(data)
+}
+
+func TestLocalScraper(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "sf2", "game.json"), `{
+  "title": "Street Fighter II: The World Warrior",
+  "roms": ["sf2", "sf2ua"],
+  "crcs": ["DEADBEEF"],
+  "fields": {"genre": ["Fighting"], "developer": ["Capcom"]},
+  "media": {"boxfront": "cover.jpg"}
+}`)
+	writeFile(t, filepath.Join(dir, "sf2", "cover.jpg"), "cover")
+	writeFile(t, filepath.Join(dir, "sf2", "logo.png"), "logo")
+	writeFile(t, filepath.Join(dir, "sf2", "notes.txt"), "ignored")
+	writeFile(t, filepath.Join(dir, "Metal Slug (World)", "boxFront.png"), "ms")
+
+	s, err := Resolve("local", map[string]string{"dir": dir})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	ctx := context.Background()
+
+	results, err := s.Search(ctx, &Query{CRC: "deadbeef"})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, MatchCRC, results[0].Match)
+		assert.Equal(t, []string{"Capcom"}, results[0].Fields["developer"])
+		assert.Equal(t, []*Media{{Asset: "boxfront", URL: "sf2/cover.jpg"}, {Asset: "logo", URL: "sf2/logo.png"}}, results[0].Media)
+		assert.Equal(t, "cover", fetch(t, s, results[0].Media[0]))
+	}
+
+	results, _ = s.Search(ctx, &Query{RomName: "SF2UA"})
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, MatchRom, results[0].Match)
+	}
+	results, _ = s.Search(ctx, &Query{Title: "metal slug"})
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, MatchTitle, results[0].Match)
+		assert.Equal(t, "boxfront", results[0].Media[0].Asset)
+	}
+	results, _ = s.Search(ctx, &Query{RomName: "kof98"})
+	assert.Empty(t, results)
+
+	_, err = s.FetchMedia(ctx, &Media{URL: "../secret"})
+	assert.Error(t, err)
+}
+
+func TestHTTPScraperWithCacheAndRateLimit(t *testing.T) {
+	var searches, fetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		searches.Add(1)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var results []*Result
+		if r.URL.Query().Get("rom") == "sf2" {
+			results = append(results,
+				&Result{Title: "Street Fighter II", Match: MatchTitle},
+				&Result{Title: "Street Fighter II", Match: MatchRom,
+					Fields: map[string][]string{"description": {"A fighting game."}},
+					Media:  []*Media{{Asset: "boxfront", URL: "media/sf2.png"}}},
+			)
+		}
+		_ = json.NewEncoder(w).Encode(&httpSearchResponse{Results: results})
+	})
+	mux.HandleFunc("/api/media/sf2.png", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write([]byte("png"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	provider, err := Resolve("http", map[string]string{"url": srv.URL + "/api", "token": "secret"})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	s := Cache(RateLimit(provider, 50*time.Millisecond), t.TempDir(), time.Hour)
+	ctx := context.Background()
+
+	start := time.Now()
+	results, err := s.Search(ctx, &Query{RomName: "sf2", Title: "Street Fighter II"})
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 2) {
+		return
+	}
+	assert.Equal(t, MatchRom, results[0].Match, "better matches first")
+	assert.Equal(t, srv.URL+"/api/media/sf2.png", results[0].Media[0].URL)
+	assert.Equal(t, "png", fetch(t, s, results[0].Media[0]))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "second request waits for the rate limit")
+
+	again, err := s.Search(ctx, &Query{RomName: "sf2", Title: "Street Fighter II"})
+	assert.NoError(t, err)
+	assert.Equal(t, results[0].Title, again[0].Title)
+	assert.Equal(t, "png", fetch(t, s, again[0].Media[0]))
+	assert.Equal(t, int32(1), searches.Load())
+	assert.Equal(t, int32(1), fetches.Load())
+
+	_, err = s.Search(ctx, &Query{RomName: "kof98"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), searches.Load())
+}
+
+func TestPlan(t *testing.T) {
+	res := &Result{
+		Fields: map[string][]string{
+			"description": {"New description"},
+			"genre":       {"Fighting"},
+			"developer":   {"Capcom"},
+			"players":     {"2"},
+		},
+		Media: []*Media{{Asset: "boxfront", URL: "a.png"}, {Asset: "logo", URL: "b.png"}},
+	}
+	current := map[string][]string{
+		"description":     {"Old description"},
+		"developer":       {"Capcom"},
+		"assets.boxfront": {"media/sf2/boxFront.png"},
+	}
+	keys := func(changes []*Change) []string {
+		var out []string
+		for _, change := range changes {
+			out = append(out, change.Key)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"genre", "assets.logo"}, keys(Plan(current, res, PolicyFill)))
+	overwrite := Plan(current, res, PolicyOverwrite)
+	assert.Equal(t, []string{"description", "genre", "assets.boxfront", "assets.logo"}, keys(overwrite))
+	assert.Equal(t, []string{"Old description"}, overwrite[0].Old)
+	assert.Equal(t, "a.png", overwrite[2].Media.URL)
+	assert.Equal(t, keys(overwrite), keys(Plan(current, res, PolicyAsk)))
+
+	_, err := ParsePolicy("merge")
+	assert.Error(t, err)
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	assert.Equal(t, "streetfighterii", NormalizeTitle("Street Fighter II (World 910522) [!]"))
+	assert.Equal(t, "拳皇98", NormalizeTitle("拳皇 '98"))
+}
@@ -83,6 +83,11 @@ func convertRoms(roms []dat.Rom) []SubRomFile {
 
 // TestDir scans a directory and validates matching archives.
 func (t *tester) TestDir(ctx Context, romdir string, biosdir string, exts []string) (*RomTestResult, error) {
+	return t.TestDirProgress(ctx, romdir, biosdir, exts, nil)
+}
+
+// TestDirProgress is TestDir reporting every tested archive to progress.
+func (t *tester) TestDirProgress(ctx Context, romdir string, biosdir string, exts []string, progress ProgressFunc) (*RomTestResult, error) {
 	if romdir == "" {
 		return nil, errors.New("romdir is required")
 	}
@@ -94,14 +99,59 @@ func (t *tester) TestDir(ctx Context, romdir string, biosdir string, exts []stri
 	if len(paths) == 0 {
 		return nil, errors.New("no rom files provided")
 	}
+	return t.testPaths(ctx, paths, biosdir, buildPathIndex(paths, biosdir, allowed), progress)
+}
+
+// TestFiles validates only the given archives. romdir is still scanned so
+// that parent archives can be resolved; files that no longer exist or do not
+// match exts are skipped.
+func (t *tester) TestFiles(ctx Context, romdir string, biosdir string, files []string, exts []string) (*RomTestResult, error) {
+	return t.TestFilesProgress(ctx, romdir, biosdir, files, exts, nil)
+}
+
+// TestFilesProgress is TestFiles reporting every tested archive to progress.
+func (t *tester) TestFilesProgress(ctx Context, romdir string, biosdir string, files []string, exts []string, progress ProgressFunc) (*RomTestResult, error) {
+	if romdir == "" {
+		return nil, errors.New("romdir is required")
+	}
+	allowed := normalizeExts(exts)
+	var targets []string
+	for _, p := range files {
+		if len(allowed) > 0 {
+			ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(p)), ".")
+			if _, ok := allowed[ext]; !ok {
+				continue
+			}
+		}
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		targets = append(targets, filepath.Clean(p))
+	}
+	if len(targets) == 0 {
+		return &RomTestResult{}, nil
+	}
+	paths, err := collectPaths(romdir, allowed)
+	if err != nil {
+		return nil, err
+	}
+	return t.testPaths(ctx, targets, biosdir, buildPathIndex(paths, biosdir, allowed), progress)
+}
+
+func buildPathIndex(paths []string, biosdir string, allowed map[string]struct{}) map[string]string {
 	nameToPath := indexPaths(paths)
 	// include bios directory files
 	if biosdir != "" {
 		biosPaths, _ := collectPaths(biosdir, allowed)
 		nameToPath = mergePathIndexWithBiosPreference(nameToPath, biosPaths, biosdir)
 	}
+	return nameToPath
+}
+
+func (t *tester) testPaths(ctx Context, paths []string, biosdir string, nameToPath map[string]string, progress ProgressFunc) (*RomTestResult, error) {
 	var results []*RomFileTestResult
-	for _, p := range paths {
+	for idx, p := range paths {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -113,6 +163,9 @@ func (t *tester) TestDir(ctx Context, romdir string, biosdir string, exts []stri
 		}
 		result.FilePath = p
 		results = append(results, result)
+		if progress != nil {
+			progress(idx+1, len(paths), result)
+		}
 	}
 	return &RomTestResult{List: results}, nil
 }
@@ -122,6 +122,58 @@ func TestMameSDK(t *testing.T) {
 	}
 }
 
+func TestMameSDKTestDirProgress(t *testing.T) {
+	dir := t.TempDir()
+	datPath := filepath.Join(dir, "mame.dat")
+	if err := os.WriteFile(datPath, []byte(mameSampleDat), 0o644); err != nil {
+		t.Fatalf("write dat: %v", err)
+	}
+	romDir := filepath.Join(dir, "roms")
+	biosDir := filepath.Join(dir, "bios")
+	for _, d := range []string{romDir, biosDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", d, err)
+		}
+	}
+	writeZip(t, filepath.Join(romDir, "mamegame.zip"), map[string][]byte{
+		"m.bin": []byte("abc"),
+	})
+	writeZip(t, filepath.Join(romDir, "unknown.zip"), map[string][]byte{
+		"u.bin": []byte("xyz"),
+	})
+	writeZip(t, filepath.Join(biosDir, "biosset.zip"), map[string][]byte{
+		"bios.bin": []byte{0x4b, 0x2e},
+	})
+
+	sdk, err := NewMameTestSDK(datPath)
+	if err != nil {
+		t.Fatalf("init sdk: %v", err)
+	}
+	var progressCalls [][2]int
+	var items []string
+	res, err := sdk.TestDirProgress(stdCtx{context.Background()}, romDir, biosDir, []string{"zip"}, func(done, total int, item *RomFileTestResult) {
+		progressCalls = append(progressCalls, [2]int{done, total})
+		if item != nil {
+			items = append(items, item.FilePath)
+		}
+	})
+	if err != nil {
+		t.Fatalf("test dir: %v", err)
+	}
+	if len(progressCalls) != 2 || progressCalls[0] != [2]int{1, 2} || progressCalls[1] != [2]int{2, 2} {
+		t.Fatalf("unexpected progress calls %v", progressCalls)
+	}
+	if len(items) != len(res.List) {
+		t.Fatalf("expected an item per result, got %v for %d results", items, len(res.List))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sdk.TestDirProgress(stdCtx{ctx}, romDir, biosDir, []string{"zip"}, nil); err == nil {
+		t.Fatalf("expected canceled test dir to fail")
+	}
+}
+
 func TestParentAndBiosLabeling(t *testing.T) {
 	dir := t.TempDir()
 	datPath := filepath.Join(dir, "chain.dat")
@@ -183,6 +235,54 @@ func TestParentAndBiosLabeling(t *testing.T) {
 	}
 }
 
+func TestTestFilesOnlyTestsGivenArchives(t *testing.T) {
+	dir := t.TempDir()
+	datPath := filepath.Join(dir, "chain.dat")
+	datContent := `<?xml version="1.0"?>
+<datafile>
+  <header><name>Chain</name></header>
+  <game name="child" romof="parent1">
+    <rom name="c.bin" size="1" crc="00"/>
+  </game>
+  <game name="parent1">
+    <rom name="p.bin" size="1" crc="00"/>
+  </game>
+</datafile>`
+	if err := os.WriteFile(datPath, []byte(datContent), 0o644); err != nil {
+		t.Fatalf("write dat: %v", err)
+	}
+	romDir := filepath.Join(dir, "roms")
+	if err := os.MkdirAll(filepath.Join(romDir, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir roms: %v", err)
+	}
+	writeZip(t, filepath.Join(romDir, "sub", "child.zip"), map[string][]byte{"c.bin": {0}})
+	writeZip(t, filepath.Join(romDir, "parent1.zip"), map[string][]byte{"p.bin": {0}})
+
+	sdk, err := NewFBNeoTestSDK(datPath)
+	if err != nil {
+		t.Fatalf("init sdk: %v", err)
+	}
+	files := []string{
+		filepath.Join(romDir, "sub", "child.zip"),
+		filepath.Join(romDir, "missing.zip"),
+		filepath.Join(romDir, "readme.txt"),
+	}
+	res, err := sdk.TestFiles(stdCtx{context.Background()}, romDir, "", files, []string{"zip"})
+	if err != nil {
+		t.Fatalf("test files: %v", err)
+	}
+	if len(res.List) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(res.List))
+	}
+	child := res.List[0]
+	if child.RomName != "child" {
+		t.Fatalf("unexpected result %s", child.RomName)
+	}
+	if len(child.ParentList) != 1 || !child.ParentList[0].Exist {
+		t.Fatalf("parent outside the tested files must be resolved: %+v", child.ParentList)
+	}
+}
+
 func writeZip(t *testing.T, path string, files map[string][]byte) {
 	t.Helper()
 	var buf bytes.Buffer
@@ -57,9 +57,16 @@ type RomTestResult struct {
 	List []*RomFileTestResult
 }
 
+// ProgressFunc is called after each archive has been tested, with the number
+// of archives done so far and the total.
+type ProgressFunc func(done, total int, item *RomFileTestResult)
+
 // IRomTestSDK provides ROM validation.
 type IRomTestSDK interface {
 	TestDir(ctx Context, romdir string, biosdir string, exts []string) (*RomTestResult, error)
+	TestDirProgress(ctx Context, romdir string, biosdir string, exts []string, progress ProgressFunc) (*RomTestResult, error)
+	TestFiles(ctx Context, romdir string, biosdir string, files []string, exts []string) (*RomTestResult, error)
+	TestFilesProgress(ctx Context, romdir string, biosdir string, files []string, exts []string, progress ProgressFunc) (*RomTestResult, error)
 }
 
 // Context is a minimal subset of context.Context to avoid tight coupling.
@@ -0,0 +1,77 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Term is a single query term. An empty Field matches any full-text field.
+type Term struct {
+	Field string
+	Text  string
+}
+
+// Query is a parsed search: a document matches when it matches every term.
+type Query struct {
+	Terms []Term
+}
+
+// ParseQuery parses free text mixed with field scoped parts such as
+// `developer:capcom genre:"beat em up" status:red`. Field names are only
+// recognised when known reports them as indexed, so titles like
+// "Re:Zero" still search as plain text.
+func ParseQuery(text string, known func(field string) bool) *Query {
+	q := &Query{}
+	for _, part := range splitQuery(text) {
+		field, value, ok := strings.Cut(part, ":")
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.Trim(value, `"`)
+		if ok && value != "" && isFieldName(field) && known != nil && known(field) {
+			for _, tok := range tokenize(value, false) {
+				q.Terms = append(q.Terms, Term{Field: field, Text: tok})
+			}
+			continue
+		}
+		for _, tok := range tokenize(strings.Trim(part, `"`), false) {
+			q.Terms = append(q.Terms, Term{Text: tok})
+		}
+	}
+	return q
+}
+
+// splitQuery splits on whitespace outside double quotes.
+func splitQuery(text string) []string {
+	var out []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			cur.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if cur.Len() > 0 {
+				out = append(out, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
+
+func isFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
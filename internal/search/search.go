@@ -0,0 +1,293 @@
+// Package search is an in-memory inverted index over small documents, used
+// by the web UI to search game metadata.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Match weights by how a query term matched an indexed term.
+const (
+	exactWeight  = 1.0
+	prefixWeight = 0.6
+	fuzzyWeight  = 0.3
+
+	// keywordPrefix marks keyword postings, keeping them out of unscoped
+	// matches.
+	keywordPrefix = "#"
+)
+
+// Document is a unit of search. Fields are full-text searchable, with or
+// without a field scope; Keywords such as a status can only be matched with
+// an explicit `key:value` scope.
+type Document struct {
+	ID       string
+	Fields   map[string][]string
+	Keywords map[string][]string
+}
+
+// Result is a matching document and its relevance.
+type Result struct {
+	ID    string
+	Score float64
+}
+
+type posting struct {
+	term  string
+	field string
+}
+
+type docEntry struct {
+	id       string
+	postings []posting
+}
+
+// Index is safe for concurrent use.
+type Index struct {
+	mu      sync.Mutex
+	weights map[string]float64
+	docs    []*docEntry
+	ids     map[string]int
+	terms   map[string]map[string]map[int]struct{}
+	fields  map[string]int
+	dict    []string
+	dirty   bool
+}
+
+// New returns an empty index. weights boosts matches in the named fields;
+// unnamed fields weigh 1.
+func New(weights map[string]float64) *Index {
+	return &Index{
+		weights: weights,
+		ids:     make(map[string]int),
+		terms:   make(map[string]map[string]map[int]struct{}),
+		fields:  make(map[string]int),
+	}
+}
+
+// Add indexes doc, replacing any document with the same ID. A replaced
+// document keeps its position among equally scored results.
+func (x *Index) Add(doc *Document) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	seq, ok := x.ids[doc.ID]
+	if ok {
+		x.removeLocked(seq)
+	} else {
+		seq = len(x.docs)
+		x.docs = append(x.docs, nil)
+		x.ids[doc.ID] = seq
+	}
+	entry := &docEntry{id: doc.ID}
+	seen := make(map[posting]struct{})
+	add := func(field string, terms []string) {
+		for _, term := range terms {
+			p := posting{term: term, field: field}
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			byField, ok := x.terms[term]
+			if !ok {
+				byField = make(map[string]map[int]struct{})
+				x.terms[term] = byField
+				x.dirty = true
+			}
+			if byField[field] == nil {
+				byField[field] = make(map[int]struct{})
+				x.fields[field]++
+			}
+			byField[field][seq] = struct{}{}
+			entry.postings = append(entry.postings, p)
+		}
+	}
+	for field, values := range doc.Fields {
+		field = strings.ToLower(field)
+		for _, value := range values {
+			add(field, tokenize(value, true))
+		}
+	}
+	for field, values := range doc.Keywords {
+		field = keywordPrefix + strings.ToLower(field)
+		for _, value := range values {
+			add(field, tokenize(value, false))
+		}
+	}
+	x.docs[seq] = entry
+}
+
+// Remove drops the document with id, if indexed.
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	seq, ok := x.ids[id]
+	if !ok {
+		return
+	}
+	x.removeLocked(seq)
+	x.docs[seq] = nil
+	delete(x.ids, id)
+}
+
+func (x *Index) removeLocked(seq int) {
+	entry := x.docs[seq]
+	if entry == nil {
+		return
+	}
+	for _, p := range entry.postings {
+		byField := x.terms[p.term]
+		docs := byField[p.field]
+		delete(docs, seq)
+		if len(docs) > 0 {
+			continue
+		}
+		delete(byField, p.field)
+		if x.fields[p.field]--; x.fields[p.field] <= 0 {
+			delete(x.fields, p.field)
+		}
+		if len(byField) == 0 {
+			delete(x.terms, p.term)
+			x.dirty = true
+		}
+	}
+}
+
+// Len returns the number of indexed documents.
+func (x *Index) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.ids)
+}
+
+// HasField reports whether any document has field as a field or keyword.
+func (x *Index) HasField(field string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.fields[field] > 0 || x.fields[keywordPrefix+field] > 0
+}
+
+// Search parses text with ParseQuery and runs it.
+func (x *Index) Search(text string) []*Result {
+	return x.Run(ParseQuery(text, x.HasField))
+}
+
+// Run returns the documents matching every term of q, best first; equal
+// scores keep indexing order. An empty query matches nothing.
+func (x *Index) Run(q *Query) []*Result {
+	if q == nil || len(q.Terms) == 0 {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dirty {
+		x.dict = x.dict[:0]
+		for term := range x.terms {
+			x.dict = append(x.dict, term)
+		}
+		sort.Strings(x.dict)
+		x.dirty = false
+	}
+	var scores map[int]float64
+	for _, term := range q.Terms {
+		termScores := x.matchTerm(term)
+		if scores == nil {
+			scores = termScores
+		} else {
+			for seq, score := range scores {
+				if extra, ok := termScores[seq]; ok {
+					scores[seq] = score + extra
+				} else {
+					delete(scores, seq)
+				}
+			}
+		}
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+	out := make([]*Result, 0, len(scores))
+	seqs := make([]int, 0, len(scores))
+	for seq := range scores {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		if scores[seqs[i]] != scores[seqs[j]] {
+			return scores[seqs[i]] > scores[seqs[j]]
+		}
+		return seqs[i] < seqs[j]
+	})
+	for _, seq := range seqs {
+		out = append(out, &Result{ID: x.docs[seq].id, Score: scores[seq]})
+	}
+	return out
+}
+
+// matchTerm scores every document matching term: exactly, as a prefix of
+// an indexed term, or within a small edit distance of one.
+func (x *Index) matchTerm(term Term) map[int]float64 {
+	scores := make(map[int]float64)
+	x.collect(scores, term, term.Text, exactWeight)
+	length := utf8.RuneCountInString(term.Text)
+	if length >= 2 {
+		start := sort.SearchStrings(x.dict, term.Text)
+		for _, candidate := range x.dict[start:] {
+			if !strings.HasPrefix(candidate, term.Text) {
+				break
+			}
+			if candidate != term.Text {
+				x.collect(scores, term, candidate, prefixWeight)
+			}
+		}
+	}
+	if maxDist := fuzzyDistance(length); maxDist > 0 {
+		for _, candidate := range x.dict {
+			if candidate == term.Text || strings.HasPrefix(candidate, term.Text) {
+				continue
+			}
+			if editDistance(term.Text, candidate, maxDist) <= maxDist {
+				x.collect(scores, term, candidate, fuzzyWeight)
+			}
+		}
+	}
+	return scores
+}
+
+// fuzzyDistance is the edit distance tolerated for a term of length runes:
+// none for short terms, where a typo is as likely another word.
+func fuzzyDistance(length int) int {
+	switch {
+	case length >= 8:
+		return 2
+	case length >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (x *Index) collect(scores map[int]float64, term Term, indexed string, weight float64) {
+	for field, docs := range x.terms[indexed] {
+		if term.Field == "" && strings.HasPrefix(field, keywordPrefix) {
+			continue
+		}
+		if term.Field != "" && term.Field != field && keywordPrefix+term.Field != field {
+			continue
+		}
+		score := weight * x.fieldWeight(strings.TrimPrefix(field, keywordPrefix))
+		for seq := range docs {
+			if score > scores[seq] {
+				scores[seq] = score
+			}
+		}
+	}
+}
+
+func (x *Index) fieldWeight(field string) float64 {
+	if w, ok := x.weights[field]; ok {
+		return w
+	}
+	return 1
+}
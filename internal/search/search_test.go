@@ -0,0 +1,76 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ids(results []*Result) []string {
+	out := make([]string, 0, len(results))
+	for _, r := range results {
+		out = append(out, r.ID)
+	}
+	return out
+}
+
+func newTestIndex() *Index {
+	x := New(map[string]float64{"title": 3})
+	x.Add(&Document{
+		ID:       "sf2",
+		Fields:   map[string][]string{"title": {"Street Fighter II"}, "developer": {"Capcom"}, "genre": {"Fighting"}},
+		Keywords: map[string][]string{"status": {"green"}},
+	})
+	x.Add(&Document{
+		ID:       "kof",
+		Fields:   map[string][]string{"title": {"拳皇 98"}, "developer": {"SNK"}, "description": {"Street brawl with fighters from SNK games"}},
+		Keywords: map[string][]string{"status": {"red"}},
+	})
+	x.Add(&Document{
+		ID:       "sfz",
+		Fields:   map[string][]string{"title": {"街头霸王 Zero"}, "developer": {"Capcom"}, "genre": {"Fighting"}},
+		Keywords: map[string][]string{"status": {"red"}},
+	})
+	return x
+}
+
+func TestSearch(t *testing.T) {
+	x := newTestIndex()
+	assert.Equal(t, []string{"sf2", "kof"}, ids(x.Search("street")), "title matches rank above description")
+	assert.Equal(t, []string{"sfz"}, ids(x.Search("jietou")), "pinyin prefix")
+	assert.Equal(t, []string{"sfz"}, ids(x.Search("jtbw")), "pinyin initials")
+	assert.Equal(t, []string{"kof"}, ids(x.Search("拳皇")))
+	assert.Equal(t, []string{"sf2", "sfz"}, ids(x.Search("capcm")), "one typo is tolerated")
+	assert.Equal(t, []string{"sfz"}, ids(x.Search("developer:capcom status:red")))
+	assert.Equal(t, []string{"sf2"}, ids(x.Search(`developer:capcom title:"street fighter"`)))
+	assert.Empty(t, x.Search("red"), "keywords need a scope")
+	assert.Empty(t, x.Search(""))
+	assert.Empty(t, x.Search("developer:snk genre:fighting"))
+
+	x.Add(&Document{ID: "sf2", Fields: map[string][]string{"title": {"Street Fighter II Turbo"}}, Keywords: map[string][]string{"status": {"red"}}})
+	assert.Equal(t, []string{"sf2", "kof"}, ids(x.Search("status:red fighter")), "replaced documents are re-indexed")
+	x.Remove("kof")
+	assert.Empty(t, x.Search("snk"))
+	assert.False(t, x.HasField("description"))
+	assert.Equal(t, 2, x.Len())
+}
+
+func TestParseQuery(t *testing.T) {
+	known := func(field string) bool { return field == "developer" }
+	q := ParseQuery(`Re:Zero developer:"Data East" 街头`, known)
+	assert.Equal(t, []Term{
+		{Text: "re"},
+		{Text: "zero"},
+		{Field: "developer", Text: "data"},
+		{Field: "developer", Text: "east"},
+		{Text: "街"},
+		{Text: "头"},
+	}, q.Terms)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 1, editDistance("capcom", "capcm", 2))
+	assert.Equal(t, 1, editDistance("fighter", "fihgter", 2), "adjacent swap")
+	assert.Equal(t, 2, editDistance("fighter", "fgihtre", 2))
+	assert.Equal(t, 3, editDistance("a", "abcdef", 2), "exceeding max reports max+1")
+}
@@ -0,0 +1,108 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+)
+
+var pinyinArgs = pinyin.NewArgs()
+
+// tokenize splits text into lower-cased terms. Runs of letters and digits
+// form one term; every Han character is a term of its own. With withPinyin
+// set, each run of Han characters also yields its pinyin syllables, the
+// syllables joined together and their initials, so "街头霸王" can be found
+// by "jietou" or "jtbw".
+func tokenize(text string, withPinyin bool) []string {
+	var out []string
+	var word, han []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			out = append(out, string(word))
+			word = word[:0]
+		}
+	}
+	flushHan := func() {
+		if len(han) == 0 {
+			return
+		}
+		for _, r := range han {
+			out = append(out, string(r))
+		}
+		if withPinyin {
+			out = append(out, pinyinTerms(string(han))...)
+		}
+		han = han[:0]
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushHan()
+			word = append(word, unicode.ToLower(r))
+		default:
+			flushWord()
+			flushHan()
+		}
+	}
+	flushWord()
+	flushHan()
+	return out
+}
+
+func pinyinTerms(text string) []string {
+	syllables := pinyin.LazyPinyin(text, pinyinArgs)
+	if len(syllables) == 0 {
+		return nil
+	}
+	out := append([]string(nil), syllables...)
+	if len(syllables) > 1 {
+		var initials strings.Builder
+		for _, s := range syllables {
+			if s != "" {
+				initials.WriteByte(s[0])
+			}
+		}
+		out = append(out, strings.Join(syllables, ""), initials.String())
+	}
+	return out
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, where swapping two adjacent characters counts as one edit, or max+1 once
+// it is known to exceed max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > max {
+			return max + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
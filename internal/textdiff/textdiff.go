@@ -0,0 +1,267 @@
+// Package textdiff produces line based diffs in the unified format.
+package textdiff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OpKind identifies how a line changed between two texts.
+type OpKind int
+
+const (
+	OpEqual OpKind = iota
+	OpDelete
+	OpInsert
+)
+
+// Edit is a single line of an edit script.
+type Edit struct {
+	Kind OpKind
+	Text string
+}
+
+// maxLCSCells bounds the quadratic fallback used when a changed region has no
+// unique lines to anchor on; larger regions are reported as a full rewrite.
+const maxLCSCells = 1 << 20
+
+// Lines returns the edit script turning a into b. Lines shared by both
+// inputs exactly once are used as anchors (patience diff), which keeps the
+// output readable for metadata files where most lines are unique.
+func Lines(a, b []string) []Edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	out := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		out = append(out, Edit{Kind: OpEqual, Text: line})
+	}
+	out = append(out, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		out = append(out, Edit{Kind: OpEqual, Text: line})
+	}
+	return out
+}
+
+func diffMiddle(a, b []string) []Edit {
+	if len(a) == 0 || len(b) == 0 {
+		return rewrite(a, b)
+	}
+	anchors := uniqueAnchors(a, b)
+	if len(anchors) == 0 {
+		if len(a)*len(b) <= maxLCSCells {
+			return lcs(a, b)
+		}
+		return rewrite(a, b)
+	}
+	var out []Edit
+	prevA, prevB := 0, 0
+	for _, anchor := range anchors {
+		out = append(out, Lines(a[prevA:anchor[0]], b[prevB:anchor[1]])...)
+		out = append(out, Edit{Kind: OpEqual, Text: a[anchor[0]]})
+		prevA, prevB = anchor[0]+1, anchor[1]+1
+	}
+	return append(out, Lines(a[prevA:], b[prevB:])...)
+}
+
+func rewrite(a, b []string) []Edit {
+	out := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a {
+		out = append(out, Edit{Kind: OpDelete, Text: line})
+	}
+	for _, line := range b {
+		out = append(out, Edit{Kind: OpInsert, Text: line})
+	}
+	return out
+}
+
+// uniqueAnchors returns the longest increasing run of (indexA, indexB) pairs
+// for lines occurring exactly once in both a and b.
+func uniqueAnchors(a, b []string) [][2]int {
+	type counter struct {
+		countA, countB int
+		indexA, indexB int
+	}
+	counts := make(map[string]*counter)
+	for idx, line := range a {
+		c := counts[line]
+		if c == nil {
+			c = &counter{}
+			counts[line] = c
+		}
+		c.countA++
+		c.indexA = idx
+	}
+	for idx, line := range b {
+		c := counts[line]
+		if c == nil {
+			continue
+		}
+		c.countB++
+		c.indexB = idx
+	}
+	var pairs [][2]int
+	for _, line := range a {
+		c := counts[line]
+		if c.countA == 1 && c.countB == 1 {
+			pairs = append(pairs, [2]int{c.indexA, c.indexB})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	// Patience sorting: longest increasing subsequence on indexB.
+	var tails []int
+	prev := make([]int, len(pairs))
+	for idx, pair := range pairs {
+		pos := sort.Search(len(tails), func(i int) bool { return pairs[tails[i]][1] >= pair[1] })
+		if pos > 0 {
+			prev[idx] = tails[pos-1]
+		} else {
+			prev[idx] = -1
+		}
+		if pos == len(tails) {
+			tails = append(tails, idx)
+		} else {
+			tails[pos] = idx
+		}
+	}
+	out := make([][2]int, len(tails))
+	for idx, pos := tails[len(tails)-1], len(tails)-1; idx >= 0; idx, pos = prev[idx], pos-1 {
+		out[pos] = pairs[idx]
+	}
+	return out
+}
+
+func lcs(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	table := make([]int, (n+1)*(m+1))
+	at := func(i, j int) int { return table[i*(m+1)+j] }
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i*(m+1)+j] = at(i+1, j+1) + 1
+			} else {
+				table[i*(m+1)+j] = max(at(i+1, j), at(i, j+1))
+			}
+		}
+	}
+	out := make([]Edit, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, Edit{Kind: OpEqual, Text: a[i]})
+			i++
+			j++
+		case at(i+1, j) >= at(i, j+1):
+			out = append(out, Edit{Kind: OpDelete, Text: a[i]})
+			i++
+		default:
+			out = append(out, Edit{Kind: OpInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		out = append(out, Edit{Kind: OpDelete, Text: a[i]})
+	}
+	for ; j < m; j++ {
+		out = append(out, Edit{Kind: OpInsert, Text: b[j]})
+	}
+	return out
+}
+
+// Unified renders the difference between from and to in the unified diff
+// format with contextLines lines of context around each change. It returns
+// an empty string when both texts are equal.
+func Unified(fromName, toName, from, to string, contextLines int) string {
+	if from == to {
+		return ""
+	}
+	if contextLines < 0 {
+		contextLines = 0
+	}
+	edits := Lines(splitLines(from), splitLines(to))
+
+	var sb strings.Builder
+	sb.WriteString("--- " + fromName + "\n")
+	sb.WriteString("+++ " + toName + "\n")
+
+	// Line numbers (0-based) in the old and new text before each edit.
+	oldLine := make([]int, len(edits)+1)
+	newLine := make([]int, len(edits)+1)
+	for idx, edit := range edits {
+		oldLine[idx+1], newLine[idx+1] = oldLine[idx], newLine[idx]
+		if edit.Kind != OpInsert {
+			oldLine[idx+1]++
+		}
+		if edit.Kind != OpDelete {
+			newLine[idx+1]++
+		}
+	}
+
+	idx := 0
+	for idx < len(edits) {
+		if edits[idx].Kind == OpEqual {
+			idx++
+			continue
+		}
+		start := max(idx-contextLines, 0)
+		end := idx
+		for {
+			for end < len(edits) && edits[end].Kind != OpEqual {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].Kind == OpEqual {
+				next++
+			}
+			if next < len(edits) && next-end <= 2*contextLines {
+				end = next
+				continue
+			}
+			end = min(end+contextLines, len(edits))
+			break
+		}
+		oldCount := oldLine[end] - oldLine[start]
+		newCount := newLine[end] - newLine[start]
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldLine[start], oldCount), hunkRange(newLine[start], newCount))
+		for _, edit := range edits[start:end] {
+			switch edit.Kind {
+			case OpEqual:
+				sb.WriteByte(' ')
+			case OpDelete:
+				sb.WriteByte('-')
+			case OpInsert:
+				sb.WriteByte('+')
+			}
+			sb.WriteString(edit.Text)
+			sb.WriteByte('\n')
+		}
+		idx = end
+	}
+	return sb.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,65 @@
+package textdiff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnified(t *testing.T) {
+	from := "game: A\nfile: a.zip\ndeveloper: capcom\n\ngame: B\nfile: b.zip\ndeveloper: CAPCOM\n"
+	to := "game: A\nfile: a.zip\ndeveloper: Capcom\n\ngame: B\nfile: b.zip\ndeveloper: Capcom\n"
+	expected := `--- a/metadata.pegasus.txt
++++ b/metadata.pegasus.txt
+@@ -2,3 +2,3 @@
+ file: a.zip
+-developer: capcom
++developer: Capcom
+ 
+@@ -6,2 +6,2 @@
+ file: b.zip
+-developer: CAPCOM
++developer: Capcom
+`
+	assert.Equal(t, expected, Unified("a/metadata.pegasus.txt", "b/metadata.pegasus.txt", from, to, 1))
+	assert.Equal(t, "", Unified("a", "b", from, from, 3))
+}
+
+func TestUnifiedSeparateHunks(t *testing.T) {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n"
+	to := "1\nX\n3\n4\n5\n6\n7\n"
+	expected := `--- a
++++ b
+@@ -1,3 +1,3 @@
+ 1
+-2
++X
+ 3
+@@ -7,2 +7 @@
+ 7
+-8
+`
+	assert.Equal(t, expected, Unified("a", "b", from, to, 1))
+}
+
+func TestLinesWithoutAnchors(t *testing.T) {
+	a := []string{"x", "y", "x"}
+	b := []string{"y", "x", "y"}
+	edits := Lines(a, b)
+	var oldLines, newLines []string
+	equal := 0
+	for _, edit := range edits {
+		if edit.Kind != OpInsert {
+			oldLines = append(oldLines, edit.Text)
+		}
+		if edit.Kind != OpDelete {
+			newLines = append(newLines, edit.Text)
+		}
+		if edit.Kind == OpEqual {
+			equal++
+		}
+	}
+	assert.Equal(t, a, oldLines)
+	assert.Equal(t, b, newLines)
+	assert.Equal(t, 2, equal)
+}
@@ -35,6 +35,15 @@
   const editCancel = document.getElementById("edit-cancel");
   const editClose = document.getElementById("edit-close");
   const editStatus = document.getElementById("edit-status");
+  const editConflict = document.getElementById("edit-conflict");
+  const editScrapeControls = document.getElementById("edit-scrape-controls");
+  const editScrapePolicy = document.getElementById("edit-scrape-policy");
+  const editScrapeButton = document.getElementById("edit-scrape");
+  const editScrapePanel = document.getElementById("edit-scrape-panel");
+  const collectionConflict = document.getElementById("collection-conflict");
+  const collectionLaunchInput = document.getElementById("collection-launch");
+  const collectionCwdInput = document.getElementById("collection-cwd");
+  const collectionLaunchCheck = document.getElementById("collection-launch-check");
   const collectionSearchInput = document.getElementById("collection-search-input");
   const romInfoButton = document.getElementById("show-rom-info");
   const romInfoModal = document.getElementById("rominfo-modal");
@@ -45,6 +54,50 @@
   const romInfoStatus = document.getElementById("rominfo-status");
   const romInfoCurrentSubroms = document.getElementById("rominfo-current-subroms");
   const moreFieldsButton = document.getElementById("edit-add-field");
+  const orphanButton = document.getElementById("show-orphans");
+  const orphanModal = document.getElementById("orphan-modal");
+  const orphanClose = document.getElementById("orphan-close");
+  const orphanRomList = document.getElementById("orphan-rom-list");
+  const orphanRomAll = document.getElementById("orphan-rom-all");
+  const orphanAdoptButton = document.getElementById("orphan-adopt");
+  const orphanMediaList = document.getElementById("orphan-media-list");
+  const orphanStatus = document.getElementById("orphan-status");
+  const batchButton = document.getElementById("batch-games");
+  const batchModal = document.getElementById("batch-modal");
+  const batchClose = document.getElementById("batch-close");
+  const batchCancel = document.getElementById("batch-cancel");
+  const batchForm = document.getElementById("batch-form");
+  const batchSummary = document.getElementById("batch-summary");
+  const batchOp = document.getElementById("batch-op");
+  const batchField = document.getElementById("batch-field");
+  const batchFieldOptions = document.getElementById("batch-field-options");
+  const batchValues = document.getElementById("batch-values");
+  const batchPattern = document.getElementById("batch-pattern");
+  const batchReplacement = document.getElementById("batch-replacement");
+  const batchRegex = document.getElementById("batch-regex");
+  const batchResults = document.getElementById("batch-results");
+  const batchStatus = document.getElementById("batch-status");
+  const replaceButton = document.getElementById("show-replace");
+  const replaceModal = document.getElementById("replace-modal");
+  const replaceClose = document.getElementById("replace-close");
+  const replaceForm = document.getElementById("replace-form");
+  const replaceScope = document.getElementById("replace-scope");
+  const replaceKeys = document.getElementById("replace-keys");
+  const replaceFind = document.getElementById("replace-find");
+  const replaceReplacement = document.getElementById("replace-replacement");
+  const replaceRegex = document.getElementById("replace-regex");
+  const replaceApplyButton = document.getElementById("replace-apply");
+  const replacePreview = document.getElementById("replace-preview");
+  const replaceStatus = document.getElementById("replace-status");
+  const historyUndoButton = document.getElementById("history-undo");
+  const historyRedoButton = document.getElementById("history-redo");
+  const eventNotice = document.getElementById("event-notice");
+  const showJobsButton = document.getElementById("show-jobs");
+  const jobsModal = document.getElementById("jobs-modal");
+  const jobsClose = document.getElementById("jobs-close");
+  const jobsStart = document.getElementById("jobs-start");
+  const jobsList = document.getElementById("jobs-list");
+  const jobsStatus = document.getElementById("jobs-status");
   const INDEX_FIELD_KEY = "x-index-id";
   const COLLECTION_FIELD_CONFIG = [
     { id: "collection-x-index-id", key: "x-index-id", readonly: true },
@@ -97,7 +150,18 @@
   let editContext = null;
   let collectionEditContext = null;
   let romInfoData = null;
+  let orphanContext = null;
   let currentVirtualId = null;
+  let eventNoticeTimer = null;
+  let jobs = [];
+  let jobKinds = [];
+  let romStatusRenderTimer = null;
+  const clientId = `${Date.now().toString(36)}-${Math.random().toString(36).slice(2, 10)}`;
+  const nativeFetch = window.fetch.bind(window);
+  const csrfToken = readMeta("retrog-csrf");
+  const readonlyMode = readMeta("retrog-readonly") === "1";
+  const scraperName = readMeta("retrog-scraper");
+  const defaultScrapePolicy = readMeta("retrog-scrape-policy") || "fill";
   const expandedVirtuals = new Set();
   const collectionExtensions = new Map();
   const MULTILINE_TEXT_KEYS = new Set(["description", "summary", "desc"]);
@@ -135,6 +199,16 @@
   let searchQuery = "";
   let searchCollectionId = "";
   let showExtraFields = false;
+  let replacePreviewRequest = null;
+  // searchState holds the server side search for the current query: the
+  // matched game ids, the total and the key of the request that produced them.
+  let searchState = { key: "", items: [], total: 0, loading: false, error: "" };
+  let searchTimer = null;
+  let searchSeq = 0;
+  let launchCheckTimer = null;
+  let launchCheckSeq = 0;
+  const SEARCH_PAGE_SIZE = 200;
+  const gameDetailRequests = new Map();
 
   if (moreFieldsButton) {
     moreFieldsButton.textContent = "更多字段";
@@ -167,9 +241,29 @@
       .filter((v) => v.length);
   }
 
+  // fetch tags every request with this tab's id, so the tab can recognise
+  // its own changes in the event stream, and with the CSRF token the server
+  // requires on every write.
+  function fetch(input, options = {}) {
+    const headers = new Headers(options.headers || {});
+    headers.set("X-Retrog-Client", clientId);
+    if (csrfToken) {
+      headers.set("X-Retrog-CSRF", csrfToken);
+    }
+    return nativeFetch(input, { ...options, headers });
+  }
+
+  function readMeta(name) {
+    const el = document.querySelector(`meta[name="${name}"]`);
+    return el ? el.getAttribute("content") || "" : "";
+  }
+
   async function init() {
+    if (readonlyMode) {
+      document.body.classList.add("readonly");
+    }
     try {
-      const res = await fetch("/api/collections");
+      const res = await fetch("api/collections?view=summary");
       if (!res.ok) {
         throw new Error(`HTTP ${res.status}`);
       }
@@ -178,6 +272,9 @@
       populateCollectionFilterOptions();
       renderCollections();
       updateMissingToggleButton();
+      refreshHistory();
+      refreshJobs();
+      connectEvents();
     } catch (err) {
       collectionEmpty.textContent = `加载合集失败: ${err.message}`;
       collectionEmpty.style.display = "block";
@@ -652,6 +749,12 @@
     return isAssetKey(key) || isFileKey(key);
   }
 
+  // thumbnailUrl asks the server for a scaled down copy of an image asset;
+  // the full file stays reachable through asset.url.
+  function thumbnailUrl(url, width) {
+    return `${url}${url.includes("?") ? "&" : "?"}w=${width}`;
+  }
+
   function assetNameFromKey(key) {
     if (!isAssetKey(key)) {
       return "";
@@ -677,6 +780,19 @@
   }
 
   function renderSearchResults(query) {
+    if (searchKey(query) !== searchState.key) {
+      scheduleSearch(query);
+    }
+    if (searchState.key !== searchKey(query) || (searchState.loading && !searchState.items.length)) {
+      gameEmpty.textContent = "搜索中…";
+      gameEmpty.style.display = "block";
+      return;
+    }
+    if (searchState.error) {
+      gameEmpty.textContent = `搜索失败: ${searchState.error}`;
+      gameEmpty.style.display = "block";
+      return;
+    }
     const matches = findMatchingGames(query);
     if (!matches.length) {
       gameEmpty.textContent = "没有匹配的游戏";
@@ -706,11 +822,129 @@
       }
       gameList.appendChild(item);
     });
+    if (searchState.total > searchState.items.length) {
+      const more = document.createElement("li");
+      more.className = "search-more";
+      const button = document.createElement("button");
+      button.type = "button";
+      button.disabled = searchState.loading;
+      button.textContent = searchState.loading
+        ? "加载中…"
+        : `加载更多（${searchState.items.length}/${searchState.total}）`;
+      button.addEventListener("click", () => fetchSearchPage(query, searchState.items.length));
+      more.appendChild(button);
+      gameList.appendChild(more);
+    }
     renderFields();
     renderMedia();
     updateActionButtons();
   }
 
+  function searchKey(query) {
+    return [query, searchCollectionId, showMissingGames ? "1" : "0"].join("\n");
+  }
+
+  // scheduleSearch debounces typing before asking the server for the first
+  // page of matches.
+  function scheduleSearch(query) {
+    if (searchTimer) {
+      clearTimeout(searchTimer);
+    }
+    searchTimer = setTimeout(() => {
+      searchTimer = null;
+      fetchSearchPage(query, 0);
+    }, 250);
+  }
+
+  async function fetchSearchPage(query, offset) {
+    const key = searchKey(query);
+    const seq = ++searchSeq;
+    if (offset === 0) {
+      searchState = { key, items: [], total: 0, loading: true, error: "" };
+    } else {
+      searchState.loading = true;
+    }
+    renderGames();
+    const params = new URLSearchParams({ q: query, offset: String(offset), limit: String(SEARCH_PAGE_SIZE) });
+    if (!showMissingGames) {
+      params.set("missing", "exclude");
+    }
+    if (searchCollectionId) {
+      params.set("collection", searchCollectionId);
+    }
+    try {
+      const res = await fetch(`api/search?${params.toString()}`);
+      if (!res.ok) {
+        throw new Error((await res.text()) || `HTTP ${res.status}`);
+      }
+      const data = await res.json();
+      if (seq !== searchSeq) {
+        return;
+      }
+      const items = (data.games || []).map((item) => ({ id: item.id, collection_id: item.collection_id }));
+      searchState = {
+        key,
+        items: offset === 0 ? items : searchState.items.concat(items),
+        total: data.total || 0,
+        loading: false,
+        error: "",
+      };
+    } catch (err) {
+      if (seq !== searchSeq) {
+        return;
+      }
+      searchState = { ...searchState, key, loading: false, error: err.message };
+    }
+    if ((searchQuery || "").trim().toLowerCase() === query) {
+      renderGames();
+    }
+  }
+
+  // ensureGameDetails loads the fields and assets the summary view leaves
+  // out. Concurrent callers share one request.
+  function ensureGameDetails(game) {
+    if (!game || (Array.isArray(game.fields) && Array.isArray(game.assets))) {
+      return Promise.resolve(game);
+    }
+    if (gameDetailRequests.has(game)) {
+      return gameDetailRequests.get(game);
+    }
+    const request = (async () => {
+      const res = await fetch(`api/games/${encodeURIComponent(game.id)}`);
+      if (!res.ok) {
+        throw new Error((await res.text()) || `HTTP ${res.status}`);
+      }
+      const data = await res.json();
+      Object.assign(game, data.game || {});
+      game.fields = Array.isArray(game.fields) ? game.fields : [];
+      game.assets = Array.isArray(game.assets) ? game.assets : [];
+      return game;
+    })();
+    gameDetailRequests.set(game, request);
+    request.finally(() => gameDetailRequests.delete(game)).catch(() => {});
+    return request;
+  }
+
+  // loadSelectedGameDetails fetches the details of a listed game and renders
+  // the side panels once they arrive, if the game is still selected.
+  function loadSelectedGameDetails(game, emptyEl) {
+    emptyEl.textContent = "加载中…";
+    emptyEl.style.display = "block";
+    ensureGameDetails(game)
+      .then(() => {
+        if (currentGameId === game.id) {
+          renderFields();
+          renderMedia();
+        }
+      })
+      .catch((err) => {
+        if (currentGameId === game.id) {
+          fieldEmpty.textContent = `加载游戏详情失败: ${err.message}`;
+          mediaEmpty.textContent = `加载游戏详情失败: ${err.message}`;
+        }
+      });
+  }
+
   function renderCollectionGames() {
     const coll = getCurrentCollection();
     const virtualGroup = currentVirtualId ? buildVirtualCollections().find((v) => v.id === currentVirtualId) : null;
@@ -959,47 +1193,20 @@
     return field.values.some((value) => value && value.trim().length);
   }
 
+  // findMatchingGames resolves the loaded search results against the local
+  // collections, dropping games that have since been removed or hidden.
   function findMatchingGames(query) {
-    const matches = [];
-    const scopes = searchCollectionId
-      ? collections.filter((c) => c.id === searchCollectionId)
-      : collections;
-    scopes.forEach((collection) => {
-      (collection.games || []).forEach((game) => {
-        if (!shouldDisplayGame(game)) {
-          return;
-        }
-        if (matchesQuery(game, query)) {
-          matches.push({ collection, game });
-        }
-      });
-    });
-    return matches;
-  }
-
-  function matchesQuery(game, query) {
-    const haystacks = [];
-    haystacks.push(game.title || "");
-    haystacks.push(game.display_name || "");
-    haystacks.push(getFieldText(game, ["name", "game", "title"]));
-    haystacks.push(getFieldText(game, ["desc", "description", "summary"]));
-    haystacks.push(getFieldText(game, ["file", "files"]));
-    return haystacks.some((text) => text.toLowerCase().includes(query));
-  }
-
-  function getFieldText(game, keys) {
-    if (!game || !game.fields) {
-      return "";
+    if (searchState.key !== searchKey(query)) {
+      return [];
     }
-    const lowerKeys = new Set(keys.map((k) => k.toLowerCase()));
-    const values = [];
-    game.fields.forEach((field) => {
-      const key = (field.key || "").toLowerCase();
-      if (lowerKeys.has(key)) {
-        values.push(...(field.values || []));
+    const matches = [];
+    searchState.items.forEach((item) => {
+      const { game, collection } = findGameWithCollectionById(item.id);
+      if (game && collection && shouldDisplayGame(game)) {
+        matches.push({ collection, game });
       }
     });
-    return values.join("\n");
+    return matches;
   }
 
   function applyCollectionUpdate(updated) {
@@ -1023,6 +1230,385 @@
     }
     buildCollectionExtensionMap();
     populateCollectionFilterOptions();
+    refreshHistory();
+  }
+
+  function renderHistoryButtons(history) {
+    const undo = (history && history.undo) || [];
+    const redo = (history && history.redo) || [];
+    if (historyUndoButton) {
+      historyUndoButton.disabled = !undo.length;
+      historyUndoButton.title = undo.length ? `撤销: ${undo[0].label} (${formatHistoryTime(undo[0].time)})` : "没有可撤销的操作";
+    }
+    if (historyRedoButton) {
+      historyRedoButton.disabled = !redo.length;
+      historyRedoButton.title = redo.length ? `重做: ${redo[0].label} (${formatHistoryTime(redo[0].time)})` : "没有可重做的操作";
+    }
+  }
+
+  function formatHistoryTime(value) {
+    const date = new Date(value);
+    return Number.isNaN(date.getTime()) ? value || "" : date.toLocaleTimeString();
+  }
+
+  async function refreshHistory() {
+    try {
+      const res = await fetch("api/history");
+      if (!res.ok) {
+        return;
+      }
+      renderHistoryButtons(await res.json());
+    } catch (err) {
+      console.warn("load history failed", err);
+    }
+  }
+
+  async function replayHistory(action) {
+    const button = action === "undo" ? historyUndoButton : historyRedoButton;
+    const label = action === "undo" ? "撤销" : "重做";
+    if (button) {
+      button.disabled = true;
+    }
+    try {
+      const res = await fetch(`api/history/${action}`, { method: "POST" });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || `${label}失败`);
+      }
+      const data = await res.json();
+      if (Array.isArray(data.collections)) {
+        collections = data.collections;
+        buildCollectionExtensionMap();
+        populateCollectionFilterOptions();
+      }
+      renderCollections();
+      renderGames();
+      renderFields();
+      renderMedia();
+      renderHistoryButtons(data.history);
+    } catch (err) {
+      window.alert(`${label}失败: ${err.message || err}`);
+      refreshHistory();
+    }
+  }
+
+  function connectEvents() {
+    if (typeof EventSource === "undefined") {
+      return;
+    }
+    const source = new EventSource("api/events");
+    let disconnected = false;
+    source.addEventListener("open", () => {
+      if (disconnected) {
+        disconnected = false;
+        reloadAllCollections();
+      }
+    });
+    source.addEventListener("error", () => {
+      disconnected = true;
+    });
+    source.addEventListener("collections.changed", (evt) => {
+      const data = parseEventData(evt);
+      if (data && data.client !== clientId) {
+        applyCollectionsChanged(data);
+      }
+    });
+    source.addEventListener("romcheck.progress", (evt) => {
+      const data = parseEventData(evt);
+      if (!data) {
+        return;
+      }
+      if (data.state === "progress") {
+        applyRomStatusPatches(data.games);
+      } else {
+        showRomCheckProgress(data);
+      }
+    });
+    source.addEventListener("job.updated", (evt) => {
+      const data = parseEventData(evt);
+      if (data) {
+        upsertJob(data);
+      }
+    });
+  }
+
+  function parseEventData(evt) {
+    try {
+      return JSON.parse(evt.data);
+    } catch (err) {
+      return null;
+    }
+  }
+
+  function showEventNotice(message, isError = false) {
+    if (!eventNotice) {
+      return;
+    }
+    eventNotice.textContent = message;
+    eventNotice.classList.toggle("error", isError);
+    eventNotice.classList.remove("hidden");
+    if (eventNoticeTimer) {
+      clearTimeout(eventNoticeTimer);
+    }
+    eventNoticeTimer = setTimeout(() => {
+      eventNotice.classList.add("hidden");
+      eventNoticeTimer = null;
+    }, 4000);
+  }
+
+  function showRomCheckProgress(data) {
+    const family = data.family || "rom";
+    if (data.state === "started") {
+      showEventNotice(data.files ? `正在校验 ROM (${family}, ${data.files} 个文件)…` : `正在校验 ROM (${family})…`);
+    } else if (data.state === "finished") {
+      showEventNotice(`ROM 校验完成 (${family})`);
+    } else if (data.state === "failed") {
+      showEventNotice(`ROM 校验失败 (${family}): ${data.error || ""}`, true);
+    }
+  }
+
+  // applyRomStatusPatches fills in ROM check results as they arrive. The
+  // lists are re-rendered at most twice per second.
+  function applyRomStatusPatches(patches) {
+    if (!Array.isArray(patches) || !patches.length) {
+      return;
+    }
+    const byPath = new Map();
+    patches.forEach((patch) => {
+      if (!byPath.has(patch.metadata_path)) {
+        byPath.set(patch.metadata_path, new Map());
+      }
+      byPath.get(patch.metadata_path).set(patch.x_index_id, patch);
+    });
+    collections.forEach((coll) => {
+      const games = byPath.get(coll.metadata_path);
+      if (!games) {
+        return;
+      }
+      (coll.games || []).forEach((game) => {
+        const patch = games.get(game.x_index_id);
+        if (patch) {
+          game.rom_status = patch.rom_status;
+          game.rom_status_emoji = patch.rom_status_emoji;
+        }
+      });
+    });
+    if (!romStatusRenderTimer) {
+      romStatusRenderTimer = setTimeout(() => {
+        romStatusRenderTimer = null;
+        renderGames();
+        renderFields();
+      }, 500);
+    }
+  }
+
+  async function refreshJobs() {
+    try {
+      const res = await fetch("api/jobs");
+      if (!res.ok) {
+        return;
+      }
+      const data = await res.json();
+      jobs = Array.isArray(data.jobs) ? data.jobs : [];
+      jobKinds = Array.isArray(data.kinds) ? data.kinds : [];
+      renderJobs();
+    } catch (err) {
+      // the jobs panel is optional
+    }
+  }
+
+  function upsertJob(job) {
+    const idx = jobs.findIndex((item) => item.id === job.id);
+    if (idx === -1) {
+      jobs.unshift(job);
+    } else {
+      jobs[idx] = job;
+    }
+    renderJobs();
+  }
+
+  function renderJobs() {
+    const running = jobs.filter((job) => job.state === "running");
+    if (showJobsButton) {
+      showJobsButton.textContent = running.length ? `任务 (${running.length})` : "任务";
+    }
+    if (jobsStart) {
+      jobsStart.innerHTML = "";
+      jobKinds.forEach((kind) => {
+        const button = document.createElement("button");
+        button.type = "button";
+        button.textContent = `运行${kind.label}`;
+        button.disabled = running.some((job) => job.kind === kind.kind);
+        button.addEventListener("click", () => startJob(kind.kind));
+        jobsStart.appendChild(button);
+      });
+    }
+    if (!jobsList) {
+      return;
+    }
+    jobsList.innerHTML = "";
+    if (!jobs.length) {
+      const empty = document.createElement("li");
+      empty.className = "job-meta";
+      empty.textContent = "暂无任务";
+      jobsList.appendChild(empty);
+      return;
+    }
+    const stateLabels = { running: "运行中", succeeded: "已完成", failed: "失败", canceled: "已取消" };
+    jobs.forEach((job) => {
+      const item = document.createElement("li");
+      item.className = "job-item";
+      const head = document.createElement("div");
+      head.className = "job-head";
+      const title = document.createElement("strong");
+      title.textContent = `#${job.id} ${job.label}`;
+      head.appendChild(title);
+      if (job.state === "running") {
+        const cancel = document.createElement("button");
+        cancel.type = "button";
+        cancel.className = "ghost danger";
+        cancel.textContent = "取消";
+        cancel.addEventListener("click", () => cancelJob(job.id));
+        head.appendChild(cancel);
+      }
+      item.appendChild(head);
+      const bar = document.createElement("div");
+      bar.className = "job-progress";
+      const fill = document.createElement("span");
+      fill.style.width = `${Math.min(100, Math.max(0, job.progress || 0)).toFixed(1)}%`;
+      bar.appendChild(fill);
+      item.appendChild(bar);
+      const meta = document.createElement("div");
+      meta.className = job.state === "failed" ? "job-meta error" : "job-meta";
+      const parts = [stateLabels[job.state] || job.state, `${Math.floor(job.progress || 0)}%`];
+      if (job.message) {
+        parts.push(job.message);
+      }
+      if (job.error) {
+        parts.push(job.error);
+      }
+      meta.textContent = parts.join(" · ");
+      item.appendChild(meta);
+      jobsList.appendChild(item);
+    });
+  }
+
+  async function startJob(kind) {
+    setJobsStatus("");
+    try {
+      const res = await fetch("api/jobs", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ kind }),
+      });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "启动任务失败");
+      }
+      upsertJob(await res.json());
+    } catch (err) {
+      setJobsStatus(err.message || "启动任务失败", true);
+    }
+  }
+
+  async function cancelJob(id) {
+    setJobsStatus("");
+    try {
+      const res = await fetch(`api/jobs/${encodeURIComponent(id)}/cancel`, { method: "POST" });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "取消任务失败");
+      }
+      upsertJob(await res.json());
+    } catch (err) {
+      setJobsStatus(err.message || "取消任务失败", true);
+    }
+  }
+
+  function setJobsStatus(message, isError = false) {
+    if (!jobsStatus) {
+      return;
+    }
+    jobsStatus.textContent = message;
+    jobsStatus.style.color = isError ? "#ff8a8a" : "var(--text-muted)";
+  }
+
+  function openJobsModal() {
+    if (!jobsModal) {
+      return;
+    }
+    setJobsStatus("");
+    renderJobs();
+    refreshJobs();
+    jobsModal.classList.remove("hidden");
+  }
+
+  function closeJobsModal() {
+    if (jobsModal) {
+      jobsModal.classList.add("hidden");
+    }
+  }
+
+  async function reloadAllCollections() {
+    try {
+      const res = await fetch("api/collections?view=summary");
+      if (!res.ok) {
+        throw new Error(`HTTP ${res.status}`);
+      }
+      collections = await res.json();
+      searchState.key = "";
+      buildCollectionExtensionMap();
+      populateCollectionFilterOptions();
+      renderCollections();
+      renderGames();
+      renderFields();
+      renderMedia();
+      refreshHistory();
+      refreshJobs();
+      showEventNotice("已重新连接服务器，数据已刷新");
+    } catch (err) {
+      showEventNotice(`刷新合集失败: ${err.message}`, true);
+    }
+  }
+
+  // applyCollectionsChanged swaps in the collections of metadata files that
+  // were changed in another tab or on disk.
+  function applyCollectionsChanged(data) {
+    const updated = Array.isArray(data.collections) ? data.collections : [];
+    const replaced = new Set(data.removed || []);
+    updated.forEach((coll) => replaced.add(coll.metadata_path));
+    if (!replaced.size) {
+      return;
+    }
+    const next = [];
+    let inserted = false;
+    collections.forEach((coll) => {
+      if (!replaced.has(coll.metadata_path)) {
+        next.push(coll);
+        return;
+      }
+      if (!inserted) {
+        next.push(...updated);
+        inserted = true;
+      }
+    });
+    if (!inserted) {
+      next.push(...updated);
+    }
+    collections = next;
+    searchState.key = "";
+    buildCollectionExtensionMap();
+    populateCollectionFilterOptions();
+    renderCollections();
+    renderGames();
+    renderFields();
+    renderMedia();
+    refreshHistory();
+    if (data.source === "disk") {
+      showEventNotice(`检测到磁盘变更，已重新加载 ${replaced.size} 个 metadata`);
+    } else {
+      showEventNotice(`其他窗口已修改: ${data.label || "数据"}`);
+    }
   }
 
   function getUsedKeys() {
@@ -1233,7 +1819,7 @@
     }
     if (asset.type === "image") {
       const img = document.createElement("img");
-      img.src = asset.url;
+      img.src = thumbnailUrl(asset.url, 512);
       img.alt = asset.name;
       previewEl.appendChild(img);
     } else if (asset.type === "video") {
@@ -1262,7 +1848,7 @@
     }
     if (asset.type === "image") {
       const img = document.createElement("img");
-      img.src = asset.url;
+      img.src = thumbnailUrl(asset.url, 512);
       img.alt = asset.name || asset.file_name || "";
       container.appendChild(img);
       return;
@@ -1356,7 +1942,7 @@
     formData.append("field", key);
     formData.append("file", file);
     try {
-      const res = await fetch("/api/games/upload", {
+      const res = await fetch("api/games/upload", {
         method: "POST",
         body: formData,
       });
@@ -1367,6 +1953,9 @@
           duplicateError.isDuplicate = true;
           throw duplicateError;
         }
+        if (res.status === 422) {
+          throw new Error(`媒体文件不符合要求: ${text.replace(/^media rejected:\s*/, "").trim()}`);
+        }
         throw new Error(text || "上传失败");
       }
       const data = await res.json();
@@ -1415,7 +2004,11 @@
       fieldEmpty.style.display = "block";
       return;
     }
-    if (!game.fields || !game.fields.length) {
+    if (!Array.isArray(game.fields)) {
+      loadSelectedGameDetails(game, fieldEmpty);
+      return;
+    }
+    if (!game.fields.length) {
       fieldEmpty.textContent = "该游戏没有额外字段";
       fieldEmpty.style.display = "block";
       return;
@@ -1445,7 +2038,11 @@
       mediaEmpty.style.display = "block";
       return;
     }
-    if (!game.assets || !game.assets.length) {
+    if (!Array.isArray(game.assets)) {
+      loadSelectedGameDetails(game, mediaEmpty);
+      return;
+    }
+    if (!game.assets.length) {
       mediaEmpty.textContent = "该游戏没有媒体文件";
       mediaEmpty.style.display = "block";
       return;
@@ -1458,10 +2055,16 @@
       title.textContent = `${asset.name} (${asset.file_name || ""})`;
       card.appendChild(title);
       if (asset.type === "image") {
+        const link = document.createElement("a");
+        link.href = asset.url;
+        link.target = "_blank";
+        link.rel = "noreferrer";
         const img = document.createElement("img");
-        img.src = asset.url;
+        img.src = thumbnailUrl(asset.url, 512);
         img.alt = asset.name;
-        card.appendChild(img);
+        img.loading = "lazy";
+        link.appendChild(img);
+        card.appendChild(link);
       } else if (asset.type === "video") {
         const video = document.createElement("video");
         video.src = asset.url;
@@ -1493,7 +2096,21 @@
       setEditStatus("该游戏缺少 ROM，无法编辑", true);
       return;
     }
+    const target = gameOverride || baseContext.game;
+    if (!Array.isArray(target.fields)) {
+      setEditStatus("正在加载游戏详情…");
+      ensureGameDetails(target)
+        .then(() => openEditModal(gameOverride, contextOverride))
+        .catch((err) => setEditStatus(`加载游戏详情失败: ${err.message}`, true));
+      return;
+    }
     showExtraFields = false;
+    hideConflict(editConflict);
+    hideConflict(editScrapePanel);
+    if (editScrapeControls) {
+      editScrapeControls.classList.toggle("hidden", !scraperName || Boolean(baseContext.isNew));
+      editScrapePolicy.value = defaultScrapePolicy;
+    }
     editContext = { ...baseContext };
     removedFields = [];
     populateEditFields(gameOverride || baseContext.game);
@@ -1560,7 +2177,7 @@
       if (romInfoModal) {
         romInfoModal.classList.remove("hidden");
       }
-      const res = await fetch(`/api/games/rominfo?${params.toString()}`);
+      const res = await fetch(`api/games/rominfo?${params.toString()}`);
       if (!res.ok) {
         const text = await res.text();
         throw new Error(text || "获取 ROM 信息失败");
@@ -2009,6 +2626,30 @@
     romInfoStatus.style.color = isError ? "#ff8a8a" : "var(--text-muted)";
   }
 
+  function setOrphanStatus(message, isError = false) {
+    if (!orphanStatus) {
+      return;
+    }
+    orphanStatus.textContent = message || "";
+    orphanStatus.style.color = isError ? "#ff8a8a" : "var(--text-muted)";
+  }
+
+  function setBatchStatus(message, isError = false) {
+    if (!batchStatus) {
+      return;
+    }
+    batchStatus.textContent = message || "";
+    batchStatus.classList.toggle("error", Boolean(isError));
+  }
+
+  function setReplaceStatus(message, isError = false) {
+    if (!replaceStatus) {
+      return;
+    }
+    replaceStatus.textContent = message || "";
+    replaceStatus.classList.toggle("error", Boolean(isError));
+  }
+
   function updateActionButtons() {
     const context = getCurrentSelectionContext();
     const hasSelection = Boolean(context);
@@ -2036,7 +2677,18 @@
       deleteButton.classList.toggle("disabled", disableDelete);
       deleteButton.title = isMissing ? "缺失 ROM 的游戏仅支持查看" : "";
     }
-    if (editCollectionButton) {
+    if (orphanButton) {
+      orphanButton.disabled = disableCollectionEdit;
+      orphanButton.classList.toggle("disabled", disableCollectionEdit);
+      orphanButton.title = disableCollectionEdit ? "请选择真实目录查看孤立文件" : "";
+    }
+    if (batchButton) {
+      const disableBatch = !collectVisibleGames().length;
+      batchButton.disabled = disableBatch;
+      batchButton.classList.toggle("disabled", disableBatch);
+      batchButton.title = disableBatch ? "当前列表没有可编辑的游戏" : "";
+    }
+    if (editCollectionButton) {
       editCollectionButton.disabled = disableCollectionEdit;
       editCollectionButton.classList.toggle("disabled", disableCollectionEdit);
       editCollectionButton.title = disableCollectionEdit ? "虚拟目录不可编辑合集" : "";
@@ -2086,9 +2738,11 @@
       setCollectionStatus("请选择需要编辑的合集", true);
       return;
     }
+    hideConflict(collectionConflict);
     collectionEditContext = {
       metadata_path: collection.metadata_path,
       x_index_id: collection.x_index_id,
+      revision: collection.revision || "",
       originalFields: Array.isArray(collection.fields)
         ? collection.fields.map((field) => ({
           key: field?.key || "",
@@ -2099,6 +2753,7 @@
     populateCollectionForm(collection);
     setCollectionStatus("");
     collectionModal.classList.remove("hidden");
+    runLaunchCheck();
   }
 
   function closeCollectionModal() {
@@ -2110,6 +2765,100 @@
     }
     collectionEditContext = null;
     setCollectionStatus("");
+    launchCheckSeq++;
+    renderLaunchCheck(null);
+  }
+
+  // scheduleLaunchCheck debounces typing in launch and cwd before asking the
+  // server to dry-run the command.
+  function scheduleLaunchCheck() {
+    if (launchCheckTimer) {
+      clearTimeout(launchCheckTimer);
+    }
+    launchCheckTimer = setTimeout(() => {
+      launchCheckTimer = null;
+      runLaunchCheck();
+    }, 400);
+  }
+
+  async function runLaunchCheck() {
+    if (!collectionLaunchCheck || !collectionEditContext || readonlyMode) {
+      return;
+    }
+    const seq = ++launchCheckSeq;
+    const launch = collectionLaunchInput ? collectionLaunchInput.value : "";
+    if (!launch.trim()) {
+      renderLaunchCheck(null);
+      return;
+    }
+    try {
+      const res = await fetch("api/collections/launch-check", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          metadata_path: collectionEditContext.metadata_path,
+          x_index_id: collectionEditContext.x_index_id,
+          launch,
+          workdir: collectionCwdInput ? collectionCwdInput.value : "",
+        }),
+      });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "检查失败");
+      }
+      const data = await res.json();
+      if (seq === launchCheckSeq) {
+        renderLaunchCheck(data);
+      }
+    } catch (err) {
+      if (seq === launchCheckSeq) {
+        renderLaunchCheck({ problems: [err.message || "检查失败"] });
+      }
+    }
+  }
+
+  function renderLaunchCheck(result) {
+    if (!collectionLaunchCheck) {
+      return;
+    }
+    collectionLaunchCheck.innerHTML = "";
+    if (!result) {
+      collectionLaunchCheck.classList.add("hidden");
+      return;
+    }
+    const addLine = (label, value) => {
+      const line = document.createElement("p");
+      line.appendChild(document.createTextNode(`${label}: `));
+      const code = document.createElement("code");
+      code.textContent = value;
+      line.appendChild(code);
+      collectionLaunchCheck.appendChild(line);
+    };
+    if (result.core) {
+      addLine("核心", result.core);
+    }
+    if (Array.isArray(result.args) && result.args.length) {
+      addLine("示例", result.args.map((arg) => (/[\s"']/.test(arg) || !arg ? JSON.stringify(arg) : arg)).join(" "));
+    }
+    if (result.workdir) {
+      addLine("工作目录", result.workdir);
+    }
+    const problems = Array.isArray(result.problems) ? result.problems : [];
+    if (problems.length) {
+      const list = document.createElement("ul");
+      problems.forEach((problem) => {
+        const item = document.createElement("li");
+        item.textContent = problem;
+        list.appendChild(item);
+      });
+      collectionLaunchCheck.appendChild(list);
+    } else {
+      const ok = document.createElement("p");
+      ok.className = "ok";
+      ok.textContent = "启动命令检查通过";
+      collectionLaunchCheck.appendChild(ok);
+    }
+    collectionLaunchCheck.classList.remove("hidden");
   }
 
   function populateCollectionForm(collection) {
@@ -2173,6 +2922,856 @@
     return "";
   }
 
+  async function openOrphanModal(collection) {
+    if (!orphanModal || !collection) {
+      return;
+    }
+    orphanContext = {
+      collectionId: collection.id,
+      metadata_path: collection.metadata_path,
+    };
+    renderOrphans(null);
+    orphanModal.classList.remove("hidden");
+    setOrphanStatus("扫描中...");
+    try {
+      const params = new URLSearchParams({ metadata_path: collection.metadata_path });
+      const res = await fetch(`api/collections/orphans?${params.toString()}`);
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "扫描失败");
+      }
+      const data = await res.json();
+      renderOrphans(Array.isArray(data) ? data[0] : null);
+      setOrphanStatus("");
+    } catch (err) {
+      setOrphanStatus(err.message || "扫描失败", true);
+    }
+  }
+
+  function closeOrphanModal() {
+    if (orphanModal) {
+      orphanModal.classList.add("hidden");
+    }
+    orphanContext = null;
+    setOrphanStatus("");
+  }
+
+  function renderOrphans(data) {
+    if (orphanRomList) {
+      orphanRomList.innerHTML = "";
+    }
+    if (orphanMediaList) {
+      orphanMediaList.innerHTML = "";
+    }
+    if (orphanRomAll) {
+      orphanRomAll.checked = false;
+    }
+    if (!data) {
+      return;
+    }
+    const roms = Array.isArray(data.roms) ? data.roms : [];
+    const media = Array.isArray(data.media) ? data.media : [];
+    if (orphanRomList) {
+      if (!roms.length) {
+        orphanRomList.appendChild(createOrphanEmptyItem("没有未登记的 ROM"));
+      }
+      roms.forEach((rom) => {
+        const item = document.createElement("li");
+        const checkbox = document.createElement("input");
+        checkbox.type = "checkbox";
+        checkbox.value = rom.file;
+        item.appendChild(checkbox);
+        item.appendChild(createOrphanMainText(rom.title || rom.file, rom.rel_path || rom.file));
+        orphanRomList.appendChild(item);
+      });
+    }
+    if (orphanMediaList) {
+      if (!media.length) {
+        orphanMediaList.appendChild(createOrphanEmptyItem("没有未关联的媒体目录"));
+      }
+      media.forEach((folder) => {
+        orphanMediaList.appendChild(createOrphanMediaItem(folder));
+      });
+    }
+  }
+
+  function createOrphanEmptyItem(text) {
+    const item = document.createElement("li");
+    item.className = "empty";
+    item.textContent = text;
+    return item;
+  }
+
+  function createOrphanMainText(title, detail) {
+    const main = document.createElement("div");
+    main.className = "orphan-item-main";
+    const titleEl = document.createElement("span");
+    titleEl.textContent = title || "";
+    const detailEl = document.createElement("span");
+    detailEl.textContent = detail || "";
+    main.appendChild(titleEl);
+    main.appendChild(detailEl);
+    return main;
+  }
+
+  function createOrphanMediaItem(folder) {
+    const item = document.createElement("li");
+    const thumbs = document.createElement("div");
+    thumbs.className = "orphan-thumbs";
+    (folder.assets || [])
+      .filter((asset) => asset && asset.type === "image")
+      .slice(0, 3)
+      .forEach((asset) => {
+        const img = document.createElement("img");
+        img.src = thumbnailUrl(asset.url, 128);
+        img.alt = asset.file_name || "";
+        img.loading = "lazy";
+        thumbs.appendChild(img);
+      });
+    item.appendChild(thumbs);
+    item.appendChild(createOrphanMainText(folder.path || folder.name, (folder.files || []).join(", ")));
+    const select = document.createElement("select");
+    const placeholder = document.createElement("option");
+    placeholder.value = "";
+    placeholder.textContent = "关联到游戏...";
+    select.appendChild(placeholder);
+    const collection = collections.find((c) => orphanContext && c.id === orphanContext.collectionId);
+    ((collection && collection.games) || []).forEach((game) => {
+      const option = document.createElement("option");
+      option.value = String(game.x_index_id);
+      option.textContent = `${game.title || ""} (${game.rel_rom_path || game.rom_path || ""})`;
+      select.appendChild(option);
+    });
+    const attachButton = document.createElement("button");
+    attachButton.type = "button";
+    attachButton.textContent = "关联";
+    attachButton.addEventListener("click", () => {
+      const xIndexId = Number(select.value);
+      if (!xIndexId) {
+        setOrphanStatus("请选择需要关联的游戏", true);
+        return;
+      }
+      submitOrphanMediaAction(folder.name, "attach", xIndexId);
+    });
+    const deleteButtonEl = document.createElement("button");
+    deleteButtonEl.type = "button";
+    deleteButtonEl.className = "danger";
+    deleteButtonEl.textContent = "删除";
+    deleteButtonEl.addEventListener("click", () => {
+      if (!window.confirm(`确定删除媒体目录 ${folder.path || folder.name} ?`)) {
+        return;
+      }
+      submitOrphanMediaAction(folder.name, "delete", 0);
+    });
+    item.appendChild(select);
+    item.appendChild(attachButton);
+    item.appendChild(deleteButtonEl);
+    return item;
+  }
+
+  function applyOrphanActionResult(data) {
+    applyCollectionUpdate(data.collection);
+    renderCollections();
+    renderGames();
+    renderFields();
+    renderMedia();
+    renderOrphans(data.orphans);
+  }
+
+  async function submitOrphanAdoption() {
+    if (!orphanContext || !orphanRomList) {
+      return;
+    }
+    const files = Array.from(orphanRomList.querySelectorAll("input[type=checkbox]:checked")).map(
+      (input) => input.value,
+    );
+    if (!files.length) {
+      setOrphanStatus("请选择需要创建的 ROM", true);
+      return;
+    }
+    setOrphanStatus("创建中...");
+    try {
+      const res = await fetch("api/collections/orphans/adopt", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ metadata_path: orphanContext.metadata_path, files }),
+      });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "创建失败");
+      }
+      const data = await res.json();
+      applyOrphanActionResult(data);
+      const created = Array.isArray(data.created) ? data.created.length : 0;
+      const failed = Array.isArray(data.failed) ? data.failed : [];
+      if (failed.length) {
+        setOrphanStatus(
+          `已创建 ${created} 个游戏, 失败 ${failed.length} 个: ${failed.map((f) => `${f.file}(${f.message})`).join(", ")}`,
+          true,
+        );
+      } else {
+        setOrphanStatus(`已创建 ${created} 个游戏`);
+      }
+    } catch (err) {
+      setOrphanStatus(err.message || "创建失败", true);
+    }
+  }
+
+  async function submitOrphanMediaAction(name, action, xIndexId) {
+    if (!orphanContext) {
+      return;
+    }
+    setOrphanStatus("处理中...");
+    try {
+      const res = await fetch("api/collections/orphans/media", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          metadata_path: orphanContext.metadata_path,
+          name,
+          action,
+          x_index_id: xIndexId,
+        }),
+      });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "处理失败");
+      }
+      const data = await res.json();
+      applyOrphanActionResult(data);
+      setOrphanStatus(action === "delete" ? "已删除媒体目录" : "已关联媒体目录");
+    } catch (err) {
+      setOrphanStatus(err.message || "处理失败", true);
+    }
+  }
+
+  // collectVisibleGames mirrors renderGames and returns the games currently
+  // listed, which are the targets of a batch edit.
+  function collectVisibleGames() {
+    const query = (searchQuery || "").trim().toLowerCase();
+    if (query) {
+      return findMatchingGames(query);
+    }
+    const coll = getCurrentCollection();
+    if (coll) {
+      return (coll.games || []).filter((game) => shouldDisplayGame(game)).map((game) => ({ game, collection: coll }));
+    }
+    const virtualGroup = currentVirtualId ? buildVirtualCollections().find((v) => v.id === currentVirtualId) : null;
+    if (!virtualGroup) {
+      return [];
+    }
+    const merged = [];
+    virtualGroup.children.forEach((child) => {
+      (child.games || []).forEach((game) => {
+        if (shouldDisplayGame(game)) {
+          merged.push({ game, collection: child });
+        }
+      });
+    });
+    return merged;
+  }
+
+  function buildBatchTargets(entries) {
+    const grouped = new Map();
+    entries.forEach(({ game, collection }) => {
+      if (!collection || !collection.metadata_path || !game.x_index_id) {
+        return;
+      }
+      if (!grouped.has(collection.metadata_path)) {
+        grouped.set(collection.metadata_path, []);
+      }
+      grouped.get(collection.metadata_path).push(game.x_index_id);
+    });
+    return Array.from(grouped.entries()).map(([metadataPath, ids]) => ({
+      metadata_path: metadataPath,
+      x_index_ids: ids,
+    }));
+  }
+
+  function updateBatchFormMode() {
+    const isReplace = batchOp && batchOp.value === "replace";
+    if (batchForm) {
+      batchForm.querySelectorAll(".batch-replace").forEach((el) => el.classList.toggle("hidden", !isReplace));
+      batchForm.querySelectorAll(".batch-values").forEach((el) => el.classList.toggle("hidden", isReplace));
+    }
+  }
+
+  function openBatchModal() {
+    if (!batchModal) {
+      return;
+    }
+    const entries = collectVisibleGames();
+    if (!entries.length) {
+      return;
+    }
+    if (batchSummary) {
+      batchSummary.textContent = `将修改当前列表中的 ${entries.length} 个游戏`;
+    }
+    if (batchFieldOptions) {
+      batchFieldOptions.innerHTML = "";
+      KNOWN_GAME_FIELDS.filter((key) => key !== INDEX_FIELD_KEY).forEach((key) => {
+        const option = document.createElement("option");
+        option.value = key;
+        batchFieldOptions.appendChild(option);
+      });
+    }
+    if (batchResults) {
+      batchResults.innerHTML = "";
+    }
+    updateBatchFormMode();
+    setBatchStatus("");
+    batchModal.classList.remove("hidden");
+  }
+
+  function closeBatchModal() {
+    if (batchModal) {
+      batchModal.classList.add("hidden");
+    }
+    setBatchStatus("");
+  }
+
+  function renderBatchResults(results) {
+    if (!batchResults) {
+      return;
+    }
+    batchResults.innerHTML = "";
+    results
+      .filter((res) => res.changed || res.error)
+      .forEach((res) => {
+        const item = document.createElement("li");
+        const detail = res.error ? `失败: ${res.error}` : "已修改";
+        item.appendChild(createOrphanMainText(res.title || `#${res.x_index_id}`, detail));
+        item.classList.toggle("error", Boolean(res.error));
+        batchResults.appendChild(item);
+      });
+  }
+
+  async function handleBatchSubmit(event) {
+    event.preventDefault();
+    const targets = buildBatchTargets(collectVisibleGames());
+    if (!targets.length) {
+      setBatchStatus("当前列表没有可编辑的游戏", true);
+      return;
+    }
+    const field = (batchField.value || "").trim();
+    if (!field) {
+      setBatchStatus("请填写字段名", true);
+      return;
+    }
+    const operation = { op: batchOp.value, field };
+    if (operation.op === "replace") {
+      operation.pattern = batchPattern.value || "";
+      operation.replacement = batchReplacement.value || "";
+      operation.regex = Boolean(batchRegex.checked);
+      if (!operation.pattern) {
+        setBatchStatus("请填写需要查找的内容", true);
+        return;
+      }
+    } else {
+      operation.values = (batchValues.value || "")
+        .split("\n")
+        .map((v) => v.trim())
+        .filter(Boolean);
+      if (operation.op !== "set" && !operation.values.length) {
+        setBatchStatus("请填写字段值", true);
+        return;
+      }
+    }
+    setBatchStatus("保存中...");
+    try {
+      const res = await fetch("api/games/batch", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ targets, operations: [operation] }),
+      });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "批量修改失败");
+      }
+      const data = await res.json();
+      (data.collections || []).forEach((coll) => applyCollectionUpdate(coll));
+      renderCollections();
+      renderGames();
+      renderFields();
+      renderMedia();
+      const results = Array.isArray(data.results) ? data.results : [];
+      renderBatchResults(results);
+      const changed = results.filter((r) => r.changed).length;
+      const failed = results.filter((r) => r.error).length;
+      setBatchStatus(`已修改 ${changed} 个游戏${failed ? `, 失败 ${failed} 个` : ""}`, failed > 0);
+    } catch (err) {
+      setBatchStatus(err.message || "批量修改失败", true);
+    }
+  }
+
+  function openReplaceModal() {
+    if (!replaceModal) {
+      return;
+    }
+    const collection = getCurrentCollection();
+    if (replaceScope) {
+      const currentOption = replaceScope.querySelector("option[value=current]");
+      if (currentOption) {
+        currentOption.disabled = !collection;
+        currentOption.textContent = collection ? `当前合集: ${collection.display_name || collection.name}` : "当前合集";
+      }
+      if (!collection) {
+        replaceScope.value = "";
+      }
+    }
+    resetReplacePreview();
+    setReplaceStatus("");
+    replaceModal.classList.remove("hidden");
+  }
+
+  function closeReplaceModal() {
+    if (replaceModal) {
+      replaceModal.classList.add("hidden");
+    }
+    resetReplacePreview();
+    setReplaceStatus("");
+  }
+
+  function resetReplacePreview() {
+    replacePreviewRequest = null;
+    if (replacePreview) {
+      replacePreview.innerHTML = "";
+    }
+    if (replaceApplyButton) {
+      replaceApplyButton.disabled = true;
+    }
+  }
+
+  function buildReplaceRequest() {
+    const keys = (replaceKeys.value || "")
+      .split(",")
+      .map((v) => v.trim())
+      .filter(Boolean);
+    if (!keys.length) {
+      throw new Error("请填写需要替换的字段");
+    }
+    if (!replaceFind.value) {
+      throw new Error("请填写查找内容");
+    }
+    const payload = {
+      keys,
+      find: replaceFind.value,
+      replacement: replaceReplacement.value || "",
+      regex: Boolean(replaceRegex.checked),
+    };
+    const collection = getCurrentCollection();
+    if (replaceScope && replaceScope.value === "current" && collection) {
+      payload.metadata_path = collection.metadata_path;
+    }
+    return payload;
+  }
+
+  async function postReplaceRequest(payload) {
+    const res = await fetch("api/metadata/replace", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(payload),
+    });
+    if (!res.ok) {
+      const text = await res.text();
+      throw new Error(text || "替换失败");
+    }
+    return res.json();
+  }
+
+  function renderReplacePreview(files) {
+    if (!replacePreview) {
+      return;
+    }
+    replacePreview.innerHTML = "";
+    files.forEach((file) => {
+      const section = document.createElement("div");
+      const title = document.createElement("h4");
+      title.textContent = `${file.rel_path} (${file.count} 处)`;
+      const pre = document.createElement("pre");
+      pre.className = "replace-diff";
+      (file.diff || "").split("\n").forEach((line) => {
+        const span = document.createElement("span");
+        if (line.startsWith("@@")) {
+          span.className = "hunk";
+        } else if (line.startsWith("+") && !line.startsWith("+++")) {
+          span.className = "add";
+        } else if (line.startsWith("-") && !line.startsWith("---")) {
+          span.className = "del";
+        }
+        span.textContent = `${line}\n`;
+        pre.appendChild(span);
+      });
+      section.appendChild(title);
+      section.appendChild(pre);
+      replacePreview.appendChild(section);
+    });
+  }
+
+  async function handleReplacePreview(event) {
+    event.preventDefault();
+    resetReplacePreview();
+    let payload;
+    try {
+      payload = buildReplaceRequest();
+    } catch (err) {
+      setReplaceStatus(err.message, true);
+      return;
+    }
+    setReplaceStatus("预览中...");
+    try {
+      const data = await postReplaceRequest(payload);
+      const files = Array.isArray(data.files) ? data.files : [];
+      renderReplacePreview(files);
+      if (!files.length) {
+        setReplaceStatus("没有匹配的内容");
+        return;
+      }
+      // Apply only writes the previewed files, as long as they are unchanged.
+      const hashes = {};
+      files.forEach((file) => {
+        hashes[file.metadata_path] = file.hash;
+      });
+      replacePreviewRequest = { ...payload, hashes };
+      replaceApplyButton.disabled = false;
+      const total = files.reduce((sum, file) => sum + (file.count || 0), 0);
+      setReplaceStatus(`共 ${files.length} 个文件, ${total} 处修改`);
+    } catch (err) {
+      setReplaceStatus(err.message || "预览失败", true);
+    }
+  }
+
+  async function handleReplaceApply() {
+    if (!replacePreviewRequest) {
+      return;
+    }
+    replaceApplyButton.disabled = true;
+    setReplaceStatus("写入中...");
+    try {
+      const data = await postReplaceRequest({ ...replacePreviewRequest, apply: true });
+      if (Array.isArray(data.collections)) {
+        collections = data.collections;
+        buildCollectionExtensionMap();
+        populateCollectionFilterOptions();
+      }
+      renderCollections();
+      renderGames();
+      renderFields();
+      renderMedia();
+      replacePreviewRequest = null;
+      refreshHistory();
+      const files = Array.isArray(data.files) ? data.files : [];
+      setReplaceStatus(`已写入 ${files.length} 个文件`);
+    } catch (err) {
+      setReplaceStatus(err.message || "替换失败", true);
+    }
+  }
+
+  function isJSONResponse(res) {
+    return (res.headers.get("Content-Type") || "").includes("application/json");
+  }
+
+  // mergeFieldLists merges the fields edited in this tab (mine) with the
+  // version on disk (theirs), both derived from base. A key changed on both
+  // sides keeps this tab's value and is reported as a conflict.
+  function mergeFieldLists(base, mine, theirs) {
+    const toMap = (list) => {
+      const map = new Map();
+      (list || []).forEach((field) => {
+        if (!field || !field.key) {
+          return;
+        }
+        const key = field.key.toLowerCase();
+        if (!map.has(key)) {
+          map.set(key, { key: field.key, values: [] });
+        }
+        map.get(key).values.push(...(field.values || []));
+      });
+      return map;
+    };
+    const same = (a, b) => JSON.stringify(a ? a.values : []) === JSON.stringify(b ? b.values : []);
+    const baseMap = toMap(base);
+    const mineMap = toMap(mine);
+    const theirsMap = toMap(theirs);
+    const keys = [];
+    [theirsMap, mineMap, baseMap].forEach((map) => {
+      map.forEach((_, key) => {
+        if (!keys.includes(key)) {
+          keys.push(key);
+        }
+      });
+    });
+    const fields = [];
+    const conflicts = [];
+    keys.forEach((key) => {
+      const baseField = baseMap.get(key);
+      const mineField = mineMap.get(key);
+      const theirsField = theirsMap.get(key);
+      let picked = mineField;
+      if (same(mineField, baseField)) {
+        picked = theirsField;
+      } else if (!same(theirsField, baseField) && !same(mineField, theirsField)) {
+        conflicts.push(key);
+      }
+      if (picked && picked.values.length) {
+        fields.push({ key: picked.key, values: [...picked.values] });
+      }
+    });
+    return { fields, conflicts };
+  }
+
+  function hideConflict(container) {
+    if (container) {
+      container.innerHTML = "";
+      container.classList.add("hidden");
+    }
+  }
+
+  function renderConflict(container, data, onMerge, onReload) {
+    if (!container) {
+      return;
+    }
+    container.innerHTML = "";
+    const message = document.createElement("p");
+    message.textContent = "保存失败：该条目已被其他人修改。可以合并对方的修改后再保存，或放弃本地修改重新加载。";
+    container.appendChild(message);
+    const diff = Array.isArray(data.diff) ? data.diff : [];
+    if (diff.length) {
+      const list = document.createElement("ul");
+      list.className = "conflict-diff";
+      diff.forEach((item) => {
+        const li = document.createElement("li");
+        const key = document.createElement("strong");
+        key.textContent = `${item.key}: `;
+        const current = document.createElement("span");
+        current.className = "current";
+        current.textContent = `磁盘上 ${(item.current || []).join(" / ") || "(空)"}`;
+        const submitted = document.createElement("span");
+        submitted.className = "submitted";
+        submitted.textContent = `；你的 ${(item.submitted || []).join(" / ") || "(空)"}`;
+        li.append(key, current, submitted);
+        list.appendChild(li);
+      });
+      container.appendChild(list);
+    }
+    const actions = document.createElement("div");
+    actions.className = "conflict-actions";
+    const reloadButton = document.createElement("button");
+    reloadButton.type = "button";
+    reloadButton.textContent = "重新加载";
+    reloadButton.addEventListener("click", onReload);
+    const mergeButton = document.createElement("button");
+    mergeButton.type = "button";
+    mergeButton.textContent = "合并";
+    mergeButton.addEventListener("click", onMerge);
+    actions.append(reloadButton, mergeButton);
+    container.appendChild(actions);
+    container.classList.remove("hidden");
+  }
+
+  function findEditRow(key) {
+    return Array.from(editFields.querySelectorAll(".edit-field-row")).find((row) => row.dataset.key === key) || null;
+  }
+
+  function applyScrapeChange(change) {
+    let row = findEditRow(change.key);
+    if (!row) {
+      row = createEditableFieldRow(
+        { key: change.key, values: [] },
+        { isNew: false, sourceGame: editContext?.game || null, initialValues: [] },
+      );
+      row.classList.remove("collapsed-hidden");
+      editFields.appendChild(row);
+    }
+    const state = getRowState(row);
+    if (state.valueArea) {
+      state.valueArea.value = (change.new || []).join("\n");
+    }
+    if (change.asset && state.previewEl) {
+      renderAssetPreviewFromPayload(state.previewEl, change.asset);
+    }
+  }
+
+  function renderScrapeChoices(data, changes) {
+    editScrapePanel.innerHTML = "";
+    const message = document.createElement("p");
+    message.textContent = `${scraperName} 匹配到「${data.title}」，勾选要填入的字段：`;
+    editScrapePanel.appendChild(message);
+    const list = document.createElement("ul");
+    list.className = "conflict-diff scrape-choices";
+    const boxes = changes.map((change) => {
+      const li = document.createElement("li");
+      const label = document.createElement("label");
+      const box = document.createElement("input");
+      box.type = "checkbox";
+      box.checked = !(change.old || []).length;
+      const key = document.createElement("strong");
+      key.textContent = `${change.key}: `;
+      const current = document.createElement("span");
+      current.className = "submitted";
+      current.textContent = `当前 ${(change.old || []).join(" / ") || "(空)"}`;
+      const next = document.createElement("span");
+      next.className = "current";
+      next.textContent = `；刮削 ${change.asset ? change.asset.file_name || "媒体文件" : (change.new || []).join(" / ")}`;
+      label.append(box, key, current, next);
+      li.appendChild(label);
+      list.appendChild(li);
+      return box;
+    });
+    editScrapePanel.appendChild(list);
+    const actions = document.createElement("div");
+    actions.className = "conflict-actions";
+    const cancelButton = document.createElement("button");
+    cancelButton.type = "button";
+    cancelButton.textContent = "取消";
+    cancelButton.addEventListener("click", () => hideConflict(editScrapePanel));
+    const applyButton = document.createElement("button");
+    applyButton.type = "button";
+    applyButton.textContent = "填入所选";
+    applyButton.addEventListener("click", () => {
+      const picked = changes.filter((_, idx) => boxes[idx].checked);
+      picked.forEach(applyScrapeChange);
+      hideConflict(editScrapePanel);
+      setEditStatus(`已填入 ${picked.length} 项，保存后生效`);
+    });
+    actions.append(cancelButton, applyButton);
+    editScrapePanel.appendChild(actions);
+    editScrapePanel.classList.remove("hidden");
+  }
+
+  async function handleScrape() {
+    if (!editContext || editContext.isNew) {
+      return;
+    }
+    const policy = editScrapePolicy.value;
+    hideConflict(editScrapePanel);
+    setEditStatus("刮削中...");
+    editScrapeButton.disabled = true;
+    try {
+      const res = await fetch("api/games/scrape", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          metadata_path: editContext.metadata_path,
+          x_index_id: editContext.x_index_id,
+          policy,
+        }),
+      });
+      if (!res.ok) {
+        const text = await res.text();
+        throw new Error(text || "刮削失败");
+      }
+      const data = await res.json();
+      if (!data.title) {
+        setEditStatus("刮削源中未找到该游戏", true);
+        return;
+      }
+      const rejected = data.changes.filter((change) => change.error);
+      const changes = data.changes.filter((change) => !change.error);
+      const skipped = rejected.length ? `，${rejected.length} 个媒体文件不符合要求已跳过` : "";
+      if (!changes.length) {
+        setEditStatus(`匹配到「${data.title}」，没有需要更新的字段${skipped}`);
+        return;
+      }
+      if (policy === "ask") {
+        renderScrapeChoices(data, changes);
+        setEditStatus(skipped.replace(/^，/, ""));
+        return;
+      }
+      changes.forEach(applyScrapeChange);
+      setEditStatus(`匹配到「${data.title}」，已填入 ${changes.length} 项，保存后生效${skipped}`);
+    } catch (err) {
+      setEditStatus(`刮削失败: ${err.message}`, true);
+    } finally {
+      editScrapeButton.disabled = false;
+    }
+  }
+
+  function showGameConflict(data, submitted) {
+    setEditStatus("");
+    renderConflict(
+      editConflict,
+      data,
+      () => {
+        if (!editContext || !data.game) {
+          return;
+        }
+        const base = editContext.game ? editContext.game.fields : [];
+        const merged = mergeFieldLists(base, submitted, data.game.fields);
+        adoptConflictGame(data);
+        populateEditFields({ ...data.game, fields: merged.fields });
+        updateCollapsibleVisibility();
+        hideConflict(editConflict);
+        setEditStatus(
+          merged.conflicts.length
+            ? `以下字段两边都有修改，已保留你的修改，请确认后保存: ${merged.conflicts.join(", ")}`
+            : "已合并最新修改，请确认后保存",
+          merged.conflicts.length > 0,
+        );
+      },
+      () => {
+        if (!editContext || !data.game) {
+          return;
+        }
+        adoptConflictGame(data);
+        removedFields = [];
+        populateEditFields(data.game);
+        updateCollapsibleVisibility();
+        hideConflict(editConflict);
+        setEditStatus("已加载最新内容");
+      },
+    );
+  }
+
+  function adoptConflictGame(data) {
+    applyCollectionUpdate(data.collection);
+    editContext.game = data.game;
+    editContext.collection = data.collection || editContext.collection;
+    renderCollections();
+    renderGames();
+    renderFields();
+    renderMedia();
+  }
+
+  function showCollectionConflict(data, submitted) {
+    setCollectionStatus("");
+    const adopt = (fields) => {
+      applyCollectionUpdate(data.collection);
+      collectionEditContext.revision = data.collection.revision || "";
+      collectionEditContext.originalFields = fields.map((field) => ({
+        key: field.key,
+        values: [...(field.values || [])],
+      }));
+      populateCollectionForm({ ...data.collection, fields });
+      hideConflict(collectionConflict);
+      renderCollections();
+    };
+    renderConflict(
+      collectionConflict,
+      data,
+      () => {
+        if (!collectionEditContext || !data.collection) {
+          return;
+        }
+        const merged = mergeFieldLists(collectionEditContext.originalFields, submitted, data.collection.fields);
+        adopt(merged.fields);
+        setCollectionStatus(
+          merged.conflicts.length
+            ? `以下字段两边都有修改，已保留你的修改，请确认后保存: ${merged.conflicts.join(", ")}`
+            : "已合并最新修改，请确认后保存",
+          merged.conflicts.length > 0,
+        );
+      },
+      () => {
+        if (!collectionEditContext || !data.collection) {
+          return;
+        }
+        adopt(data.collection.fields || []);
+        setCollectionStatus("已加载最新内容");
+      },
+    );
+  }
+
   async function handleEditSubmit(event) {
     event.preventDefault();
     if (duplicateRows.size > 0) {
@@ -2194,13 +3793,16 @@
     setEditStatus("保存中...");
     const virtualSelectionName = wasPureVirtual ? (context.collection?.name || "") : "";
     try {
-      const endpoint = context.isNew ? "/api/games/create" : "/api/games/update";
+      const endpoint = context.isNew ? "api/games/create" : "api/games/update";
       const body = {
         metadata_path: context.metadata_path,
         x_index_id: context.x_index_id,
         fields: fieldsPayload,
       };
       if (!context.isNew) {
+        if (context.game && context.game.revision) {
+          body.revision = context.game.revision;
+        }
         const allRemoved = [];
         if (Array.isArray(removedFields)) {
           allRemoved.push(...removedFields);
@@ -2217,6 +3819,10 @@
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify(body),
       });
+      if (res.status === 409 && isJSONResponse(res)) {
+        showGameConflict(await res.json(), fieldsPayload);
+        return;
+      }
       if (!res.ok) {
         const text = await res.text();
         throw new Error(text || "保存失败");
@@ -2344,6 +3950,101 @@
     collectionClose.addEventListener("click", closeCollectionModal);
   }
 
+  if (orphanButton) {
+    orphanButton.addEventListener("click", () => {
+      const collection = getCurrentCollection();
+      if (!collection) {
+        return;
+      }
+      openOrphanModal(collection);
+    });
+  }
+  if (orphanClose) {
+    orphanClose.addEventListener("click", closeOrphanModal);
+  }
+  if (orphanModal) {
+    orphanModal.addEventListener("click", (event) => {
+      if (event.target === orphanModal) {
+        closeOrphanModal();
+      }
+    });
+  }
+  if (orphanRomAll) {
+    orphanRomAll.addEventListener("change", () => {
+      if (!orphanRomList) {
+        return;
+      }
+      orphanRomList.querySelectorAll("input[type=checkbox]").forEach((input) => {
+        input.checked = orphanRomAll.checked;
+      });
+    });
+  }
+  if (orphanAdoptButton) {
+    orphanAdoptButton.addEventListener("click", submitOrphanAdoption);
+  }
+
+  if (historyUndoButton) {
+    historyUndoButton.addEventListener("click", () => replayHistory("undo"));
+  }
+  if (historyRedoButton) {
+    historyRedoButton.addEventListener("click", () => replayHistory("redo"));
+  }
+  if (replaceButton) {
+    replaceButton.addEventListener("click", openReplaceModal);
+  }
+  if (replaceClose) {
+    replaceClose.addEventListener("click", closeReplaceModal);
+  }
+  if (showJobsButton) {
+    showJobsButton.addEventListener("click", openJobsModal);
+  }
+  if (jobsClose) {
+    jobsClose.addEventListener("click", closeJobsModal);
+  }
+  if (jobsModal) {
+    jobsModal.addEventListener("click", (event) => {
+      if (event.target === jobsModal) {
+        closeJobsModal();
+      }
+    });
+  }
+  if (replaceModal) {
+    replaceModal.addEventListener("click", (event) => {
+      if (event.target === replaceModal) {
+        closeReplaceModal();
+      }
+    });
+  }
+  if (replaceForm) {
+    replaceForm.addEventListener("submit", handleReplacePreview);
+    replaceForm.addEventListener("input", resetReplacePreview);
+  }
+  if (replaceApplyButton) {
+    replaceApplyButton.addEventListener("click", handleReplaceApply);
+  }
+  if (batchButton) {
+    batchButton.addEventListener("click", openBatchModal);
+  }
+  if (batchClose) {
+    batchClose.addEventListener("click", closeBatchModal);
+  }
+  if (batchCancel) {
+    batchCancel.addEventListener("click", closeBatchModal);
+  }
+  if (batchModal) {
+    batchModal.addEventListener("click", (event) => {
+      if (event.target === batchModal) {
+        closeBatchModal();
+      }
+    });
+  }
+  if (batchOp) {
+    batchOp.addEventListener("change", updateBatchFormMode);
+  }
+  if (batchForm) {
+    batchForm.addEventListener("submit", handleBatchSubmit);
+  }
+
   if (collectionCancel) {
     collectionCancel.addEventListener("click", (event) => {
       event.preventDefault();
@@ -2359,6 +4060,12 @@
     });
   }
 
+  [collectionLaunchInput, collectionCwdInput].forEach((input) => {
+    if (input) {
+      input.addEventListener("input", scheduleLaunchCheck);
+    }
+  });
+
   if (collectionForm) {
     collectionForm.addEventListener("submit", async (event) => {
       event.preventDefault();
@@ -2374,15 +4081,20 @@
       }
       setCollectionStatus("保存中...");
       try {
-        const res = await fetch("/api/collections/update", {
+        const res = await fetch("api/collections/update", {
           method: "POST",
           headers: { "Content-Type": "application/json" },
           body: JSON.stringify({
             metadata_path: collectionEditContext.metadata_path,
             x_index_id: collectionEditContext.x_index_id,
+            revision: collectionEditContext.revision || "",
             fields: fieldsPayload,
           }),
         });
+        if (res.status === 409 && isJSONResponse(res)) {
+          showCollectionConflict(await res.json(), fieldsPayload);
+          return;
+        }
         if (!res.ok) {
           const text = await res.text();
           throw new Error(text || "保存失败");
@@ -2476,7 +4188,7 @@
       }
       setDeleteStatus("删除中...");
       try {
-        const res = await fetch("/api/games/delete", {
+        const res = await fetch("api/games/delete", {
           method: "POST",
           headers: { "Content-Type": "application/json" },
           body: JSON.stringify({
@@ -2530,6 +4242,9 @@
   if (editClose) {
     editClose.addEventListener("click", closeEditModal);
   }
+  if (editScrapeButton) {
+    editScrapeButton.addEventListener("click", handleScrape);
+  }
   if (editModal) {
     editModal.addEventListener("click", (event) => {
       if (event.target === editModal) {